@@ -0,0 +1,82 @@
+package accesslog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/rezkam/TaxMan/internal/httputil"
+	"github.com/rezkam/TaxMan/internal/requestctx"
+)
+
+// RequestIDHeader carries the ID of a request, both from the client and back in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the request IDs accepted from clients, longer ones are replaced.
+const maxRequestIDLength = 128
+
+// unmatchedRoute is the route logged for the requests not routed to any route.
+const unmatchedRoute = "unmatched"
+
+// Middleware assigns an ID to every request to next and logs one line per request once it is served.
+// The ID is taken from the X-Request-ID header when the client sent a valid one and generated otherwise,
+// it is stored in the context of the request and returned in the X-Request-ID header of the response.
+// Requests are logged with the pattern of the route of mux they are routed to.
+func Middleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		ctx := requestctx.WithRequestID(r.Context(), requestID)
+
+		route := unmatchedRoute
+		if _, pattern := mux.Handler(r); pattern != "" {
+			route = pattern
+		}
+
+		start := time.Now()
+		recorder := httputil.NewResponseRecorder(w)
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		if recorder.Status() >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.Log(ctx, level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", route,
+			"status", recorder.Status(),
+			"bytes", recorder.Size(),
+			"duration", time.Since(start),
+			"remote_addr", r.RemoteAddr,
+			"user_agent", r.UserAgent(),
+		)
+	})
+}
+
+// validRequestID reports whether a request ID sent by a client is short and made of printable ASCII only,
+// so that it cannot forge log lines or headers.
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] < '!' || requestID[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID generates a random request ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	// crypto/rand.Read never returns an error on the supported platforms
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,90 @@
+package accesslog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rezkam/TaxMan/internal/requestctx"
+	"github.com/stretchr/testify/require"
+)
+
+// captureLogs routes the default logger to a buffer for the duration of the test and returns the logged records.
+func captureLogs(t *testing.T) func() []map[string]any {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(requestctx.NewLogHandler(slog.NewJSONHandler(&buf, nil))))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	return func() []map[string]any {
+		var records []map[string]any
+		scanner := bufio.NewScanner(&buf)
+		for scanner.Scan() {
+			var record map[string]any
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+			records = append(records, record)
+		}
+		return records
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	logs := captureLogs(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /tax", func(w http.ResponseWriter, r *http.Request) {
+		slog.ErrorContext(r.Context(), "failed to add or update tax record")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("failed"))
+	})
+	handler := Middleware(mux, mux)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/tax", nil)
+	req.Header.Set(RequestIDHeader, "client-request-1")
+	handler.ServeHTTP(rr, req)
+
+	require.Equal(t, "client-request-1", rr.Header().Get(RequestIDHeader))
+	records := logs()
+	require.Len(t, records, 2)
+	require.Equal(t, "failed to add or update tax record", records[0]["msg"])
+	require.Equal(t, "client-request-1", records[0]["request_id"])
+
+	access := records[1]
+	require.Equal(t, "request", access["msg"])
+	require.Equal(t, "ERROR", access["level"])
+	require.Equal(t, "client-request-1", access["request_id"])
+	require.Equal(t, http.MethodPost, access["method"])
+	require.Equal(t, "POST /tax", access["route"])
+	require.Equal(t, float64(http.StatusInternalServerError), access["status"])
+	require.Equal(t, float64(len("failed")), access["bytes"])
+}
+
+func TestMiddlewareGeneratesRequestID(t *testing.T) {
+	logs := captureLogs(t)
+	mux := http.NewServeMux()
+	handler := Middleware(mux, mux)
+
+	for _, requestID := range []string{"", "forged\nline", strings.Repeat("a", maxRequestIDLength+1)} {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/nowhere", nil)
+		req.Header.Set(RequestIDHeader, requestID)
+		handler.ServeHTTP(rr, req)
+
+		generated := rr.Header().Get(RequestIDHeader)
+		require.Len(t, generated, 32)
+		require.NotEqual(t, requestID, generated)
+	}
+
+	records := logs()
+	require.Len(t, records, 3)
+	require.Equal(t, unmatchedRoute, records[0]["route"])
+	require.Equal(t, "INFO", records[0]["level"])
+	require.Equal(t, float64(http.StatusNotFound), records[0]["status"])
+	require.NotEqual(t, records[0]["request_id"], records[1]["request_id"])
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rezkam/TaxMan/internal/problem"
+	"github.com/rezkam/TaxMan/model"
+)
+
+// CreateAPIKeyRequestToModel converts and validates the request for creating an API key.
+func (s *Service) CreateAPIKeyRequestToModel(req CreateAPIKeyRequest) (model.APIKey, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return model.APIKey{}, problem.Invalid("name", problem.CodeNameRequired, "name is required")
+	}
+	if utf8.RuneCountInString(name) > s.config.MaxKeyNameLength {
+		return model.APIKey{}, problem.Invalid("name", problem.CodeNameTooLong, "name exceeds maximum length")
+	}
+	if len(req.Scopes) == 0 {
+		return model.APIKey{}, problem.Invalid("scopes", problem.CodeScopesRequired, "at least one scope is required")
+	}
+	var scopes []model.Scope
+	for _, scope := range req.Scopes {
+		if !slices.Contains(model.ValidScopes, scope) {
+			return model.APIKey{}, problem.Invalid("scopes", problem.CodeInvalidScope, fmt.Sprintf("invalid scope %q", scope))
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	var municipalities []string
+	for _, municipality := range req.Municipalities {
+		if municipality == "" {
+			return model.APIKey{}, problem.Invalid("municipalities", problem.CodeMunicipalityRequired, "municipality name cannot be empty")
+		}
+		if utf8.RuneCountInString(municipality) > s.config.MaxMunicipalityNameLength {
+			return model.APIKey{}, problem.Invalid("municipalities", problem.CodeMunicipalityTooLong, "municipality name exceeds maximum length")
+		}
+		if !slices.Contains(municipalities, municipality) {
+			municipalities = append(municipalities, municipality)
+		}
+	}
+	return model.APIKey{Name: name, Scopes: scopes, Municipalities: municipalities}, nil
+}
+
+// KeyIDRequestToModel parses and validates the ID of an API key taken from the URL.
+func (s *Service) KeyIDRequestToModel(id string) (int64, error) {
+	if id == "" {
+		return 0, problem.Invalid("id", problem.CodeInvalidID, "api key id is required")
+	}
+	parsedID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || parsedID <= 0 {
+		return 0, problem.Invalid("id", problem.CodeInvalidID, "invalid api key id")
+	}
+	return parsedID, nil
+}
+
+// APIKeyToResponse converts an API key to its response type, without its secret.
+func APIKeyToResponse(key model.APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:             key.ID,
+		Name:           key.Name,
+		Prefix:         key.Prefix,
+		Scopes:         key.Scopes,
+		Municipalities: key.Municipalities,
+		CreatedAt:      key.CreatedAt,
+		RevokedAt:      key.RevokedAt,
+	}
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/rezkam/TaxMan/internal/jsonutils"
+	"github.com/rezkam/TaxMan/internal/problem"
+	"github.com/rezkam/TaxMan/model"
+)
+
+func (s *Service) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	if err := jsonutils.DecodeRequest(w, r, &req, s.config.MaxRequestBodySize); err != nil {
+		problem.WriteDecodeError(w, r, err)
+		return
+	}
+
+	key, err := s.CreateAPIKeyRequestToModel(req)
+	if err != nil {
+		problem.WriteInvalid(w, r, err)
+		return
+	}
+
+	created, secret, err := s.CreateAPIKey(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, model.ErrConflict) {
+			problem.Write(w, r, http.StatusConflict, problem.CodeAPIKeyNameTaken, "an active api key with this name already exists")
+			return
+		}
+		slog.ErrorContext(r.Context(), "failed to create api key", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternalError, "failed to create api key")
+		return
+	}
+
+	// The key is only shown once, only its hash is stored
+	resp := APIKeyToResponse(created)
+	resp.Key = secret
+	jsonutils.JsonResponse(w, resp, http.StatusCreated)
+}
+
+func (s *Service) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.store.ListAPIKeys(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list api keys", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternalError, "failed to list api keys")
+		return
+	}
+
+	resp := ListAPIKeysResponse{Keys: make([]APIKeyResponse, 0, len(keys))}
+	for _, key := range keys {
+		resp.Keys = append(resp.Keys, APIKeyToResponse(key))
+	}
+	jsonutils.JsonResponse(w, resp, http.StatusOK)
+}
+
+func (s *Service) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	keyID, err := s.KeyIDRequestToModel(r.PathValue(s.config.KeyIDURLPattern))
+	if err != nil {
+		problem.WriteInvalid(w, r, err)
+		return
+	}
+
+	if err := s.store.RevokeAPIKey(r.Context(), keyID); err != nil {
+		if errors.Is(err, model.ErrNotFound) {
+			problem.Write(w, r, http.StatusNotFound, problem.CodeAPIKeyNotFound, "api key not found")
+			return
+		}
+		slog.ErrorContext(r.Context(), "failed to revoke api key", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternalError, "failed to revoke api key")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rezkam/TaxMan/model"
+	"github.com/stretchr/testify/require"
+)
+
+// newJSONRequest creates a request with a JSON body.
+func newJSONRequest(method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestCreateAPIKeyHandler(t *testing.T) {
+	t.Run("key returned once and stored hashed", func(t *testing.T) {
+		var storedHash []byte
+		store := &mockStore{
+			createAPIKeyFunc: func(ctx context.Context, key model.APIKey, keyHash []byte) (model.APIKey, error) {
+				storedHash = keyHash
+				key.ID = 3
+				return key, nil
+			},
+		}
+		svc, err := New(store, testConfig())
+		require.NoError(t, err)
+
+		reqBody, err := json.Marshal(CreateAPIKeyRequest{
+			Name:           "ci",
+			Scopes:         []model.Scope{model.ScopeWrite, model.ScopeWrite},
+			Municipalities: []string{"Copenhagen", "Copenhagen"},
+		})
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		svc.CreateAPIKeyHandler(rr, newJSONRequest(http.MethodPost, "/api-keys", bytes.NewReader(reqBody)))
+
+		require.Equal(t, http.StatusCreated, rr.Code)
+		var resp APIKeyResponse
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		require.True(t, strings.HasPrefix(resp.Key, apiKeyPrefix))
+		require.True(t, strings.HasPrefix(resp.Key, resp.Prefix))
+		require.Equal(t, []model.Scope{model.ScopeWrite}, resp.Scopes)
+		require.Equal(t, []string{"Copenhagen"}, resp.Municipalities)
+		require.Equal(t, hashAPIKey(resp.Key), storedHash)
+		require.NotContains(t, string(storedHash), resp.Key)
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		svc, err := New(&mockStore{}, testConfig())
+		require.NoError(t, err)
+
+		for _, req := range []CreateAPIKeyRequest{
+			{Name: "", Scopes: []model.Scope{model.ScopeRead}},
+			{Name: "ci"},
+			{Name: "ci", Scopes: []model.Scope{"root"}},
+			{Name: strings.Repeat("a", 51), Scopes: []model.Scope{model.ScopeRead}},
+			{Name: "ci", Scopes: []model.Scope{model.ScopeWrite}, Municipalities: []string{""}},
+			{Name: "ci", Scopes: []model.Scope{model.ScopeWrite}, Municipalities: []string{strings.Repeat("a", 51)}},
+		} {
+			reqBody, err := json.Marshal(req)
+			require.NoError(t, err)
+			rr := httptest.NewRecorder()
+			svc.CreateAPIKeyHandler(rr, newJSONRequest(http.MethodPost, "/api-keys", bytes.NewReader(reqBody)))
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("duplicate name", func(t *testing.T) {
+		store := &mockStore{
+			createAPIKeyFunc: func(ctx context.Context, key model.APIKey, keyHash []byte) (model.APIKey, error) {
+				return model.APIKey{}, model.ErrConflict
+			},
+		}
+		svc, err := New(store, testConfig())
+		require.NoError(t, err)
+
+		reqBody, err := json.Marshal(CreateAPIKeyRequest{Name: "ci", Scopes: []model.Scope{model.ScopeRead}})
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		svc.CreateAPIKeyHandler(rr, newJSONRequest(http.MethodPost, "/api-keys", bytes.NewReader(reqBody)))
+		require.Equal(t, http.StatusConflict, rr.Code)
+	})
+}
+
+func TestRevokeAPIKeyHandler(t *testing.T) {
+	store := &mockStore{
+		revokeAPIKeyFunc: func(ctx context.Context, keyID int64) error {
+			if keyID != 1 {
+				return model.ErrNotFound
+			}
+			return nil
+		},
+	}
+	svc, err := New(store, testConfig())
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /api-keys/{id}", svc.RevokeAPIKeyHandler)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/api-keys/1", nil))
+	require.Equal(t, http.StatusNoContent, rr.Code)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/api-keys/2", nil))
+	require.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxJWKSSize is the maximum size of a JWKS document.
+	maxJWKSSize = 1 << 20
+	// jwksFetchTimeout is how long fetching a JWKS from a URL may take.
+	jwksFetchTimeout = 10 * time.Second
+)
+
+// ErrUnknownKey is returned when a token is signed with a key missing from the key set.
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// KeySet resolves the public keys that sign the tokens by their key ID.
+type KeySet interface {
+	Key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+// JWKS is a KeySet read from a JSON Web Key Set document, either a local file or a URL.
+// The keys are cached for the TTL and reloaded early when a token refers to an unknown key,
+// so that keys rotated by the issuer are picked up without a restart.
+type JWKS struct {
+	source string
+	client *http.Client
+	// ttl is how long the keys are used before they are reloaded.
+	ttl time.Duration
+	// minRefreshInterval limits how often unknown keys trigger a reload, so that forged key IDs cannot flood the source.
+	minRefreshInterval time.Duration
+	now                func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	// reloading is the reload in progress, which concurrent callers wait for rather than fetching again.
+	reloading *jwksReload
+}
+
+// jwksReload is a reload of the keys shared by the callers that need it.
+type jwksReload struct {
+	done chan struct{}
+	err  error
+}
+
+// NewJWKS creates a key set loaded from source, an http(s) URL or a file path.
+func NewJWKS(source string, ttl, minRefreshInterval time.Duration) *JWKS {
+	return &JWKS{
+		source:             source,
+		client:             &http.Client{Timeout: jwksFetchTimeout},
+		ttl:                ttl,
+		minRefreshInterval: minRefreshInterval,
+		now:                time.Now,
+	}
+}
+
+// Key returns the public key with the given key ID. An empty kid matches the only key of a set with a single key.
+func (j *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	keys, fetchedAt := j.cached()
+	if keys == nil || j.now().Sub(fetchedAt) >= j.ttl {
+		if err := j.refresh(ctx, fetchedAt); err != nil {
+			if keys == nil {
+				return nil, err
+			}
+			// Stale keys keep tokens verifiable while the source is unavailable
+			slog.Warn("failed to reload jwks, using cached keys", "source", j.source, "error", err)
+		}
+		keys, fetchedAt = j.cached()
+	}
+	if key, ok := lookupKey(keys, kid); ok {
+		return key, nil
+	}
+
+	if j.now().Sub(fetchedAt) < j.minRefreshInterval {
+		return nil, ErrUnknownKey
+	}
+	if err := j.refresh(ctx, fetchedAt); err != nil {
+		return nil, err
+	}
+	keys, _ = j.cached()
+	if key, ok := lookupKey(keys, kid); ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// cached returns the cached keys and when they were loaded.
+func (j *JWKS) cached() (map[string]crypto.PublicKey, time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.keys, j.fetchedAt
+}
+
+// lookupKey finds the key of kid among keys.
+func lookupKey(keys map[string]crypto.PublicKey, kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+	key, ok := keys[kid]
+	return key, ok
+}
+
+// refresh reloads the keys from the source, unless they were reloaded since fetchedAt.
+// The source is read without holding the lock, so that a slow source does not block the lookups of cached keys,
+// and concurrent callers wait for a single reload. The load time is recorded even when it fails,
+// so that a failing source is not retried on every request.
+func (j *JWKS) refresh(ctx context.Context, fetchedAt time.Time) error {
+	j.mu.Lock()
+	if !j.fetchedAt.Equal(fetchedAt) {
+		j.mu.Unlock()
+		return nil
+	}
+	reload := j.reloading
+	if reload == nil {
+		reload = &jwksReload{done: make(chan struct{})}
+		j.reloading = reload
+		// The reload outlives the caller that started it, the timeout of the client bounds it
+		go j.reload(context.WithoutCancel(ctx), reload)
+	}
+	j.mu.Unlock()
+
+	select {
+	case <-reload.done:
+		return reload.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reload loads the keys from the source and completes reload.
+func (j *JWKS) reload(ctx context.Context, reload *jwksReload) {
+	keys, err := j.load(ctx)
+
+	j.mu.Lock()
+	j.fetchedAt = j.now()
+	if err == nil {
+		j.keys = keys
+	}
+	j.reloading = nil
+	j.mu.Unlock()
+
+	reload.err = err
+	close(reload.done)
+}
+
+// load reads and parses the keys of the source.
+func (j *JWKS) load(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	data, err := j.read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jwks from %s: %w", j.source, err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse jwks from %s: %w", j.source, err)
+	}
+	return keys, nil
+}
+
+// read loads the JWKS document from its URL or file.
+func (j *JWKS) read(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(j.source, "http://") && !strings.HasPrefix(j.source, "https://") {
+		return os.ReadFile(j.source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
+
+// jsonWebKey holds the members of a JSON Web Key used for RSA and EC signature keys.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS parses the signature keys of a JWKS document by their key ID. Keys of other uses or types are skipped.
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		var err error
+		switch jwk.Kty {
+		case "RSA":
+			key, err = parseRSAKey(jwk)
+		case "EC":
+			key, err = parseECKey(jwk)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signature keys found")
+	}
+	return keys, nil
+}
+
+func parseRSAKey(jwk jsonWebKey) (*rsa.PublicKey, error) {
+	n, err := decodeBigInt(jwk.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	e, err := decodeBigInt(jwk.E)
+	if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid exponent")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func parseECKey(jwk jsonWebKey) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch jwk.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+	}
+	x, err := decodeBigInt(jwk.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid x coordinate: %w", err)
+	}
+	y, err := decodeBigInt(jwk.Y)
+	if err != nil {
+		return nil, fmt.Errorf("invalid y coordinate: %w", err)
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("point is not on the curve")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// decodeBigInt decodes an unsigned big-endian integer encoded as base64url without padding.
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
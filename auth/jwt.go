@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rezkam/TaxMan/model"
+)
+
+// signingMethods are the asymmetric algorithms accepted for tokens, symmetric and "none" algorithms are rejected.
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+type JWTConfig struct {
+	// KeySet resolves the keys that sign the tokens.
+	KeySet KeySet
+	// Issuer is the required iss claim, not checked when empty.
+	Issuer string
+	// Audience is the required aud claim, not checked when empty.
+	Audience string
+	// RolesClaim is the claim holding the roles of the caller, as an array or a space-separated string.
+	// Nested claims are separated by dots, e.g. "realm_access.roles".
+	RolesClaim string
+	// RoleScopes maps the roles of the tokens to the scopes they grant, unmapped roles grant nothing.
+	RoleScopes map[string]model.Scope
+	// MunicipalitiesClaim is the claim holding the municipalities whose records the caller may change,
+	// as an array or a single string. Nested claims are separated by dots. Tokens are not restricted when it is
+	// empty or the token lacks the claim, and rejected when the claim holds no municipality.
+	MunicipalitiesClaim string
+	// Leeway is the clock skew tolerated when checking the expiry and validity of tokens.
+	Leeway time.Duration
+}
+
+// validateJWTConfig checks if the provided JWTConfig values are valid.
+func validateJWTConfig(config *JWTConfig) error {
+	if config.KeySet == nil {
+		return errors.New("JWT KeySet cannot be nil")
+	}
+	if config.RolesClaim == "" {
+		return errors.New("JWT RolesClaim cannot be empty")
+	}
+	for role, scope := range config.RoleScopes {
+		if !slices.Contains(model.ValidScopes, scope) {
+			return fmt.Errorf("role %q maps to invalid scope %q", role, scope)
+		}
+	}
+	return nil
+}
+
+// AuthenticateJWT verifies a bearer token and returns its principal, named after its subject
+// and granted the scopes of its roles. It returns ErrUnauthenticated for invalid tokens.
+func (s *Service) AuthenticateJWT(ctx context.Context, token string) (Principal, error) {
+	config := s.config.JWT
+	if config == nil {
+		return Principal{}, ErrUnauthenticated
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(signingMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(config.Leeway),
+	}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return config.KeySet.Key(ctx, kid)
+	}, options...)
+	if err != nil {
+		if !errors.Is(err, ErrUnknownKey) && errors.Is(err, jwt.ErrTokenUnverifiable) {
+			// The key set could not be loaded, the token itself may be valid
+			slog.ErrorContext(ctx, "failed to resolve token signing key", "error", err)
+		}
+		return Principal{}, ErrUnauthenticated
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return Principal{}, ErrUnauthenticated
+	}
+
+	var scopes []model.Scope
+	for _, role := range rolesFromClaims(claims, config.RolesClaim) {
+		if scope, ok := config.RoleScopes[role]; ok {
+			scopes = append(scopes, scope)
+		}
+	}
+	principal := Principal{Name: subject, Scopes: scopes}
+	if config.MunicipalitiesClaim != "" {
+		if value := claimValue(claims, config.MunicipalitiesClaim); value != nil {
+			principal.Municipalities = municipalitiesFromClaim(value)
+			if len(principal.Municipalities) == 0 {
+				// Restricted to no municipality, which must not be mistaken for unrestricted
+				return Principal{}, ErrUnauthenticated
+			}
+		}
+	}
+	return principal, nil
+}
+
+// rolesFromClaims reads the roles held by the claim at the dotted path.
+func rolesFromClaims(claims jwt.MapClaims, path string) []string {
+	switch roles := claimValue(claims, path).(type) {
+	case string:
+		return strings.Fields(roles)
+	case []any:
+		return stringsOf(roles)
+	default:
+		return nil
+	}
+}
+
+// municipalitiesFromClaim reads the municipalities held by a claim value.
+// Municipality names may contain spaces, so a string claim is a single municipality.
+func municipalitiesFromClaim(value any) []string {
+	switch municipalities := value.(type) {
+	case string:
+		if municipalities == "" {
+			return nil
+		}
+		return []string{municipalities}
+	case []any:
+		return stringsOf(municipalities)
+	default:
+		return nil
+	}
+}
+
+// claimValue returns the value of the claim at the dotted path, nil if it does not exist.
+func claimValue(claims jwt.MapClaims, path string) any {
+	var value any = map[string]any(claims)
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	return value
+}
+
+// stringsOf returns the non-empty strings of a claim array, skipping other values.
+func stringsOf(values []any) []string {
+	var strs []string
+	for _, value := range values {
+		if str, ok := value.(string); ok && str != "" {
+			strs = append(strs, str)
+		}
+	}
+	return strs
+}
@@ -0,0 +1,282 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rezkam/TaxMan/model"
+	"github.com/stretchr/testify/require"
+)
+
+func rsaJWK(t *testing.T, kid string, key *rsa.PrivateKey) map[string]string {
+	t.Helper()
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func jwksDocument(t *testing.T, keys ...map[string]string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	require.NoError(t, err)
+	return data
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":   "clerk@example.com",
+		"iss":   "https://idp.example.com",
+		"aud":   "taxman",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": []string{"tax-editor", "unknown"},
+	}
+}
+
+func jwtService(t *testing.T, keySet KeySet) *Service {
+	t.Helper()
+	config := testConfig()
+	config.JWT = &JWTConfig{
+		KeySet:     keySet,
+		Issuer:     "https://idp.example.com",
+		Audience:   "taxman",
+		RolesClaim: "roles",
+		RoleScopes: map[string]model.Scope{"tax-reader": model.ScopeRead, "tax-editor": model.ScopeWrite},
+	}
+	svc, err := New(memoryStore(), config)
+	require.NoError(t, err)
+	return svc
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, jwksDocument(t, rsaJWK(t, "key-1", key)), 0o600))
+	svc := jwtService(t, NewJWKS(path, time.Hour, time.Minute))
+
+	t.Run("valid token", func(t *testing.T) {
+		principal, err := svc.AuthenticateJWT(context.Background(), signToken(t, jwt.SigningMethodRS256, "key-1", key, validClaims()))
+		require.NoError(t, err)
+		require.Equal(t, "clerk@example.com", principal.Name)
+		require.Equal(t, []model.Scope{model.ScopeWrite}, principal.Scopes)
+	})
+
+	invalid := map[string]func(jwt.MapClaims){
+		"expired":        func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"no expiry":      func(c jwt.MapClaims) { delete(c, "exp") },
+		"wrong issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"wrong audience": func(c jwt.MapClaims) { c["aud"] = "other" },
+		"no subject":     func(c jwt.MapClaims) { delete(c, "sub") },
+	}
+	for name, change := range invalid {
+		t.Run(name, func(t *testing.T) {
+			claims := validClaims()
+			change(claims)
+			_, err := svc.AuthenticateJWT(context.Background(), signToken(t, jwt.SigningMethodRS256, "key-1", key, claims))
+			require.ErrorIs(t, err, ErrUnauthenticated)
+		})
+	}
+
+	t.Run("symmetric algorithm rejected", func(t *testing.T) {
+		token := signToken(t, jwt.SigningMethodHS256, "key-1", []byte("secret"), validClaims())
+		_, err := svc.AuthenticateJWT(context.Background(), token)
+		require.ErrorIs(t, err, ErrUnauthenticated)
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		_, err = svc.AuthenticateJWT(context.Background(), signToken(t, jwt.SigningMethodRS256, "key-1", other, validClaims()))
+		require.ErrorIs(t, err, ErrUnauthenticated)
+		_, err = svc.AuthenticateJWT(context.Background(), signToken(t, jwt.SigningMethodRS256, "key-2", other, validClaims()))
+		require.ErrorIs(t, err, ErrUnauthenticated)
+	})
+
+	t.Run("malformed token", func(t *testing.T) {
+		_, err := svc.AuthenticateJWT(context.Background(), "not.a.token")
+		require.ErrorIs(t, err, ErrUnauthenticated)
+	})
+
+	t.Run("municipalities", func(t *testing.T) {
+		svc := jwtService(t, NewJWKS(path, time.Hour, time.Minute))
+		svc.config.JWT.MunicipalitiesClaim = "taxman.municipalities"
+
+		principal, err := svc.AuthenticateJWT(context.Background(), signToken(t, jwt.SigningMethodRS256, "key-1", key, validClaims()))
+		require.NoError(t, err)
+		require.Empty(t, principal.Municipalities)
+
+		for _, tt := range []struct {
+			claim    any
+			expected []string
+		}{
+			{"Copenhagen", []string{"Copenhagen"}},
+			{"Frederiksberg Municipality", []string{"Frederiksberg Municipality"}},
+			{[]any{"Aarhus", 7, "Roskilde"}, []string{"Aarhus", "Roskilde"}},
+		} {
+			claims := validClaims()
+			claims["taxman"] = map[string]any{"municipalities": tt.claim}
+			principal, err := svc.AuthenticateJWT(context.Background(), signToken(t, jwt.SigningMethodRS256, "key-1", key, claims))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, principal.Municipalities)
+		}
+
+		claims := validClaims()
+		claims["taxman"] = map[string]any{"municipalities": []any{}}
+		_, err = svc.AuthenticateJWT(context.Background(), signToken(t, jwt.SigningMethodRS256, "key-1", key, claims))
+		require.ErrorIs(t, err, ErrUnauthenticated)
+	})
+}
+
+func TestJWKSRotation(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	var document atomic.Value
+	document.Store(jwksDocument(t, rsaJWK(t, "old", oldKey)))
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write(document.Load().([]byte))
+	}))
+	defer server.Close()
+
+	jwks := NewJWKS(server.URL, time.Hour, 0)
+	now := time.Now()
+	jwks.now = func() time.Time { return now }
+	svc := jwtService(t, jwks)
+
+	_, err = svc.AuthenticateJWT(context.Background(), signToken(t, jwt.SigningMethodRS256, "old", oldKey, validClaims()))
+	require.NoError(t, err)
+	_, err = svc.AuthenticateJWT(context.Background(), signToken(t, jwt.SigningMethodRS256, "old", oldKey, validClaims()))
+	require.NoError(t, err)
+	require.Equal(t, int32(1), fetches.Load(), "keys are cached")
+
+	// The issuer rotates to a new key, which is fetched when a token refers to it
+	document.Store(jwksDocument(t, map[string]string{
+		"kty": "EC",
+		"kid": "new",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(newKey.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(newKey.Y.FillBytes(make([]byte, 32))),
+	}))
+	_, err = svc.AuthenticateJWT(context.Background(), signToken(t, jwt.SigningMethodES256, "new", newKey, validClaims()))
+	require.NoError(t, err)
+	require.Equal(t, int32(2), fetches.Load())
+
+	// Unknown keys do not reload the key set more often than the minimum refresh interval
+	jwks.minRefreshInterval = time.Minute
+	_, err = svc.AuthenticateJWT(context.Background(), signToken(t, jwt.SigningMethodRS256, "old", oldKey, validClaims()))
+	require.ErrorIs(t, err, ErrUnauthenticated)
+	require.Equal(t, int32(2), fetches.Load())
+
+	// Expired keys are reloaded
+	now = now.Add(2 * time.Hour)
+	_, err = svc.AuthenticateJWT(context.Background(), signToken(t, jwt.SigningMethodES256, "new", newKey, validClaims()))
+	require.NoError(t, err)
+	require.Equal(t, int32(3), fetches.Load())
+}
+
+func TestJWKSReloadDoesNotBlockLookups(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every reload after the first one hangs until released
+		if fetches.Add(1) > 1 {
+			<-release
+		}
+		w.Write(jwksDocument(t, rsaJWK(t, "key-1", key)))
+	}))
+	defer server.Close()
+
+	jwks := NewJWKS(server.URL, time.Hour, time.Minute)
+	_, err = jwks.Key(context.Background(), "key-1")
+	require.NoError(t, err)
+
+	// Unknown keys reload the key set once the minimum refresh interval passed
+	now := time.Now().Add(2 * time.Minute)
+	jwks.now = func() time.Time { return now }
+	errs := make(chan error, 3)
+	for range 3 {
+		go func() {
+			_, err := jwks.Key(context.Background(), "unknown")
+			errs <- err
+		}()
+	}
+	require.Eventually(t, func() bool { return fetches.Load() == 2 }, time.Second, time.Millisecond)
+
+	// Known keys are served while the reload is in progress
+	_, err = jwks.Key(context.Background(), "key-1")
+	require.NoError(t, err)
+
+	close(release)
+	for range 3 {
+		require.ErrorIs(t, <-errs, ErrUnknownKey)
+	}
+	require.Equal(t, int32(2), fetches.Load(), "concurrent unknown keys share a single reload")
+}
+
+func TestRolesFromClaims(t *testing.T) {
+	claims := jwt.MapClaims{
+		"scope":        "tax-reader tax-editor",
+		"realm_access": map[string]any{"roles": []any{"tax-admin", 7}},
+	}
+	require.Equal(t, []string{"tax-reader", "tax-editor"}, rolesFromClaims(claims, "scope"))
+	require.Equal(t, []string{"tax-admin"}, rolesFromClaims(claims, "realm_access.roles"))
+	require.Nil(t, rolesFromClaims(claims, "realm_access.groups"))
+	require.Nil(t, rolesFromClaims(claims, "scope.roles"))
+}
+
+func TestMiddlewareJWT(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, jwksDocument(t, rsaJWK(t, "key-1", key)), 0o600))
+	svc := jwtService(t, NewJWKS(path, time.Hour, time.Minute))
+
+	handler := svc.Middleware(testMux(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	claims := validClaims()
+	claims["roles"] = []string{"tax-reader"}
+	readerToken := signToken(t, jwt.SigningMethodRS256, "key-1", key, claims)
+	editorToken := signToken(t, jwt.SigningMethodRS256, "key-1", key, validClaims())
+
+	for token, expected := range map[string]int{readerToken: http.StatusForbidden, editorToken: http.StatusOK} {
+		req := httptest.NewRequest(http.MethodPost, "/tax", nil)
+		req.Header.Set(authorizationHeader, "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		require.Equal(t, expected, rr.Code)
+	}
+}
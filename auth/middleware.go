@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/rezkam/TaxMan/internal/httputil"
+	"github.com/rezkam/TaxMan/internal/problem"
+	"github.com/rezkam/TaxMan/internal/requestctx"
+	"github.com/rezkam/TaxMan/model"
+)
+
+const (
+	authorizationHeader   = "Authorization"
+	apiKeyHeader          = "X-API-Key"
+	wwwAuthenticateHeader = "WWW-Authenticate"
+	bearerScheme          = "Bearer"
+)
+
+// adminPathPrefixes are the paths managing the API itself, which require the admin scope for every method
+// in every version of the API.
+var adminPathPrefixes = []string{"/api-keys", "/webhooks"}
+
+// Middleware authenticates the requests to next and rejects the ones lacking the scope they require.
+// Reads of the routes of mux listed in Config.PublicReads may be anonymous.
+// Credentials are read from the X-API-Key header or as a bearer token of the Authorization header,
+// bearer tokens that are not API keys are verified as JWTs when they are enabled.
+// The authenticated caller is recorded as the actor of the request.
+func (s *Service) Middleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		required := s.requiredScope(mux, r)
+
+		credential := credentialFromRequest(r)
+		if credential == "" {
+			if required != "" {
+				unauthorized(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := s.authenticate(r, credential)
+		if err != nil {
+			if !errors.Is(err, ErrUnauthenticated) {
+				slog.ErrorContext(r.Context(), "failed to authenticate request", "error", err)
+				problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternalError, "failed to authenticate request")
+				return
+			}
+			unauthorized(w, r)
+			return
+		}
+		if required != "" && !principal.HasScope(required) {
+			problem.Write(w, r, http.StatusForbidden, problem.CodeInsufficientScope, "the credentials lack the "+string(required)+" scope")
+			return
+		}
+
+		ctx := WithPrincipal(r.Context(), principal)
+		ctx = requestctx.WithActor(ctx, principal.Name)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticate resolves the principal of a credential.
+func (s *Service) authenticate(r *http.Request, credential string) (Principal, error) {
+	if IsAPIKey(credential) {
+		return s.AuthenticateAPIKey(r.Context(), credential)
+	}
+	return s.AuthenticateJWT(r.Context(), credential)
+}
+
+// requiredScope is the scope a request routed by mux requires, empty if it may be anonymous.
+func (s *Service) requiredScope(mux *http.ServeMux, r *http.Request) model.Scope {
+	path := httputil.UnversionedPath(r.URL.Path)
+	for _, prefix := range adminPathPrefixes {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return model.ScopeAdmin
+		}
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		if !s.config.RequireKeyForReads && s.publicRead(mux, r) {
+			return ""
+		}
+		return model.ScopeRead
+	default:
+		return model.ScopeWrite
+	}
+}
+
+// publicRead reports whether r is routed by mux to one of the routes of Config.PublicReads in any version.
+func (s *Service) publicRead(mux *http.ServeMux, r *http.Request) bool {
+	_, pattern := mux.Handler(r)
+	return pattern != "" && slices.Contains(s.config.PublicReads, httputil.UnversionedPattern(pattern))
+}
+
+// credentialFromRequest returns the credential of a request, or an empty string if it has none.
+func credentialFromRequest(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+	scheme, token, found := strings.Cut(r.Header.Get(authorizationHeader), " ")
+	if found && strings.EqualFold(scheme, bearerScheme) {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// unauthorized rejects a request without valid credentials.
+func unauthorized(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(wwwAuthenticateHeader, bearerScheme)
+	problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthenticated, ErrUnauthenticated.Error())
+}
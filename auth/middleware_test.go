@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rezkam/TaxMan/internal/requestctx"
+	"github.com/rezkam/TaxMan/model"
+	"github.com/stretchr/testify/require"
+)
+
+const testPublicRead = "GET /tax/{municipality}/{date}"
+
+func testConfig() Config {
+	return Config{MaxKeyNameLength: 50, MaxMunicipalityNameLength: 50, KeyIDURLPattern: "id", PublicReads: []string{testPublicRead}}
+}
+
+// testMux routes the reads of the tests, of which only the rate lookup is public.
+func testMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, pattern := range []string{testPublicRead, "GET /v1/tax/{municipality}/{date}", "GET /v2/tax/{municipality}/{date}",
+		"GET /tax/records/{id}/history", "GET /tax/drafts", "GET /tax/changes/stream", "GET /metrics"} {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {})
+	}
+	return mux
+}
+
+func TestMiddleware(t *testing.T) {
+	svc, err := New(memoryStore(), testConfig())
+	require.NoError(t, err)
+
+	_, readKey, err := svc.CreateAPIKey(context.Background(), model.APIKey{Name: "reader", Scopes: []model.Scope{model.ScopeRead}})
+	require.NoError(t, err)
+	_, writeKey, err := svc.CreateAPIKey(context.Background(), model.APIKey{Name: "writer", Scopes: []model.Scope{model.ScopeWrite}})
+	require.NoError(t, err)
+	_, adminKey, err := svc.CreateAPIKey(context.Background(), model.APIKey{Name: "admin", Scopes: []model.Scope{model.ScopeAdmin}})
+	require.NoError(t, err)
+
+	var actor string
+	handler := svc.Middleware(testMux(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor = requestctx.Actor(r.Context())
+	}))
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		header       string
+		key          string
+		expectedCode int
+	}{
+		{"anonymous read", http.MethodGet, "/tax/Copenhagen/2024-01-01", "", "", http.StatusOK},
+		{"anonymous write", http.MethodPost, "/tax", "", "", http.StatusUnauthorized},
+		{"unknown key", http.MethodPost, "/tax", apiKeyHeader, "tm_unknown", http.StatusUnauthorized},
+		{"malformed key", http.MethodGet, "/tax/Copenhagen/2024-01-01", apiKeyHeader, "not-a-key", http.StatusUnauthorized},
+		{"read key write", http.MethodPost, "/tax", apiKeyHeader, readKey, http.StatusForbidden},
+		{"write key write", http.MethodPost, "/tax", apiKeyHeader, writeKey, http.StatusOK},
+		{"bearer write key", http.MethodDelete, "/tax/records/1", authorizationHeader, "Bearer " + writeKey, http.StatusOK},
+		{"write key admin", http.MethodGet, "/api-keys", apiKeyHeader, writeKey, http.StatusForbidden},
+		{"write key webhooks", http.MethodPost, "/webhooks", apiKeyHeader, writeKey, http.StatusForbidden},
+		{"admin key admin", http.MethodGet, "/api-keys", apiKeyHeader, adminKey, http.StatusOK},
+		{"anonymous versioned admin", http.MethodGet, "/v1/api-keys", "", "", http.StatusUnauthorized},
+		{"write key versioned webhooks", http.MethodGet, "/v2/webhooks/1", apiKeyHeader, writeKey, http.StatusForbidden},
+		{"anonymous versioned read", http.MethodGet, "/v1/tax/Copenhagen/2024-01-01", "", "", http.StatusOK},
+		{"anonymous v2 read", http.MethodGet, "/v2/tax/Copenhagen/2024-01-01", "", "", http.StatusOK},
+		{"anonymous history", http.MethodGet, "/tax/records/1/history", "", "", http.StatusUnauthorized},
+		{"anonymous versioned history", http.MethodGet, "/v1/tax/records/1/history", "", "", http.StatusUnauthorized},
+		{"anonymous drafts", http.MethodGet, "/tax/drafts", "", "", http.StatusUnauthorized},
+		{"anonymous change stream", http.MethodGet, "/tax/changes/stream", "", "", http.StatusUnauthorized},
+		{"anonymous metrics", http.MethodGet, "/metrics", "", "", http.StatusUnauthorized},
+		{"anonymous unknown route", http.MethodGet, "/unknown", "", "", http.StatusUnauthorized},
+		{"read key history", http.MethodGet, "/tax/records/1/history", apiKeyHeader, readKey, http.StatusOK},
+		{"read key metrics", http.MethodGet, "/metrics", apiKeyHeader, readKey, http.StatusOK},
+		{"admin key write", http.MethodPost, "/tax", apiKeyHeader, adminKey, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.key)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			require.Equal(t, tt.expectedCode, rr.Code)
+			if rr.Code == http.StatusUnauthorized {
+				require.Equal(t, bearerScheme, rr.Header().Get(wwwAuthenticateHeader))
+				require.Contains(t, rr.Body.String(), `"code":"unauthenticated"`)
+			}
+			if rr.Code == http.StatusForbidden {
+				require.Contains(t, rr.Body.String(), `"code":"insufficient_scope"`)
+			}
+		})
+	}
+
+	t.Run("actor recorded", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/tax", nil)
+		req.Header.Set(apiKeyHeader, writeKey)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		require.Equal(t, "apikey:writer", actor)
+	})
+
+	t.Run("reads require a key when configured", func(t *testing.T) {
+		config := testConfig()
+		config.RequireKeyForReads = true
+		strict, err := New(svc.store, config)
+		require.NoError(t, err)
+		handler := strict.Middleware(testMux(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/tax/Copenhagen/2024-01-01", nil))
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+
+		req := httptest.NewRequest(http.MethodGet, "/tax/Copenhagen/2024-01-01", nil)
+		req.Header.Set(apiKeyHeader, readKey)
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+	})
+}
+
+func TestEnsureAPIKey(t *testing.T) {
+	store := memoryStore()
+	svc, err := New(store, testConfig())
+	require.NoError(t, err)
+
+	const secret = "tm_0123456789abcdefghijklmnop"
+	require.NoError(t, svc.EnsureAPIKey(context.Background(), "bootstrap", secret, []model.Scope{model.ScopeAdmin}))
+	// An existing key is not created again
+	store.createAPIKeyFunc = func(ctx context.Context, key model.APIKey, keyHash []byte) (model.APIKey, error) {
+		t.Fatal("key created twice")
+		return key, nil
+	}
+	require.NoError(t, svc.EnsureAPIKey(context.Background(), "bootstrap", secret, []model.Scope{model.ScopeAdmin}))
+
+	principal, err := svc.AuthenticateAPIKey(context.Background(), secret)
+	require.NoError(t, err)
+	require.True(t, principal.HasScope(model.ScopeWrite))
+
+	require.Error(t, svc.EnsureAPIKey(context.Background(), "bootstrap", "short", []model.Scope{model.ScopeAdmin}))
+}
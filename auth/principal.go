@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+	"slices"
+
+	"github.com/rezkam/TaxMan/model"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	// Name identifies the caller, it is recorded as the actor of the changes made by the request.
+	Name   string
+	Scopes []model.Scope
+	// Municipalities are the municipalities whose tax records the principal may change, empty means all.
+	Municipalities []string
+}
+
+// HasScope reports whether one of the principal's scopes grants the required scope.
+func (p Principal) HasScope(required model.Scope) bool {
+	for _, scope := range p.Scopes {
+		if scope.Grants(required) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanChangeMunicipality reports whether the principal may change the tax records of municipality.
+func (p Principal) CanChangeMunicipality(municipality string) bool {
+	return len(p.Municipalities) == 0 || slices.Contains(p.Municipalities, municipality)
+}
+
+type contextKey int
+
+const principalKey contextKey = iota
+
+// WithPrincipal returns a copy of ctx carrying the authenticated caller.
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+// PrincipalFromContext returns the authenticated caller stored in ctx, if any.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey).(Principal)
+	return principal, ok
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rezkam/TaxMan/model"
+)
+
+const (
+	// apiKeyPrefix starts every API key, so that keys are recognized among other bearer tokens and in leaked secrets.
+	apiKeyPrefix = "tm_"
+	// apiKeySize is the number of random bytes of an API key.
+	apiKeySize = 32
+	// displayedPrefixLength is how many characters of a key are kept to recognize it.
+	displayedPrefixLength = len(apiKeyPrefix) + 8
+	// apiKeyActorPrefix marks the actors of the changes made with API keys.
+	apiKeyActorPrefix = "apikey:"
+)
+
+var (
+	// ErrUnauthenticated is returned when a request carries no valid credentials.
+	ErrUnauthenticated = errors.New("invalid or missing credentials")
+)
+
+// Service authenticates the callers of the API and manages their API keys.
+type Service struct {
+	store  apiKeyStore
+	config Config
+}
+
+type Config struct {
+	// PublicReads are the patterns of the routes, without version prefix, such as "GET /tax/{municipality}/{date}",
+	// whose reads are anonymous unless RequireKeyForReads is set. The other reads require the read scope.
+	PublicReads []string
+	// RequireKeyForReads makes the reads of PublicReads require a key with the read scope too.
+	RequireKeyForReads bool
+	// MaxKeyNameLength is the maximum length of the name of an API key.
+	MaxKeyNameLength int
+	// MaxMunicipalityNameLength is the maximum length of the municipalities an API key is restricted to.
+	MaxMunicipalityNameLength int
+	// KeyIDURLPattern is the pattern used to extract the ID of an API key from a URL.
+	KeyIDURLPattern string
+	// JWT enables bearer tokens issued by an identity provider next to API keys, nil disables them.
+	JWT *JWTConfig
+	// MaxRequestBodySize is the maximum size in bytes of request bodies.
+	// This value is optional and defaults to jsonutils.DefaultMaxBodySize.
+	MaxRequestBodySize int64
+}
+
+type apiKeyStore interface {
+	// CreateAPIKey stores a new API key identified by the hash of its secret.
+	CreateAPIKey(ctx context.Context, key model.APIKey, keyHash []byte) (model.APIKey, error)
+
+	// GetActiveAPIKeyByHash retrieves the active API key whose secret has the given hash.
+	GetActiveAPIKeyByHash(ctx context.Context, keyHash []byte) (model.APIKey, error)
+
+	// ListAPIKeys retrieves all API keys including the revoked ones.
+	ListAPIKeys(ctx context.Context) ([]model.APIKey, error)
+
+	// RevokeAPIKey revokes an API key.
+	RevokeAPIKey(ctx context.Context, keyID int64) error
+}
+
+// New creates a new Service with the provided store and configuration.
+func New(store apiKeyStore, config Config) (*Service, error) {
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+	return &Service{store: store, config: config}, nil
+}
+
+// validateConfig checks if the provided Config values are valid.
+func validateConfig(config Config) error {
+	if config.MaxKeyNameLength <= 0 {
+		return errors.New("MaxKeyNameLength must be greater than 0")
+	}
+	if config.MaxMunicipalityNameLength <= 0 {
+		return errors.New("MaxMunicipalityNameLength must be greater than 0")
+	}
+	if config.KeyIDURLPattern == "" {
+		return errors.New("KeyIDURLPattern cannot be empty")
+	}
+	if config.MaxRequestBodySize < 0 {
+		return errors.New("MaxRequestBodySize cannot be negative")
+	}
+	if config.JWT != nil {
+		return validateJWTConfig(config.JWT)
+	}
+	return nil
+}
+
+// CreateAPIKey generates a new API key from the name, scopes and municipalities of key, and returns it along with
+// its secret, which is not stored and cannot be retrieved later.
+func (s *Service) CreateAPIKey(ctx context.Context, key model.APIKey) (model.APIKey, string, error) {
+	random := make([]byte, apiKeySize)
+	if _, err := rand.Read(random); err != nil {
+		return model.APIKey{}, "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	secret := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(random)
+
+	key.Prefix = secret[:displayedPrefixLength]
+	created, err := s.store.CreateAPIKey(ctx, key, hashAPIKey(secret))
+	if err != nil {
+		return model.APIKey{}, "", err
+	}
+	return created, secret, nil
+}
+
+// EnsureAPIKey stores the given secret as an API key unless it is already an active key.
+// It is used to bootstrap the first admin key from the configuration.
+func (s *Service) EnsureAPIKey(ctx context.Context, name, secret string, scopes []model.Scope) error {
+	if !IsAPIKey(secret) || len(secret) < displayedPrefixLength+apiKeySize/2 {
+		return fmt.Errorf("api key must start with %q and be at least %d characters long", apiKeyPrefix, displayedPrefixLength+apiKeySize/2)
+	}
+	_, err := s.store.GetActiveAPIKeyByHash(ctx, hashAPIKey(secret))
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, model.ErrNotFound) {
+		return err
+	}
+	_, err = s.store.CreateAPIKey(ctx, model.APIKey{
+		Name:   name,
+		Prefix: secret[:displayedPrefixLength],
+		Scopes: scopes,
+	}, hashAPIKey(secret))
+	return err
+}
+
+// AuthenticateAPIKey returns the principal of an active API key, or ErrUnauthenticated if the key is unknown or revoked.
+func (s *Service) AuthenticateAPIKey(ctx context.Context, secret string) (Principal, error) {
+	key, err := s.store.GetActiveAPIKeyByHash(ctx, hashAPIKey(secret))
+	if err != nil {
+		if errors.Is(err, model.ErrNotFound) {
+			return Principal{}, ErrUnauthenticated
+		}
+		return Principal{}, err
+	}
+	return Principal{Name: apiKeyActorPrefix + key.Name, Scopes: key.Scopes, Municipalities: key.Municipalities}, nil
+}
+
+// IsAPIKey reports whether a credential has the format of an API key.
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, apiKeyPrefix)
+}
+
+// hashAPIKey hashes the secret of an API key for storage and lookup.
+// Keys are long random strings, so a fast unsalted hash is enough to protect them and keeps lookups indexable.
+func hashAPIKey(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/rezkam/TaxMan/model"
+)
+
+type mockStore struct {
+	createAPIKeyFunc          func(ctx context.Context, key model.APIKey, keyHash []byte) (model.APIKey, error)
+	getActiveAPIKeyByHashFunc func(ctx context.Context, keyHash []byte) (model.APIKey, error)
+	listAPIKeysFunc           func(ctx context.Context) ([]model.APIKey, error)
+	revokeAPIKeyFunc          func(ctx context.Context, keyID int64) error
+}
+
+func (m *mockStore) CreateAPIKey(ctx context.Context, key model.APIKey, keyHash []byte) (model.APIKey, error) {
+	if m.createAPIKeyFunc != nil {
+		return m.createAPIKeyFunc(ctx, key, keyHash)
+	}
+	key.ID = 1
+	return key, nil
+}
+
+func (m *mockStore) GetActiveAPIKeyByHash(ctx context.Context, keyHash []byte) (model.APIKey, error) {
+	if m.getActiveAPIKeyByHashFunc != nil {
+		return m.getActiveAPIKeyByHashFunc(ctx, keyHash)
+	}
+	return model.APIKey{}, model.ErrNotFound
+}
+
+func (m *mockStore) ListAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	if m.listAPIKeysFunc != nil {
+		return m.listAPIKeysFunc(ctx)
+	}
+	return nil, nil
+}
+
+func (m *mockStore) RevokeAPIKey(ctx context.Context, keyID int64) error {
+	if m.revokeAPIKeyFunc != nil {
+		return m.revokeAPIKeyFunc(ctx, keyID)
+	}
+	return nil
+}
+
+// memoryStore returns a mockStore keeping created keys in memory, so that they can authenticate.
+func memoryStore() *mockStore {
+	keys := map[string]model.APIKey{}
+	return &mockStore{
+		createAPIKeyFunc: func(ctx context.Context, key model.APIKey, keyHash []byte) (model.APIKey, error) {
+			key.ID = int64(len(keys) + 1)
+			keys[string(keyHash)] = key
+			return key, nil
+		},
+		getActiveAPIKeyByHashFunc: func(ctx context.Context, keyHash []byte) (model.APIKey, error) {
+			key, ok := keys[string(keyHash)]
+			if !ok {
+				return model.APIKey{}, model.ErrNotFound
+			}
+			return key, nil
+		},
+	}
+}
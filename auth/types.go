@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/rezkam/TaxMan/model"
+)
+
+// CreateAPIKeyRequest is the request type for creating an API key.
+type CreateAPIKeyRequest struct {
+	Name   string        `json:"name"`
+	Scopes []model.Scope `json:"scopes"`
+	// Municipalities optionally restricts the changes made with the key to the records of these municipalities.
+	Municipalities []string `json:"municipalities,omitempty"`
+}
+
+// APIKeyResponse is the response type for an API key.
+// The key itself is only returned when it is created.
+type APIKeyResponse struct {
+	ID             int64         `json:"id"`
+	Name           string        `json:"name"`
+	Prefix         string        `json:"prefix"`
+	Scopes         []model.Scope `json:"scopes"`
+	Municipalities []string      `json:"municipalities,omitempty"`
+	Key            string        `json:"key,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+	RevokedAt      *time.Time    `json:"revoked_at,omitempty"`
+}
+
+// ListAPIKeysResponse is the response type for listing API keys.
+type ListAPIKeysResponse struct {
+	Keys []APIKeyResponse `json:"keys"`
+}
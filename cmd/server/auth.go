@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rezkam/TaxMan/auth"
+	"github.com/rezkam/TaxMan/internal/constants"
+	"github.com/rezkam/TaxMan/internal/routes"
+	"github.com/rezkam/TaxMan/model"
+	"github.com/rezkam/TaxMan/store"
+)
+
+const (
+	// requireKeyForReadsKey is the key for the environment variable making rate lookups require an API key like
+	// the other read requests.
+	requireKeyForReadsKey = "REQUIRE_KEY_FOR_READS"
+	// bootstrapAPIKeyKey is the key for the environment variable holding an admin API key created at startup,
+	// used to create the other keys of a new deployment.
+	bootstrapAPIKeyKey = "BOOTSTRAP_API_KEY"
+	// bootstrapAPIKeyName is the name of the API key created from BOOTSTRAP_API_KEY.
+	bootstrapAPIKeyName = "bootstrap"
+	// maxAPIKeyNameLength is the maximum length of the name of an API key.
+	maxAPIKeyNameLength = 100
+	// jwksKey is the key for the environment variable holding the URL or file of the JWKS verifying bearer tokens.
+	// Bearer tokens other than API keys are rejected when it is not set.
+	jwksKey = "JWT_JWKS"
+	// jwtIssuerKey is the key for the environment variable holding the required issuer of bearer tokens.
+	jwtIssuerKey = "JWT_ISSUER"
+	// jwtAudienceKey is the key for the environment variable holding the required audience of bearer tokens.
+	jwtAudienceKey = "JWT_AUDIENCE"
+	// jwtRolesClaimKey is the key for the environment variable naming the claim holding the roles of the caller.
+	jwtRolesClaimKey = "JWT_ROLES_CLAIM"
+	// defaultJWTRolesClaim is the default claim holding the roles of the caller.
+	defaultJWTRolesClaim = "roles"
+	// jwtRoleScopesKey is the key for the environment variable mapping roles to scopes, such as "tax-editor=write,tax-admin=admin".
+	jwtRoleScopesKey = "JWT_ROLE_SCOPES"
+	// jwtMunicipalitiesClaimKey is the key for the environment variable naming the claim holding the municipalities
+	// whose tax records the caller may change.
+	jwtMunicipalitiesClaimKey = "JWT_MUNICIPALITIES_CLAIM"
+	// jwksCacheTTLKey is the key for the environment variable holding how long the keys of the JWKS are cached.
+	jwksCacheTTLKey = "JWKS_CACHE_TTL"
+	// defaultJWKSCacheTTL is the default time the keys of the JWKS are cached for.
+	defaultJWKSCacheTTL = time.Hour
+	// jwksMinRefreshInterval limits how often tokens signed with unknown keys reload the JWKS.
+	jwksMinRefreshInterval = time.Minute
+	// jwtLeeway is the clock skew tolerated when checking the expiry of bearer tokens.
+	jwtLeeway = 30 * time.Second
+)
+
+func NewAuthService(store *store.PostgresStore) (*auth.Service, error) {
+	requireKeyForReads, err := boolFromEnv(requireKeyForReadsKey, false)
+	if err != nil {
+		return nil, err
+	}
+	jwtConfig, err := jwtConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	maxRequestBodySize, err := intFromEnv(maxRequestBodySizeKey, defaultMaxRequestBodySize)
+	if err != nil {
+		return nil, err
+	}
+	svc, err := auth.New(store, auth.Config{
+		PublicReads:               []string{routes.TaxRatePattern},
+		RequireKeyForReads:        requireKeyForReads,
+		MaxKeyNameLength:          maxAPIKeyNameLength,
+		MaxMunicipalityNameLength: maxMunicipalityNameLength,
+		KeyIDURLPattern:           constants.APIKeyIDURLPattern,
+		JWT:                       jwtConfig,
+		MaxRequestBodySize:        int64(maxRequestBodySize),
+	})
+	if err != nil {
+		slog.Error("failed to create auth service", "error", err)
+		return nil, err
+	}
+
+	if bootstrapKey := os.Getenv(bootstrapAPIKeyKey); bootstrapKey != "" {
+		err := svc.EnsureAPIKey(context.Background(), bootstrapAPIKeyName, bootstrapKey, []model.Scope{model.ScopeAdmin})
+		if err != nil {
+			slog.Error("failed to create bootstrap api key", "key", bootstrapAPIKeyKey, "error", err)
+			return nil, err
+		}
+	}
+	return svc, nil
+}
+
+// jwtConfigFromEnv reads the verification of bearer tokens from the environment, nil when no JWKS is configured.
+func jwtConfigFromEnv() (*auth.JWTConfig, error) {
+	jwks := os.Getenv(jwksKey)
+	if jwks == "" {
+		return nil, nil
+	}
+	ttl, err := durationFromEnv(jwksCacheTTLKey, defaultJWKSCacheTTL)
+	if err != nil {
+		return nil, err
+	}
+	roleScopes, err := roleScopesFromEnv(jwtRoleScopesKey)
+	if err != nil {
+		return nil, err
+	}
+	rolesClaim := os.Getenv(jwtRolesClaimKey)
+	if rolesClaim == "" {
+		rolesClaim = defaultJWTRolesClaim
+	}
+	return &auth.JWTConfig{
+		KeySet:              auth.NewJWKS(jwks, ttl, jwksMinRefreshInterval),
+		Issuer:              os.Getenv(jwtIssuerKey),
+		Audience:            os.Getenv(jwtAudienceKey),
+		RolesClaim:          rolesClaim,
+		RoleScopes:          roleScopes,
+		MunicipalitiesClaim: os.Getenv(jwtMunicipalitiesClaimKey),
+		Leeway:              jwtLeeway,
+	}, nil
+}
+
+// roleScopesFromEnv reads a mapping of roles to scopes such as "tax-editor=write,tax-admin=admin"
+// from the environment variable key.
+func roleScopesFromEnv(key string) (map[string]model.Scope, error) {
+	roleScopes := map[string]model.Scope{}
+	value := os.Getenv(key)
+	if value == "" {
+		return roleScopes, nil
+	}
+	for _, mapping := range strings.Split(value, ",") {
+		role, scope, found := strings.Cut(mapping, "=")
+		role, scope = strings.TrimSpace(role), strings.TrimSpace(scope)
+		if !found || role == "" || !slices.Contains(model.ValidScopes, model.Scope(scope)) {
+			slog.Error("invalid role mapping", "key", key, "value", mapping)
+			return nil, fmt.Errorf("invalid role mapping in %s: %q", key, mapping)
+		}
+		roleScopes[role] = model.Scope(scope)
+	}
+	return roleScopes, nil
+}
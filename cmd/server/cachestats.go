@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/rezkam/TaxMan/taxservice"
+	"go.uber.org/fx"
+)
+
+// cacheStatsInterval is how often the usage of the rate lookup cache is logged.
+const cacheStatsInterval = 5 * time.Minute
+
+// CacheStatsReporter periodically logs the hits and misses of the rate lookup cache.
+type CacheStatsReporter struct {
+	taxService *taxservice.Service
+}
+
+// NewCacheStatsReporter creates the reporter and runs it for the lifetime of the application.
+func NewCacheStatsReporter(lc fx.Lifecycle, taxService *taxservice.Service) *CacheStatsReporter {
+	reporter := &CacheStatsReporter{taxService: taxService}
+	runPeriodically(lc, "cache-stats", cacheStatsInterval, reporter.report)
+	return reporter
+}
+
+// report logs the cumulative cache usage since the application started.
+func (r *CacheStatsReporter) report(context.Context) {
+	stats := r.taxService.RateCacheStats()
+	slog.Info("rate cache stats", "hits", stats.Hits, "misses", stats.Misses, "entries", stats.Entries)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/rezkam/TaxMan/health"
+	"github.com/rezkam/TaxMan/store"
+)
+
+const (
+	// readinessCheckTimeout bounds the readiness check of the store.
+	readinessCheckTimeout = 2 * time.Second
+	// drainDelayKey is the key for the environment variable holding how long the server keeps serving
+	// after failing its readiness probe on shutdown.
+	drainDelayKey = "DRAIN_DELAY"
+	// defaultDrainDelay gives load balancers probing every few seconds time to stop routing traffic.
+	defaultDrainDelay = 5 * time.Second
+)
+
+// NewHealthChecker creates the checker answering the liveness and readiness probes.
+func NewHealthChecker(postgresStore *store.PostgresStore) (*health.Checker, error) {
+	drainDelay, err := nonNegativeDurationFromEnv(drainDelayKey, defaultDrainDelay)
+	if err != nil {
+		return nil, err
+	}
+	checker, err := health.New(postgresStore, health.Config{
+		CheckTimeout: readinessCheckTimeout,
+		DrainDelay:   drainDelay,
+	})
+	if err != nil {
+		slog.Error("failed to create health checker", "error", err)
+		return nil, err
+	}
+	return checker, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/rezkam/TaxMan/store"
+	"github.com/rezkam/TaxMan/taxservice"
+	"go.uber.org/fx"
+)
+
+// listenRetryInterval is how long to wait before listening again after the listener failed.
+const listenRetryInterval = 5 * time.Second
+
+// CacheInvalidator evicts the cached rate lookups of the municipalities changed by any instance.
+type CacheInvalidator struct {
+	store      *store.PostgresStore
+	taxService *taxservice.Service
+}
+
+// NewCacheInvalidator creates the invalidator and listens for changes for the lifetime of the application.
+func NewCacheInvalidator(lc fx.Lifecycle, postgresStore *store.PostgresStore, taxService *taxservice.Service) *CacheInvalidator {
+	invalidator := &CacheInvalidator{store: postgresStore, taxService: taxService}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			slog.Info("Starting tax record change listener")
+			go func() {
+				defer close(done)
+				invalidator.listen(ctx)
+			}()
+			return nil
+		},
+		OnStop: func(stopCtx context.Context) error {
+			slog.Info("Stopping tax record change listener")
+			cancel()
+			select {
+			case <-done:
+				return nil
+			case <-stopCtx.Done():
+				return stopCtx.Err()
+			}
+		},
+	})
+	return invalidator
+}
+
+// listen evicts changed municipalities until ctx is done, listening again if the listener fails.
+func (i *CacheInvalidator) listen(ctx context.Context) {
+	for {
+		err := i.store.ListenTaxRecordChanges(ctx, i.taxService.InvalidateTaxRates, i.taxService.InvalidateAllTaxRates)
+		if err == nil {
+			return
+		}
+		slog.Error("tax record change listener failed", "error", err, "retryIn", listenRetryInterval)
+		// Changes may be missed until listening again
+		i.taxService.InvalidateAllTaxRates()
+		select {
+		case <-time.After(listenRetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// runPeriodically runs task every interval, starting right away, for the lifetime of the application.
+// The task is cancelled and awaited when the application stops.
+func runPeriodically(lc fx.Lifecycle, name string, interval time.Duration, task func(context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			slog.Info("Starting background job", "job", name, "interval", interval)
+			go func() {
+				defer close(done)
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				for {
+					task(ctx)
+					select {
+					case <-ticker.C:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+			return nil
+		},
+		OnStop: func(stopCtx context.Context) error {
+			slog.Info("Stopping background job", "job", name)
+			cancel()
+			select {
+			case <-done:
+				return nil
+			case <-stopCtx.Done():
+				return stopCtx.Err()
+			}
+		},
+	})
+}
+
+// durationFromEnv reads a duration such as "720h" from the environment variable key,
+// falling back to defaultValue when it is not set.
+func durationFromEnv(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		slog.Error("invalid duration", "key", key, "value", value)
+		return 0, fmt.Errorf("invalid duration in %s: %q", key, value)
+	}
+	return duration, nil
+}
+
+// nonNegativeDurationFromEnv reads a duration like durationFromEnv, also accepting 0 to turn off what it configures.
+func nonNegativeDurationFromEnv(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		slog.Error("invalid duration", "key", key, "value", value)
+		return 0, fmt.Errorf("invalid duration in %s: %q", key, value)
+	}
+	return duration, nil
+}
+
+// boolFromEnv reads a boolean such as "true" from the environment variable key,
+// falling back to defaultValue when it is not set.
+func boolFromEnv(key string, defaultValue bool) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Error("invalid boolean", "key", key, "value", value)
+		return false, fmt.Errorf("invalid boolean in %s: %q", key, value)
+	}
+	return parsed, nil
+}
+
+// intFromEnv reads a non-negative integer from the environment variable key,
+// falling back to defaultValue when it is not set.
+func intFromEnv(key string, defaultValue int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		slog.Error("invalid integer", "key", key, "value", value)
+		return 0, fmt.Errorf("invalid integer in %s: %q", key, value)
+	}
+	return parsed, nil
+}
@@ -3,16 +3,27 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"go.uber.org/fx/fxevent"
 	"log/slog"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/rezkam/TaxMan/accesslog"
+	"github.com/rezkam/TaxMan/auth"
+	"github.com/rezkam/TaxMan/health"
 	"github.com/rezkam/TaxMan/internal/constants"
+	"github.com/rezkam/TaxMan/internal/requestctx"
 	"github.com/rezkam/TaxMan/internal/routes"
+	"github.com/rezkam/TaxMan/metrics"
+	"github.com/rezkam/TaxMan/openapi"
+	"github.com/rezkam/TaxMan/ratelimit"
 	"github.com/rezkam/TaxMan/store"
 	"github.com/rezkam/TaxMan/taxservice"
+	"github.com/rezkam/TaxMan/webhook"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/fx"
 )
 
@@ -27,6 +38,24 @@ const (
 	maxMunicipalityNameLength = 100
 	// databaseURLKey is the key for the DATABASE_URL environment variable.
 	databaseURLKey = "DATABASE_URL"
+	// requireApprovalKey is the key for the environment variable enabling the approval workflow for tax record changes.
+	requireApprovalKey = "REQUIRE_APPROVAL"
+	// cacheControlPoliciesKey is the key for the environment variable setting the Cache-Control policies of rate lookups.
+	cacheControlPoliciesKey = "CACHE_CONTROL_POLICIES"
+	// defaultCacheControlPolicies revalidates lookups of recent dates and lets clients cache older dates for longer.
+	defaultCacheControlPolicies = "0s=no-cache;24h=public, max-age=3600;8760h=public, max-age=86400"
+	// rateCacheSizeKey is the key for the environment variable holding the maximum number of cached rate lookups.
+	rateCacheSizeKey = "RATE_CACHE_SIZE"
+	// defaultRateCacheSize is the default maximum number of cached rate lookups.
+	defaultRateCacheSize = 10000
+	// rateCacheTTLKey is the key for the environment variable holding how long rate lookups are cached.
+	rateCacheTTLKey = "RATE_CACHE_TTL"
+	// defaultRateCacheTTL is the default time rate lookups are cached for.
+	defaultRateCacheTTL = 5 * time.Minute
+	// maxRequestBodySizeKey is the key for the environment variable holding the maximum size in bytes of request bodies.
+	maxRequestBodySizeKey = "MAX_REQUEST_BODY_SIZE"
+	// defaultMaxRequestBodySize is the default maximum size in bytes of request bodies.
+	defaultMaxRequestBodySize = 1 << 20
 	// defaultLogLevel is the default log level for the application.
 	defaultLogLevel = slog.LevelInfo
 )
@@ -44,10 +73,26 @@ func main() {
 			NewJSONLogger,
 			NewPostgresStore,
 			NewTaxService,
+			NewAuthService,
 			NewHTTPServer,
 			NewServeMux,
+			NewRetentionJob,
+			NewDraftScheduler,
+			NewCacheStatsReporter,
+			NewCacheInvalidator,
+			NewWebhookService,
+			NewWebhookDispatcher,
+			NewOutboxPublisher,
+			NewOutboxDispatcher,
+			NewRateLimiter,
+			NewMetrics,
+			NewTracerProvider,
+			NewHealthChecker,
+			NewOpenAPIValidator,
 		),
-		fx.Invoke(func(s *http.Server) {}),
+		fx.Invoke(func(s *http.Server, j *RetentionJob, d *DraftScheduler, c *CacheStatsReporter, i *CacheInvalidator,
+			w *WebhookDispatcher, o *OutboxDispatcher) {
+		}),
 	)
 
 	// run the application
@@ -56,12 +101,12 @@ func main() {
 }
 
 // NewJSONLogger creates a new JSON logger and sets it as the default logger.
-// This also sets the default log level.
+// This also sets the default log level. Records logged with the context of a request carry its ID.
 func NewJSONLogger() *slog.Logger {
-	handler := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
+	handler := requestctx.NewLogHandler(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
 		Level:     defaultLogLevel,
 		AddSource: true,
-	})
+	}))
 	logger := slog.New(handler).With("app", "TaxMan")
 	slog.SetDefault(logger)
 	return logger
@@ -94,11 +139,37 @@ func NewPostgresStore(lc fx.Lifecycle) (*store.PostgresStore, error) {
 }
 
 func NewTaxService(store *store.PostgresStore) (*taxservice.Service, error) {
+	requireApproval, err := boolFromEnv(requireApprovalKey, false)
+	if err != nil {
+		return nil, err
+	}
+	cacheControlPolicies, err := cacheControlPoliciesFromEnv(cacheControlPoliciesKey, defaultCacheControlPolicies)
+	if err != nil {
+		return nil, err
+	}
+	rateCacheSize, err := intFromEnv(rateCacheSizeKey, defaultRateCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	rateCacheTTL, err := durationFromEnv(rateCacheTTLKey, defaultRateCacheTTL)
+	if err != nil {
+		return nil, err
+	}
+	maxRequestBodySize, err := intFromEnv(maxRequestBodySizeKey, defaultMaxRequestBodySize)
+	if err != nil {
+		return nil, err
+	}
 	svc, err := taxservice.New(store, taxservice.Config{
 		MaxMunicipalityNameLength: maxMunicipalityNameLength,
 		MunicipalityURLPattern:    constants.MunicipalityURLPattern,
 		DateURLPattern:            constants.DateURLPattern,
+		RecordIDURLPattern:        constants.RecordIDURLPattern,
 		DefaultTaxRate:            &defaultTaxRate,
+		CacheControlPolicies:      cacheControlPolicies,
+		RateCacheSize:             rateCacheSize,
+		RateCacheTTL:              rateCacheTTL,
+		RequireApproval:           requireApproval,
+		MaxRequestBodySize:        int64(maxRequestBodySize),
 	})
 	if err != nil {
 		slog.Error("failed to create tax service", "error", err)
@@ -107,7 +178,29 @@ func NewTaxService(store *store.PostgresStore) (*taxservice.Service, error) {
 	return svc, nil
 }
 
-func NewHTTPServer(lc fx.Lifecycle, mux *http.ServeMux, logger *slog.Logger) *http.Server {
+// cacheControlPoliciesFromEnv reads Cache-Control policies such as "0s=no-cache;24h=public, max-age=3600"
+// from the environment variable key, falling back to defaultValue when it is not set.
+// Each policy maps the minimum age of the queried date to the Cache-Control header sent for it.
+func cacheControlPoliciesFromEnv(key string, defaultValue string) ([]taxservice.CacheControlPolicy, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		value = defaultValue
+	}
+	var policies []taxservice.CacheControlPolicy
+	for _, policy := range strings.Split(value, ";") {
+		minAge, cacheControl, found := strings.Cut(policy, "=")
+		age, err := time.ParseDuration(strings.TrimSpace(minAge))
+		if !found || err != nil || strings.TrimSpace(cacheControl) == "" {
+			slog.Error("invalid cache control policy", "key", key, "value", policy)
+			return nil, fmt.Errorf("invalid cache control policy in %s: %q", key, policy)
+		}
+		policies = append(policies, taxservice.CacheControlPolicy{MinAge: age, CacheControl: strings.TrimSpace(cacheControl)})
+	}
+	return policies, nil
+}
+
+func NewHTTPServer(lc fx.Lifecycle, handler http.Handler, checker *health.Checker, taxService *taxservice.Service,
+	logger *slog.Logger) *http.Server {
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = defaultHTTPPort
@@ -116,11 +209,13 @@ func NewHTTPServer(lc fx.Lifecycle, mux *http.ServeMux, logger *slog.Logger) *ht
 	// Create an HTTP server with read and write timeouts
 	httpServer := &http.Server{
 		Addr:         ":" + port,
-		Handler:      mux,
+		Handler:      handler,
 		ReadTimeout:  httpReadTimeout,
 		WriteTimeout: httpWriteTimeout,
 		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
 	}
+	// Shutdown waits for the requests in flight, change streams only end when told to
+	httpServer.RegisterOnShutdown(taxService.CloseChangeStreams)
 
 	lc.Append(fx.Hook{
 		// Add a hook to schedule the start the server after dependencies are available
@@ -133,8 +228,10 @@ func NewHTTPServer(lc fx.Lifecycle, mux *http.ServeMux, logger *slog.Logger) *ht
 			}()
 			return nil
 		},
-		// Add a hook to schedule the shutdown of the server when the application is stopped
+		// Add a hook to schedule the shutdown of the server when the application is stopped,
+		// failing the readiness probe first so that load balancers drain the traffic
 		OnStop: func(ctx context.Context) error {
+			checker.Drain(ctx)
 			slog.Info("Stopping HTTP server")
 			return httpServer.Shutdown(ctx)
 		},
@@ -142,8 +239,27 @@ func NewHTTPServer(lc fx.Lifecycle, mux *http.ServeMux, logger *slog.Logger) *ht
 	return httpServer
 }
 
-func NewServeMux(taxService *taxservice.Service) *http.ServeMux {
+// NewServeMux routes the requests to the services, tracing, identifying and logging, measuring, limiting the failed
+// authentications of each IP address, authenticating, rate limiting and validating them against the OpenAPI
+// specification first.
+// The health probes and the API documentation are answered directly, so that they neither require credentials
+// nor consume rate limits.
+func NewServeMux(taxService *taxservice.Service, webhookService *webhook.Service, authService *auth.Service,
+	limiter *ratelimit.Limiter, m *metrics.Metrics, tracerProvider trace.TracerProvider, checker *health.Checker,
+	validator *openapi.Validator) http.Handler {
 	mux := http.NewServeMux()
-	routes.SetupTaxRoutes(taxService, mux)
-	return mux
+	registered := &routes.PatternRecorder{Mux: mux}
+	routes.SetupVersionedRoutes(taxService, webhookService, authService, registered)
+	routes.SetupMetricsRoutes(m, registered)
+	for _, pattern := range limiter.UnmatchedRoutes(registered.Patterns) {
+		slog.Warn("rate limit does not apply to any route", "key", rateLimitsKey, "pattern", pattern)
+	}
+	handler := accesslog.Middleware(mux, m.Middleware(mux, limiter.AuthFailureMiddleware(
+		authService.Middleware(mux, limiter.Middleware(mux, validator.Middleware(mux))))))
+
+	probes := http.NewServeMux()
+	routes.SetupHealthRoutes(checker, probes)
+	routes.SetupDocsRoutes(probes)
+	probes.Handle("/", traceRequests(mux, handler, tracerProvider))
+	return probes
 }
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"github.com/rezkam/TaxMan/metrics"
+	"github.com/rezkam/TaxMan/store"
+	"github.com/rezkam/TaxMan/taxservice"
+)
+
+// NewMetrics creates the metrics of the application, observing the store and the tax service.
+func NewMetrics(postgresStore *store.PostgresStore, taxService *taxservice.Service) *metrics.Metrics {
+	m := metrics.New()
+	postgresStore.SetStatementObserver(m.ObserveStatement)
+	m.RegisterDBStats(postgresStore.Stats)
+	m.RegisterTaxService(taxService)
+	return m
+}
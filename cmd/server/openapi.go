@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/rezkam/TaxMan/docs"
+	"github.com/rezkam/TaxMan/internal/routes"
+	"github.com/rezkam/TaxMan/openapi"
+)
+
+const (
+	// validateRequestsKey is the key for the environment variable enabling the validation of requests
+	// against the OpenAPI specification.
+	validateRequestsKey = "OPENAPI_VALIDATE_REQUESTS"
+	// validateResponsesKey is the key for the environment variable enabling the validation of responses
+	// against the OpenAPI specification.
+	validateResponsesKey = "OPENAPI_VALIDATE_RESPONSES"
+)
+
+// NewOpenAPIValidator creates the validator of the requests and responses against the embedded OpenAPI specification.
+func NewOpenAPIValidator() (*openapi.Validator, error) {
+	validateRequests, err := boolFromEnv(validateRequestsKey, false)
+	if err != nil {
+		return nil, err
+	}
+	validateResponses, err := boolFromEnv(validateResponsesKey, false)
+	if err != nil {
+		return nil, err
+	}
+	maxRequestBodySize, err := intFromEnv(maxRequestBodySizeKey, defaultMaxRequestBodySize)
+	if err != nil {
+		return nil, err
+	}
+	validator, err := openapi.New(docs.OpenAPI, openapi.Config{
+		ValidateRequests:   validateRequests,
+		ValidateResponses:  validateResponses,
+		MaxRequestBodySize: int64(maxRequestBodySize),
+		// The routes without version prefix are the deprecated aliases of v1
+		AliasPrefix: routes.V1Prefix,
+		// v2 serves the routes of v1 whose responses did not change in v2
+		InheritedPrefixes: map[string]string{routes.V2Prefix: routes.V1Prefix},
+	})
+	if err != nil {
+		slog.Error("failed to create OpenAPI validator", "error", err)
+		return nil, err
+	}
+	return validator, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/rezkam/TaxMan/outbox"
+	"github.com/rezkam/TaxMan/store"
+	"go.uber.org/fx"
+)
+
+const (
+	// outboxPublisherKey is the key for the environment variable selecting the publisher of the outbox: log, webhook or file.
+	outboxPublisherKey = "OUTBOX_PUBLISHER"
+	// defaultOutboxPublisher is the publisher of the outbox when none is configured.
+	defaultOutboxPublisher = "log"
+	// outboxWebhookURLKey is the key for the environment variable holding the URL the webhook publisher posts to.
+	outboxWebhookURLKey = "OUTBOX_WEBHOOK_URL"
+	// outboxWebhookSecretKey is the key for the environment variable holding the secret the webhook publisher signs with.
+	outboxWebhookSecretKey = "OUTBOX_WEBHOOK_SECRET"
+	// outboxFilePathKey is the key for the environment variable holding the file the file publisher appends to.
+	outboxFilePathKey = "OUTBOX_FILE_PATH"
+	// outboxWebhookTimeout is how long the receiver of the webhook publisher has to answer.
+	outboxWebhookTimeout = 10 * time.Second
+	// outboxDispatchInterval is how often the outbox is checked for events to publish.
+	outboxDispatchInterval = 2 * time.Second
+	// outboxBatchSize is the maximum number of events published by one dispatch.
+	outboxBatchSize = 100
+	// outboxLease is how long claimed events are hidden from other instances. A batch outliving it
+	// is published twice in part, which at-least-once delivery allows.
+	outboxLease = 5 * time.Minute
+	// outboxInitialBackoff is the delay after the first failed attempt to publish an event.
+	outboxInitialBackoff = 5 * time.Second
+	// outboxMaxBackoff caps the delay between two attempts to publish an event.
+	outboxMaxBackoff = 10 * time.Minute
+)
+
+// NewOutboxPublisher creates the publisher of the outbox selected by the environment.
+func NewOutboxPublisher(lc fx.Lifecycle, logger *slog.Logger) (outbox.Publisher, error) {
+	kind := os.Getenv(outboxPublisherKey)
+	if kind == "" {
+		kind = defaultOutboxPublisher
+	}
+
+	switch kind {
+	case "log":
+		return outbox.NewLogPublisher(logger), nil
+	case "webhook":
+		url := os.Getenv(outboxWebhookURLKey)
+		if url == "" {
+			slog.Error("outbox webhook URL not set", "key", outboxWebhookURLKey)
+			return nil, fmt.Errorf("%s must be set for the webhook publisher", outboxWebhookURLKey)
+		}
+		// Signatures made with an empty key would authenticate nothing
+		secret := os.Getenv(outboxWebhookSecretKey)
+		if secret == "" {
+			slog.Error("outbox webhook secret not set", "key", outboxWebhookSecretKey)
+			return nil, fmt.Errorf("%s must be set for the webhook publisher", outboxWebhookSecretKey)
+		}
+		return outbox.NewWebhookPublisher(url, secret, outboxWebhookTimeout), nil
+	case "file":
+		path := os.Getenv(outboxFilePathKey)
+		if path == "" {
+			slog.Error("outbox file path not set", "key", outboxFilePathKey)
+			return nil, fmt.Errorf("%s must be set for the file publisher", outboxFilePathKey)
+		}
+		publisher, err := outbox.NewFilePublisher(path)
+		if err != nil {
+			return nil, err
+		}
+		// Hooks run in reverse order on stop, so the dispatcher using the file is stopped first
+		lc.Append(fx.Hook{
+			OnStop: func(context.Context) error {
+				return publisher.Close()
+			},
+		})
+		return publisher, nil
+	default:
+		slog.Error("invalid outbox publisher", "key", outboxPublisherKey, "value", kind)
+		return nil, fmt.Errorf("invalid publisher in %s: %q", outboxPublisherKey, kind)
+	}
+}
+
+// OutboxDispatcher periodically publishes the changes of tax records waiting in the outbox.
+type OutboxDispatcher struct {
+	dispatcher *outbox.Dispatcher
+}
+
+// NewOutboxDispatcher creates the outbox dispatcher and runs it for the lifetime of the application.
+func NewOutboxDispatcher(lc fx.Lifecycle, postgresStore *store.PostgresStore, publisher outbox.Publisher) (*OutboxDispatcher, error) {
+	dispatcher, err := outbox.NewDispatcher(postgresStore, publisher, outbox.Config{
+		BatchSize:      outboxBatchSize,
+		Lease:          outboxLease,
+		InitialBackoff: outboxInitialBackoff,
+		MaxBackoff:     outboxMaxBackoff,
+	})
+	if err != nil {
+		slog.Error("failed to create outbox dispatcher", "error", err)
+		return nil, err
+	}
+	job := &OutboxDispatcher{dispatcher: dispatcher}
+	runPeriodically(lc, "outbox-dispatcher", outboxDispatchInterval, job.dispatch)
+	return job, nil
+}
+
+// dispatch publishes the events of the outbox that are due.
+func (o *OutboxDispatcher) dispatch(ctx context.Context) {
+	published, err := o.dispatcher.Dispatch(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("failed to dispatch outbox events", "error", err)
+		}
+		return
+	}
+	if published > 0 {
+		slog.Info("published outbox events", "count", published)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rezkam/TaxMan/ratelimit"
+	"github.com/rezkam/TaxMan/store"
+	"go.uber.org/fx"
+)
+
+const (
+	// rateLimitsKey is the key for the environment variable holding the rate limits of the clients.
+	rateLimitsKey = "RATE_LIMITS"
+	// defaultRateLimits lets each client make 20 requests per second in bursts of up to 40 requests.
+	defaultRateLimits = "default=20:40"
+	// defaultRateLimitRoute is the route of the rate limit applying to the requests without a limit of their own.
+	defaultRateLimitRoute = "default"
+	// authFailureLimitKey is the key for the environment variable holding the limit of authentication failures per IP address.
+	authFailureLimitKey = "AUTH_FAILURE_LIMIT"
+	// defaultAuthFailureLimit lets each IP address fail to authenticate 20 times in a row, and once every 5 seconds after.
+	defaultAuthFailureLimit = "0.2:20"
+	// dailyQuotaKey is the key for the environment variable holding the number of requests a client may make per day.
+	dailyQuotaKey = "DAILY_QUOTA"
+	// rateLimitPruneInterval is how often the idle buckets and the quota usage of previous days are removed.
+	rateLimitPruneInterval = time.Minute
+)
+
+// NewRateLimiter creates the rate limiter of the clients and prunes its state for the lifetime of the application.
+func NewRateLimiter(lc fx.Lifecycle, postgresStore *store.PostgresStore) (*ratelimit.Limiter, error) {
+	config, err := rateLimitsFromEnv(rateLimitsKey, defaultRateLimits)
+	if err != nil {
+		return nil, err
+	}
+	dailyQuota, err := intFromEnv(dailyQuotaKey, 0)
+	if err != nil {
+		return nil, err
+	}
+	config.DailyQuota = int64(dailyQuota)
+	config.AuthFailures, err = limitFromEnv(authFailureLimitKey, defaultAuthFailureLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	limiter, err := ratelimit.New(postgresStore, config)
+	if err != nil {
+		slog.Error("failed to create rate limiter", "error", err)
+		return nil, err
+	}
+	runPeriodically(lc, "rate-limit-prune", rateLimitPruneInterval, func(ctx context.Context) {
+		limiter.PruneBuckets()
+		if _, err := limiter.PurgeQuotaUsage(ctx); err != nil {
+			slog.Error("failed to purge quota usage", "error", err)
+		}
+	})
+	return limiter, nil
+}
+
+// rateLimitsFromEnv reads rate limits such as "default=20:40;GET /tax/{municipality}/{date}=5:10"
+// from the environment variable key, falling back to defaultValue when it is not set.
+// Each limit maps a route pattern, or "default" for the other routes, to the requests per second and burst
+// allowed to a client. Patterns are written without a version prefix, such as /v1, and limit the route
+// in every version of the API. A rate of 0 does not limit the route.
+func rateLimitsFromEnv(key string, defaultValue string) (ratelimit.Config, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		value = defaultValue
+	}
+	var config ratelimit.Config
+	for _, limit := range strings.Split(value, ";") {
+		separator := strings.LastIndex(limit, "=")
+		if separator < 0 {
+			slog.Error("invalid rate limit", "key", key, "value", limit)
+			return ratelimit.Config{}, fmt.Errorf("invalid rate limit in %s: %q", key, limit)
+		}
+		route := strings.TrimSpace(limit[:separator])
+		parsed, ok := parseLimit(limit[separator+1:])
+		if route == "" || !ok {
+			slog.Error("invalid rate limit", "key", key, "value", limit)
+			return ratelimit.Config{}, fmt.Errorf("invalid rate limit in %s: %q", key, limit)
+		}
+
+		if route == defaultRateLimitRoute {
+			config.Default = parsed
+			continue
+		}
+		config.Routes = append(config.Routes, ratelimit.RouteLimit{Pattern: route, Limit: parsed})
+	}
+	return config, nil
+}
+
+// limitFromEnv reads a rate limit such as "0.2:20" from the environment variable key,
+// falling back to defaultValue when it is not set.
+func limitFromEnv(key string, defaultValue string) (ratelimit.Limit, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		value = defaultValue
+	}
+	limit, ok := parseLimit(value)
+	if !ok {
+		slog.Error("invalid rate limit", "key", key, "value", value)
+		return ratelimit.Limit{}, fmt.Errorf("invalid rate limit in %s: %q", key, value)
+	}
+	return limit, nil
+}
+
+// parseLimit parses a rate limit of the form "<rate>:<burst>" and reports whether it is well-formed.
+func parseLimit(value string) (ratelimit.Limit, bool) {
+	rate, burst, found := strings.Cut(value, ":")
+	parsedRate, rateErr := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+	parsedBurst, burstErr := strconv.Atoi(strings.TrimSpace(burst))
+	if !found || rateErr != nil || burstErr != nil {
+		return ratelimit.Limit{}, false
+	}
+	return ratelimit.Limit{Rate: parsedRate, Burst: parsedBurst}, true
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/rezkam/TaxMan/store"
+	"go.uber.org/fx"
+)
+
+const (
+	// softDeleteRetentionKey is the key for the environment variable holding how long soft-deleted records are kept.
+	softDeleteRetentionKey = "SOFT_DELETE_RETENTION"
+	// defaultSoftDeleteRetention is how long soft-deleted records are kept before they are purged.
+	defaultSoftDeleteRetention = 90 * 24 * time.Hour
+	// retentionInterval is how often soft-deleted records are checked for purging.
+	retentionInterval = time.Hour
+)
+
+// RetentionJob periodically purges soft-deleted tax records older than the configured retention.
+type RetentionJob struct {
+	store     *store.PostgresStore
+	retention time.Duration
+}
+
+// NewRetentionJob creates the retention job and runs it for the lifetime of the application.
+func NewRetentionJob(lc fx.Lifecycle, postgresStore *store.PostgresStore) (*RetentionJob, error) {
+	retention, err := durationFromEnv(softDeleteRetentionKey, defaultSoftDeleteRetention)
+	if err != nil {
+		return nil, err
+	}
+	job := &RetentionJob{store: postgresStore, retention: retention}
+	runPeriodically(lc, "retention", retentionInterval, job.purge)
+	return job, nil
+}
+
+// purge removes the soft-deleted records whose retention has expired.
+func (j *RetentionJob) purge(ctx context.Context) {
+	purged, err := j.store.PurgeDeletedTaxRecords(ctx, j.retention)
+	if err != nil {
+		slog.Error("failed to purge deleted tax records", "error", err)
+		return
+	}
+	if purged > 0 {
+		slog.Info("purged deleted tax records", "count", purged, "retention", j.retention)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/rezkam/TaxMan/internal/requestctx"
+	"github.com/rezkam/TaxMan/taxservice"
+	"go.uber.org/fx"
+)
+
+const (
+	// schedulerInterval is how often approved drafts are checked for publishing.
+	schedulerInterval = time.Minute
+	// schedulerActor is the actor recorded for the records published by the scheduler.
+	schedulerActor = "scheduler"
+)
+
+// DraftScheduler periodically publishes the approved drafts whose scheduled time has passed.
+type DraftScheduler struct {
+	taxService *taxservice.Service
+}
+
+// NewDraftScheduler creates the draft scheduler and runs it for the lifetime of the application.
+func NewDraftScheduler(lc fx.Lifecycle, taxService *taxservice.Service) *DraftScheduler {
+	scheduler := &DraftScheduler{taxService: taxService}
+	runPeriodically(lc, "draft-scheduler", schedulerInterval, scheduler.publishDue)
+	return scheduler
+}
+
+// publishDue publishes the approved drafts that are due.
+func (d *DraftScheduler) publishDue(ctx context.Context) {
+	ctx = requestctx.WithActor(ctx, schedulerActor)
+	published, err := d.taxService.PublishDueTaxRecordDrafts(ctx, time.Now())
+	if err != nil {
+		slog.Error("failed to publish scheduled tax record drafts", "error", err)
+		return
+	}
+	if published > 0 {
+		slog.Info("published scheduled tax record drafts", "count", published)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/fx"
+)
+
+const (
+	// tracesExporterKey is the key for the environment variable choosing where traces are exported:
+	// "otlp", "stdout" or "none".
+	tracesExporterKey = "OTEL_TRACES_EXPORTER"
+	// defaultTracesExporter disables tracing unless an exporter is configured.
+	defaultTracesExporter = "none"
+	// serviceName identifies the traces of the application, OTEL_SERVICE_NAME overrides it.
+	serviceName = "taxman"
+)
+
+// NewTracerProvider creates the tracer provider exporting the traces of the application, and installs it along with
+// the W3C trace context propagator as the global tracer provider and propagator.
+// Spans still buffered are exported when the application stops.
+func NewTracerProvider(lc fx.Lifecycle) (trace.TracerProvider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	exporterName := os.Getenv(tracesExporterKey)
+	if exporterName == "" {
+		exporterName = defaultTracesExporter
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch exporterName {
+	case "none":
+		return noop.NewTracerProvider(), nil
+	case "otlp":
+		// The endpoint and headers are read from the OTEL_EXPORTER_OTLP_* environment variables
+		exporter, err = otlptracehttp.New(context.Background())
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	default:
+		slog.Error("invalid traces exporter", "key", tracesExporterKey, "value", exporterName)
+		return nil, fmt.Errorf("invalid traces exporter in %s: %q", tracesExporterKey, exporterName)
+	}
+	if err != nil {
+		slog.Error("failed to create traces exporter", "exporter", exporterName, "error", err)
+		return nil, err
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		slog.Error("failed to create trace resource", "error", err)
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			slog.Info("flushing traces")
+			return provider.Shutdown(ctx)
+		},
+	})
+	return provider, nil
+}
+
+// traceRequests starts a span for every request to next, continuing the trace of the caller's traceparent header.
+// Spans are named after the method and route pattern of mux the requests are routed to.
+func traceRequests(mux *http.ServeMux, next http.Handler, provider trace.TracerProvider) http.Handler {
+	return otelhttp.NewHandler(next, "http.server",
+		otelhttp.WithTracerProvider(provider),
+		otelhttp.WithPropagators(otel.GetTextMapPropagator()),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			if _, pattern := mux.Handler(r); pattern != "" {
+				return pattern
+			}
+			return r.Method + " unmatched"
+		}),
+	)
+}
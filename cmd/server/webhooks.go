@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/rezkam/TaxMan/internal/constants"
+	"github.com/rezkam/TaxMan/store"
+	"github.com/rezkam/TaxMan/webhook"
+	"go.uber.org/fx"
+)
+
+const (
+	// webhookMaxAttemptsKey is the key for the environment variable holding how often a notification is attempted.
+	webhookMaxAttemptsKey = "WEBHOOK_MAX_ATTEMPTS"
+	// defaultWebhookMaxAttempts is how often a notification is attempted before it is moved to the dead letters.
+	defaultWebhookMaxAttempts = 8
+	// webhookInitialBackoffKey is the key for the environment variable holding the delay after the first failed attempt.
+	webhookInitialBackoffKey = "WEBHOOK_INITIAL_BACKOFF"
+	// defaultWebhookInitialBackoff is the default delay after the first failed attempt of a notification.
+	defaultWebhookInitialBackoff = 10 * time.Second
+	// webhookMaxBackoffKey is the key for the environment variable capping the delay between attempts.
+	webhookMaxBackoffKey = "WEBHOOK_MAX_BACKOFF"
+	// defaultWebhookMaxBackoff is the default cap of the delay between two attempts of a notification.
+	defaultWebhookMaxBackoff = time.Hour
+	// webhookTimeoutKey is the key for the environment variable holding how long receivers have to answer.
+	webhookTimeoutKey = "WEBHOOK_TIMEOUT"
+	// defaultWebhookTimeout is the default time receivers have to answer a notification.
+	defaultWebhookTimeout = 10 * time.Second
+	// webhookBatchSize is the maximum number of notifications sent by one dispatch.
+	webhookBatchSize = 100
+	// webhookDispatchInterval is how often new changes are fanned out and due notifications are sent.
+	webhookDispatchInterval = 5 * time.Second
+)
+
+func NewWebhookService(store *store.PostgresStore) (*webhook.Service, error) {
+	maxAttempts, err := intFromEnv(webhookMaxAttemptsKey, defaultWebhookMaxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	initialBackoff, err := durationFromEnv(webhookInitialBackoffKey, defaultWebhookInitialBackoff)
+	if err != nil {
+		return nil, err
+	}
+	maxBackoff, err := durationFromEnv(webhookMaxBackoffKey, defaultWebhookMaxBackoff)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := durationFromEnv(webhookTimeoutKey, defaultWebhookTimeout)
+	if err != nil {
+		return nil, err
+	}
+	maxRequestBodySize, err := intFromEnv(maxRequestBodySizeKey, defaultMaxRequestBodySize)
+	if err != nil {
+		return nil, err
+	}
+	svc, err := webhook.New(store, webhook.Config{
+		MaxMunicipalityNameLength: maxMunicipalityNameLength,
+		SubscriptionIDURLPattern:  constants.SubscriptionIDURLPattern,
+		MaxAttempts:               maxAttempts,
+		InitialBackoff:            initialBackoff,
+		MaxBackoff:                maxBackoff,
+		DeliveryTimeout:           timeout,
+		BatchSize:                 webhookBatchSize,
+		MaxRequestBodySize:        int64(maxRequestBodySize),
+	})
+	if err != nil {
+		slog.Error("failed to create webhook service", "error", err)
+		return nil, err
+	}
+	return svc, nil
+}
+
+// WebhookDispatcher periodically notifies the webhook subscriptions of the changes of tax records.
+type WebhookDispatcher struct {
+	webhookService *webhook.Service
+}
+
+// NewWebhookDispatcher creates the dispatcher and runs it for the lifetime of the application.
+func NewWebhookDispatcher(lc fx.Lifecycle, webhookService *webhook.Service) *WebhookDispatcher {
+	dispatcher := &WebhookDispatcher{webhookService: webhookService}
+	runPeriodically(lc, "webhook-dispatcher", webhookDispatchInterval, dispatcher.dispatch)
+	return dispatcher
+}
+
+// dispatch sends the notifications that are due.
+func (d *WebhookDispatcher) dispatch(ctx context.Context) {
+	delivered, err := d.webhookService.DispatchWebhooks(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("failed to dispatch webhooks", "error", err)
+		}
+		return
+	}
+	if delivered > 0 {
+		slog.Info("delivered webhook notifications", "count", delivered)
+	}
+}
@@ -0,0 +1,14 @@
+// Package docs embeds the API documentation in the server.
+package docs
+
+import _ "embed"
+
+// OpenAPI is the OpenAPI specification of the API.
+//
+//go:embed openapi.yaml
+var OpenAPI []byte
+
+// UI is the page rendering OpenAPI, in which the server inlines the specification.
+//
+//go:embed index.html
+var UI []byte
@@ -0,0 +1,108 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rezkam/TaxMan/internal/jsonutils"
+)
+
+const (
+	statusOK          = "ok"
+	statusReady       = "ready"
+	statusUnavailable = "unavailable"
+	statusDraining    = "draining"
+)
+
+// Checker answers the liveness and readiness probes of the application.
+// The application is live as long as it answers, and ready while its store is ready and it is not draining.
+type Checker struct {
+	store    readinessStore
+	config   Config
+	draining atomic.Bool
+}
+
+type Config struct {
+	// CheckTimeout bounds the readiness check of the store.
+	CheckTimeout time.Duration
+	// DrainDelay is how long the application keeps serving once it reports not being ready,
+	// giving load balancers time to stop routing traffic to it before it shuts down.
+	DrainDelay time.Duration
+}
+
+type readinessStore interface {
+	// Ready returns an error unless the store can serve requests.
+	Ready(ctx context.Context) error
+}
+
+// StatusResponse is the body of the probe responses.
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+// New creates a new Checker with the provided store and configuration.
+func New(store readinessStore, config Config) (*Checker, error) {
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+	return &Checker{store: store, config: config}, nil
+}
+
+// validateConfig checks if the provided Config values are valid.
+func validateConfig(config Config) error {
+	if config.CheckTimeout <= 0 {
+		return errors.New("check timeout must be greater than 0")
+	}
+	if config.DrainDelay < 0 {
+		return errors.New("drain delay must not be negative")
+	}
+	return nil
+}
+
+// LivenessHandler reports that the process is alive.
+func (c *Checker) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	respond(w, statusOK, http.StatusOK)
+}
+
+// ReadinessHandler reports whether the application is ready to serve requests.
+// It fails once the application is draining, or while the store is not ready.
+func (c *Checker) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	if c.draining.Load() {
+		respond(w, statusDraining, http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), c.config.CheckTimeout)
+	defer cancel()
+	if err := c.store.Ready(ctx); err != nil {
+		slog.WarnContext(ctx, "store is not ready", "error", err)
+		respond(w, statusUnavailable, http.StatusServiceUnavailable)
+		return
+	}
+	respond(w, statusReady, http.StatusOK)
+}
+
+// Drain fails the readiness probe from now on and waits for the drain delay, or until ctx is done,
+// so that load balancers stop routing traffic before the application stops serving.
+func (c *Checker) Drain(ctx context.Context) {
+	if c.draining.Swap(true) || c.config.DrainDelay == 0 {
+		return
+	}
+	slog.Info("draining traffic before shutdown", "delay", c.config.DrainDelay)
+	timer := time.NewTimer(c.config.DrainDelay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// respond writes the status of a probe, which must never be cached.
+func respond(w http.ResponseWriter, status string, code int) {
+	w.Header().Set("Cache-Control", "no-store")
+	jsonutils.JsonResponse(w, StatusResponse{Status: status}, code)
+}
@@ -0,0 +1,94 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testConfig = Config{
+	CheckTimeout: time.Second,
+	DrainDelay:   50 * time.Millisecond,
+}
+
+func probe(t *testing.T, handler http.HandlerFunc) (int, string) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+	var response StatusResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	return rr.Code, response.Status
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(&mockStore{}, Config{})
+	require.Error(t, err)
+	_, err = New(&mockStore{}, Config{CheckTimeout: time.Second, DrainDelay: -time.Second})
+	require.Error(t, err)
+	_, err = New(&mockStore{}, Config{CheckTimeout: time.Second})
+	require.NoError(t, err)
+}
+
+func TestReadinessHandler(t *testing.T) {
+	var storeErr error
+	var deadlineSet bool
+	checker, err := New(&mockStore{readyFunc: func(ctx context.Context) error {
+		_, deadlineSet = ctx.Deadline()
+		return storeErr
+	}}, testConfig)
+	require.NoError(t, err)
+
+	code, status := probe(t, checker.ReadinessHandler)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, statusReady, status)
+	require.True(t, deadlineSet)
+
+	storeErr = errors.New("connection refused")
+	code, status = probe(t, checker.ReadinessHandler)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, statusUnavailable, status)
+
+	code, status = probe(t, checker.LivenessHandler)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, statusOK, status)
+}
+
+func TestDrain(t *testing.T) {
+	checker, err := New(&mockStore{}, testConfig)
+	require.NoError(t, err)
+
+	start := time.Now()
+	checker.Drain(context.Background())
+	require.GreaterOrEqual(t, time.Since(start), testConfig.DrainDelay)
+
+	code, status := probe(t, checker.ReadinessHandler)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, statusDraining, status)
+
+	code, status = probe(t, checker.LivenessHandler)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, statusOK, status)
+
+	start = time.Now()
+	checker.Drain(context.Background())
+	require.Less(t, time.Since(start), testConfig.DrainDelay, "draining again does not wait")
+}
+
+func TestDrainCanceled(t *testing.T) {
+	checker, err := New(&mockStore{}, Config{CheckTimeout: time.Second, DrainDelay: time.Hour})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	checker.Drain(ctx)
+
+	code, _ := probe(t, checker.ReadinessHandler)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+}
@@ -0,0 +1,16 @@
+package health
+
+import (
+	"context"
+)
+
+type mockStore struct {
+	readyFunc func(ctx context.Context) error
+}
+
+func (m *mockStore) Ready(ctx context.Context) error {
+	if m.readyFunc != nil {
+		return m.readyFunc(ctx)
+	}
+	return nil
+}
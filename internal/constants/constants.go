@@ -5,4 +5,10 @@ const (
 	DateURLPattern = "date"
 	// MunicipalityURLPattern is the pattern for the municipality name in the URL.
 	MunicipalityURLPattern = "municipality"
+	// RecordIDURLPattern is the pattern for the ID of a tax record in the URL.
+	RecordIDURLPattern = "id"
+	// SubscriptionIDURLPattern is the pattern for the ID of a webhook subscription in the URL.
+	SubscriptionIDURLPattern = "id"
+	// APIKeyIDURLPattern is the pattern for the ID of an API key in the URL.
+	APIKeyIDURLPattern = "id"
 )
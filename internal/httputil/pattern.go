@@ -0,0 +1,26 @@
+package httputil
+
+import (
+	"regexp"
+	"strings"
+)
+
+// apiVersionPrefix matches the prefix of the paths of the versions of the API, such as /v1.
+var apiVersionPrefix = regexp.MustCompile(`^/v[0-9]+(/|$)`)
+
+// UnversionedPath strips the version prefix of the API, such as /v1, from path.
+func UnversionedPath(path string) string {
+	if loc := apiVersionPrefix.FindStringIndex(path); loc != nil {
+		return "/" + path[loc[1]:]
+	}
+	return path
+}
+
+// UnversionedPattern strips the version prefix of the API from the path of a route pattern such as
+// "GET /v1/tax/{municipality}/{date}", so that a route is identified alike in every version and in its aliases.
+func UnversionedPattern(pattern string) string {
+	if method, path, found := strings.Cut(pattern, " "); found {
+		return method + " " + UnversionedPath(path)
+	}
+	return UnversionedPath(pattern)
+}
@@ -0,0 +1,23 @@
+package httputil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnversionedPattern(t *testing.T) {
+	testCases := map[string]string{
+		"GET /v1/tax/{municipality}/{date}": "GET /tax/{municipality}/{date}",
+		"GET /v2/tax/{municipality}/{date}": "GET /tax/{municipality}/{date}",
+		"GET /tax/{municipality}/{date}":    "GET /tax/{municipality}/{date}",
+		"POST /v12":                         "POST /",
+		"/v1/webhooks/":                     "/webhooks/",
+		"GET /vintage/{id}":                 "GET /vintage/{id}",
+	}
+	for pattern, expected := range testCases {
+		t.Run(pattern, func(t *testing.T) {
+			require.Equal(t, expected, UnversionedPattern(pattern))
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package httputil
+
+import (
+	"io"
+	"net/http"
+)
+
+// ResponseRecorder records the status code and the size of the response written by a handler,
+// for the middlewares logging, measuring or validating responses.
+type ResponseRecorder struct {
+	http.ResponseWriter
+	// Body receives a copy of the body written, when set.
+	Body        io.Writer
+	status      int
+	size        int
+	wroteHeader bool
+}
+
+// NewResponseRecorder creates a ResponseRecorder writing to w.
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
+	return &ResponseRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+// Status returns the status code of the response, http.StatusOK if the handler did not write one.
+func (r *ResponseRecorder) Status() int {
+	return r.status
+}
+
+// Size returns the number of bytes of the body written.
+func (r *ResponseRecorder) Size() int {
+	return r.size
+}
+
+func (r *ResponseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *ResponseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	if r.Body != nil {
+		r.Body.Write(b[:n])
+	}
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the flushing of the underlying writer, which streams rely on.
+func (r *ResponseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
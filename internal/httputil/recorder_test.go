@@ -0,0 +1,45 @@
+package httputil
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseRecorder(t *testing.T) {
+	t.Run("implicit status", func(t *testing.T) {
+		recorder := NewResponseRecorder(httptest.NewRecorder())
+		_, err := recorder.Write([]byte("hello"))
+		require.NoError(t, err)
+		// Writing a status after the body does not change the status sent
+		recorder.WriteHeader(http.StatusInternalServerError)
+
+		require.Equal(t, http.StatusOK, recorder.Status())
+		require.Equal(t, 5, recorder.Size())
+	})
+
+	t.Run("first status and body copy", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		var body bytes.Buffer
+		recorder := NewResponseRecorder(rr)
+		recorder.Body = &body
+
+		recorder.WriteHeader(http.StatusNotFound)
+		recorder.WriteHeader(http.StatusOK)
+		_, err := recorder.Write([]byte("missing"))
+		require.NoError(t, err)
+
+		require.Equal(t, http.StatusNotFound, recorder.Status())
+		require.Equal(t, "missing", body.String())
+		require.Equal(t, "missing", rr.Body.String())
+	})
+
+	t.Run("flushes the underlying writer", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		require.NoError(t, http.NewResponseController(NewResponseRecorder(rr)).Flush())
+		require.True(t, rr.Flushed)
+	})
+}
@@ -0,0 +1,76 @@
+package jsonutils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxBodySize is the maximum size in bytes of request bodies when none is configured.
+const DefaultMaxBodySize = 1 << 20
+
+const jsonMediaType = "application/json"
+
+var (
+	// ErrUnsupportedMediaType is returned for request bodies that are not declared as JSON.
+	ErrUnsupportedMediaType = errors.New("content type must be " + jsonMediaType)
+	// ErrBodyTooLarge is returned for request bodies exceeding the maximum size.
+	ErrBodyTooLarge = errors.New("request body is too large")
+)
+
+// DecodeRequest decodes the body of r, which must be a single JSON object of at most maxSize bytes, into dst.
+// A maxSize of 0 applies DefaultMaxBodySize. Bodies not declared as JSON fail with ErrUnsupportedMediaType,
+// larger ones with ErrBodyTooLarge, and bodies with unknown fields, trailing data or invalid JSON
+// with an error describing the problem.
+func DecodeRequest(w http.ResponseWriter, r *http.Request, dst any, maxSize int64) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != jsonMediaType {
+		return ErrUnsupportedMediaType
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxBodySize
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(dst); err != nil {
+		return decodeError(err)
+	}
+	if err := decoder.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return decodeError(err)
+		}
+		return errors.New("request body must contain a single json object")
+	}
+	return nil
+}
+
+// decodeError describes the failure to decode a request body without exposing the types of the decoded value.
+func decodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return fmt.Errorf("%w, the limit is %d bytes", ErrBodyTooLarge, maxBytesErr.Limit)
+	case errors.Is(err, io.EOF):
+		return errors.New("request body is empty")
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("malformed json at offset %d", syntaxErr.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return errors.New("malformed json")
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return fmt.Errorf("invalid type of field %q", typeErr.Field)
+	case errors.As(err, &typeErr):
+		return errors.New("request body must be a json object")
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return errors.New(strings.TrimPrefix(err.Error(), "json: "))
+	default:
+		return errors.New("invalid json input")
+	}
+}
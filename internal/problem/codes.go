@@ -0,0 +1,64 @@
+package problem
+
+// Code identifies a kind of failure, clients may rely on it not changing.
+type Code string
+
+// Codes of the failures of any request.
+const (
+	CodeInvalidRequest           Code = "invalid_request"
+	CodeValidationFailed         Code = "validation_failed"
+	CodeInvalidJSON              Code = "invalid_json"
+	CodeUnsupportedMediaType     Code = "unsupported_media_type"
+	CodeBodyTooLarge             Code = "body_too_large"
+	CodeInternalError            Code = "internal_error"
+	CodeUnauthenticated          Code = "unauthenticated"
+	CodeInsufficientScope        Code = "insufficient_scope"
+	CodeMunicipalityNotPermitted Code = "municipality_not_permitted"
+	CodeRateLimitExceeded        Code = "rate_limit_exceeded"
+	CodeDailyQuotaExceeded       Code = "daily_quota_exceeded"
+	CodeInvalidID                Code = "invalid_id"
+)
+
+// Codes of the failures of tax rates, tax records and their drafts.
+const (
+	CodeMunicipalityRequired Code = "municipality_required"
+	CodeMunicipalityTooLong  Code = "municipality_too_long"
+	CodeMunicipalityReserved Code = "municipality_reserved"
+	CodeInvalidTaxRate       Code = "invalid_tax_rate"
+	CodeDateRequired         Code = "date_required"
+	CodeInvalidDate          Code = "invalid_date"
+	CodeInvalidPeriodType    Code = "invalid_period_type"
+	CodeInvalidAsOf          Code = "invalid_as_of"
+	CodeInvalidIfMatch       Code = "invalid_if_match"
+	CodeIfMatchRequired      Code = "if_match_required"
+	CodeInvalidLastEventID   Code = "invalid_last_event_id"
+	CodeInvalidPublishAt     Code = "invalid_publish_at"
+	CodeInvalidDraftStatus   Code = "invalid_draft_status"
+	CodeTaxRateNotFound      Code = "tax_rate_not_found"
+	CodeTaxRecordNotFound    Code = "tax_record_not_found"
+	CodeTaxRecordModified    Code = "tax_record_modified"
+	CodeTaxRecordConflict    Code = "tax_record_conflict"
+	CodeApprovalRequired     Code = "approval_required"
+	CodeDraftNotFound        Code = "draft_not_found"
+	CodeInvalidDraftState    Code = "invalid_draft_state"
+	CodeDraftNotDue          Code = "draft_not_due"
+	CodeApproverRequired     Code = "approver_required"
+	CodeSelfApproval         Code = "self_approval"
+)
+
+// Codes of the failures of API keys.
+const (
+	CodeNameRequired    Code = "name_required"
+	CodeNameTooLong     Code = "name_too_long"
+	CodeScopesRequired  Code = "scopes_required"
+	CodeInvalidScope    Code = "invalid_scope"
+	CodeAPIKeyNameTaken Code = "api_key_name_taken"
+	CodeAPIKeyNotFound  Code = "api_key_not_found"
+)
+
+// Codes of the failures of webhook subscriptions.
+const (
+	CodeInvalidURL           Code = "invalid_url"
+	CodeInvalidLimit         Code = "invalid_limit"
+	CodeSubscriptionNotFound Code = "subscription_not_found"
+)
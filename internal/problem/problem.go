@@ -0,0 +1,142 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/rezkam/TaxMan/internal/jsonutils"
+	"github.com/rezkam/TaxMan/internal/requestctx"
+)
+
+// contentType is the media type of RFC 7807 problem details.
+const contentType = "application/problem+json"
+
+// typePrefix prefixes the code of a problem to form its type URI.
+const typePrefix = "urn:taxman:problem:"
+
+// Problem is an RFC 7807 problem details response. Code identifies the kind of failure for clients,
+// it never changes for a kind of failure while Detail is meant for humans and may.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      Code         `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is a validation failure of a field of a request, it is returned by the converters validating requests.
+// Field is the name of the field in the body, the path, the query or the header of the request.
+type FieldError struct {
+	Field  string `json:"field"`
+	Code   Code   `json:"code"`
+	Detail string `json:"detail"`
+}
+
+// Invalid returns the validation failure of a field.
+func Invalid(field string, code Code, detail string) *FieldError {
+	return &FieldError{Field: field, Code: code, Detail: detail}
+}
+
+func (e *FieldError) Error() string {
+	return e.Detail
+}
+
+// FieldErrors are the validation failures of several fields of a request, collected to report them at once.
+type FieldErrors []FieldError
+
+func (e FieldErrors) Error() string {
+	details := make([]string, 0, len(e))
+	for _, fieldErr := range e {
+		details = append(details, fieldErr.Detail)
+	}
+	return strings.Join(details, "; ")
+}
+
+// Add collects the validation failures of err, it does nothing if err is nil.
+// Errors that are not field errors are collected without a field.
+func (e *FieldErrors) Add(err error) {
+	var fieldErrs FieldErrors
+	var fieldErr *FieldError
+	switch {
+	case err == nil:
+	case errors.As(err, &fieldErrs):
+		*e = append(*e, fieldErrs...)
+	case errors.As(err, &fieldErr):
+		*e = append(*e, *fieldErr)
+	default:
+		*e = append(*e, FieldError{Code: CodeInvalidRequest, Detail: err.Error()})
+	}
+}
+
+// Err returns the collected validation failures as an error, nil if there are none.
+func (e FieldErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Write writes a problem with the status and code of a failure along with the ID of the request.
+func Write(w http.ResponseWriter, r *http.Request, status int, code Code, detail string) {
+	write(w, r, Problem{Status: status, Code: code, Detail: detail})
+}
+
+// WriteInvalid writes the 400 problem of a request failing validation with err.
+// Field errors are detailed in the errors of the problem. The problem has the code of the invalid field
+// when a single field is invalid, and the validation_failed code when several are.
+func WriteInvalid(w http.ResponseWriter, r *http.Request, err error) {
+	var fieldErrs FieldErrors
+	if errors.As(err, &fieldErrs) && len(fieldErrs) > 1 {
+		write(w, r, Problem{
+			Status: http.StatusBadRequest,
+			Code:   CodeValidationFailed,
+			Detail: fieldErrs.Error(),
+			Errors: fieldErrs,
+		})
+		return
+	}
+	if len(fieldErrs) == 1 {
+		err = &fieldErrs[0]
+	}
+
+	var fieldErr *FieldError
+	if errors.As(err, &fieldErr) {
+		write(w, r, Problem{
+			Status: http.StatusBadRequest,
+			Code:   fieldErr.Code,
+			Detail: fieldErr.Detail,
+			Errors: []FieldError{*fieldErr},
+		})
+		return
+	}
+	Write(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+}
+
+// WriteDecodeError writes the problem of a request whose body failed to decode with err,
+// as returned by jsonutils.DecodeRequest.
+func WriteDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, jsonutils.ErrUnsupportedMediaType):
+		Write(w, r, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, err.Error())
+	case errors.Is(err, jsonutils.ErrBodyTooLarge):
+		Write(w, r, http.StatusRequestEntityTooLarge, CodeBodyTooLarge, err.Error())
+	default:
+		Write(w, r, http.StatusBadRequest, CodeInvalidJSON, err.Error())
+	}
+}
+
+func write(w http.ResponseWriter, r *http.Request, p Problem) {
+	p.Type = typePrefix + string(p.Code)
+	p.Title = http.StatusText(p.Status)
+	p.Instance = r.URL.Path
+	p.RequestID = requestctx.RequestID(r.Context())
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
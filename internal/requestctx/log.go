@@ -0,0 +1,34 @@
+package requestctx
+
+import (
+	"context"
+	"log/slog"
+)
+
+// requestIDLogKey is the attribute holding the request ID in log records.
+const requestIDLogKey = "request_id"
+
+// LogHandler adds the ID of the request being served to the records logged with its context.
+type LogHandler struct {
+	slog.Handler
+}
+
+// NewLogHandler returns a handler adding the request ID of the context to the records it passes to handler.
+func NewLogHandler(handler slog.Handler) *LogHandler {
+	return &LogHandler{Handler: handler}
+}
+
+func (h *LogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if requestID := RequestID(ctx); requestID != "" {
+		record.AddAttrs(slog.String(requestIDLogKey, requestID))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &LogHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *LogHandler) WithGroup(name string) slog.Handler {
+	return &LogHandler{Handler: h.Handler.WithGroup(name)}
+}
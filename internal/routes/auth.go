@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"fmt"
+
+	"github.com/rezkam/TaxMan/internal/constants"
+
+	"github.com/rezkam/TaxMan/auth"
+)
+
+// SetupAPIKeyRoutes sets up the routes for managing API keys.
+func SetupAPIKeyRoutes(svc *auth.Service, mux Mux) {
+
+	const keyIDWildcard = constants.APIKeyIDURLPattern
+
+	mux.HandleFunc("POST /api-keys", svc.CreateAPIKeyHandler)
+	mux.HandleFunc("GET /api-keys", svc.ListAPIKeysHandler)
+	mux.HandleFunc(fmt.Sprintf("DELETE /api-keys/{%s}", keyIDWildcard), svc.RevokeAPIKeyHandler)
+}
@@ -0,0 +1,9 @@
+package routes
+
+import "github.com/rezkam/TaxMan/openapi"
+
+// SetupDocsRoutes sets up the routes serving the OpenAPI specification and its docs UI.
+func SetupDocsRoutes(mux Mux) {
+	mux.HandleFunc("GET /openapi.yaml", openapi.SpecHandler)
+	mux.HandleFunc("GET /docs", openapi.UIHandler)
+}
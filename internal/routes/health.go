@@ -0,0 +1,9 @@
+package routes
+
+import "github.com/rezkam/TaxMan/health"
+
+// SetupHealthRoutes sets up the liveness and readiness probes.
+func SetupHealthRoutes(checker *health.Checker, mux Mux) {
+	mux.HandleFunc("GET /healthz", checker.LivenessHandler)
+	mux.HandleFunc("GET /readyz", checker.ReadinessHandler)
+}
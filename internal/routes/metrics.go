@@ -0,0 +1,8 @@
+package routes
+
+import "github.com/rezkam/TaxMan/metrics"
+
+// SetupMetricsRoutes sets up the route exposing the metrics to Prometheus.
+func SetupMetricsRoutes(m *metrics.Metrics, mux Mux) {
+	mux.Handle("GET /metrics", m.Handler())
+}
@@ -0,0 +1,25 @@
+package routes
+
+import "net/http"
+
+// Mux registers the handlers of routes, it is implemented by http.ServeMux.
+type Mux interface {
+	Handle(pattern string, handler http.Handler)
+	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
+}
+
+// PatternRecorder registers routes on a Mux and records their patterns.
+type PatternRecorder struct {
+	Mux
+	Patterns []string
+}
+
+func (m *PatternRecorder) Handle(pattern string, handler http.Handler) {
+	m.Patterns = append(m.Patterns, pattern)
+	m.Mux.Handle(pattern, handler)
+}
+
+func (m *PatternRecorder) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.Patterns = append(m.Patterns, pattern)
+	m.Mux.HandleFunc(pattern, handler)
+}
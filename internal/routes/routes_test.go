@@ -0,0 +1,108 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rezkam/TaxMan/auth"
+	"github.com/rezkam/TaxMan/docs"
+	"github.com/rezkam/TaxMan/health"
+	"github.com/rezkam/TaxMan/openapi"
+	"github.com/rezkam/TaxMan/taxservice"
+	"github.com/rezkam/TaxMan/webhook"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingMux records the patterns of the registered routes.
+type recordingMux struct {
+	patterns []string
+}
+
+func (m *recordingMux) Handle(pattern string, handler http.Handler) {
+	m.patterns = append(m.patterns, pattern)
+}
+
+func (m *recordingMux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.patterns = append(m.patterns, pattern)
+}
+
+func TestRoutesAreSpecified(t *testing.T) {
+	doc, err := openapi.Load(docs.OpenAPI)
+	require.NoError(t, err)
+
+	api := &recordingMux{}
+	SetupVersionedRoutes(&taxservice.Service{}, &webhook.Service{}, &auth.Service{}, api)
+	require.NotEmpty(t, api.patterns)
+	// The deprecated aliases of v1 are specified by the routes they alias,
+	// and the routes of v2 unchanged from v1 by the routes of v1
+	for i, pattern := range api.patterns {
+		method, path, _ := strings.Cut(pattern, " ")
+		if rest, found := strings.CutPrefix(path, V2Prefix+"/"); found {
+			if pathItem := doc.Paths.Value(path); pathItem == nil || pathItem.GetOperation(method) == nil {
+				api.patterns[i] = method + " " + V1Prefix + "/" + rest
+			}
+		} else if !strings.HasPrefix(path, V1Prefix+"/") {
+			api.patterns[i] = method + " " + V1Prefix + path
+		}
+	}
+
+	mux := &recordingMux{}
+	SetupHealthRoutes(&health.Checker{}, mux)
+	SetupDocsRoutes(mux)
+
+	for _, pattern := range append(api.patterns, mux.patterns...) {
+		method, path, found := strings.Cut(pattern, " ")
+		require.True(t, found, "route %q has no method", pattern)
+
+		pathItem := doc.Paths.Find(path)
+		require.NotNil(t, pathItem, "path of route %q is missing from the OpenAPI specification", pattern)
+		require.NotNil(t, pathItem.GetOperation(method), "method of route %q is missing from the OpenAPI specification", pattern)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	mux := http.NewServeMux()
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.PathValue("id")))
+	}
+	NewGroup(mux, V2Prefix).HandleFunc("GET /things/{id}", handler)
+	NewDeprecatedGroup(mux, V1Prefix, time.Unix(1760745600, 0)).HandleFunc("GET /things/{id}", handler)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v2/things/1", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "1", rr.Body.String())
+	require.Empty(t, rr.Header().Get("Deprecation"))
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/things/2", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "2", rr.Body.String())
+	require.Equal(t, "@1760745600", rr.Header().Get("Deprecation"))
+	require.Equal(t, `</v1/things/2>; rel="successor-version"`, rr.Header().Get("Link"))
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/things/3", nil))
+	require.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestV2ServesEveryRoute(t *testing.T) {
+	api := &recordingMux{}
+	SetupVersionedRoutes(&taxservice.Service{}, &webhook.Service{}, &auth.Service{}, api)
+
+	v2 := map[string]bool{}
+	for _, pattern := range api.patterns {
+		if method, path, _ := strings.Cut(pattern, " "); strings.HasPrefix(path, V2Prefix+"/") {
+			v2[method+" "+strings.TrimPrefix(path, V2Prefix)] = true
+		}
+	}
+	for _, pattern := range api.patterns {
+		method, path, _ := strings.Cut(pattern, " ")
+		if rest, found := strings.CutPrefix(path, V1Prefix+"/"); found {
+			require.True(t, v2[method+" /"+rest], "route %q is missing from v2", pattern)
+		}
+	}
+}
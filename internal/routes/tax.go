@@ -2,21 +2,48 @@ package routes
 
 import (
 	"fmt"
-	"net/http"
 
 	"github.com/rezkam/TaxMan/internal/constants"
 
 	"github.com/rezkam/TaxMan/taxservice"
 )
 
+// TaxRatePattern is the pattern of the route looking up tax rates, in every version of the API.
+var TaxRatePattern = fmt.Sprintf("GET /tax/{%s}/{%s}", constants.MunicipalityURLPattern, constants.DateURLPattern)
+
 // SetupTaxRoutes sets up the routes for the tax service.
-func SetupTaxRoutes(svc *taxservice.Service, mux *http.ServeMux) {
+func SetupTaxRoutes(svc *taxservice.Service, mux Mux) {
+	mux.HandleFunc(TaxRatePattern, svc.GetTaxRateHandler)
+	setupTaxRecordRoutes(svc, mux)
+}
+
+// SetupTaxRoutesV2 sets up the routes for the tax service in v2 of the API, which looks up tax rates
+// with the v2 response and serves the other routes like v1.
+func SetupTaxRoutesV2(svc *taxservice.Service, mux Mux) {
+	mux.HandleFunc(TaxRatePattern, svc.GetTaxRateV2Handler)
+	setupTaxRecordRoutes(svc, mux)
+}
+
+// setupTaxRecordRoutes sets up the routes of the tax service managing tax records, which every version shares.
+func setupTaxRecordRoutes(svc *taxservice.Service, mux Mux) {
 
 	const (
-		municipalityNameWildcard = constants.MunicipalityURLPattern
-		dateWildcard             = constants.DateURLPattern
+		recordIDWildcard = constants.RecordIDURLPattern
 	)
 
 	mux.HandleFunc("POST /tax", svc.AddOrUpdateTaxRecordHandler)
-	mux.HandleFunc(fmt.Sprintf("GET /tax/{%s}/{%s}", municipalityNameWildcard, dateWildcard), svc.GetTaxRateHandler)
+	mux.HandleFunc(fmt.Sprintf("GET /tax/records/{%s}", recordIDWildcard), svc.GetTaxRecordHandler)
+	mux.HandleFunc(fmt.Sprintf("PUT /tax/records/{%s}", recordIDWildcard), svc.UpdateTaxRecordHandler)
+	mux.HandleFunc(fmt.Sprintf("DELETE /tax/records/{%s}", recordIDWildcard), svc.DeleteTaxRecordHandler)
+	mux.HandleFunc(fmt.Sprintf("POST /tax/records/{%s}/restore", recordIDWildcard), svc.RestoreTaxRecordHandler)
+	mux.HandleFunc(fmt.Sprintf("GET /tax/records/{%s}/history", recordIDWildcard), svc.GetTaxRecordHistoryHandler)
+
+	mux.HandleFunc("GET /tax/changes/stream", svc.StreamTaxRecordChangesHandler)
+
+	mux.HandleFunc("POST /tax/drafts", svc.CreateTaxRecordDraftHandler)
+	mux.HandleFunc("GET /tax/drafts", svc.ListTaxRecordDraftsHandler)
+	mux.HandleFunc(fmt.Sprintf("GET /tax/drafts/{%s}", recordIDWildcard), svc.GetTaxRecordDraftHandler)
+	mux.HandleFunc(fmt.Sprintf("POST /tax/drafts/{%s}/submit", recordIDWildcard), svc.SubmitTaxRecordDraftHandler)
+	mux.HandleFunc(fmt.Sprintf("POST /tax/drafts/{%s}/approve", recordIDWildcard), svc.ApproveTaxRecordDraftHandler)
+	mux.HandleFunc(fmt.Sprintf("POST /tax/drafts/{%s}/publish", recordIDWildcard), svc.PublishTaxRecordDraftHandler)
 }
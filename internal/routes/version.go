@@ -0,0 +1,81 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rezkam/TaxMan/auth"
+	"github.com/rezkam/TaxMan/taxservice"
+	"github.com/rezkam/TaxMan/webhook"
+)
+
+const (
+	// V1Prefix prefixes the paths of the routes of v1 of the API.
+	V1Prefix = "/v1"
+	// V2Prefix prefixes the paths of the routes of v2 of the API.
+	V2Prefix = "/v2"
+)
+
+// unversionedDeprecation is when the routes without a version prefix were deprecated in favour of /v1.
+var unversionedDeprecation = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+
+// Group registers routes on a Mux with a prefix to their paths, such as the routes of a version of the API.
+type Group struct {
+	mux    Mux
+	prefix string
+	wrap   func(http.Handler) http.Handler
+}
+
+// NewGroup creates a Group registering routes on mux under prefix.
+func NewGroup(mux Mux, prefix string) *Group {
+	return &Group{mux: mux, prefix: prefix, wrap: func(handler http.Handler) http.Handler { return handler }}
+}
+
+// NewDeprecatedGroup creates a Group registering routes on mux without prefix, as deprecated aliases of the routes
+// under successorPrefix. Their responses carry a Deprecation header and link to the route they alias.
+func NewDeprecatedGroup(mux Mux, successorPrefix string, deprecation time.Time) *Group {
+	deprecationHeader := fmt.Sprintf("@%d", deprecation.Unix())
+	return &Group{mux: mux, wrap: func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", deprecationHeader)
+			w.Header().Add("Link", fmt.Sprintf(`<%s%s>; rel="successor-version"`, successorPrefix, r.URL.EscapedPath()))
+			handler.ServeHTTP(w, r)
+		})
+	}}
+}
+
+func (g *Group) Handle(pattern string, handler http.Handler) {
+	g.mux.Handle(g.pattern(pattern), g.wrap(handler))
+}
+
+func (g *Group) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	g.Handle(pattern, http.HandlerFunc(handler))
+}
+
+// pattern prefixes the path of pattern, which may start with a method.
+func (g *Group) pattern(pattern string) string {
+	if method, path, found := strings.Cut(pattern, " "); found {
+		return method + " " + g.prefix + path
+	}
+	return g.prefix + pattern
+}
+
+// SetupVersionedRoutes sets up the routes of every version of the API.
+// v1 is served under /v1, and without prefix as its deprecated alias. v2 is served under /v2 and holds
+// every route of v1, the routes whose responses changed in v2 answering with their v2 responses.
+func SetupVersionedRoutes(taxService *taxservice.Service, webhookService *webhook.Service, authService *auth.Service, mux Mux) {
+	setupV1 := func(mux Mux) {
+		SetupTaxRoutes(taxService, mux)
+		SetupWebhookRoutes(webhookService, mux)
+		SetupAPIKeyRoutes(authService, mux)
+	}
+	setupV1(NewGroup(mux, V1Prefix))
+	setupV1(NewDeprecatedGroup(mux, V1Prefix, unversionedDeprecation))
+
+	v2 := NewGroup(mux, V2Prefix)
+	SetupTaxRoutesV2(taxService, v2)
+	SetupWebhookRoutes(webhookService, v2)
+	SetupAPIKeyRoutes(authService, v2)
+}
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"fmt"
+
+	"github.com/rezkam/TaxMan/internal/constants"
+
+	"github.com/rezkam/TaxMan/webhook"
+)
+
+// SetupWebhookRoutes sets up the routes for managing webhook subscriptions.
+func SetupWebhookRoutes(svc *webhook.Service, mux Mux) {
+
+	const subscriptionIDWildcard = constants.SubscriptionIDURLPattern
+
+	mux.HandleFunc("POST /webhooks", svc.CreateWebhookSubscriptionHandler)
+	mux.HandleFunc("GET /webhooks", svc.ListWebhookSubscriptionsHandler)
+	mux.HandleFunc(fmt.Sprintf("GET /webhooks/{%s}", subscriptionIDWildcard), svc.GetWebhookSubscriptionHandler)
+	mux.HandleFunc(fmt.Sprintf("DELETE /webhooks/{%s}", subscriptionIDWildcard), svc.DeleteWebhookSubscriptionHandler)
+	mux.HandleFunc(fmt.Sprintf("GET /webhooks/{%s}/deliveries", subscriptionIDWildcard), svc.ListWebhookDeliveryAttemptsHandler)
+	mux.HandleFunc(fmt.Sprintf("GET /webhooks/{%s}/dead-letters", subscriptionIDWildcard), svc.ListWebhookDeadLettersHandler)
+}
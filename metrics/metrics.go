@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rezkam/TaxMan/internal/httputil"
+	"github.com/rezkam/TaxMan/taxservice"
+)
+
+const namespace = "taxman"
+
+// unmatchedRoute is the route label of the requests not routed to any route, keeping the labels bounded.
+const unmatchedRoute = "unmatched"
+
+// otherMethod is the method label of the requests with a method outside knownMethods, keeping the labels bounded.
+const otherMethod = "other"
+
+// knownMethods are the methods labelled as they are.
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// Metrics collects the metrics of the application and exposes them to Prometheus.
+type Metrics struct {
+	registry          *prometheus.Registry
+	requests          *prometheus.CounterVec
+	requestDuration   *prometheus.HistogramVec
+	statementDuration *prometheus.HistogramVec
+}
+
+// New creates the metrics of the application along with the metrics of the Go runtime and the process.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by route, method and status.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests by route, method and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		statementDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "db_statement_duration_seconds",
+			Help:      "Execution time of prepared statements by statement and outcome.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"statement", "outcome"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.statementDuration,
+	)
+	return m
+}
+
+// Handler serves the collected metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Middleware counts and times the requests to next, labelled by the pattern of the route of mux they are routed to
+// without its version prefix, so that a route is measured alike in every version of the API and in its aliases.
+func (m *Metrics) Middleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := unmatchedRoute
+		if _, pattern := mux.Handler(r); pattern != "" {
+			route = httputil.UnversionedPattern(pattern)
+		}
+
+		start := time.Now()
+		recorder := httputil.NewResponseRecorder(w)
+		next.ServeHTTP(recorder, r)
+
+		method := r.Method
+		if !knownMethods[method] {
+			method = otherMethod
+		}
+		status := strconv.Itoa(recorder.Status())
+		m.requests.WithLabelValues(route, method, status).Inc()
+		m.requestDuration.WithLabelValues(route, method, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// ObserveStatement records an execution of a prepared statement, it is a store.StatementObserver.
+func (m *Metrics) ObserveStatement(statement string, duration time.Duration, err error) {
+	outcome := "success"
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		outcome = "error"
+	}
+	m.statementDuration.WithLabelValues(statement, outcome).Observe(duration.Seconds())
+}
+
+// RegisterDBStats exposes the statistics of a connection pool, read from stats whenever the metrics are collected.
+func (m *Metrics) RegisterDBStats(stats func() sql.DBStats) {
+	m.registry.MustRegister(newDBStatsCollector(stats))
+}
+
+// RegisterTaxService exposes the outcomes of the tax rate lookups and the usage of the rate lookup cache.
+func (m *Metrics) RegisterTaxService(svc *taxservice.Service) {
+	outcome := func(name string, value func(taxservice.TaxRateStats) uint64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "tax_rate_lookups_total",
+			Help:        "Number of tax rate lookups by outcome.",
+			ConstLabels: prometheus.Labels{"outcome": name},
+		}, func() float64 { return float64(value(svc.TaxRateStats())) })
+	}
+	m.registry.MustRegister(
+		outcome("record", func(s taxservice.TaxRateStats) uint64 { return s.RecordHits }),
+		outcome("default", func(s taxservice.TaxRateStats) uint64 { return s.DefaultFallbacks }),
+		outcome("not_found", func(s taxservice.TaxRateStats) uint64 { return s.NotFound }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_cache_hits_total",
+			Help:      "Number of rate lookups answered from the cache.",
+		}, func() float64 { return float64(svc.RateCacheStats().Hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_cache_misses_total",
+			Help:      "Number of rate lookups missing the cache.",
+		}, func() float64 { return float64(svc.RateCacheStats().Misses) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "rate_cache_entries",
+			Help:      "Number of rate lookups held by the cache.",
+		}, func() float64 { return float64(svc.RateCacheStats().Entries) }),
+	)
+}
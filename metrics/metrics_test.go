@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rezkam/TaxMan/taxservice"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	body, err := io.ReadAll(rr.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestMiddleware(t *testing.T) {
+	m := New()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tax/{municipality}/{date}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("municipality") == "Unknown" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	mux.HandleFunc("GET /v2/tax/{municipality}/{date}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /stream", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, http.NewResponseController(w).Flush())
+	})
+	handler := m.Middleware(mux, mux)
+
+	for _, path := range []string{"/tax/Copenhagen/2024-01-01", "/tax/Aarhus/2024-01-01", "/tax/Unknown/2024-01-01", "/v2/tax/Aarhus/2024-01-01", "/stream", "/nowhere"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	for _, method := range []string{"BREW", "PROPFIND"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/nowhere", nil))
+	}
+
+	// The versions of a route are measured alike
+	require.Equal(t, 3.0, testutil.ToFloat64(m.requests.WithLabelValues("GET /tax/{municipality}/{date}", http.MethodGet, "200")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues("GET /tax/{municipality}/{date}", http.MethodGet, "404")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues("GET /stream", http.MethodGet, "200")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues(unmatchedRoute, http.MethodGet, "404")))
+	require.Equal(t, 2.0, testutil.ToFloat64(m.requests.WithLabelValues(unmatchedRoute, otherMethod, "404")))
+	require.Contains(t, scrape(t, m), `taxman_http_request_duration_seconds_count{method="GET",route="GET /tax/{municipality}/{date}",status="200"} 3`)
+}
+
+func TestObserveStatement(t *testing.T) {
+	m := New()
+	m.ObserveStatement("selectTaxRecords", 3*time.Millisecond, nil)
+	m.ObserveStatement("selectTaxRecordByID", time.Millisecond, sql.ErrNoRows)
+	m.ObserveStatement("selectTaxRecords", time.Second, errors.New("connection refused"))
+
+	body := scrape(t, m)
+	require.Contains(t, body, `taxman_db_statement_duration_seconds_count{outcome="success",statement="selectTaxRecords"} 1`)
+	require.Contains(t, body, `taxman_db_statement_duration_seconds_count{outcome="success",statement="selectTaxRecordByID"} 1`)
+	require.Contains(t, body, `taxman_db_statement_duration_seconds_count{outcome="error",statement="selectTaxRecords"} 1`)
+}
+
+func TestRegisteredStats(t *testing.T) {
+	m := New()
+	m.RegisterDBStats(func() sql.DBStats {
+		return sql.DBStats{MaxOpenConnections: 25, OpenConnections: 3, InUse: 1, Idle: 2, WaitCount: 4}
+	})
+	svc, err := taxservice.New(nil, taxservice.Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+	})
+	require.NoError(t, err)
+	m.RegisterTaxService(svc)
+
+	body := scrape(t, m)
+	require.Contains(t, body, "taxman_db_max_open_connections 25")
+	require.Contains(t, body, "taxman_db_in_use_connections 1")
+	require.Contains(t, body, "taxman_db_wait_count_total 4")
+	require.Contains(t, body, `taxman_tax_rate_lookups_total{outcome="default"} 0`)
+	require.Contains(t, body, `taxman_tax_rate_lookups_total{outcome="not_found"} 0`)
+	require.Contains(t, body, "taxman_rate_cache_hits_total 0")
+}
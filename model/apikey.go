@@ -0,0 +1,54 @@
+package model
+
+import "time"
+
+// Scope is a permission granted to a caller, through its API key or the roles of its token.
+// Scopes are ordered, a key with a scope is also granted the scopes below it: admin > write > read.
+type Scope string
+
+const (
+	ScopeRead  Scope = "read"
+	ScopeWrite Scope = "write"
+	// ScopeAdmin grants the management of API keys and webhook subscriptions.
+	ScopeAdmin Scope = "admin"
+)
+
+// ValidScopes contains all valid scopes
+var ValidScopes = []Scope{ScopeRead, ScopeWrite, ScopeAdmin}
+
+// scopeLevel defines the order of scopes, a scope grants every scope with a lower or equal level.
+var scopeLevel = map[Scope]int{
+	ScopeRead:  1,
+	ScopeWrite: 2,
+	ScopeAdmin: 3,
+}
+
+// Grants reports whether the scope includes the required scope.
+func (s Scope) Grants(required Scope) bool {
+	level, ok := scopeLevel[s]
+	return ok && level >= scopeLevel[required]
+}
+
+// APIKey is a credential used to call the API. Only a hash of the key is stored, Prefix is the
+// beginning of the key kept to help owners recognize it.
+type APIKey struct {
+	ID     int64
+	Name   string
+	Prefix string
+	Scopes []Scope
+	// Municipalities restricts the changes made with the key to the records of these municipalities, empty means all.
+	Municipalities []string
+	CreatedAt      time.Time
+	// RevokedAt is when the key was revoked, nil for active keys.
+	RevokedAt *time.Time
+}
+
+// HasScope reports whether one of the key's scopes grants the required scope.
+func (k APIKey) HasScope(required Scope) bool {
+	for _, scope := range k.Scopes {
+		if scope.Grants(required) {
+			return true
+		}
+	}
+	return false
+}
@@ -8,6 +8,22 @@ import (
 var (
 	ErrNotFound      = errors.New("not found")
 	ErrInvalidPeriod = errors.New("invalid period type")
+	ErrConflict      = errors.New("conflict")
+	ErrInvalidState  = errors.New("invalid state")
+	// ErrVersionMismatch is returned when a record was changed since the version the caller expected.
+	ErrVersionMismatch = errors.New("version mismatch")
+	// ErrVersionRequired is returned when a record would be overwritten without the version the caller expects.
+	ErrVersionRequired = errors.New("version required")
+	// ErrNotDue is returned when a draft is published before the time it is scheduled for.
+	ErrNotDue = errors.New("not due")
+)
+
+// Expected versions of the changes that are not conditioned on a single version of a record.
+const (
+	// AnyVersion is the expected version of a change applying to whatever version of the record, as with If-Match: *.
+	AnyVersion int64 = 0
+	// NoVersion is the expected version of a write that must create its record rather than overwrite an existing one.
+	NoVersion int64 = -1
 )
 
 // PeriodType defines the type of period for a tax record
@@ -42,15 +58,103 @@ func GetPeriodTypePriority(pt PeriodType) (int, error) {
 
 // TaxRecord represents a tax record with appropriate types.
 type TaxRecord struct {
+	ID           int64
 	Municipality string
 	TaxRate      float64
 	StartDate    time.Time
-	EndDate      time.Time
-	PeriodType   PeriodType
+	// EndDate is the last day the record applies to, inclusive.
+	// A zero EndDate means the record is open-ended and applies until further notice.
+	EndDate    time.Time
+	PeriodType PeriodType
+	// Version is incremented on every change of the record and is used for optimistic concurrency.
+	Version int64
+	// DeletedAt is when the record was soft-deleted, a zero DeletedAt means the record is active.
+	DeletedAt time.Time
+}
+
+// IsDeleted reports whether the record is soft-deleted.
+func (r TaxRecord) IsDeleted() bool {
+	return !r.DeletedAt.IsZero()
+}
+
+// IsOpenEnded reports whether the record has no end date.
+func (r TaxRecord) IsOpenEnded() bool {
+	return r.EndDate.IsZero()
 }
 
 // TaxQuery represents a query for a tax rate.
 type TaxQuery struct {
 	Municipality string
 	Date         time.Time
+	// AsOf evaluates the query against the records as they were known at that moment.
+	// A zero AsOf queries the current records.
+	AsOf time.Time
+}
+
+// ChangeAction describes the kind of change recorded in the history of a tax record.
+type ChangeAction string
+
+const (
+	ActionCreate  ChangeAction = "create"
+	ActionUpdate  ChangeAction = "update"
+	ActionDelete  ChangeAction = "delete"
+	ActionRestore ChangeAction = "restore"
+)
+
+// TaxRecordState holds the mutable values of a tax record at one point in its history.
+type TaxRecordState struct {
+	TaxRate   float64
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+// TaxRecordHistoryEntry represents a single change made to a tax record.
+// Old is nil when the record was created or restored, New is nil when it was deleted.
+type TaxRecordHistoryEntry struct {
+	ID        int64
+	RecordID  int64
+	Action    ChangeAction
+	Old       *TaxRecordState
+	New       *TaxRecordState
+	Actor     string
+	RequestID string
+	ChangedAt time.Time
+}
+
+// TaxRecordChange is an entry of the change log of tax records, ordered by ID in commit order.
+// Record holds the state of the record after the change, or before it for deletions.
+type TaxRecordChange struct {
+	ID        int64
+	Action    ChangeAction
+	Record    TaxRecord
+	ChangedAt time.Time
+}
+
+// DraftStatus is the stage of a tax record draft in the approval workflow.
+type DraftStatus string
+
+const (
+	DraftStatusDraft           DraftStatus = "draft"
+	DraftStatusPendingApproval DraftStatus = "pending_approval"
+	DraftStatusApproved        DraftStatus = "approved"
+	DraftStatusPublished       DraftStatus = "published"
+)
+
+// ValidDraftStatuses contains all valid draft statuses in the order of the workflow.
+var ValidDraftStatuses = []DraftStatus{DraftStatusDraft, DraftStatusPendingApproval, DraftStatusApproved, DraftStatusPublished}
+
+// TaxRecordDraft is a proposed change to the tax records that only takes effect once it is approved and published.
+type TaxRecordDraft struct {
+	ID     int64
+	Record TaxRecord
+	Status DraftStatus
+	// PublishAt is when an approved draft is published automatically.
+	// A zero PublishAt means the draft is only published on request.
+	PublishAt  time.Time
+	CreatedBy  string
+	ApprovedBy string
+	// PublishedRecordID is the ID of the tax record written when the draft was published.
+	PublishedRecordID int64
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
 }
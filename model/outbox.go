@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// OutboxEvent is a change of a tax record waiting in the outbox until it is published.
+// Attempts counts the failed attempts to publish it, LastError describes the latest one.
+type OutboxEvent struct {
+	ID        int64
+	Change    TaxRecordChange
+	Attempts  int
+	LastError string
+	CreatedAt time.Time
+}
@@ -0,0 +1,60 @@
+package model
+
+import "time"
+
+// WebhookSubscription registers an HTTP endpoint that is notified of the changes of tax records.
+type WebhookSubscription struct {
+	ID  int64
+	URL string
+	// Municipality limits the notifications to the changes of one municipality, empty means all municipalities.
+	Municipality string
+	// Secret is the key of the HMAC signature sent with every notification.
+	Secret    string
+	CreatedAt time.Time
+}
+
+// WebhookDeliveryStatus is the stage of the delivery of a change to a webhook subscription.
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliveryDelivered WebhookDeliveryStatus = "delivered"
+	// WebhookDeliveryDead marks a delivery that failed every attempt and was moved to the dead letters.
+	WebhookDeliveryDead WebhookDeliveryStatus = "dead"
+)
+
+// WebhookDelivery is the delivery of a change of a tax record to a webhook subscription.
+type WebhookDelivery struct {
+	ID            int64
+	Subscription  WebhookSubscription
+	Change        TaxRecordChange
+	Status        WebhookDeliveryStatus
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// WebhookDeliveryAttempt is the log of a single attempt to deliver a change to a webhook subscription.
+// StatusCode is 0 when no response was received, Error describes why the attempt failed.
+type WebhookDeliveryAttempt struct {
+	ID          int64
+	DeliveryID  int64
+	Attempt     int
+	StatusCode  int
+	Error       string
+	Duration    time.Duration
+	AttemptedAt time.Time
+}
+
+// WebhookDeadLetter is a delivery that was given up after its last attempt failed.
+type WebhookDeadLetter struct {
+	ID             int64
+	DeliveryID     int64
+	SubscriptionID int64
+	ChangeID       int64
+	Attempts       int
+	LastError      string
+	CreatedAt      time.Time
+}
@@ -0,0 +1,47 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/rezkam/TaxMan/docs"
+	"github.com/rezkam/TaxMan/internal/problem"
+)
+
+// uiSpecPlaceholder is replaced by the specification in the documentation page.
+const uiSpecPlaceholder = "{{spec}}"
+
+// uiPage is the documentation page with the specification inlined as JSON, so that it renders it without
+// loading anything else. json.Marshal escapes <, > and &, so the specification cannot end its script element.
+var uiPage = sync.OnceValues(func() ([]byte, error) {
+	doc, err := Load(docs.OpenAPI)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Replace(docs.UI, []byte(uiSpecPlaceholder), spec, 1), nil
+})
+
+// SpecHandler serves the OpenAPI specification of the API.
+func SpecHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(docs.OpenAPI)
+}
+
+// UIHandler serves the page rendering the OpenAPI specification of the API.
+func UIHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := uiPage()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to render documentation page", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternalError, "failed to render documentation page")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
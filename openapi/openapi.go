@@ -0,0 +1,238 @@
+package openapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+
+	"github.com/rezkam/TaxMan/internal/httputil"
+	"github.com/rezkam/TaxMan/internal/jsonutils"
+	"github.com/rezkam/TaxMan/internal/problem"
+)
+
+// maxValidatedResponseSize bounds the bodies of the responses kept for validation,
+// larger responses and streams exceeding it are not validated.
+const maxValidatedResponseSize = 1 << 20
+
+// Validator validates the requests to the API, and the responses of the API, against its OpenAPI specification.
+type Validator struct {
+	router  routers.Router
+	options *openapi3filter.Options
+	config  Config
+}
+
+type Config struct {
+	// ValidateRequests rejects requests not conforming to the specification with 400.
+	ValidateRequests bool
+	// ValidateResponses logs the responses not conforming to the specification, they are still sent.
+	ValidateResponses bool
+	// MaxRequestBodySize is the maximum size in bytes of the request bodies read for validation.
+	// A value of 0 applies jsonutils.DefaultMaxBodySize.
+	MaxRequestBodySize int64
+	// AliasPrefix is prepended to the paths of the requests missing from the specification, such as "/v1",
+	// so that routes served without the prefix as aliases are validated as the routes they alias.
+	AliasPrefix string
+	// InheritedPrefixes maps the prefixes of versions of the API, such as "/v2", to the prefix of the version whose
+	// routes they serve unchanged, such as "/v1". The requests of a version missing from the specification
+	// are validated as the routes of the version it inherits them from.
+	InheritedPrefixes map[string]string
+}
+
+// Load parses and validates the OpenAPI specification spec.
+func Load(spec []byte) (*openapi3.T, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OpenAPI specification: %w", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI specification: %w", err)
+	}
+	return doc, nil
+}
+
+// New creates a new Validator of the OpenAPI specification spec with the provided configuration.
+func New(spec []byte, config Config) (*Validator, error) {
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+	if config.MaxRequestBodySize == 0 {
+		config.MaxRequestBodySize = jsonutils.DefaultMaxBodySize
+	}
+
+	doc, err := Load(spec)
+	if err != nil {
+		return nil, err
+	}
+	// Match the paths of requests regardless of the server they were sent to.
+	doc.Servers = nil
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to route OpenAPI specification: %w", err)
+	}
+
+	options := &openapi3filter.Options{
+		// Credentials are checked by the authentication middleware.
+		AuthenticationFunc:    openapi3filter.NoopAuthenticationFunc,
+		IncludeResponseStatus: true,
+	}
+	options.WithCustomSchemaErrorFunc(schemaErrorMessage)
+	return &Validator{
+		router:  router,
+		options: options,
+		config:  config,
+	}, nil
+}
+
+// schemaErrorMessage formats the schema errors of requests without the schema and the value,
+// which the library includes by default and which are not meant for clients.
+func schemaErrorMessage(err *openapi3.SchemaError) string {
+	reason := err.Reason
+	if err.Origin != nil {
+		reason = err.Origin.Error()
+	} else if reason == "" {
+		reason = fmt.Sprintf("doesn't match schema %q", err.SchemaField)
+	}
+	if path := err.JSONPointer(); len(path) > 0 {
+		return fmt.Sprintf("Error at \"/%s\": %s", strings.Join(path, "/"), reason)
+	}
+	return reason
+}
+
+// validateConfig checks if the provided Config values are valid.
+func validateConfig(config Config) error {
+	if config.MaxRequestBodySize < 0 {
+		return errors.New("MaxRequestBodySize cannot be negative")
+	}
+	return nil
+}
+
+// Middleware validates the requests to next and the responses of next, as enabled by the configuration.
+// Requests to routes missing from the specification, also under the prefixes they alias or inherit, are passed to
+// next unvalidated.
+func (v *Validator) Middleware(next http.Handler) http.Handler {
+	if !v.config.ValidateRequests && !v.config.ValidateResponses {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routed, route, pathParams, err := v.findRoute(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		input := &openapi3filter.RequestValidationInput{
+			Request:    routed,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    v.options,
+		}
+
+		if v.config.ValidateRequests {
+			routed.Body = http.MaxBytesReader(w, routed.Body, v.config.MaxRequestBodySize)
+			err := openapi3filter.ValidateRequest(r.Context(), input)
+			// The validation reads the body and replaces it with a copy, which next reads
+			r.Body = routed.Body
+			if err != nil {
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					problem.Write(w, r, http.StatusRequestEntityTooLarge, problem.CodeBodyTooLarge,
+						fmt.Sprintf("%s, the limit is %d bytes", jsonutils.ErrBodyTooLarge, maxBytesErr.Limit))
+					return
+				}
+				problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+				return
+			}
+		}
+		if !v.config.ValidateResponses {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var body cappedBuffer
+		recorder := httputil.NewResponseRecorder(w)
+		recorder.Body = &body
+		next.ServeHTTP(recorder, r)
+		if body.truncated {
+			return
+		}
+		err = openapi3filter.ValidateResponse(r.Context(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 recorder.Status(),
+			Header:                 w.Header(),
+			Body:                   io.NopCloser(&body),
+			Options:                v.options,
+		})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "response does not conform to the OpenAPI specification",
+				"route", route.Method+" "+route.Path, "status", recorder.Status(), "error", err)
+		}
+	})
+}
+
+// findRoute finds the route of the specification r is sent to. Requests of a version inheriting routes are
+// validated as the route of the inherited version, unless their version specifies that route itself, and
+// requests missing from the specification are tried with the alias prefix.
+// It returns the request to validate, which is r or a copy of r with the path of the route it is validated as.
+func (v *Validator) findRoute(r *http.Request) (*http.Request, *routers.Route, map[string]string, error) {
+	for prefix, inherited := range v.config.InheritedPrefixes {
+		if !strings.HasPrefix(r.URL.Path, prefix+"/") {
+			continue
+		}
+		// The versions share the paths of their routes, so that the route found in the inherited version,
+		// which holds every route, is the route r is sent to.
+		aliased := withPathPrefix(r, prefix, inherited)
+		route, pathParams, err := v.router.FindRoute(aliased)
+		if err == nil && !specifies(route.Spec, prefix+strings.TrimPrefix(route.Path, inherited), route.Method) {
+			return aliased, route, pathParams, nil
+		}
+		break
+	}
+
+	route, pathParams, err := v.router.FindRoute(r)
+	if err == nil || v.config.AliasPrefix == "" {
+		return r, route, pathParams, err
+	}
+	aliased := withPathPrefix(r, "", v.config.AliasPrefix)
+	route, pathParams, err = v.router.FindRoute(aliased)
+	return aliased, route, pathParams, err
+}
+
+// specifies reports whether doc specifies the operation of method on path.
+func specifies(doc *openapi3.T, path, method string) bool {
+	pathItem := doc.Paths.Value(path)
+	return pathItem != nil && pathItem.GetOperation(method) != nil
+}
+
+// withPathPrefix returns a copy of r whose path has prefix replaced with replacement.
+func withPathPrefix(r *http.Request, prefix, replacement string) *http.Request {
+	aliased := r.Clone(r.Context())
+	aliased.URL.Path = replacement + strings.TrimPrefix(r.URL.Path, prefix)
+	if r.URL.RawPath != "" {
+		aliased.URL.RawPath = replacement + strings.TrimPrefix(r.URL.RawPath, prefix)
+	}
+	return aliased
+}
+
+// cappedBuffer keeps the body of a response for validation, unless it exceeds maxValidatedResponseSize.
+type cappedBuffer struct {
+	bytes.Buffer
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if !b.truncated && b.Len()+len(p) <= maxValidatedResponseSize {
+		return b.Buffer.Write(p)
+	}
+	b.truncated = true
+	b.Reset()
+	return len(p), nil
+}
@@ -0,0 +1,191 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rezkam/TaxMan/docs"
+	"github.com/rezkam/TaxMan/internal/problem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	_, err := Load(docs.OpenAPI)
+	require.NoError(t, err)
+}
+
+func TestUIHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	UIHandler(rr, httptest.NewRequest(http.MethodGet, "/docs", nil))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	page := rr.Body.String()
+	require.NotContains(t, page, uiSpecPlaceholder)
+	// The page loads nothing from other origins
+	require.NotContains(t, page, "src=\"http")
+	require.NotContains(t, page, "href=\"http")
+
+	// The inlined specification is the embedded one
+	start := strings.Index(page, `<script id="spec" type="application/json">`)
+	require.NotEqual(t, -1, start)
+	inlined := page[start+len(`<script id="spec" type="application/json">`):]
+	inlined = inlined[:strings.Index(inlined, "</script>")]
+	var spec struct {
+		Paths map[string]any `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(inlined), &spec))
+	require.Contains(t, spec.Paths, "/v1/tax/{municipality}/{date}")
+}
+
+// newTestHandler returns a mux answering the lookups of tax rates with body and recording the routes it served.
+func newTestHandler(body string, served *[]string) http.Handler {
+	mux := http.NewServeMux()
+	for _, pattern := range []string{"GET /v1/tax/{municipality}/{date}", "GET /v1/tax/records/{id}", "POST /v1/tax", "GET /unspecified"} {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			*served = append(*served, pattern)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(body))
+		})
+	}
+	return mux
+}
+
+func TestMiddlewareValidatesRequests(t *testing.T) {
+	validator, err := New(docs.OpenAPI, Config{ValidateRequests: true, MaxRequestBodySize: 256})
+	require.NoError(t, err)
+	var served []string
+	handler := validator.Middleware(newTestHandler(`{}`, &served))
+
+	testCases := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+		code   problem.Code
+	}{
+		{"valid lookup", http.MethodGet, "/v1/tax/Copenhagen/2024-01-01", "", http.StatusOK, ""},
+		{"literal path segments", http.MethodGet, "/v1/tax/records/5", "", http.StatusOK, ""},
+		{"invalid record ID", http.MethodGet, "/v1/tax/records/five", "", http.StatusBadRequest, problem.CodeInvalidRequest},
+		{"invalid query parameter", http.MethodGet, "/v1/tax/Copenhagen/2024-01-01?as_of=yesterday", "", http.StatusBadRequest, problem.CodeInvalidRequest},
+		{"valid body", http.MethodPost, "/v1/tax", `{"municipality":"Copenhagen","tax_rate":0.2,"start_date":"2024-01-01","period_type":"yearly"}`, http.StatusOK, ""},
+		{"invalid body", http.MethodPost, "/v1/tax", `{"municipality":"Copenhagen","tax_rate":0.2,"start_date":"2024-01-01","period_type":"hourly"}`, http.StatusBadRequest, problem.CodeInvalidRequest},
+		{"missing required field", http.MethodPost, "/v1/tax", `{"municipality":"Copenhagen","tax_rate":0.2,"period_type":"yearly"}`, http.StatusBadRequest, problem.CodeInvalidRequest},
+		{"too large body", http.MethodPost, "/v1/tax", `{"municipality":"` + strings.Repeat("a", 256) + `"}`, http.StatusRequestEntityTooLarge, problem.CodeBodyTooLarge},
+		{"unspecified route", http.MethodGet, "/unspecified?anything=goes", "", http.StatusOK, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			served = nil
+			req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			if tc.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.status, rr.Code, rr.Body.String())
+			if tc.code == "" {
+				require.Len(t, served, 1)
+				return
+			}
+			require.Empty(t, served)
+			var body problem.Problem
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+			require.Equal(t, tc.code, body.Code)
+			// The schemas and values are kept out of the details returned to clients
+			require.NotContains(t, body.Detail, "Schema:")
+			require.NotContains(t, body.Detail, "Value:")
+		})
+	}
+}
+
+func TestMiddlewareValidatesAliases(t *testing.T) {
+	validator, err := New(docs.OpenAPI, Config{ValidateRequests: true, AliasPrefix: "/v1"})
+	require.NoError(t, err)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /tax", func(w http.ResponseWriter, r *http.Request) {
+		// The aliased route reads the body the validation read
+		io.Copy(w, r.Body)
+	})
+	mux.HandleFunc("GET /tax/records/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	handler := validator.Middleware(mux)
+
+	body := `{"municipality":"Copenhagen","tax_rate":0.2,"start_date":"2024-01-01","period_type":"yearly"}`
+	req := httptest.NewRequest(http.MethodPost, "/tax", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, body, rr.Body.String())
+
+	req = httptest.NewRequest(http.MethodPost, "/tax", strings.NewReader(`{"municipality":"Copenhagen","period_type":"hourly"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/tax/records/five", nil))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	var problemBody problem.Problem
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&problemBody))
+	require.Equal(t, "/tax/records/five", problemBody.Instance)
+}
+
+func TestMiddlewareValidatesInheritedRoutes(t *testing.T) {
+	validator, err := New(docs.OpenAPI, Config{ValidateRequests: true, InheritedPrefixes: map[string]string{"/v2": "/v1"}})
+	require.NoError(t, err)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v2/tax/records/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /v2/tax/{municipality}/{date}", func(w http.ResponseWriter, r *http.Request) {})
+	handler := validator.Middleware(mux)
+
+	for target, status := range map[string]int{
+		"/v2/tax/records/5":                             http.StatusOK,
+		"/v2/tax/records/five":                          http.StatusBadRequest,
+		"/v2/tax/Copenhagen/2024-01-01":                 http.StatusOK,
+		"/v2/tax/Copenhagen/2024-01-01?as_of=yesterday": http.StatusBadRequest,
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		require.Equal(t, status, rr.Code, target)
+	}
+}
+
+func TestMiddlewareValidatesResponses(t *testing.T) {
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	validator, err := New(docs.OpenAPI, Config{ValidateResponses: true})
+	require.NoError(t, err)
+
+	var served []string
+	body := `{"municipality":"Copenhagen","date":"2024-01-01","tax_rate":0.2,"is_default_rate":false}`
+	rr := httptest.NewRecorder()
+	validator.Middleware(newTestHandler(body, &served)).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/tax/Copenhagen/2024-01-01", nil))
+	require.Equal(t, body, rr.Body.String())
+	require.Empty(t, logs.String())
+
+	body = `{"municipality":"Copenhagen","tax_rate":"0.2"}`
+	rr = httptest.NewRecorder()
+	validator.Middleware(newTestHandler(body, &served)).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/tax/Copenhagen/2024-01-01", nil))
+	require.Equal(t, body, rr.Body.String(), "non-conforming responses are still sent")
+	require.Contains(t, logs.String(), "response does not conform to the OpenAPI specification")
+	require.Contains(t, logs.String(), `"route":"GET /v1/tax/{municipality}/{date}"`)
+}
+
+func TestMiddlewareDisabled(t *testing.T) {
+	validator, err := New(docs.OpenAPI, Config{})
+	require.NoError(t, err)
+	mux := http.NewServeMux()
+	require.Equal(t, http.Handler(mux), validator.Middleware(mux))
+}
@@ -0,0 +1,113 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/rezkam/TaxMan/model"
+)
+
+// Dispatcher publishes the events of the outbox through a Publisher.
+type Dispatcher struct {
+	store     outboxStore
+	publisher Publisher
+	config    Config
+	now       func() time.Time
+}
+
+type Config struct {
+	// BatchSize is the maximum number of events published by one dispatch.
+	BatchSize int
+	// Lease is how long a claimed event is hidden from other dispatchers before it is published again.
+	// It should exceed the time needed to publish a batch.
+	Lease time.Duration
+	// InitialBackoff is the delay before an event that failed to publish is published again, it doubles with every failure.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts to publish an event.
+	MaxBackoff time.Duration
+}
+
+type outboxStore interface {
+	// ClaimOutboxEvents claims at most limit due events of the outbox, postponing them by lease.
+	ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]model.OutboxEvent, error)
+
+	// DeleteOutboxEvent removes a published event from the outbox.
+	DeleteOutboxEvent(ctx context.Context, eventID int64) error
+
+	// FailOutboxEvent records a failed attempt to publish an event, which is published again at nextAttemptAt.
+	FailOutboxEvent(ctx context.Context, eventID int64, reason string, nextAttemptAt time.Time) error
+}
+
+// NewDispatcher creates a new Dispatcher with the provided store, publisher and configuration.
+func NewDispatcher(store outboxStore, publisher Publisher, config Config) (*Dispatcher, error) {
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+	return &Dispatcher{
+		store:     store,
+		publisher: publisher,
+		config:    config,
+		now:       time.Now,
+	}, nil
+}
+
+// validateConfig checks if the provided Config values are valid.
+func validateConfig(config Config) error {
+	if config.BatchSize <= 0 {
+		return errors.New("BatchSize must be greater than 0")
+	}
+	if config.Lease <= 0 {
+		return errors.New("Lease must be greater than 0")
+	}
+	if config.InitialBackoff <= 0 || config.MaxBackoff < config.InitialBackoff {
+		return errors.New("InitialBackoff must be greater than 0 and not exceed MaxBackoff")
+	}
+	return nil
+}
+
+// Dispatch publishes the due events of the outbox and returns how many were published.
+// An event is removed from the outbox only after it was published, failed events are retried with backoff.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	events, err := d.store.ClaimOutboxEvents(ctx, d.config.BatchSize, d.config.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, event := range events {
+		if err := d.publisher.Publish(ctx, event); err != nil {
+			if ctx.Err() != nil {
+				// The lease makes the event due again, the failure is not the event's
+				return published, ctx.Err()
+			}
+			slog.Warn("failed to publish outbox event", "eventID", event.ID, "changeID", event.Change.ID,
+				"attempts", event.Attempts+1, "error", err)
+			nextAttemptAt := d.now().Add(d.backoff(event.Attempts + 1))
+			if err := d.store.FailOutboxEvent(ctx, event.ID, err.Error(), nextAttemptAt); err != nil {
+				slog.Error("failed to record outbox event failure", "eventID", event.ID, "error", err)
+			}
+			continue
+		}
+		if err := d.store.DeleteOutboxEvent(ctx, event.ID); err != nil {
+			// The event is published again after its lease, which at-least-once delivery allows
+			slog.Error("failed to delete published outbox event", "eventID", event.ID, "error", err)
+			continue
+		}
+		published++
+	}
+	return published, nil
+}
+
+// backoff is the delay after the given number of failed attempts, doubling from InitialBackoff up to MaxBackoff.
+func (d *Dispatcher) backoff(failures int) time.Duration {
+	delay := d.config.InitialBackoff
+	for range failures - 1 {
+		delay *= 2
+		if delay >= d.config.MaxBackoff {
+			return d.config.MaxBackoff
+		}
+	}
+	return delay
+}
@@ -0,0 +1,96 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rezkam/TaxMan/model"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig() Config {
+	return Config{
+		BatchSize:      10,
+		Lease:          time.Minute,
+		InitialBackoff: time.Second,
+		MaxBackoff:     10 * time.Second,
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("published events are deleted and failed events retried", func(t *testing.T) {
+		var deleted []int64
+		var failedID int64
+		var failedAt time.Time
+		store := &mockStore{
+			claimOutboxEventsFunc: func(ctx context.Context, limit int, lease time.Duration) ([]model.OutboxEvent, error) {
+				require.Equal(t, 10, limit)
+				require.Equal(t, time.Minute, lease)
+				return []model.OutboxEvent{
+					{ID: 1, Change: model.TaxRecordChange{ID: 11}},
+					{ID: 2, Change: model.TaxRecordChange{ID: 12}, Attempts: 2},
+					{ID: 3, Change: model.TaxRecordChange{ID: 13}},
+				}, nil
+			},
+			deleteOutboxEventFunc: func(ctx context.Context, eventID int64) error {
+				deleted = append(deleted, eventID)
+				return nil
+			},
+			failOutboxEventFunc: func(ctx context.Context, eventID int64, reason string, nextAttemptAt time.Time) error {
+				failedID, failedAt = eventID, nextAttemptAt
+				require.Equal(t, "unavailable", reason)
+				return nil
+			},
+		}
+		publisher := publisherFunc(func(ctx context.Context, event model.OutboxEvent) error {
+			if event.ID == 2 {
+				return errors.New("unavailable")
+			}
+			return nil
+		})
+		dispatcher, err := NewDispatcher(store, publisher, testConfig())
+		require.NoError(t, err)
+		dispatcher.now = func() time.Time { return now }
+
+		published, err := dispatcher.Dispatch(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 2, published)
+		require.Equal(t, []int64{1, 3}, deleted)
+		require.Equal(t, int64(2), failedID)
+		// The third failure waits twice as long as the second one
+		require.Equal(t, now.Add(4*time.Second), failedAt)
+	})
+
+	t.Run("claim error", func(t *testing.T) {
+		store := &mockStore{
+			claimOutboxEventsFunc: func(ctx context.Context, limit int, lease time.Duration) ([]model.OutboxEvent, error) {
+				return nil, errors.New("db down")
+			},
+		}
+		dispatcher, err := NewDispatcher(store, publisherFunc(func(context.Context, model.OutboxEvent) error { return nil }), testConfig())
+		require.NoError(t, err)
+
+		_, err = dispatcher.Dispatch(context.Background())
+		require.Error(t, err)
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	dispatcher, err := NewDispatcher(&mockStore{}, nil, testConfig())
+	require.NoError(t, err)
+
+	require.Equal(t, time.Second, dispatcher.backoff(1))
+	require.Equal(t, 8*time.Second, dispatcher.backoff(4))
+	require.Equal(t, 10*time.Second, dispatcher.backoff(5))
+}
+
+func TestInvalidConfig(t *testing.T) {
+	config := testConfig()
+	config.MaxBackoff = time.Millisecond
+	_, err := NewDispatcher(&mockStore{}, nil, config)
+	require.Error(t, err)
+}
@@ -0,0 +1,138 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rezkam/TaxMan/model"
+	"github.com/rezkam/TaxMan/webhook"
+)
+
+// maxResponseBodySize is how much of a receiver's response body is read before the connection is reused.
+const maxResponseBodySize = 64 << 10
+
+// Publisher delivers the events of the outbox to their destination.
+// An event is deleted from the outbox only after Publish returns nil, and is published again otherwise,
+// so publishers may see the same event more than once and consumers should deduplicate by the change ID.
+type Publisher interface {
+	Publish(ctx context.Context, event model.OutboxEvent) error
+}
+
+// LogPublisher publishes events by logging them.
+type LogPublisher struct {
+	logger *slog.Logger
+}
+
+// NewLogPublisher creates a publisher that logs the events with logger.
+func NewLogPublisher(logger *slog.Logger) *LogPublisher {
+	return &LogPublisher{logger: logger}
+}
+
+func (p *LogPublisher) Publish(ctx context.Context, event model.OutboxEvent) error {
+	p.logger.InfoContext(ctx, "tax record change published",
+		"changeID", event.Change.ID,
+		"action", event.Change.Action,
+		"recordID", event.Change.Record.ID,
+		"municipality", event.Change.Record.Municipality,
+		"taxRate", event.Change.Record.TaxRate)
+	return nil
+}
+
+// FilePublisher publishes events by appending them as JSON lines to a file.
+type FilePublisher struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFilePublisher creates a publisher appending to the file at path, which is created if it does not exist.
+func NewFilePublisher(path string) (*FilePublisher, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open outbox file: %w", err)
+	}
+	return &FilePublisher{file: file}, nil
+}
+
+// Publish appends the event to the file and syncs it to disk before the event is removed from the outbox.
+func (p *FilePublisher) Publish(_ context.Context, event model.OutboxEvent) error {
+	line, err := json.Marshal(webhook.ChangeToPayload(event.Change))
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	line = append(line, '\n')
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.file.Write(line); err != nil {
+		return fmt.Errorf("failed to write event: %w", err)
+	}
+	if err := p.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync outbox file: %w", err)
+	}
+	return nil
+}
+
+// Close closes the file.
+func (p *FilePublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.file.Close()
+}
+
+// WebhookPublisher publishes events by posting them to a URL, signed like the notifications of webhook subscriptions.
+type WebhookPublisher struct {
+	url    string
+	secret string
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewWebhookPublisher creates a publisher posting to url, signing the events with secret.
+func NewWebhookPublisher(url, secret string, timeout time.Duration) *WebhookPublisher {
+	return &WebhookPublisher{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: timeout},
+		now:    time.Now,
+	}
+}
+
+func (p *WebhookPublisher) Publish(ctx context.Context, event model.OutboxEvent) error {
+	body, err := json.Marshal(webhook.ChangeToPayload(event.Change))
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+	timestamp := p.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "TaxMan-Outbox")
+	req.Header.Set(webhook.DeliveryHeader, strconv.FormatInt(event.ID, 10))
+	req.Header.Set(webhook.EventHeader, string(event.Change.Action))
+	req.Header.Set(webhook.TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(p.secret, timestamp, body))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBodySize))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
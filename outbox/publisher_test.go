@@ -0,0 +1,94 @@
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rezkam/TaxMan/internal/utils"
+	"github.com/rezkam/TaxMan/model"
+	"github.com/rezkam/TaxMan/webhook"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvent() model.OutboxEvent {
+	return model.OutboxEvent{
+		ID: 4,
+		Change: model.TaxRecordChange{
+			ID:     9,
+			Action: model.ActionCreate,
+			Record: model.TaxRecord{
+				ID:           2,
+				Municipality: "Odense",
+				TaxRate:      0.3,
+				StartDate:    utils.DateOnly(2024, time.January, 1),
+				PeriodType:   model.Yearly,
+			},
+		},
+	}
+}
+
+func TestFilePublisher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	publisher, err := NewFilePublisher(path)
+	require.NoError(t, err)
+
+	require.NoError(t, publisher.Publish(context.Background(), testEvent()))
+	require.NoError(t, publisher.Publish(context.Background(), testEvent()))
+	require.NoError(t, publisher.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var payloads []webhook.Payload
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var payload webhook.Payload
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &payload))
+		payloads = append(payloads, payload)
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, payloads, 2)
+	require.Equal(t, int64(9), payloads[0].ID)
+	require.Equal(t, "Odense", payloads[0].Municipality)
+	require.Empty(t, payloads[0].EndDate)
+}
+
+func TestWebhookPublisher(t *testing.T) {
+	t.Run("signed event posted", func(t *testing.T) {
+		var body []byte
+		var header http.Header
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ = io.ReadAll(r.Body)
+			header = r.Header.Clone()
+		}))
+		defer receiver.Close()
+
+		publisher := NewWebhookPublisher(receiver.URL, "secret", time.Second)
+		require.NoError(t, publisher.Publish(context.Background(), testEvent()))
+
+		timestamp, err := strconv.ParseInt(header.Get(webhook.TimestampHeader), 10, 64)
+		require.NoError(t, err)
+		require.True(t, webhook.VerifySignature("secret", timestamp, body, header.Get(webhook.SignatureHeader)))
+		require.Equal(t, "4", header.Get(webhook.DeliveryHeader))
+	})
+
+	t.Run("error status fails the event", func(t *testing.T) {
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer receiver.Close()
+
+		publisher := NewWebhookPublisher(receiver.URL, "secret", time.Second)
+		require.Error(t, publisher.Publish(context.Background(), testEvent()))
+	})
+}
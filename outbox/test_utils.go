@@ -0,0 +1,41 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/rezkam/TaxMan/model"
+)
+
+type mockStore struct {
+	claimOutboxEventsFunc func(ctx context.Context, limit int, lease time.Duration) ([]model.OutboxEvent, error)
+	deleteOutboxEventFunc func(ctx context.Context, eventID int64) error
+	failOutboxEventFunc   func(ctx context.Context, eventID int64, reason string, nextAttemptAt time.Time) error
+}
+
+func (m *mockStore) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]model.OutboxEvent, error) {
+	if m.claimOutboxEventsFunc != nil {
+		return m.claimOutboxEventsFunc(ctx, limit, lease)
+	}
+	return nil, nil
+}
+
+func (m *mockStore) DeleteOutboxEvent(ctx context.Context, eventID int64) error {
+	if m.deleteOutboxEventFunc != nil {
+		return m.deleteOutboxEventFunc(ctx, eventID)
+	}
+	return nil
+}
+
+func (m *mockStore) FailOutboxEvent(ctx context.Context, eventID int64, reason string, nextAttemptAt time.Time) error {
+	if m.failOutboxEventFunc != nil {
+		return m.failOutboxEventFunc(ctx, eventID, reason, nextAttemptAt)
+	}
+	return nil
+}
+
+type publisherFunc func(ctx context.Context, event model.OutboxEvent) error
+
+func (f publisherFunc) Publish(ctx context.Context, event model.OutboxEvent) error {
+	return f(ctx, event)
+}
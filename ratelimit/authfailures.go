@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rezkam/TaxMan/internal/httputil"
+	"github.com/rezkam/TaxMan/internal/problem"
+)
+
+// authFailuresPattern keys the buckets counting the authentication failures of IP addresses, no route has it as pattern.
+const authFailuresPattern = "auth-failures"
+
+// AuthFailureMiddleware rejects the requests of IP addresses that exceeded their limit of authentication failures
+// with 429, counting the requests next rejects with 401. It must run before authentication, which the route limits
+// of Middleware follow, so that guessing credentials is limited although it never yields an authenticated client.
+func (l *Limiter) AuthFailureMiddleware(next http.Handler) http.Handler {
+	limit := l.config.AuthFailures
+	if limit.unlimited() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := bucketKey{client: ipOf(r), pattern: authFailuresPattern}
+		if wait, ok := l.failuresLeft(key, limit); !ok {
+			w.Header().Set(retryAfterHeader, strconv.Itoa(max(1, ceilSeconds(wait))))
+			problem.Write(w, r, http.StatusTooManyRequests, problem.CodeRateLimitExceeded, "too many failed authentication attempts")
+			return
+		}
+
+		recorder := httputil.NewResponseRecorder(w)
+		next.ServeHTTP(recorder, r)
+		if recorder.Status() == http.StatusUnauthorized {
+			l.countFailure(key, limit)
+		}
+	})
+}
+
+// failuresLeft reports whether the bucket of key allows another failure, or how long it takes to allow one.
+func (l *Limiter) failuresLeft(key bucketKey, limit Limit) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		return 0, true
+	}
+	b.refill(limit, l.now())
+	if b.remaining() >= 1 {
+		return 0, true
+	}
+	return b.untilNext(limit), false
+}
+
+// countFailure takes a token from the bucket of key for a failed authentication.
+func (l *Limiter) countFailure(key bucketKey, limit Limit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = newBucket(limit, now)
+		l.buckets[key] = b
+	}
+	b.take(limit, now)
+}
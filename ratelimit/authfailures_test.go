@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthFailureMiddleware(t *testing.T) {
+	now := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(t, &mockStore{}, Config{AuthFailures: Limit{Rate: 0.5, Burst: 2}}, &now)
+	handler := limiter.AuthFailureMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "valid" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	serveKey := func(key, remoteAddr string) int {
+		rr := serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Set("X-API-Key", key)
+			handler.ServeHTTP(w, r)
+		}), http.MethodGet, "/tax/records/1", remoteAddr, nil)
+		return rr.Code
+	}
+
+	// Successful requests are not counted
+	for range 5 {
+		require.Equal(t, http.StatusOK, serveKey("valid", "192.0.2.1:1234"))
+	}
+
+	require.Equal(t, http.StatusUnauthorized, serveKey("guess", "192.0.2.1:1234"))
+	require.Equal(t, http.StatusUnauthorized, serveKey("guess", "192.0.2.1:5678"))
+
+	// Once the failures are used up, every request of the address is rejected until the bucket refills
+	rr := serve(handler, http.MethodGet, "/tax/records/1", "192.0.2.1:1234", nil)
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	require.Equal(t, "2", rr.Header().Get("Retry-After"))
+	require.Equal(t, http.StatusTooManyRequests, serveKey("valid", "192.0.2.1:1234"))
+
+	// Other addresses have their own failures
+	require.Equal(t, http.StatusUnauthorized, serveKey("guess", "192.0.2.2:1234"))
+
+	now = now.Add(2 * time.Second)
+	require.Equal(t, http.StatusOK, serveKey("valid", "192.0.2.1:1234"))
+
+	now = now.Add(4 * time.Second)
+	require.Equal(t, 2, limiter.PruneBuckets())
+}
+
+func TestAuthFailureMiddlewareUnlimited(t *testing.T) {
+	limiter, err := New(&mockStore{}, Config{})
+	require.NoError(t, err)
+	handler := limiter.AuthFailureMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+
+	for range 10 {
+		rr := serve(handler, http.MethodGet, "/tax/records/1", "192.0.2.1:1234", nil)
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+	}
+}
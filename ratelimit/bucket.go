@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// Limit is the rate of a token bucket, allowing Rate requests per second on average in bursts of up to Burst requests.
+// A zero Rate does not limit the requests.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// unlimited reports whether the limit lets every request through.
+func (l Limit) unlimited() bool {
+	return l.Rate == 0
+}
+
+// bucket is a token bucket refilled at the rate of its limit, each request taking one token.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newBucket(limit Limit, now time.Time) *bucket {
+	return &bucket{tokens: float64(limit.Burst), updated: now}
+}
+
+// refill adds the tokens accumulated since the last update, up to the burst of limit.
+func (b *bucket) refill(limit Limit, now time.Time) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed.Seconds()*limit.Rate)
+		b.updated = now
+	}
+}
+
+// take takes a token if one is left and reports whether it did.
+func (b *bucket) take(limit Limit, now time.Time) bool {
+	b.refill(limit, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// remaining is the number of whole tokens left.
+func (b *bucket) remaining() int {
+	return int(b.tokens)
+}
+
+// untilFull is how long the bucket takes to refill completely.
+func (b *bucket) untilFull(limit Limit) time.Duration {
+	return secondsToDuration((float64(limit.Burst) - b.tokens) / limit.Rate)
+}
+
+// untilNext is how long the bucket takes to refill the next token.
+func (b *bucket) untilNext(limit Limit) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return secondsToDuration((1 - b.tokens) / limit.Rate)
+}
+
+// full reports whether the bucket has refilled completely by now, so that it no longer needs to be kept.
+func (b *bucket) full(limit Limit, now time.Time) bool {
+	return now.Sub(b.updated) >= b.untilFull(limit)
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,32 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucket(t *testing.T) {
+	limit := Limit{Rate: 2, Burst: 3}
+	start := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	b := newBucket(limit, start)
+
+	for i := 0; i < 3; i++ {
+		require.True(t, b.take(limit, start))
+	}
+	require.False(t, b.take(limit, start))
+	require.Equal(t, 0, b.remaining())
+	require.Equal(t, 500*time.Millisecond, b.untilNext(limit))
+	require.Equal(t, 1500*time.Millisecond, b.untilFull(limit))
+
+	// Half a second refills one token
+	require.True(t, b.take(limit, start.Add(500*time.Millisecond)))
+	require.False(t, b.take(limit, start.Add(500*time.Millisecond)))
+
+	// The bucket never holds more than its burst
+	later := start.Add(time.Hour)
+	require.True(t, b.full(limit, later))
+	b.refill(limit, later)
+	require.Equal(t, 3, b.remaining())
+}
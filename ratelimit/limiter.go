@@ -0,0 +1,273 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rezkam/TaxMan/auth"
+	"github.com/rezkam/TaxMan/internal/httputil"
+	"github.com/rezkam/TaxMan/internal/problem"
+)
+
+const (
+	limitHeader      = "RateLimit-Limit"
+	remainingHeader  = "RateLimit-Remaining"
+	resetHeader      = "RateLimit-Reset"
+	retryAfterHeader = "Retry-After"
+)
+
+// Limiter limits the requests of each client with token buckets and optional daily quotas.
+// Clients are identified by their authenticated principal, or by their IP address when anonymous.
+type Limiter struct {
+	store   quotaStore
+	config  Config
+	limits  map[string]Limit
+	now     func() time.Time
+	mu      sync.Mutex
+	buckets map[bucketKey]*bucket
+}
+
+type Config struct {
+	// Default is the limit of the requests not matching a route limit.
+	Default Limit
+	// Routes are the limits of the requests routed to the routes registered with their patterns.
+	Routes []RouteLimit
+	// DailyQuota is the number of requests a client may make per UTC day, 0 disables the quota.
+	DailyQuota int64
+	// AuthFailures limits the requests of each IP address rejected for lacking valid credentials,
+	// see AuthFailureMiddleware. A zero Rate disables it.
+	AuthFailures Limit
+}
+
+// RouteLimit applies a limit to the requests of the route registered with a pattern such as
+// "GET /tax/{municipality}/{date}", in every version of the API and in its unversioned aliases.
+// Each client has a separate bucket per route limit, shared by the versions of the route.
+type RouteLimit struct {
+	Pattern string
+	Limit   Limit
+}
+
+type quotaStore interface {
+	// IncrementQuotaUsage counts a request of client on day and returns the number of requests it made that day.
+	IncrementQuotaUsage(ctx context.Context, client string, day time.Time) (int64, error)
+
+	// DeleteQuotaUsageBefore removes the request counts of the days before day.
+	DeleteQuotaUsageBefore(ctx context.Context, day time.Time) (int64, error)
+}
+
+// bucketKey identifies the bucket of a client for the route limit with an unversioned pattern,
+// empty for the default limit.
+type bucketKey struct {
+	client  string
+	pattern string
+}
+
+// New creates a new Limiter with the provided store and configuration.
+func New(store quotaStore, config Config) (*Limiter, error) {
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+	limits := make(map[string]Limit, len(config.Routes))
+	for _, route := range config.Routes {
+		limits[httputil.UnversionedPattern(route.Pattern)] = route.Limit
+	}
+	return &Limiter{
+		store:   store,
+		config:  config,
+		limits:  limits,
+		now:     time.Now,
+		buckets: make(map[bucketKey]*bucket),
+	}, nil
+}
+
+// validateConfig checks if the provided Config values are valid.
+func validateConfig(config Config) error {
+	if err := validateLimit(config.Default); err != nil {
+		return fmt.Errorf("invalid default limit: %w", err)
+	}
+	for _, route := range config.Routes {
+		if route.Pattern == "" {
+			return errors.New("route limit pattern cannot be empty")
+		}
+		if err := validateLimit(route.Limit); err != nil {
+			return fmt.Errorf("invalid limit of %q: %w", route.Pattern, err)
+		}
+	}
+	if config.DailyQuota < 0 {
+		return errors.New("DailyQuota cannot be negative")
+	}
+	if err := validateLimit(config.AuthFailures); err != nil {
+		return fmt.Errorf("invalid limit of authentication failures: %w", err)
+	}
+	return nil
+}
+
+func validateLimit(limit Limit) error {
+	if limit.Rate < 0 || math.IsInf(limit.Rate, 0) || math.IsNaN(limit.Rate) {
+		return errors.New("rate must be a non-negative number")
+	}
+	if !limit.unlimited() && limit.Burst < 1 {
+		return errors.New("burst must be at least 1")
+	}
+	return nil
+}
+
+// Middleware rejects the requests to next of clients exceeding their rate limit or daily quota with 429.
+// The route limits apply to the routes of mux the requests are routed to.
+// It reports the state of the client's bucket in the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers.
+// It must run after authentication to tell authenticated clients apart.
+func (l *Limiter) Middleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := clientOf(r)
+
+		pattern, limit := l.limitOf(mux, r)
+		if !limit.unlimited() {
+			if !l.take(w, bucketKey{client: client, pattern: pattern}, limit) {
+				problem.Write(w, r, http.StatusTooManyRequests, problem.CodeRateLimitExceeded, "rate limit exceeded")
+				return
+			}
+		}
+
+		if l.config.DailyQuota > 0 && !l.withinQuota(w, r, client) {
+			problem.Write(w, r, http.StatusTooManyRequests, problem.CodeDailyQuotaExceeded, "daily quota exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitOf returns the unversioned pattern of the route limit of the route r is routed to by mux and its limit,
+// or the default limit.
+func (l *Limiter) limitOf(mux *http.ServeMux, r *http.Request) (string, Limit) {
+	_, pattern := mux.Handler(r)
+	pattern = httputil.UnversionedPattern(pattern)
+	if limit, ok := l.limits[pattern]; ok {
+		return pattern, limit
+	}
+	return "", l.config.Default
+}
+
+// UnmatchedRoutes returns the patterns of the route limits matching none of patterns, the patterns of the
+// registered routes. The limits of such routes never apply, which is likely a mistake in the configuration.
+func (l *Limiter) UnmatchedRoutes(patterns []string) []string {
+	registered := make(map[string]bool, len(patterns))
+	for _, pattern := range patterns {
+		registered[httputil.UnversionedPattern(pattern)] = true
+	}
+	var unmatched []string
+	for _, route := range l.config.Routes {
+		if !registered[httputil.UnversionedPattern(route.Pattern)] {
+			unmatched = append(unmatched, route.Pattern)
+		}
+	}
+	return unmatched
+}
+
+// take takes a token from the bucket of key and writes its state to the headers of w.
+// It reports whether the request is allowed.
+func (l *Limiter) take(w http.ResponseWriter, key bucketKey, limit Limit) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = newBucket(limit, now)
+		l.buckets[key] = b
+	}
+	allowed := b.take(limit, now)
+
+	w.Header().Set(limitHeader, strconv.Itoa(limit.Burst))
+	w.Header().Set(remainingHeader, strconv.Itoa(b.remaining()))
+	w.Header().Set(resetHeader, strconv.Itoa(ceilSeconds(b.untilFull(limit))))
+	if !allowed {
+		w.Header().Set(retryAfterHeader, strconv.Itoa(max(1, ceilSeconds(b.untilNext(limit)))))
+	}
+	return allowed
+}
+
+// withinQuota counts the request of client and reports whether it is within the daily quota.
+// Requests are allowed if the store fails, so that an unavailable store does not reject all traffic.
+func (l *Limiter) withinQuota(w http.ResponseWriter, r *http.Request, client string) bool {
+	now := l.now().UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	requests, err := l.store.IncrementQuotaUsage(r.Context(), client, day)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to count quota usage", "client", client, "error", err)
+		return true
+	}
+	if requests <= l.config.DailyQuota {
+		return true
+	}
+	w.Header().Set(retryAfterHeader, strconv.Itoa(ceilSeconds(day.AddDate(0, 0, 1).Sub(now))))
+	return false
+}
+
+// PruneBuckets removes the buckets that have refilled completely, which behave like new buckets,
+// and returns how many were removed.
+func (l *Limiter) PruneBuckets() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	pruned := 0
+	for key, b := range l.buckets {
+		if b.full(l.limitOfPattern(key.pattern), now) {
+			delete(l.buckets, key)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// limitOfPattern returns the limit of the route limit with pattern, or the default limit for an empty pattern.
+func (l *Limiter) limitOfPattern(pattern string) Limit {
+	switch pattern {
+	case "":
+		return l.config.Default
+	case authFailuresPattern:
+		return l.config.AuthFailures
+	}
+	return l.limits[pattern]
+}
+
+// PurgeQuotaUsage removes the quota usage of the days before the current UTC day.
+func (l *Limiter) PurgeQuotaUsage(ctx context.Context) (int64, error) {
+	if l.config.DailyQuota == 0 {
+		return 0, nil
+	}
+	now := l.now().UTC()
+	return l.store.DeleteQuotaUsageBefore(ctx, time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC))
+}
+
+// clientOf identifies the client of r by its authenticated principal, or by its IP address when it is anonymous.
+// The IP address is the peer of the connection, requests relayed by a proxy share the proxy's address.
+func clientOf(r *http.Request) string {
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+		return principal.Name
+	}
+	return ipOf(r)
+}
+
+// ipOf identifies the client of r by the IP address of the peer of the connection.
+func ipOf(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// ceilSeconds rounds d up to whole seconds.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
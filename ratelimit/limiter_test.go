@@ -0,0 +1,212 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rezkam/TaxMan/auth"
+	"github.com/rezkam/TaxMan/model"
+	"github.com/stretchr/testify/require"
+)
+
+func testMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	for _, prefix := range []string{"", "/v1", "/v2"} {
+		mux.HandleFunc("GET "+prefix+"/tax/{municipality}/{date}", ok)
+	}
+	mux.HandleFunc("GET /tax/records/{id}", ok)
+	mux.HandleFunc("POST /tax", ok)
+	return mux
+}
+
+func newTestLimiter(t *testing.T, store quotaStore, config Config, now *time.Time) *Limiter {
+	t.Helper()
+	limiter, err := New(store, config)
+	require.NoError(t, err)
+	limiter.now = func() time.Time { return *now }
+	return limiter
+}
+
+func serve(handler http.Handler, method, path, remoteAddr string, principal *auth.Principal) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = remoteAddr
+	if principal != nil {
+		req = req.WithContext(auth.WithPrincipal(req.Context(), *principal))
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestMiddleware(t *testing.T) {
+	now := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(t, &mockStore{}, Config{
+		Default: Limit{Rate: 10, Burst: 5},
+		Routes: []RouteLimit{
+			{Pattern: "GET /tax/{municipality}/{date}", Limit: Limit{Rate: 1, Burst: 2}},
+		},
+	}, &now)
+	mux := testMux()
+	handler := limiter.Middleware(mux, mux)
+
+	t.Run("route limit", func(t *testing.T) {
+		rr := serve(handler, http.MethodGet, "/tax/Copenhagen/2024-01-01", "192.0.2.1:1234", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, "2", rr.Header().Get("RateLimit-Limit"))
+		require.Equal(t, "1", rr.Header().Get("RateLimit-Remaining"))
+		require.Equal(t, "1", rr.Header().Get("RateLimit-Reset"))
+
+		// Lookups of other municipalities share the bucket of the route
+		rr = serve(handler, http.MethodGet, "/tax/Aarhus/2024-01-01", "192.0.2.1:5678", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		rr = serve(handler, http.MethodGet, "/tax/Copenhagen/2024-01-01", "192.0.2.1:1234", nil)
+		require.Equal(t, http.StatusTooManyRequests, rr.Code)
+		require.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+		require.Equal(t, "1", rr.Header().Get("Retry-After"))
+	})
+
+	t.Run("default limit applies to other routes", func(t *testing.T) {
+		// The more specific record route is not limited by the rate lookup route
+		rr := serve(handler, http.MethodGet, "/tax/records/1", "192.0.2.1:1234", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, "5", rr.Header().Get("RateLimit-Limit"))
+		require.Equal(t, "4", rr.Header().Get("RateLimit-Remaining"))
+	})
+
+	t.Run("clients have separate buckets", func(t *testing.T) {
+		rr := serve(handler, http.MethodGet, "/tax/Copenhagen/2024-01-01", "192.0.2.2:1234", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		principal := auth.Principal{Name: "apikey:ci", Scopes: []model.Scope{model.ScopeRead}}
+		rr = serve(handler, http.MethodGet, "/tax/Copenhagen/2024-01-01", "192.0.2.1:1234", &principal)
+		require.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("tokens refill", func(t *testing.T) {
+		now = now.Add(time.Second)
+		rr := serve(handler, http.MethodGet, "/tax/Copenhagen/2024-01-01", "192.0.2.1:1234", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("full buckets are pruned", func(t *testing.T) {
+		now = now.Add(time.Minute)
+		require.Equal(t, 4, limiter.PruneBuckets())
+		require.Empty(t, limiter.buckets)
+	})
+}
+
+func TestMiddlewareVersions(t *testing.T) {
+	now := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(t, &mockStore{}, Config{
+		Routes: []RouteLimit{
+			{Pattern: "GET /v1/tax/{municipality}/{date}", Limit: Limit{Rate: 1, Burst: 3}},
+		},
+	}, &now)
+	mux := testMux()
+	handler := limiter.Middleware(mux, mux)
+
+	// The versions of the route and its alias share the limit and the bucket of the client
+	for _, path := range []string{"/v1/tax/Copenhagen/2024-01-01", "/v2/tax/Copenhagen/2024-01-01", "/tax/Copenhagen/2024-01-01"} {
+		rr := serve(handler, http.MethodGet, path, "192.0.2.1:1234", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, "3", rr.Header().Get("RateLimit-Limit"))
+	}
+	rr := serve(handler, http.MethodGet, "/v2/tax/Aarhus/2024-01-01", "192.0.2.1:1234", nil)
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+}
+
+func TestUnmatchedRoutes(t *testing.T) {
+	limiter, err := New(&mockStore{}, Config{
+		Routes: []RouteLimit{
+			{Pattern: "GET /tax/{municipality}/{date}", Limit: Limit{Rate: 1, Burst: 1}},
+			{Pattern: "GET /v2/tax/records/{id}", Limit: Limit{Rate: 1, Burst: 1}},
+			{Pattern: "GET /tax/{municipality}", Limit: Limit{Rate: 1, Burst: 1}},
+		},
+	})
+	require.NoError(t, err)
+
+	unmatched := limiter.UnmatchedRoutes([]string{"GET /v1/tax/{municipality}/{date}", "GET /v1/tax/records/{id}"})
+	require.Equal(t, []string{"GET /tax/{municipality}"}, unmatched)
+}
+
+func TestMiddlewareUnlimited(t *testing.T) {
+	now := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(t, &mockStore{}, Config{}, &now)
+	mux := testMux()
+	handler := limiter.Middleware(mux, mux)
+
+	for i := 0; i < 100; i++ {
+		rr := serve(handler, http.MethodPost, "/tax", "192.0.2.1:1234", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Empty(t, rr.Header().Get("RateLimit-Limit"))
+	}
+}
+
+func TestDailyQuota(t *testing.T) {
+	now := time.Date(2024, time.June, 1, 23, 0, 0, 0, time.UTC)
+	usage := map[string]int64{}
+	var storeErr error
+	store := &mockStore{
+		incrementQuotaUsageFunc: func(ctx context.Context, client string, day time.Time) (int64, error) {
+			if storeErr != nil {
+				return 0, storeErr
+			}
+			require.Equal(t, time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC), day)
+			usage[client]++
+			return usage[client], nil
+		},
+	}
+	limiter := newTestLimiter(t, store, Config{DailyQuota: 2}, &now)
+	mux := testMux()
+	handler := limiter.Middleware(mux, mux)
+
+	for i := 0; i < 2; i++ {
+		rr := serve(handler, http.MethodGet, "/tax/records/1", "192.0.2.1:1234", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+	}
+	rr := serve(handler, http.MethodGet, "/tax/records/1", "192.0.2.1:1234", nil)
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	require.Contains(t, rr.Body.String(), "daily quota exceeded")
+	require.Equal(t, "3600", rr.Header().Get("Retry-After"))
+	require.Equal(t, int64(3), usage["ip:192.0.2.1"])
+
+	t.Run("store failure lets requests through", func(t *testing.T) {
+		storeErr = errors.New("store error")
+		rr := serve(handler, http.MethodGet, "/tax/records/1", "192.0.2.1:1234", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("purge usage of previous days", func(t *testing.T) {
+		var purgedBefore time.Time
+		store.deleteQuotaUsageBeforeFunc = func(ctx context.Context, day time.Time) (int64, error) {
+			purgedBefore = day
+			return 1, nil
+		}
+		purged, err := limiter.PurgeQuotaUsage(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, int64(1), purged)
+		require.Equal(t, time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC), purgedBefore)
+	})
+}
+
+func TestValidateConfig(t *testing.T) {
+	for name, config := range map[string]Config{
+		"negative rate":  {Default: Limit{Rate: -1, Burst: 1}},
+		"no burst":       {Default: Limit{Rate: 1}},
+		"empty pattern":  {Routes: []RouteLimit{{Limit: Limit{Rate: 1, Burst: 1}}}},
+		"invalid route":  {Routes: []RouteLimit{{Pattern: "POST /tax", Limit: Limit{Rate: 1}}}},
+		"negative quota": {DailyQuota: -1},
+		"auth failures":  {AuthFailures: Limit{Rate: 1}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(&mockStore{}, config)
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+type mockStore struct {
+	incrementQuotaUsageFunc    func(ctx context.Context, client string, day time.Time) (int64, error)
+	deleteQuotaUsageBeforeFunc func(ctx context.Context, day time.Time) (int64, error)
+}
+
+func (m *mockStore) IncrementQuotaUsage(ctx context.Context, client string, day time.Time) (int64, error) {
+	if m.incrementQuotaUsageFunc != nil {
+		return m.incrementQuotaUsageFunc(ctx, client, day)
+	}
+	return 1, nil
+}
+
+func (m *mockStore) DeleteQuotaUsageBefore(ctx context.Context, day time.Time) (int64, error) {
+	if m.deleteQuotaUsageBeforeFunc != nil {
+		return m.deleteQuotaUsageBeforeFunc(ctx, day)
+	}
+	return 0, nil
+}
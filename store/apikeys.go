@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/rezkam/TaxMan/model"
+)
+
+// CreateAPIKey stores a new API key identified by the hash of its secret.
+// It returns model.ErrConflict if an active key with the same name exists.
+func (s *PostgresStore) CreateAPIKey(ctx context.Context, key model.APIKey, keyHash []byte) (model.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("insertAPIKey")
+	if err != nil {
+		return model.APIKey{}, err
+	}
+	scopes := make([]string, 0, len(key.Scopes))
+	for _, scope := range key.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+	municipalities := key.Municipalities
+	if municipalities == nil {
+		municipalities = []string{}
+	}
+	created, err := scanAPIKey(stmt.QueryRowContext(ctx, key.Name, key.Prefix, keyHash, pq.Array(scopes), pq.Array(municipalities)))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return model.APIKey{}, model.ErrConflict
+		}
+		return model.APIKey{}, fmt.Errorf("failed to execute stmtInsertAPIKey: %w", err)
+	}
+	return created, nil
+}
+
+// GetActiveAPIKeyByHash retrieves the active API key whose secret has the given hash.
+// It returns model.ErrNotFound if no such key exists or it was revoked.
+func (s *PostgresStore) GetActiveAPIKeyByHash(ctx context.Context, keyHash []byte) (model.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("selectActiveAPIKeyByHash")
+	if err != nil {
+		return model.APIKey{}, err
+	}
+	key, err := scanAPIKey(stmt.QueryRowContext(ctx, keyHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.APIKey{}, model.ErrNotFound
+		}
+		return model.APIKey{}, fmt.Errorf("failed to execute stmtSelectActiveAPIKeyByHash: %w", err)
+	}
+	return key, nil
+}
+
+// ListAPIKeys retrieves all API keys including the revoked ones, oldest first.
+func (s *PostgresStore) ListAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("selectAPIKeys")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute stmtSelectAPIKeys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []model.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api key row: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read api keys: %w", err)
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an API key, which can no longer be used.
+// It returns model.ErrNotFound if the key does not exist or is already revoked.
+func (s *PostgresStore) RevokeAPIKey(ctx context.Context, keyID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("revokeAPIKey")
+	if err != nil {
+		return err
+	}
+	result, err := stmt.ExecContext(ctx, keyID)
+	if err != nil {
+		return fmt.Errorf("failed to execute stmtRevokeAPIKey: %w", err)
+	}
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to read revoked api keys: %w", err)
+	}
+	if revoked == 0 {
+		return model.ErrNotFound
+	}
+	return nil
+}
+
+// scanAPIKey scans a row selected with sqlAPIKeyColumns.
+func scanAPIKey(row rowScanner) (model.APIKey, error) {
+	var key model.APIKey
+	var scopes, municipalities []string
+	var revokedAt sql.NullTime
+	if err := row.Scan(&key.ID, &key.Name, &key.Prefix, pq.Array(&scopes), pq.Array(&municipalities), &key.CreatedAt, &revokedAt); err != nil {
+		return model.APIKey{}, err
+	}
+	for _, scope := range scopes {
+		key.Scopes = append(key.Scopes, model.Scope(scope))
+	}
+	if len(municipalities) > 0 {
+		key.Municipalities = municipalities
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return key, nil
+}
@@ -0,0 +1,220 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rezkam/TaxMan/internal/requestctx"
+	"github.com/rezkam/TaxMan/model"
+)
+
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// CreateTaxRecordDraft stores a new draft of a tax record change, created by the actor in ctx.
+func (s *PostgresStore) CreateTaxRecordDraft(ctx context.Context, draft model.TaxRecordDraft) (model.TaxRecordDraft, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("insertTaxRecordDraft")
+	if err != nil {
+		return model.TaxRecordDraft{}, err
+	}
+
+	var publishAt sql.NullTime
+	if !draft.PublishAt.IsZero() {
+		publishAt = sql.NullTime{Time: draft.PublishAt, Valid: true}
+	}
+	record := draft.Record
+	row := stmt.QueryRowContext(ctx, record.Municipality, record.TaxRate, marshalDateRange(record.StartDate, record.EndDate),
+		record.PeriodType, publishAt, requestctx.Actor(ctx))
+	created, err := scanTaxRecordDraft(row)
+	if err != nil {
+		return model.TaxRecordDraft{}, fmt.Errorf("failed to execute stmtInsertTaxRecordDraft: %w", err)
+	}
+	return created, nil
+}
+
+// GetTaxRecordDraft retrieves a draft by its ID.
+// It returns model.ErrNotFound if the draft does not exist.
+func (s *PostgresStore) GetTaxRecordDraft(ctx context.Context, draftID int64) (model.TaxRecordDraft, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("selectTaxRecordDraft")
+	if err != nil {
+		return model.TaxRecordDraft{}, err
+	}
+	draft, err := scanTaxRecordDraft(stmt.QueryRowContext(ctx, draftID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.TaxRecordDraft{}, model.ErrNotFound
+		}
+		return model.TaxRecordDraft{}, fmt.Errorf("failed to execute stmtSelectTaxRecordDraft: %w", err)
+	}
+	return draft, nil
+}
+
+// ListTaxRecordDrafts retrieves the drafts in the given status, or all drafts if status is empty, oldest first.
+func (s *PostgresStore) ListTaxRecordDrafts(ctx context.Context, status model.DraftStatus) ([]model.TaxRecordDraft, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("selectTaxRecordDrafts")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := stmt.QueryContext(ctx, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute stmtSelectTaxRecordDrafts: %w", err)
+	}
+	defer rows.Close()
+
+	var drafts []model.TaxRecordDraft
+	for rows.Next() {
+		draft, err := scanTaxRecordDraft(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan tax record draft row: %w", err)
+		}
+		drafts = append(drafts, draft)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tax record drafts: %w", err)
+	}
+	return drafts, nil
+}
+
+// TransitionTaxRecordDraft moves a draft from one status to the next. When the draft is approved,
+// the actor in ctx is recorded as the approver.
+// It returns model.ErrNotFound if the draft does not exist and model.ErrInvalidState if it is not in status from.
+func (s *PostgresStore) TransitionTaxRecordDraft(ctx context.Context, draftID int64, from, to model.DraftStatus) (model.TaxRecordDraft, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("transitionTaxRecordDraft")
+	if err != nil {
+		return model.TaxRecordDraft{}, err
+	}
+	draft, err := scanTaxRecordDraft(stmt.QueryRowContext(ctx, draftID, from, to, requestctx.Actor(ctx)))
+	if err == nil {
+		return draft, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return model.TaxRecordDraft{}, fmt.Errorf("failed to execute stmtTransitionTaxRecordDraft: %w", err)
+	}
+
+	// Nothing was updated, tell a missing draft apart from one in another status
+	if _, err := s.GetTaxRecordDraft(ctx, draftID); err != nil {
+		return model.TaxRecordDraft{}, err
+	}
+	return model.TaxRecordDraft{}, model.ErrInvalidState
+}
+
+// PublishTaxRecordDraft writes the record of an approved draft to the tax records and marks the draft as published,
+// within a single transaction. The change is attributed to the actor in ctx.
+// It returns model.ErrNotFound if the draft does not exist, model.ErrInvalidState if it is not approved
+// and model.ErrNotDue if it is scheduled to be published after now.
+func (s *PostgresStore) PublishTaxRecordDraft(ctx context.Context, draftID int64, now time.Time) (model.TaxRecordDraft, error) {
+	ctx, cancel := context.WithTimeout(ctx, transactionTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return model.TaxRecordDraft{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	selectStmt, err := s.txStatement(ctx, tx, "selectTaxRecordDraftForUpdate")
+	if err != nil {
+		return model.TaxRecordDraft{}, err
+	}
+	draft, err := scanTaxRecordDraft(selectStmt.QueryRowContext(ctx, draftID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.TaxRecordDraft{}, model.ErrNotFound
+		}
+		return model.TaxRecordDraft{}, fmt.Errorf("failed to execute stmtSelectTaxRecordDraftForUpdate: %w", err)
+	}
+	if draft.Status != model.DraftStatusApproved {
+		return model.TaxRecordDraft{}, model.ErrInvalidState
+	}
+	if !draft.PublishAt.IsZero() && draft.PublishAt.After(now) {
+		return model.TaxRecordDraft{}, model.ErrNotDue
+	}
+
+	// Drafts are reviewed before they are published, so they overwrite the record whatever its version
+	record, err := s.addOrUpdateTaxRecord(ctx, tx, draft.Record, model.AnyVersion)
+	if err != nil {
+		return model.TaxRecordDraft{}, err
+	}
+
+	markStmt, err := s.txStatement(ctx, tx, "markTaxRecordDraftPublished")
+	if err != nil {
+		return model.TaxRecordDraft{}, err
+	}
+	published, err := scanTaxRecordDraft(markStmt.QueryRowContext(ctx, draftID, record.ID))
+	if err != nil {
+		return model.TaxRecordDraft{}, fmt.Errorf("failed to execute stmtMarkTaxRecordDraftPublished: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return model.TaxRecordDraft{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return published, nil
+}
+
+// GetDueTaxRecordDraftIDs retrieves the IDs of the approved drafts scheduled to be published at or before now.
+func (s *PostgresStore) GetDueTaxRecordDraftIDs(ctx context.Context, now time.Time) ([]int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("selectDueTaxRecordDrafts")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := stmt.QueryContext(ctx, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute stmtSelectDueTaxRecordDrafts: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan due tax record draft row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read due tax record drafts: %w", err)
+	}
+	return ids, nil
+}
+
+// scanTaxRecordDraft scans a row selected with sqlTaxRecordDraftColumns.
+func scanTaxRecordDraft(row rowScanner) (model.TaxRecordDraft, error) {
+	var draft model.TaxRecordDraft
+	var period string
+	var publishAt sql.NullTime
+	var approvedBy sql.NullString
+	var publishedRecordID sql.NullInt64
+	err := row.Scan(&draft.ID, &draft.Record.Municipality, &draft.Record.TaxRate, &period, &draft.Record.PeriodType,
+		&draft.Status, &publishAt, &draft.CreatedBy, &approvedBy, &publishedRecordID, &draft.CreatedAt, &draft.UpdatedAt)
+	if err != nil {
+		return model.TaxRecordDraft{}, err
+	}
+	draft.Record.StartDate, draft.Record.EndDate, err = unmarshalDateRange(period)
+	if err != nil {
+		return model.TaxRecordDraft{}, fmt.Errorf("failed to parse period date range: %w", err)
+	}
+	draft.PublishAt = publishAt.Time
+	draft.ApprovedBy = approvedBy.String
+	draft.PublishedRecordID = publishedRecordID.Int64
+	return draft, nil
+}
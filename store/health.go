@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// requiredSchema are the tables, and the columns added to existing tables by migrations, the store relies on.
+var requiredSchema = []string{
+	"municipality_taxes",
+	"municipality_taxes.deleted_at",
+	"municipality_taxes.version",
+	"municipality_taxes_history",
+	"municipality_taxes_versions",
+	"municipality_tax_drafts",
+	"municipality_tax_changes",
+	"municipality_tax_outbox",
+	"api_keys",
+	"api_keys.municipalities",
+	"client_quota_usage",
+	"webhook_subscriptions",
+	"webhook_deliveries",
+	"webhook_delivery_attempts",
+	"webhook_dead_letters",
+	"webhook_cursor",
+}
+
+// Ready returns an error unless the store can serve requests: the database answers
+// and the migrations are applied. The migrations are checked through a prepared statement,
+// which is prepared again on the connection it runs on when needed, like the others.
+func (s *PostgresStore) Ready(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	stmt, err := s.statement("selectMissingSchema")
+	if err != nil {
+		return err
+	}
+	rows, err := stmt.QueryContext(ctx, pq.Array(requiredSchema))
+	if err != nil {
+		return fmt.Errorf("failed to execute stmtSelectMissingSchema: %w", err)
+	}
+	defer rows.Close()
+
+	var missingSchema []string
+	for rows.Next() {
+		var object string
+		if err := rows.Scan(&object); err != nil {
+			return fmt.Errorf("failed to scan missing schema: %w", err)
+		}
+		missingSchema = append(missingSchema, object)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read missing schema: %w", err)
+	}
+	if len(missingSchema) > 0 {
+		return errors.New("migrations not applied, missing " + strings.Join(missingSchema, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// TaxRecordsChangedChannel is the channel notified with the municipality's name whenever its tax records change.
+const TaxRecordsChangedChannel = "municipality_taxes_changed"
+
+const (
+	listenerMinReconnectInterval = time.Second
+	listenerMaxReconnectInterval = time.Minute
+	// listenerPingInterval is how long the listener waits without notifications before checking its connection.
+	listenerPingInterval = 90 * time.Second
+)
+
+// ListenTaxRecordChanges calls onChange with the municipality of every committed tax record change,
+// including the changes made by other instances, until ctx is done.
+// Notifications sent while the connection was lost cannot be recovered, onMissed is called after
+// every reconnection so that the caller can drop any state derived from the records.
+func (s *PostgresStore) ListenTaxRecordChanges(ctx context.Context, onChange func(municipality string), onMissed func()) error {
+	listener := pq.NewListener(s.connStr, listenerMinReconnectInterval, listenerMaxReconnectInterval,
+		func(event pq.ListenerEventType, err error) {
+			switch event {
+			case pq.ListenerEventDisconnected:
+				slog.Warn("tax record change listener disconnected", "error", err)
+			case pq.ListenerEventReconnected:
+				slog.Info("tax record change listener reconnected")
+			case pq.ListenerEventConnectionAttemptFailed:
+				slog.Warn("tax record change listener failed to connect", "error", err)
+			}
+		})
+	defer listener.Close()
+
+	if err := listener.Listen(TaxRecordsChangedChannel); err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", TaxRecordsChangedChannel, err)
+	}
+
+	for {
+		select {
+		case notification := <-listener.Notify:
+			// A nil notification signals a reconnection, notifications may have been lost meanwhile
+			if notification == nil {
+				onMissed()
+				continue
+			}
+			onChange(notification.Extra)
+		case <-time.After(listenerPingInterval):
+			if err := listener.Ping(); err != nil {
+				slog.Warn("tax record change listener ping failed", "error", err)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
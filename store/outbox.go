@@ -0,0 +1,78 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rezkam/TaxMan/model"
+)
+
+// ClaimOutboxEvents claims at most limit outbox events that are due, oldest first.
+// Claimed events are postponed by lease, so that they are published again if they are never
+// deleted or failed, e.g. after a crash. Events are therefore published at least once.
+func (s *PostgresStore) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]model.OutboxEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("claimOutboxEvents")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := stmt.QueryContext(ctx, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute stmtClaimOutboxEvents: %w", err)
+	}
+	defer rows.Close()
+
+	var events []model.OutboxEvent
+	for rows.Next() {
+		var event model.OutboxEvent
+		var period string
+		change := &event.Change
+		if err := rows.Scan(&event.ID, &event.Attempts, &event.LastError, &event.CreatedAt,
+			&change.ID, &change.Record.ID, &change.Record.Municipality, &change.Action, &change.Record.TaxRate, &period,
+			&change.Record.PeriodType, &change.ChangedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox event row: %w", err)
+		}
+		change.Record.StartDate, change.Record.EndDate, err = unmarshalDateRange(period)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse period date range: %w", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read outbox events: %w", err)
+	}
+	return events, nil
+}
+
+// DeleteOutboxEvent removes a published event from the outbox.
+func (s *PostgresStore) DeleteOutboxEvent(ctx context.Context, eventID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("deleteOutboxEvent")
+	if err != nil {
+		return err
+	}
+	if _, err := stmt.ExecContext(ctx, eventID); err != nil {
+		return fmt.Errorf("failed to execute stmtDeleteOutboxEvent: %w", err)
+	}
+	return nil
+}
+
+// FailOutboxEvent records a failed attempt to publish an event, which is published again at nextAttemptAt.
+func (s *PostgresStore) FailOutboxEvent(ctx context.Context, eventID int64, reason string, nextAttemptAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("updateOutboxEventFailure")
+	if err != nil {
+		return err
+	}
+	if _, err := stmt.ExecContext(ctx, eventID, nextAttemptAt, reason); err != nil {
+		return fmt.Errorf("failed to execute stmtUpdateOutboxEventFailure: %w", err)
+	}
+	return nil
+}
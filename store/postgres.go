@@ -7,9 +7,11 @@ import (
 	"fmt"
 	"log/slog"
 	"strings"
+	"sync/atomic"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"github.com/rezkam/TaxMan/internal/requestctx"
 	"github.com/rezkam/TaxMan/model"
 )
 
@@ -24,9 +26,16 @@ const (
 	maxIdleConnections = 5
 )
 
+// dateLayout is the layout used to exchange dates with the database.
+const dateLayout = "2006-01-02"
+
 type PostgresStore struct {
 	db                 *sql.DB
 	preparedStatements map[string]*sql.Stmt
+	// connStr is kept to open the dedicated connections of change listeners.
+	connStr string
+	// observer is notified of the executions of prepared statements, it is nil until set.
+	observer atomic.Pointer[StatementObserver]
 }
 
 // NewPostgresStore initializes and returns a new PostgresStore after ensuring the database is ready.
@@ -64,6 +73,7 @@ func NewPostgresStore(connStr string) (*PostgresStore, error) {
 	store := &PostgresStore{
 		db:                 db,
 		preparedStatements: make(map[string]*sql.Stmt),
+		connStr:            connStr,
 	}
 	if err := store.prepareStatements(prepareStmtCtx); err != nil {
 		return nil, fmt.Errorf("failed to prepare statements: %w", err)
@@ -105,7 +115,20 @@ func pingDBWithRetry(ctx context.Context, db *sql.DB) error {
 func createTables(ctx context.Context, db *sql.DB) error {
 	queries := []string{
 		sqlCreateMunicipalityTaxesTable,
+		sqlMigrateSoftDelete,
+		sqlMigrateVersion,
+		sqlCreateMunicipalityTaxesHistoryTable,
+		sqlCreateMunicipalityTaxesVersionsTable,
+		sqlCreateMunicipalityTaxDraftsTable,
+		sqlCreateMunicipalityTaxChangesTable,
+		sqlCreateOutboxTable,
+		sqlCreateAPIKeysTable,
+		sqlMigrateAPIKeyMunicipalities,
+		sqlCreateQuotaUsageTable,
+		sqlCreateWebhookTables,
 		sqlCreateIndexes,
+		sqlBackfillTaxRecordVersions,
+		sqlBackfillTaxRecordChanges,
 	}
 
 	for _, query := range queries {
@@ -117,12 +140,64 @@ func createTables(ctx context.Context, db *sql.DB) error {
 	return nil
 }
 
+// statementsToPrepare are the SQL statements of the store, prepared by name.
+var statementsToPrepare = map[string]string{
+	"insertOrUpdateTaxRecord":       sqlInsertOrUpdateTaxRecord,
+	"closeOpenEndedTaxRecords":      sqlCloseOpenEndedTaxRecords,
+	"selectTaxRecordForUpdate":      sqlSelectTaxRecordForUpdate,
+	"selectTaxRecords":              sqlSelectTaxRecords,
+	"insertTaxRecordHistory":        sqlInsertTaxRecordHistory,
+	"selectTaxRecordHistory":        sqlSelectTaxRecordHistory,
+	"selectMunicipalityLastChange":  sqlSelectMunicipalityLastChange,
+	"taxRecordExists":               sqlTaxRecordExists,
+	"closeTaxRecordVersion":         sqlCloseTaxRecordVersion,
+	"insertTaxRecordVersion":        sqlInsertTaxRecordVersion,
+	"selectTaxRecordsAsOf":          sqlSelectTaxRecordsAsOf,
+	"selectMunicipalityOfTaxRecord": sqlSelectMunicipalityOfTaxRecord,
+	"selectTaxRecordByID":           sqlSelectTaxRecordByID,
+	"selectTaxRecordByIDForUpdate":  sqlSelectTaxRecordByIDForUpdate,
+	"updateTaxRecordRate":           sqlUpdateTaxRecordRate,
+	"softDeleteTaxRecord":           sqlSoftDeleteTaxRecord,
+	"restoreTaxRecord":              sqlRestoreTaxRecord,
+	"purgeDeletedTaxRecords":        sqlPurgeDeletedTaxRecords,
+	"insertTaxRecordDraft":          sqlInsertTaxRecordDraft,
+	"selectTaxRecordDraft":          sqlSelectTaxRecordDraft,
+	"selectTaxRecordDraftForUpdate": sqlSelectTaxRecordDraftForUpdate,
+	"selectTaxRecordDrafts":         sqlSelectTaxRecordDrafts,
+	"transitionTaxRecordDraft":      sqlTransitionTaxRecordDraft,
+	"markTaxRecordDraftPublished":   sqlMarkTaxRecordDraftPublished,
+	"selectDueTaxRecordDrafts":      sqlSelectDueTaxRecordDrafts,
+	"insertTaxRecordChange":         sqlInsertTaxRecordChange,
+	"selectTaxRecordChanges":        sqlSelectTaxRecordChanges,
+	"insertOutboxEvent":             sqlInsertOutboxEvent,
+	"claimOutboxEvents":             sqlClaimOutboxEvents,
+	"deleteOutboxEvent":             sqlDeleteOutboxEvent,
+	"updateOutboxEventFailure":      sqlUpdateOutboxEventFailure,
+	"insertAPIKey":                  sqlInsertAPIKey,
+	"selectActiveAPIKeyByHash":      sqlSelectActiveAPIKeyByHash,
+	"selectAPIKeys":                 sqlSelectAPIKeys,
+	"revokeAPIKey":                  sqlRevokeAPIKey,
+	"incrementQuotaUsage":           sqlIncrementQuotaUsage,
+	"deleteQuotaUsageBefore":        sqlDeleteQuotaUsageBefore,
+	"insertWebhookSubscription":     sqlInsertWebhookSubscription,
+	"selectWebhookSubscription":     sqlSelectWebhookSubscription,
+	"selectWebhookSubscriptions":    sqlSelectWebhookSubscriptions,
+	"deleteWebhookSubscription":     sqlDeleteWebhookSubscription,
+	"selectWebhookCursorForUpdate":  sqlSelectWebhookCursorForUpdate,
+	"selectNextChangeID":            sqlSelectNextChangeID,
+	"fanOutTaxRecordChanges":        sqlFanOutTaxRecordChanges,
+	"updateWebhookCursor":           sqlUpdateWebhookCursor,
+	"claimWebhookDeliveries":        sqlClaimWebhookDeliveries,
+	"insertWebhookDeliveryAttempt":  sqlInsertWebhookDeliveryAttempt,
+	"updateWebhookDelivery":         sqlUpdateWebhookDelivery,
+	"insertWebhookDeadLetter":       sqlInsertWebhookDeadLetter,
+	"selectWebhookDeliveryAttempts": sqlSelectWebhookDeliveryAttempts,
+	"selectWebhookDeadLetters":      sqlSelectWebhookDeadLetters,
+	"selectMissingSchema":           sqlSelectMissingSchema,
+}
+
 // prepareStatements prepares all the necessary SQL statements for the store.
 func (s *PostgresStore) prepareStatements(ctx context.Context) error {
-	statementsToPrepare := map[string]string{
-		"insertOrUpdateTaxRecord": sqlInsertOrUpdateTaxRecord,
-		"selectTaxRecords":        sqlSelectTaxRecords,
-	}
 	for name, query := range statementsToPrepare {
 		stmt, err := s.db.PrepareContext(ctx, query)
 		if err != nil {
@@ -138,6 +213,15 @@ func (s *PostgresStore) prepareStatements(ctx context.Context) error {
 func (s *PostgresStore) CleanupDB() error {
 	queries := []string{
 		sqlTruncateMunicipalityTaxesTable,
+		sqlTruncateMunicipalityTaxesHistoryTable,
+		sqlTruncateMunicipalityTaxesVersionsTable,
+		sqlTruncateMunicipalityTaxDraftsTable,
+		sqlTruncateMunicipalityTaxChangesTable,
+		sqlTruncateOutboxTable,
+		sqlTruncateAPIKeysTable,
+		sqlTruncateQuotaUsageTable,
+		sqlTruncateWebhookTables,
+		sqlResetWebhookCursor,
 	}
 	for _, query := range queries {
 		if _, err := s.db.Exec(query); err != nil {
@@ -147,55 +231,497 @@ func (s *PostgresStore) CleanupDB() error {
 	return nil
 }
 
-// AddOrUpdateTaxRecord adds a new tax record or updates an existing one.
-func (s *PostgresStore) AddOrUpdateTaxRecord(ctx context.Context, record model.TaxRecord) error {
+// statement returns the prepared statement registered under name.
+func (s *PostgresStore) statement(name string) (preparedStatement, error) {
+	stmt, ok := s.preparedStatements[name]
+	if !ok {
+		return preparedStatement{}, fmt.Errorf("statement '%s' not prepared", name)
+	}
+	return preparedStatement{Stmt: stmt, name: name, store: s}, nil
+}
+
+// txStatement returns the prepared statement registered under name, bound to the transaction.
+func (s *PostgresStore) txStatement(ctx context.Context, tx *sql.Tx, name string) (preparedStatement, error) {
+	stmt, err := s.statement(name)
+	if err != nil {
+		return preparedStatement{}, err
+	}
+	stmt.Stmt = tx.StmtContext(ctx, stmt.Stmt)
+	return stmt, nil
+}
+
+// AddOrUpdateTaxRecord adds a new tax record or updates an existing one and returns the stored record.
+// An existing record is only overwritten at expectedVersion, or at any version with model.AnyVersion, and its
+// version is incremented when its rate changes. It returns model.ErrVersionRequired when a record exists and
+// expectedVersion is model.NoVersion, and model.ErrVersionMismatch when the record is not at expectedVersion,
+// along with the ID and version of the existing record if any.
+// Open-ended records of the same municipality and period type that started before the new record
+// are closed the day before the new record starts, within the same transaction.
+// Every change is written to the history of the affected records in the same transaction.
+func (s *PostgresStore) AddOrUpdateTaxRecord(ctx context.Context, record model.TaxRecord, expectedVersion int64) (model.TaxRecord, error) {
 	ctx, cancel := context.WithTimeout(ctx, transactionTimeout)
 	defer cancel()
 
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return model.TaxRecord{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	record, err = s.addOrUpdateTaxRecord(ctx, tx, record, expectedVersion)
+	if err != nil {
+		return record, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return model.TaxRecord{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return record, nil
+}
+
+// addOrUpdateTaxRecord adds or updates record within tx, see AddOrUpdateTaxRecord.
+func (s *PostgresStore) addOrUpdateTaxRecord(ctx context.Context, tx *sql.Tx, record model.TaxRecord, expectedVersion int64) (model.TaxRecord, error) {
+	if _, err := tx.ExecContext(ctx, sqlLockMunicipality, record.Municipality); err != nil {
+		return model.TaxRecord{}, fmt.Errorf("failed to lock municipality: %w", err)
+	}
+
 	period := marshalDateRange(record.StartDate, record.EndDate)
-	stmt, ok := s.preparedStatements["insertOrUpdateTaxRecord"]
-	if !ok {
-		return fmt.Errorf("statement 'stmtInsertOrUpdateTaxRecord' not prepared")
+
+	// Look up the current value, if any, so that it can be recorded in the history
+	selectStmt, err := s.txStatement(ctx, tx, "selectTaxRecordForUpdate")
+	if err != nil {
+		return model.TaxRecord{}, err
+	}
+	var existing model.TaxRecord
+	err = selectStmt.QueryRowContext(ctx, record.Municipality, period, record.PeriodType).Scan(&existing.ID, &existing.TaxRate, &existing.Version)
+	exists := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return model.TaxRecord{}, fmt.Errorf("failed to execute stmtSelectTaxRecordForUpdate: %w", err)
+	}
+	switch {
+	case exists && expectedVersion == model.NoVersion:
+		return existing, model.ErrVersionRequired
+	case exists && expectedVersion != model.AnyVersion && expectedVersion != existing.Version:
+		return existing, model.ErrVersionMismatch
+	case !exists && expectedVersion > 0:
+		// The expected record was deleted or replaced since it was read
+		return model.TaxRecord{}, model.ErrVersionMismatch
+	}
+
+	if err := s.closeOpenEndedTaxRecords(ctx, tx, record); err != nil {
+		return model.TaxRecord{}, err
 	}
 
-	_, err := stmt.ExecContext(ctx, record.Municipality, record.TaxRate, period, record.PeriodType)
+	upsertStmt, err := s.txStatement(ctx, tx, "insertOrUpdateTaxRecord")
+	if err != nil {
+		return model.TaxRecord{}, err
+	}
+	err = upsertStmt.QueryRowContext(ctx, record.Municipality, record.TaxRate, period, record.PeriodType).Scan(&record.ID, &record.Version)
 	if err != nil {
-		return fmt.Errorf("failed to execute stmtInsertOrUpdateTaxRecord: %w", err)
+		return model.TaxRecord{}, fmt.Errorf("failed to execute stmtInsertOrUpdateTaxRecord: %w", err)
 	}
-	return err
+
+	newState := &model.TaxRecordState{TaxRate: record.TaxRate, StartDate: record.StartDate, EndDate: record.EndDate}
+	switch {
+	case !exists:
+		err = s.recordChange(ctx, tx, record, model.ActionCreate, nil, newState)
+	case existing.TaxRate != record.TaxRate:
+		oldState := &model.TaxRecordState{TaxRate: existing.TaxRate, StartDate: record.StartDate, EndDate: record.EndDate}
+		err = s.recordChange(ctx, tx, record, model.ActionUpdate, oldState, newState)
+	}
+	if err != nil {
+		return model.TaxRecord{}, err
+	}
+	return record, nil
 }
 
-// GetTaxRecords retrieves all tax records for a municipality that match a specific date.
-func (s *PostgresStore) GetTaxRecords(ctx context.Context, query model.TaxQuery) ([]model.TaxRecord, error) {
+// GetTaxRecord retrieves a tax record by its ID, including soft-deleted records.
+// It returns model.ErrNotFound if the record does not exist.
+func (s *PostgresStore) GetTaxRecord(ctx context.Context, recordID int64) (model.TaxRecord, error) {
 	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
 	defer cancel()
 
-	var records []model.TaxRecord
+	stmt, err := s.statement("selectTaxRecordByID")
+	if err != nil {
+		return model.TaxRecord{}, err
+	}
+	record, err := scanTaxRecord(stmt.QueryRowContext(ctx, recordID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.TaxRecord{}, model.ErrNotFound
+		}
+		return model.TaxRecord{}, fmt.Errorf("failed to execute stmtSelectTaxRecordByID: %w", err)
+	}
+	return record, nil
+}
 
-	dateRange := marshalDateRange(query.Date, query.Date)
+// UpdateTaxRecordRate changes the tax rate of a record and returns the updated record.
+// Like AddOrUpdateTaxRecord, the version is only incremented when the rate changes.
+// An expectedVersion of 0 skips the optimistic concurrency check.
+// It returns model.ErrNotFound if the record does not exist or is deleted,
+// and model.ErrVersionMismatch if the record's version differs from expectedVersion.
+func (s *PostgresStore) UpdateTaxRecordRate(ctx context.Context, recordID int64, taxRate float64, expectedVersion int64) (model.TaxRecord, error) {
+	return s.changeTaxRecord(ctx, recordID, expectedVersion, func(ctx context.Context, tx *sql.Tx, record model.TaxRecord) (model.TaxRecord, error) {
+		if record.IsDeleted() {
+			return model.TaxRecord{}, model.ErrNotFound
+		}
+		updated, err := s.execTaxRecordStatement(ctx, tx, "updateTaxRecordRate", recordID, taxRate)
+		if err != nil {
+			return model.TaxRecord{}, err
+		}
+		if updated.TaxRate == record.TaxRate {
+			return updated, nil
+		}
+		oldState := &model.TaxRecordState{TaxRate: record.TaxRate, StartDate: record.StartDate, EndDate: record.EndDate}
+		newState := &model.TaxRecordState{TaxRate: updated.TaxRate, StartDate: updated.StartDate, EndDate: updated.EndDate}
+		return updated, s.recordChange(ctx, tx, updated, model.ActionUpdate, oldState, newState)
+	})
+}
 
-	stmt, ok := s.preparedStatements["selectTaxRecords"]
-	if !ok {
-		return nil, fmt.Errorf("statement 'sqlSelectTaxRecords' not prepared")
+// DeleteTaxRecord soft-deletes a tax record and returns the deleted record.
+// Deleted records are no longer returned by lookups but their history is kept.
+// An expectedVersion of 0 skips the optimistic concurrency check.
+// It returns model.ErrNotFound if the record does not exist or is already deleted,
+// and model.ErrVersionMismatch if the record's version differs from expectedVersion.
+func (s *PostgresStore) DeleteTaxRecord(ctx context.Context, recordID int64, expectedVersion int64) (model.TaxRecord, error) {
+	return s.changeTaxRecord(ctx, recordID, expectedVersion, func(ctx context.Context, tx *sql.Tx, record model.TaxRecord) (model.TaxRecord, error) {
+		if record.IsDeleted() {
+			return model.TaxRecord{}, model.ErrNotFound
+		}
+		deleted, err := s.execTaxRecordStatement(ctx, tx, "softDeleteTaxRecord", recordID)
+		if err != nil {
+			return model.TaxRecord{}, err
+		}
+		oldState := &model.TaxRecordState{TaxRate: record.TaxRate, StartDate: record.StartDate, EndDate: record.EndDate}
+		return deleted, s.recordChange(ctx, tx, deleted, model.ActionDelete, oldState, nil)
+	})
+}
+
+// RestoreTaxRecord restores a soft-deleted tax record and returns it.
+// An expectedVersion of 0 skips the optimistic concurrency check.
+// It returns model.ErrNotFound if the record does not exist or is not deleted,
+// model.ErrVersionMismatch if the record's version differs from expectedVersion,
+// and model.ErrConflict if another record with the same municipality, period and period type exists.
+func (s *PostgresStore) RestoreTaxRecord(ctx context.Context, recordID int64, expectedVersion int64) (model.TaxRecord, error) {
+	return s.changeTaxRecord(ctx, recordID, expectedVersion, func(ctx context.Context, tx *sql.Tx, record model.TaxRecord) (model.TaxRecord, error) {
+		if !record.IsDeleted() {
+			return model.TaxRecord{}, model.ErrNotFound
+		}
+		restored, err := s.execTaxRecordStatement(ctx, tx, "restoreTaxRecord", recordID)
+		if err != nil {
+			if isUniqueViolation(err) {
+				return model.TaxRecord{}, model.ErrConflict
+			}
+			return model.TaxRecord{}, err
+		}
+		newState := &model.TaxRecordState{TaxRate: restored.TaxRate, StartDate: restored.StartDate, EndDate: restored.EndDate}
+		return restored, s.recordChange(ctx, tx, restored, model.ActionRestore, nil, newState)
+	})
+}
+
+// changeTaxRecord runs change in a transaction on a locked tax record, after checking the record's version.
+func (s *PostgresStore) changeTaxRecord(ctx context.Context, recordID int64, expectedVersion int64,
+	change func(ctx context.Context, tx *sql.Tx, record model.TaxRecord) (model.TaxRecord, error)) (model.TaxRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, transactionTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return model.TaxRecord{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	record, err := s.lockTaxRecord(ctx, tx, recordID)
+	if err != nil {
+		return model.TaxRecord{}, err
+	}
+	if expectedVersion != 0 && record.Version != expectedVersion {
+		return model.TaxRecord{}, model.ErrVersionMismatch
+	}
+
+	changed, err := change(ctx, tx, record)
+	if err != nil {
+		return model.TaxRecord{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return model.TaxRecord{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return changed, nil
+}
+
+// execTaxRecordStatement runs a prepared statement returning sqlTaxRecordColumns within tx and scans the record.
+func (s *PostgresStore) execTaxRecordStatement(ctx context.Context, tx *sql.Tx, name string, args ...any) (model.TaxRecord, error) {
+	stmt, err := s.txStatement(ctx, tx, name)
+	if err != nil {
+		return model.TaxRecord{}, err
+	}
+	record, err := scanTaxRecord(stmt.QueryRowContext(ctx, args...))
+	if err != nil {
+		return model.TaxRecord{}, fmt.Errorf("failed to execute %s: %w", name, err)
+	}
+	return record, nil
+}
+
+// PurgeDeletedTaxRecords permanently removes the tax records that were soft-deleted longer than olderThan ago
+// and returns how many were removed. The history of purged records is kept.
+func (s *PostgresStore) PurgeDeletedTaxRecords(ctx context.Context, olderThan time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, transactionTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("purgeDeletedTaxRecords")
+	if err != nil {
+		return 0, err
 	}
+	result, err := stmt.ExecContext(ctx, olderThan.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute stmtPurgeDeletedTaxRecords: %w", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count purged tax records: %w", err)
+	}
+	return purged, nil
+}
 
-	rows, err := stmt.QueryContext(ctx, query.Municipality, dateRange)
+// lockTaxRecord locks the municipality of a tax record and the record itself for the rest of tx, and returns the record.
+// The municipality is locked first to keep the lock order of AddOrUpdateTaxRecord.
+func (s *PostgresStore) lockTaxRecord(ctx context.Context, tx *sql.Tx, recordID int64) (model.TaxRecord, error) {
+	municipalityStmt, err := s.txStatement(ctx, tx, "selectMunicipalityOfTaxRecord")
 	if err != nil {
+		return model.TaxRecord{}, err
+	}
+	var municipality string
+	if err := municipalityStmt.QueryRowContext(ctx, recordID).Scan(&municipality); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, model.ErrNotFound
+			return model.TaxRecord{}, model.ErrNotFound
+		}
+		return model.TaxRecord{}, fmt.Errorf("failed to execute stmtSelectMunicipalityOfTaxRecord: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, sqlLockMunicipality, municipality); err != nil {
+		return model.TaxRecord{}, fmt.Errorf("failed to lock municipality: %w", err)
+	}
+
+	recordStmt, err := s.txStatement(ctx, tx, "selectTaxRecordByIDForUpdate")
+	if err != nil {
+		return model.TaxRecord{}, err
+	}
+	record, err := scanTaxRecord(recordStmt.QueryRowContext(ctx, recordID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.TaxRecord{}, model.ErrNotFound
+		}
+		return model.TaxRecord{}, fmt.Errorf("failed to execute stmtSelectTaxRecordByIDForUpdate: %w", err)
+	}
+	return record, nil
+}
+
+// scanTaxRecord scans a row selected with sqlTaxRecordColumns.
+func scanTaxRecord(row rowScanner) (model.TaxRecord, error) {
+	var record model.TaxRecord
+	var period string
+	var deletedAt sql.NullTime
+	err := row.Scan(&record.ID, &record.Municipality, &record.TaxRate, &period, &record.PeriodType, &record.Version, &deletedAt)
+	if err != nil {
+		return model.TaxRecord{}, err
+	}
+	record.StartDate, record.EndDate, err = unmarshalDateRange(period)
+	if err != nil {
+		return model.TaxRecord{}, fmt.Errorf("failed to parse period date range: %w", err)
+	}
+	record.DeletedAt = deletedAt.Time
+	return record, nil
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint violation.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// closeOpenEndedTaxRecords closes the open-ended predecessors of record and records the change in their history.
+func (s *PostgresStore) closeOpenEndedTaxRecords(ctx context.Context, tx *sql.Tx, record model.TaxRecord) error {
+	stmt, err := s.txStatement(ctx, tx, "closeOpenEndedTaxRecords")
+	if err != nil {
+		return err
+	}
+	rows, err := stmt.QueryContext(ctx, record.Municipality, record.PeriodType, record.StartDate.Format(dateLayout))
+	if err != nil {
+		return fmt.Errorf("failed to execute stmtCloseOpenEndedTaxRecords: %w", err)
+	}
+
+	// Collect the closed records first, the rows must be closed before the transaction is used again
+	var closed []model.TaxRecord
+	for rows.Next() {
+		closedRecord := model.TaxRecord{Municipality: record.Municipality, PeriodType: record.PeriodType}
+		var period string
+		if err := rows.Scan(&closedRecord.ID, &closedRecord.TaxRate, &period); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan closed tax record row: %w", err)
+		}
+		closedRecord.StartDate, closedRecord.EndDate, err = unmarshalDateRange(period)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to parse period date range: %w", err)
+		}
+		closed = append(closed, closedRecord)
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("failed to read closed tax records: %w", err)
+	}
+
+	for _, closedRecord := range closed {
+		oldState := &model.TaxRecordState{TaxRate: closedRecord.TaxRate, StartDate: closedRecord.StartDate}
+		newState := &model.TaxRecordState{TaxRate: closedRecord.TaxRate, StartDate: closedRecord.StartDate, EndDate: closedRecord.EndDate}
+		if err := s.recordChange(ctx, tx, closedRecord, model.ActionUpdate, oldState, newState); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordChange keeps track of a change made to record within tx.
+// The change is appended to the record's history and a new system-time version of the record is started.
+func (s *PostgresStore) recordChange(ctx context.Context, tx *sql.Tx, record model.TaxRecord, action model.ChangeAction, oldState, newState *model.TaxRecordState) error {
+	if err := s.insertHistory(ctx, tx, record, action, oldState, newState); err != nil {
+		return err
+	}
+	if err := s.syncVersion(ctx, tx, record.ID); err != nil {
+		return err
+	}
+	if err := s.appendChangeLog(ctx, tx, record, action); err != nil {
+		return err
+	}
+	// The notification is delivered when the transaction commits, identical ones are delivered once
+	if _, err := tx.ExecContext(ctx, sqlNotifyTaxRecordsChanged, TaxRecordsChangedChannel, record.Municipality); err != nil {
+		return fmt.Errorf("failed to notify tax record change: %w", err)
+	}
+	return nil
+}
+
+// appendChangeLog appends a change of record to the change log, holding the change log lock until tx ends.
+// The change is added to the outbox in the same transaction, so that it is published exactly when it commits.
+func (s *PostgresStore) appendChangeLog(ctx context.Context, tx *sql.Tx, record model.TaxRecord, action model.ChangeAction) error {
+	if _, err := tx.ExecContext(ctx, sqlLockChangeLog); err != nil {
+		return fmt.Errorf("failed to lock change log: %w", err)
+	}
+
+	stmt, err := s.txStatement(ctx, tx, "insertTaxRecordChange")
+	if err != nil {
+		return err
+	}
+	var changeID int64
+	err = stmt.QueryRowContext(ctx, record.ID, record.Municipality, action, record.TaxRate,
+		marshalDateRange(record.StartDate, record.EndDate), record.PeriodType).Scan(&changeID)
+	if err != nil {
+		return fmt.Errorf("failed to execute stmtInsertTaxRecordChange: %w", err)
+	}
+
+	outboxStmt, err := s.txStatement(ctx, tx, "insertOutboxEvent")
+	if err != nil {
+		return err
+	}
+	if _, err := outboxStmt.ExecContext(ctx, changeID); err != nil {
+		return fmt.Errorf("failed to execute stmtInsertOutboxEvent: %w", err)
+	}
+	return nil
+}
+
+// syncVersion closes the current system-time version of a record and starts a new one
+// from the record's current state. Versions of a transaction start at the transaction's timestamp.
+func (s *PostgresStore) syncVersion(ctx context.Context, tx *sql.Tx, recordID int64) error {
+	closeStmt, err := s.txStatement(ctx, tx, "closeTaxRecordVersion")
+	if err != nil {
+		return err
+	}
+	if _, err := closeStmt.ExecContext(ctx, recordID); err != nil {
+		return fmt.Errorf("failed to execute stmtCloseTaxRecordVersion: %w", err)
+	}
+
+	insertStmt, err := s.txStatement(ctx, tx, "insertTaxRecordVersion")
+	if err != nil {
+		return err
+	}
+	if _, err := insertStmt.ExecContext(ctx, recordID); err != nil {
+		return fmt.Errorf("failed to execute stmtInsertTaxRecordVersion: %w", err)
+	}
+	return nil
+}
+
+// insertHistory appends a change of record to its history, attributing it to the actor and request in ctx.
+func (s *PostgresStore) insertHistory(ctx context.Context, tx *sql.Tx, record model.TaxRecord, action model.ChangeAction, oldState, newState *model.TaxRecordState) error {
+	stmt, err := s.txStatement(ctx, tx, "insertTaxRecordHistory")
+	if err != nil {
+		return err
+	}
+
+	var oldTaxRate, newTaxRate sql.NullFloat64
+	var oldPeriod, newPeriod, requestID sql.NullString
+	if oldState != nil {
+		oldTaxRate = sql.NullFloat64{Float64: oldState.TaxRate, Valid: true}
+		oldPeriod = sql.NullString{String: marshalDateRange(oldState.StartDate, oldState.EndDate), Valid: true}
+	}
+	if newState != nil {
+		newTaxRate = sql.NullFloat64{Float64: newState.TaxRate, Valid: true}
+		newPeriod = sql.NullString{String: marshalDateRange(newState.StartDate, newState.EndDate), Valid: true}
+	}
+	if id := requestctx.RequestID(ctx); id != "" {
+		requestID = sql.NullString{String: id, Valid: true}
+	}
+
+	_, err = stmt.ExecContext(ctx, record.ID, record.Municipality, record.PeriodType, action,
+		oldTaxRate, newTaxRate, oldPeriod, newPeriod, requestctx.Actor(ctx), requestID)
+	if err != nil {
+		return fmt.Errorf("failed to execute stmtInsertTaxRecordHistory: %w", err)
+	}
+	return nil
+}
+
+// GetTaxRecords retrieves all tax records for a municipality that match a specific date.
+// If the query has an AsOf time, the records are evaluated against the state known at that time.
+func (s *PostgresStore) GetTaxRecords(ctx context.Context, query model.TaxQuery) ([]model.TaxRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	var records []model.TaxRecord
+
+	dateRange := marshalDateRange(query.Date, query.Date)
+
+	var rows *sql.Rows
+	if query.AsOf.IsZero() {
+		stmt, err := s.statement("selectTaxRecords")
+		if err != nil {
+			return nil, err
+		}
+		rows, err = stmt.QueryContext(ctx, query.Municipality, dateRange)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, model.ErrNotFound
+			}
+			return nil, fmt.Errorf("failed to execute stmtSelectTaxRate: %w", err)
+		}
+	} else {
+		stmt, err := s.statement("selectTaxRecordsAsOf")
+		if err != nil {
+			return nil, err
+		}
+		rows, err = stmt.QueryContext(ctx, query.Municipality, dateRange, query.AsOf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute stmtSelectTaxRecordsAsOf: %w", err)
 		}
-		return nil, fmt.Errorf("failed to execute stmtSelectTaxRate: %w", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var record model.TaxRecord
 		var period string
-		if err := rows.Scan(&record.Municipality, &record.TaxRate, &period, &record.PeriodType); err != nil {
+		if err := rows.Scan(&record.ID, &record.Municipality, &record.TaxRate, &period, &record.PeriodType); err != nil {
 			return nil, fmt.Errorf("failed to scan tax record row: %w", err)
 		}
 
 		// Parse the period daterange
+		var err error
 		record.StartDate, record.EndDate, err = unmarshalDateRange(period)
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse period date range: %w", err)
@@ -205,8 +731,134 @@ func (s *PostgresStore) GetTaxRecords(ctx context.Context, query model.TaxQuery)
 	return records, nil
 }
 
+// GetMunicipalityLastChange retrieves the ID and the time of the latest change to the tax records of a municipality
+// from the change log. IDs only increase as changes commit, the times may not.
+// It returns 0 and the zero time if the municipality's records were never changed.
+func (s *PostgresStore) GetMunicipalityLastChange(ctx context.Context, municipality string) (int64, time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("selectMunicipalityLastChange")
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	var changeID int64
+	var changedAt time.Time
+	if err := stmt.QueryRowContext(ctx, municipality).Scan(&changeID, &changedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, time.Time{}, nil
+		}
+		return 0, time.Time{}, fmt.Errorf("failed to execute stmtSelectMunicipalityLastChange: %w", err)
+	}
+	return changeID, changedAt, nil
+}
+
+// GetTaxRecordChanges retrieves at most limit entries of the change log with an ID greater than afterID, oldest first.
+func (s *PostgresStore) GetTaxRecordChanges(ctx context.Context, afterID int64, limit int) ([]model.TaxRecordChange, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("selectTaxRecordChanges")
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.QueryContext(ctx, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute stmtSelectTaxRecordChanges: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []model.TaxRecordChange
+	for rows.Next() {
+		var change model.TaxRecordChange
+		var period string
+		if err := rows.Scan(&change.ID, &change.Record.ID, &change.Record.Municipality, &change.Action,
+			&change.Record.TaxRate, &period, &change.Record.PeriodType, &change.ChangedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan tax record change row: %w", err)
+		}
+		change.Record.StartDate, change.Record.EndDate, err = unmarshalDateRange(period)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse period date range: %w", err)
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tax record changes: %w", err)
+	}
+	return changes, nil
+}
+
+// GetTaxRecordHistory retrieves the changes made to a tax record, oldest first.
+// It returns model.ErrNotFound if the record does not exist and has no history.
+func (s *PostgresStore) GetTaxRecordHistory(ctx context.Context, recordID int64) ([]model.TaxRecordHistoryEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("selectTaxRecordHistory")
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.QueryContext(ctx, recordID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute stmtSelectTaxRecordHistory: %w", err)
+	}
+	defer rows.Close()
+
+	var history []model.TaxRecordHistoryEntry
+	for rows.Next() {
+		var entry model.TaxRecordHistoryEntry
+		var oldTaxRate, newTaxRate sql.NullFloat64
+		var oldPeriod, newPeriod, requestID sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.RecordID, &entry.Action, &oldTaxRate, &newTaxRate,
+			&oldPeriod, &newPeriod, &entry.Actor, &requestID, &entry.ChangedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan tax record history row: %w", err)
+		}
+		entry.RequestID = requestID.String
+		if entry.Old, err = unmarshalRecordState(oldTaxRate, oldPeriod); err != nil {
+			return nil, err
+		}
+		if entry.New, err = unmarshalRecordState(newTaxRate, newPeriod); err != nil {
+			return nil, err
+		}
+		history = append(history, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tax record history: %w", err)
+	}
+
+	if len(history) == 0 {
+		existsStmt, err := s.statement("taxRecordExists")
+		if err != nil {
+			return nil, err
+		}
+		var exists bool
+		if err := existsStmt.QueryRowContext(ctx, recordID).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("failed to execute stmtTaxRecordExists: %w", err)
+		}
+		if !exists {
+			return nil, model.ErrNotFound
+		}
+	}
+	return history, nil
+}
+
+// unmarshalRecordState builds a record state from nullable history columns, returning nil if they are not set.
+func unmarshalRecordState(taxRate sql.NullFloat64, period sql.NullString) (*model.TaxRecordState, error) {
+	if !taxRate.Valid || !period.Valid {
+		return nil, nil
+	}
+	startDate, endDate, err := unmarshalDateRange(period.String)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse history period date range: %w", err)
+	}
+	return &model.TaxRecordState{TaxRate: taxRate.Float64, StartDate: startDate, EndDate: endDate}, nil
+}
+
 // unmarshalDateRange parses a period string '[2024-01-01,2024-12-31)' into start and end dates,
 // adjusting the end date to not include the last day.
+// An unbounded period such as '[2025-01-01,)' yields a zero end date.
 func unmarshalDateRange(daterange string) (time.Time, time.Time, error) {
 
 	// Split the period into start and end parts
@@ -217,12 +869,18 @@ func unmarshalDateRange(daterange string) (time.Time, time.Time, error) {
 	}
 
 	// Parse start date
-	startDate, err := time.Parse("2006-01-02", strings.Trim(dates[0], "["))
+	startDate, err := time.Parse(dateLayout, strings.Trim(dates[0], "["))
 	if err != nil {
 		return time.Time{}, time.Time{}, fmt.Errorf("invalid start date format: %w", err)
 	}
 
-	endDate, err := time.Parse("2006-01-02", strings.Trim(dates[1], ")"))
+	// An empty upper bound means the period is open-ended
+	upperBound := strings.Trim(dates[1], ")")
+	if upperBound == "" {
+		return startDate, time.Time{}, nil
+	}
+
+	endDate, err := time.Parse(dateLayout, upperBound)
 	if err != nil {
 		return time.Time{}, time.Time{}, fmt.Errorf("invalid end date format: %w", err)
 	}
@@ -234,8 +892,12 @@ func unmarshalDateRange(daterange string) (time.Time, time.Time, error) {
 }
 
 // marshalDateRange formats a start and end date into a period string '[2024-01-01,2024-12-31)'.
+// A zero end date produces an unbounded period '[2024-01-01,)'.
 func marshalDateRange(startDate, endDate time.Time) string {
+	if endDate.IsZero() {
+		return fmt.Sprintf("[%s,)", startDate.Format(dateLayout))
+	}
 	// Add one day to the end date to make it exclusive
 	endDateExclusive := endDate.AddDate(0, 0, 1)
-	return fmt.Sprintf("[%s,%s)", startDate.Format("2006-01-02"), endDateExclusive.Format("2006-01-02"))
+	return fmt.Sprintf("[%s,%s)", startDate.Format(dateLayout), endDateExclusive.Format(dateLayout))
 }
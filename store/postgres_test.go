@@ -2,7 +2,9 @@ package store_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/rezkam/TaxMan/internal/requestctx"
 	"github.com/rezkam/TaxMan/internal/utils"
 	"os"
 	"testing"
@@ -71,7 +73,7 @@ func TestAddOrUpdateTaxRecord(t *testing.T) {
 			PeriodType:   "daily",
 		}
 
-		err := testStore.AddOrUpdateTaxRecord(context.Background(), record)
+		_, err := testStore.AddOrUpdateTaxRecord(context.Background(), record, model.AnyVersion)
 		require.NoError(t, err)
 	})
 
@@ -84,11 +86,69 @@ func TestAddOrUpdateTaxRecord(t *testing.T) {
 			PeriodType:   "daily",
 		}
 
-		err := testStore.AddOrUpdateTaxRecord(context.Background(), record)
+		_, err := testStore.AddOrUpdateTaxRecord(context.Background(), record, model.AnyVersion)
 		require.NoError(t, err)
 	})
 }
 
+func TestAddOrUpdateTaxRecordPreconditions(t *testing.T) {
+	cleanupDB(t, testStore)
+
+	record := model.TaxRecord{
+		Municipality: "Kolding",
+		TaxRate:      0.2,
+		StartDate:    utils.DateOnly(2024, time.January, 1),
+		EndDate:      utils.DateOnly(2024, time.December, 31),
+		PeriodType:   model.Yearly,
+	}
+	created, err := testStore.AddOrUpdateTaxRecord(context.Background(), record, model.NoVersion)
+	require.NoError(t, err)
+
+	t.Run("overwrite without version", func(t *testing.T) {
+		existing, err := testStore.AddOrUpdateTaxRecord(context.Background(), record, model.NoVersion)
+		require.ErrorIs(t, err, model.ErrVersionRequired)
+		require.Equal(t, created.ID, existing.ID)
+		require.Equal(t, created.Version, existing.Version)
+	})
+
+	t.Run("concurrent overwrites", func(t *testing.T) {
+		// Both writers read the same version, only the first one to write may overwrite it
+		errs := make(chan error, 2)
+		for _, taxRate := range []float64{0.3, 0.4} {
+			go func(record model.TaxRecord) {
+				_, err := testStore.AddOrUpdateTaxRecord(context.Background(), record, created.Version)
+				errs <- err
+			}(model.TaxRecord{Municipality: record.Municipality, TaxRate: taxRate, StartDate: record.StartDate,
+				EndDate: record.EndDate, PeriodType: record.PeriodType})
+		}
+		var succeeded, mismatched int
+		for range 2 {
+			switch err := <-errs; {
+			case err == nil:
+				succeeded++
+			case errors.Is(err, model.ErrVersionMismatch):
+				mismatched++
+			default:
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		require.Equal(t, 1, succeeded)
+		require.Equal(t, 1, mismatched)
+
+		stored, err := testStore.GetTaxRecord(context.Background(), created.ID)
+		require.NoError(t, err)
+		require.Equal(t, created.Version+1, stored.Version)
+	})
+
+	t.Run("expected record deleted", func(t *testing.T) {
+		other := record
+		other.PeriodType = model.Monthly
+		other.EndDate = utils.DateOnly(2024, time.January, 31)
+		_, err := testStore.AddOrUpdateTaxRecord(context.Background(), other, 1)
+		require.ErrorIs(t, err, model.ErrVersionMismatch)
+	})
+}
+
 func TestGetTaxRate(t *testing.T) {
 	cleanupDB(t, testStore)
 
@@ -127,7 +187,7 @@ func TestGetTaxRate(t *testing.T) {
 	}
 
 	for _, record := range records {
-		err := testStore.AddOrUpdateTaxRecord(context.Background(), record)
+		_, err := testStore.AddOrUpdateTaxRecord(context.Background(), record, model.AnyVersion)
 		require.NoError(t, err)
 	}
 
@@ -161,3 +221,719 @@ func TestGetTaxRate(t *testing.T) {
 		}
 	})
 }
+
+func TestOpenEndedTaxRecords(t *testing.T) {
+	cleanupDB(t, testStore)
+
+	const municipality = "Aarhus"
+
+	openEnded := model.TaxRecord{
+		Municipality: municipality,
+		TaxRate:      0.2,
+		StartDate:    utils.DateOnly(2025, time.January, 1),
+		PeriodType:   model.Yearly,
+	}
+	_, err := testStore.AddOrUpdateTaxRecord(context.Background(), openEnded, model.AnyVersion)
+	require.NoError(t, err)
+
+	t.Run("applies until further notice", func(t *testing.T) {
+		records, err := testStore.GetTaxRecords(context.Background(), model.TaxQuery{
+			Municipality: municipality,
+			Date:         utils.DateOnly(2030, time.June, 1),
+		})
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		require.Equal(t, 0.2, records[0].TaxRate)
+		require.True(t, records[0].IsOpenEnded())
+		require.Equal(t, openEnded.StartDate, records[0].StartDate)
+	})
+
+	t.Run("closed by successor of the same type", func(t *testing.T) {
+		successor := model.TaxRecord{
+			Municipality: municipality,
+			TaxRate:      0.3,
+			StartDate:    utils.DateOnly(2026, time.January, 1),
+			PeriodType:   model.Yearly,
+		}
+		_, err := testStore.AddOrUpdateTaxRecord(context.Background(), successor, model.AnyVersion)
+		require.NoError(t, err)
+
+		records, err := testStore.GetTaxRecords(context.Background(), model.TaxQuery{
+			Municipality: municipality,
+			Date:         utils.DateOnly(2025, time.December, 31),
+		})
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		require.Equal(t, 0.2, records[0].TaxRate)
+		require.Equal(t, utils.DateOnly(2025, time.December, 31), records[0].EndDate)
+
+		records, err = testStore.GetTaxRecords(context.Background(), model.TaxQuery{
+			Municipality: municipality,
+			Date:         utils.DateOnly(2026, time.January, 1),
+		})
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		require.Equal(t, 0.3, records[0].TaxRate)
+		require.True(t, records[0].IsOpenEnded())
+	})
+
+	t.Run("other period types are left open", func(t *testing.T) {
+		monthly := model.TaxRecord{
+			Municipality: municipality,
+			TaxRate:      0.4,
+			StartDate:    utils.DateOnly(2027, time.March, 1),
+			EndDate:      utils.DateOnly(2027, time.March, 31),
+			PeriodType:   model.Monthly,
+		}
+		_, err := testStore.AddOrUpdateTaxRecord(context.Background(), monthly, model.AnyVersion)
+		require.NoError(t, err)
+
+		records, err := testStore.GetTaxRecords(context.Background(), model.TaxQuery{
+			Municipality: municipality,
+			Date:         utils.DateOnly(2027, time.April, 1),
+		})
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		require.Equal(t, 0.3, records[0].TaxRate)
+	})
+}
+
+func TestGetTaxRecordHistory(t *testing.T) {
+	cleanupDB(t, testStore)
+
+	const municipality = "Odense"
+
+	ctx := requestctx.WithRequestID(requestctx.WithActor(context.Background(), "clerk"), "req-1")
+	record := model.TaxRecord{
+		Municipality: municipality,
+		TaxRate:      0.2,
+		StartDate:    utils.DateOnly(2024, time.January, 1),
+		EndDate:      utils.DateOnly(2024, time.December, 31),
+		PeriodType:   model.Yearly,
+	}
+	created, err := testStore.AddOrUpdateTaxRecord(ctx, record, model.AnyVersion)
+	require.NoError(t, err)
+	require.NotZero(t, created.ID)
+
+	record.TaxRate = 0.25
+	updated, err := testStore.AddOrUpdateTaxRecord(context.Background(), record, model.AnyVersion)
+	require.NoError(t, err)
+	require.Equal(t, created.ID, updated.ID)
+
+	t.Run("records every change", func(t *testing.T) {
+		history, err := testStore.GetTaxRecordHistory(context.Background(), created.ID)
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+
+		require.Equal(t, model.ActionCreate, history[0].Action)
+		require.Nil(t, history[0].Old)
+		require.Equal(t, 0.2, history[0].New.TaxRate)
+		require.Equal(t, "clerk", history[0].Actor)
+		require.Equal(t, "req-1", history[0].RequestID)
+
+		require.Equal(t, model.ActionUpdate, history[1].Action)
+		require.Equal(t, 0.2, history[1].Old.TaxRate)
+		require.Equal(t, 0.25, history[1].New.TaxRate)
+		require.Equal(t, requestctx.AnonymousActor, history[1].Actor)
+		require.Empty(t, history[1].RequestID)
+	})
+
+	t.Run("unchanged rate is not recorded", func(t *testing.T) {
+		_, err := testStore.AddOrUpdateTaxRecord(context.Background(), record, model.AnyVersion)
+		require.NoError(t, err)
+
+		history, err := testStore.GetTaxRecordHistory(context.Background(), created.ID)
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := testStore.GetTaxRecordHistory(context.Background(), created.ID+1000)
+		require.ErrorIs(t, err, model.ErrNotFound)
+	})
+}
+
+func TestGetTaxRecordsAsOf(t *testing.T) {
+	cleanupDB(t, testStore)
+
+	const municipality = "Aalborg"
+
+	record := model.TaxRecord{
+		Municipality: municipality,
+		TaxRate:      0.2,
+		StartDate:    utils.DateOnly(2024, time.January, 1),
+		EndDate:      utils.DateOnly(2024, time.December, 31),
+		PeriodType:   model.Yearly,
+	}
+	beforeCreate := time.Now()
+	_, err := testStore.AddOrUpdateTaxRecord(context.Background(), record, model.AnyVersion)
+	require.NoError(t, err)
+
+	// Transactions are stamped with the database clock, keep the moments apart
+	time.Sleep(50 * time.Millisecond)
+	beforeUpdate := time.Now()
+	time.Sleep(50 * time.Millisecond)
+
+	record.TaxRate = 0.3
+	_, err = testStore.AddOrUpdateTaxRecord(context.Background(), record, model.AnyVersion)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		asOf          time.Time
+		expectedRates []float64
+	}{
+		{"before the record was known", beforeCreate.Add(-time.Minute), nil},
+		{"before the update", beforeUpdate, []float64{0.2}},
+		{"current state", time.Time{}, []float64{0.3}},
+		{"after the update", time.Now().Add(time.Minute), []float64{0.3}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			records, err := testStore.GetTaxRecords(context.Background(), model.TaxQuery{
+				Municipality: municipality,
+				Date:         utils.DateOnly(2024, time.June, 1),
+				AsOf:         tc.asOf,
+			})
+			require.NoError(t, err)
+			require.Len(t, records, len(tc.expectedRates))
+			for i, record := range records {
+				require.Equal(t, tc.expectedRates[i], record.TaxRate)
+			}
+		})
+	}
+}
+
+func TestSoftDeleteTaxRecord(t *testing.T) {
+	cleanupDB(t, testStore)
+
+	const municipality = "Esbjerg"
+
+	record := model.TaxRecord{
+		Municipality: municipality,
+		TaxRate:      0.2,
+		StartDate:    utils.DateOnly(2024, time.January, 1),
+		EndDate:      utils.DateOnly(2024, time.December, 31),
+		PeriodType:   model.Yearly,
+	}
+	stored, err := testStore.AddOrUpdateTaxRecord(context.Background(), record, model.AnyVersion)
+	require.NoError(t, err)
+
+	query := model.TaxQuery{Municipality: municipality, Date: utils.DateOnly(2024, time.June, 1)}
+
+	t.Run("deleted record is hidden", func(t *testing.T) {
+		_, err := testStore.DeleteTaxRecord(context.Background(), stored.ID, 0)
+		require.NoError(t, err)
+
+		records, err := testStore.GetTaxRecords(context.Background(), query)
+		require.NoError(t, err)
+		require.Empty(t, records)
+
+		_, err = testStore.DeleteTaxRecord(context.Background(), stored.ID, 0)
+		require.ErrorIs(t, err, model.ErrNotFound)
+	})
+
+	t.Run("deleted record does not block a new one", func(t *testing.T) {
+		record.TaxRate = 0.3
+		replacement, err := testStore.AddOrUpdateTaxRecord(context.Background(), record, model.AnyVersion)
+		require.NoError(t, err)
+		require.NotEqual(t, stored.ID, replacement.ID)
+
+		_, err = testStore.RestoreTaxRecord(context.Background(), stored.ID, 0)
+		require.ErrorIs(t, err, model.ErrConflict)
+
+		_, err = testStore.DeleteTaxRecord(context.Background(), replacement.ID, 0)
+		require.NoError(t, err)
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		restored, err := testStore.RestoreTaxRecord(context.Background(), stored.ID, 0)
+		require.NoError(t, err)
+		require.Equal(t, 0.2, restored.TaxRate)
+
+		records, err := testStore.GetTaxRecords(context.Background(), query)
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		require.Equal(t, stored.ID, records[0].ID)
+
+		history, err := testStore.GetTaxRecordHistory(context.Background(), stored.ID)
+		require.NoError(t, err)
+		require.Len(t, history, 3)
+		require.Equal(t, model.ActionDelete, history[1].Action)
+		require.Nil(t, history[1].New)
+		require.Equal(t, model.ActionRestore, history[2].Action)
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		_, err := testStore.DeleteTaxRecord(context.Background(), stored.ID, 0)
+		require.NoError(t, err)
+
+		purged, err := testStore.PurgeDeletedTaxRecords(context.Background(), time.Hour)
+		require.NoError(t, err)
+		require.Zero(t, purged)
+
+		purged, err = testStore.PurgeDeletedTaxRecords(context.Background(), time.Nanosecond)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), purged)
+
+		_, err = testStore.RestoreTaxRecord(context.Background(), stored.ID, 0)
+		require.ErrorIs(t, err, model.ErrNotFound)
+
+		history, err := testStore.GetTaxRecordHistory(context.Background(), stored.ID)
+		require.NoError(t, err)
+		require.Len(t, history, 4)
+	})
+}
+
+func TestTaxRecordVersions(t *testing.T) {
+	cleanupDB(t, testStore)
+
+	record := model.TaxRecord{
+		Municipality: "Aalborg",
+		TaxRate:      0.2,
+		StartDate:    utils.DateOnly(2024, time.January, 1),
+		EndDate:      utils.DateOnly(2024, time.December, 31),
+		PeriodType:   model.Yearly,
+	}
+	stored, err := testStore.AddOrUpdateTaxRecord(context.Background(), record, model.AnyVersion)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), stored.Version)
+
+	fetched, err := testStore.GetTaxRecord(context.Background(), stored.ID)
+	require.NoError(t, err)
+	require.Equal(t, stored.Version, fetched.Version)
+	require.Equal(t, record.TaxRate, fetched.TaxRate)
+
+	updated, err := testStore.UpdateTaxRecordRate(context.Background(), stored.ID, 0.25, stored.Version)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), updated.Version)
+	require.Equal(t, 0.25, updated.TaxRate)
+
+	// Writing the same rate again changes nothing, as with AddOrUpdateTaxRecord
+	unchanged, err := testStore.UpdateTaxRecordRate(context.Background(), stored.ID, 0.25, updated.Version)
+	require.NoError(t, err)
+	require.Equal(t, updated.Version, unchanged.Version)
+
+	// A writer holding the first version loses against the update above
+	_, err = testStore.UpdateTaxRecordRate(context.Background(), stored.ID, 0.3, stored.Version)
+	require.ErrorIs(t, err, model.ErrVersionMismatch)
+	_, err = testStore.DeleteTaxRecord(context.Background(), stored.ID, stored.Version)
+	require.ErrorIs(t, err, model.ErrVersionMismatch)
+
+	deleted, err := testStore.DeleteTaxRecord(context.Background(), stored.ID, updated.Version)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), deleted.Version)
+	require.True(t, deleted.IsDeleted())
+
+	_, err = testStore.UpdateTaxRecordRate(context.Background(), stored.ID, 0.3, 0)
+	require.ErrorIs(t, err, model.ErrNotFound)
+
+	restored, err := testStore.RestoreTaxRecord(context.Background(), stored.ID, deleted.Version)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), restored.Version)
+	require.False(t, restored.IsDeleted())
+
+	history, err := testStore.GetTaxRecordHistory(context.Background(), stored.ID)
+	require.NoError(t, err)
+	require.Len(t, history, 4)
+	require.Equal(t, model.ActionUpdate, history[1].Action)
+
+	_, err = testStore.GetTaxRecord(context.Background(), 1<<40)
+	require.ErrorIs(t, err, model.ErrNotFound)
+}
+
+func TestGetMunicipalityLastChange(t *testing.T) {
+	cleanupDB(t, testStore)
+
+	changeID, lastModified, err := testStore.GetMunicipalityLastChange(context.Background(), "Vejle")
+	require.NoError(t, err)
+	require.Zero(t, changeID)
+	require.True(t, lastModified.IsZero())
+
+	stored, err := testStore.AddOrUpdateTaxRecord(context.Background(), model.TaxRecord{
+		Municipality: "Vejle",
+		TaxRate:      0.2,
+		StartDate:    utils.DateOnly(2024, time.January, 1),
+		EndDate:      utils.DateOnly(2024, time.December, 31),
+		PeriodType:   model.Yearly,
+	}, model.AnyVersion)
+	require.NoError(t, err)
+
+	createdID, created, err := testStore.GetMunicipalityLastChange(context.Background(), "Vejle")
+	require.NoError(t, err)
+	require.NotZero(t, createdID)
+	require.False(t, created.IsZero())
+
+	_, err = testStore.DeleteTaxRecord(context.Background(), stored.ID, 0)
+	require.NoError(t, err)
+
+	deletedID, deleted, err := testStore.GetMunicipalityLastChange(context.Background(), "Vejle")
+	require.NoError(t, err)
+	require.Greater(t, deletedID, createdID)
+	require.True(t, deleted.After(created))
+}
+
+func TestListenTaxRecordChanges(t *testing.T) {
+	cleanupDB(t, testStore)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan string, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- testStore.ListenTaxRecordChanges(ctx, func(municipality string) { changes <- municipality }, func() {})
+	}()
+
+	// Give the listener time to connect, notifications sent before are not delivered
+	time.Sleep(500 * time.Millisecond)
+
+	_, err := testStore.AddOrUpdateTaxRecord(context.Background(), model.TaxRecord{
+		Municipality: "Esbjerg",
+		TaxRate:      0.2,
+		StartDate:    utils.DateOnly(2024, time.January, 1),
+		EndDate:      utils.DateOnly(2024, time.December, 31),
+		PeriodType:   model.Yearly,
+	}, model.AnyVersion)
+	require.NoError(t, err)
+
+	select {
+	case municipality := <-changes:
+		require.Equal(t, "Esbjerg", municipality)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change notification received")
+	}
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestGetTaxRecordChanges(t *testing.T) {
+	cleanupDB(t, testStore)
+
+	record := model.TaxRecord{
+		Municipality: "Horsens",
+		TaxRate:      0.2,
+		StartDate:    utils.DateOnly(2024, time.January, 1),
+		PeriodType:   model.Yearly,
+	}
+	first, err := testStore.AddOrUpdateTaxRecord(context.Background(), record, model.AnyVersion)
+	require.NoError(t, err)
+
+	// The new open-ended record closes the first one
+	record.StartDate = utils.DateOnly(2025, time.January, 1)
+	record.TaxRate = 0.3
+	second, err := testStore.AddOrUpdateTaxRecord(context.Background(), record, model.AnyVersion)
+	require.NoError(t, err)
+
+	_, err = testStore.DeleteTaxRecord(context.Background(), second.ID, 0)
+	require.NoError(t, err)
+
+	changes, err := testStore.GetTaxRecordChanges(context.Background(), 0, 10)
+	require.NoError(t, err)
+	require.Len(t, changes, 4)
+	require.Equal(t, model.ActionCreate, changes[0].Action)
+	require.Equal(t, first.ID, changes[0].Record.ID)
+	require.True(t, changes[0].Record.IsOpenEnded())
+	require.Equal(t, model.ActionUpdate, changes[1].Action)
+	require.Equal(t, utils.DateOnly(2024, time.December, 31), changes[1].Record.EndDate)
+	require.Equal(t, model.ActionCreate, changes[2].Action)
+	require.Equal(t, model.ActionDelete, changes[3].Action)
+	require.Equal(t, 0.3, changes[3].Record.TaxRate)
+
+	resumed, err := testStore.GetTaxRecordChanges(context.Background(), changes[1].ID, 1)
+	require.NoError(t, err)
+	require.Len(t, resumed, 1)
+	require.Equal(t, changes[2].ID, resumed[0].ID)
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	cleanupDB(t, testStore)
+	ctx := context.Background()
+
+	all, err := testStore.CreateWebhookSubscription(ctx, model.WebhookSubscription{URL: "http://all.example.com", Secret: "a"})
+	require.NoError(t, err)
+	filtered, err := testStore.CreateWebhookSubscription(ctx, model.WebhookSubscription{
+		URL: "http://aarhus.example.com", Municipality: "Aarhus", Secret: "b"})
+	require.NoError(t, err)
+
+	_, err = testStore.AddOrUpdateTaxRecord(ctx, model.TaxRecord{
+		Municipality: "Vejle",
+		TaxRate:      0.2,
+		StartDate:    utils.DateOnly(2024, time.January, 1),
+		PeriodType:   model.Yearly,
+	}, model.AnyVersion)
+	require.NoError(t, err)
+
+	fannedOut, err := testStore.FanOutTaxRecordChanges(ctx, 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), fannedOut)
+	fannedOut, err = testStore.FanOutTaxRecordChanges(ctx, 10)
+	require.NoError(t, err)
+	require.Zero(t, fannedOut)
+
+	// Only the subscription without a municipality filter is notified of the change
+	deliveries, err := testStore.ClaimWebhookDeliveries(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	delivery := deliveries[0]
+	require.Equal(t, all.ID, delivery.Subscription.ID)
+	require.Equal(t, "Vejle", delivery.Change.Record.Municipality)
+	require.Equal(t, model.ActionCreate, delivery.Change.Action)
+
+	// A claimed delivery is not claimed again before its lease expires
+	deliveries, err = testStore.ClaimWebhookDeliveries(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, deliveries)
+
+	err = testStore.RecordWebhookDeliveryAttempt(ctx, model.WebhookDeliveryAttempt{
+		DeliveryID: delivery.ID, Attempt: 1, StatusCode: 500, Error: "unexpected status 500"},
+		model.WebhookDeliveryPending, time.Now().Add(-time.Second))
+	require.NoError(t, err)
+
+	// A dispatcher whose lease expired cannot record the same attempt again
+	err = testStore.RecordWebhookDeliveryAttempt(ctx, model.WebhookDeliveryAttempt{
+		DeliveryID: delivery.ID, Attempt: 1, Error: "connection refused"},
+		model.WebhookDeliveryDead, time.Now())
+	require.ErrorIs(t, err, model.ErrConflict)
+
+	deliveries, err = testStore.ClaimWebhookDeliveries(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	require.Equal(t, 1, deliveries[0].Attempts)
+
+	err = testStore.RecordWebhookDeliveryAttempt(ctx, model.WebhookDeliveryAttempt{
+		DeliveryID: delivery.ID, Attempt: 2, Error: "connection refused"},
+		model.WebhookDeliveryDead, time.Now())
+	require.NoError(t, err)
+
+	attempts, err := testStore.ListWebhookDeliveryAttempts(ctx, all.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, attempts, 2)
+	require.Equal(t, 2, attempts[0].Attempt)
+	require.Equal(t, 500, attempts[1].StatusCode)
+
+	deadLetters, err := testStore.ListWebhookDeadLetters(ctx, all.ID)
+	require.NoError(t, err)
+	require.Len(t, deadLetters, 1)
+	require.Equal(t, delivery.Change.ID, deadLetters[0].ChangeID)
+	require.Equal(t, "connection refused", deadLetters[0].LastError)
+
+	deliveries, err = testStore.ClaimWebhookDeliveries(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, deliveries)
+
+	require.NoError(t, testStore.DeleteWebhookSubscription(ctx, filtered.ID))
+	require.ErrorIs(t, testStore.DeleteWebhookSubscription(ctx, filtered.ID), model.ErrNotFound)
+	_, err = testStore.GetWebhookSubscription(ctx, filtered.ID)
+	require.ErrorIs(t, err, model.ErrNotFound)
+}
+
+func TestOutbox(t *testing.T) {
+	cleanupDB(t, testStore)
+	ctx := context.Background()
+
+	record, err := testStore.AddOrUpdateTaxRecord(ctx, model.TaxRecord{
+		Municipality: "Kolding",
+		TaxRate:      0.2,
+		StartDate:    utils.DateOnly(2024, time.January, 1),
+		PeriodType:   model.Yearly,
+	}, model.AnyVersion)
+	require.NoError(t, err)
+	_, err = testStore.UpdateTaxRecordRate(ctx, record.ID, 0.25, record.Version)
+	require.NoError(t, err)
+
+	events, err := testStore.ClaimOutboxEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, model.ActionCreate, events[0].Change.Action)
+	require.Equal(t, model.ActionUpdate, events[1].Change.Action)
+	require.Equal(t, 0.25, events[1].Change.Record.TaxRate)
+
+	// Claimed events are hidden until their lease expires
+	claimed, err := testStore.ClaimOutboxEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, claimed)
+
+	require.NoError(t, testStore.DeleteOutboxEvent(ctx, events[0].ID))
+	require.NoError(t, testStore.FailOutboxEvent(ctx, events[1].ID, "unavailable", time.Now().Add(-time.Second)))
+
+	claimed, err = testStore.ClaimOutboxEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	require.Equal(t, events[1].ID, claimed[0].ID)
+	require.Equal(t, 1, claimed[0].Attempts)
+	require.Equal(t, "unavailable", claimed[0].LastError)
+}
+
+func TestAPIKeys(t *testing.T) {
+	cleanupDB(t, testStore)
+	ctx := context.Background()
+
+	hash := []byte("0123456789abcdef0123456789abcdef")
+	key, err := testStore.CreateAPIKey(ctx, model.APIKey{
+		Name:           "ci",
+		Prefix:         "tm_abcdefgh",
+		Scopes:         []model.Scope{model.ScopeRead, model.ScopeWrite},
+		Municipalities: []string{"Copenhagen", "Aarhus"},
+	}, hash)
+	require.NoError(t, err)
+	require.Equal(t, []model.Scope{model.ScopeRead, model.ScopeWrite}, key.Scopes)
+	require.Equal(t, []string{"Copenhagen", "Aarhus"}, key.Municipalities)
+	require.Nil(t, key.RevokedAt)
+
+	_, err = testStore.CreateAPIKey(ctx, model.APIKey{Name: "ci", Prefix: "tm_other", Scopes: []model.Scope{model.ScopeRead}},
+		[]byte("another hash"))
+	require.ErrorIs(t, err, model.ErrConflict)
+
+	found, err := testStore.GetActiveAPIKeyByHash(ctx, hash)
+	require.NoError(t, err)
+	require.Equal(t, key.ID, found.ID)
+	require.Equal(t, key.Municipalities, found.Municipalities)
+
+	require.NoError(t, testStore.RevokeAPIKey(ctx, key.ID))
+	require.ErrorIs(t, testStore.RevokeAPIKey(ctx, key.ID), model.ErrNotFound)
+	_, err = testStore.GetActiveAPIKeyByHash(ctx, hash)
+	require.ErrorIs(t, err, model.ErrNotFound)
+
+	// The name of a revoked key can be reused
+	_, err = testStore.CreateAPIKey(ctx, model.APIKey{Name: "ci", Prefix: "tm_other", Scopes: []model.Scope{model.ScopeRead}},
+		[]byte("another hash"))
+	require.NoError(t, err)
+
+	keys, err := testStore.ListAPIKeys(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.NotNil(t, keys[0].RevokedAt)
+	require.Nil(t, keys[1].Municipalities)
+}
+
+func TestQuotaUsage(t *testing.T) {
+	cleanupDB(t, testStore)
+	ctx := context.Background()
+
+	yesterday := utils.DateOnly(2024, time.June, 1)
+	today := utils.DateOnly(2024, time.June, 2)
+
+	for expected := int64(1); expected <= 3; expected++ {
+		requests, err := testStore.IncrementQuotaUsage(ctx, "apikey:ci", today)
+		require.NoError(t, err)
+		require.Equal(t, expected, requests)
+	}
+	requests, err := testStore.IncrementQuotaUsage(ctx, "ip:192.0.2.1", today)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), requests)
+	_, err = testStore.IncrementQuotaUsage(ctx, "apikey:ci", yesterday)
+	require.NoError(t, err)
+
+	deleted, err := testStore.DeleteQuotaUsageBefore(ctx, today)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+
+	requests, err = testStore.IncrementQuotaUsage(ctx, "apikey:ci", today)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), requests)
+}
+
+func TestStatementObserver(t *testing.T) {
+	cleanupDB(t, testStore)
+	ctx := context.Background()
+
+	var executed []string
+	testStore.SetStatementObserver(func(statement string, duration time.Duration, err error) {
+		require.NoError(t, err)
+		require.Positive(t, duration)
+		executed = append(executed, statement)
+	})
+	t.Cleanup(func() { testStore.SetStatementObserver(nil) })
+
+	_, err := testStore.GetTaxRecords(ctx, model.TaxQuery{Municipality: "Copenhagen", Date: utils.DateOnly(2024, time.June, 1)})
+	require.NoError(t, err)
+	require.Contains(t, executed, "selectTaxRecords")
+}
+
+func TestTaxRecordDraftWorkflow(t *testing.T) {
+	cleanupDB(t, testStore)
+
+	const municipality = "Roskilde"
+
+	authorCtx := requestctx.WithActor(context.Background(), "author")
+	reviewerCtx := requestctx.WithActor(context.Background(), "reviewer")
+	query := model.TaxQuery{Municipality: municipality, Date: utils.DateOnly(2024, time.June, 1)}
+
+	draft, err := testStore.CreateTaxRecordDraft(authorCtx, model.TaxRecordDraft{
+		Record: model.TaxRecord{
+			Municipality: municipality,
+			TaxRate:      0.2,
+			StartDate:    utils.DateOnly(2024, time.January, 1),
+			EndDate:      utils.DateOnly(2024, time.December, 31),
+			PeriodType:   model.Yearly,
+		},
+		PublishAt: time.Now().Add(-time.Minute),
+	})
+	require.NoError(t, err)
+	require.Equal(t, model.DraftStatusDraft, draft.Status)
+	require.Equal(t, "author", draft.CreatedBy)
+
+	t.Run("drafts are not visible", func(t *testing.T) {
+		records, err := testStore.GetTaxRecords(context.Background(), query)
+		require.NoError(t, err)
+		require.Empty(t, records)
+	})
+
+	t.Run("publish requires approval", func(t *testing.T) {
+		_, err := testStore.PublishTaxRecordDraft(reviewerCtx, draft.ID, time.Now())
+		require.ErrorIs(t, err, model.ErrInvalidState)
+
+		_, err = testStore.TransitionTaxRecordDraft(reviewerCtx, draft.ID, model.DraftStatusPendingApproval, model.DraftStatusApproved)
+		require.ErrorIs(t, err, model.ErrInvalidState)
+	})
+
+	t.Run("submit and approve", func(t *testing.T) {
+		submitted, err := testStore.TransitionTaxRecordDraft(authorCtx, draft.ID, model.DraftStatusDraft, model.DraftStatusPendingApproval)
+		require.NoError(t, err)
+		require.Equal(t, model.DraftStatusPendingApproval, submitted.Status)
+		require.Empty(t, submitted.ApprovedBy)
+
+		pending, err := testStore.ListTaxRecordDrafts(context.Background(), model.DraftStatusPendingApproval)
+		require.NoError(t, err)
+		require.Len(t, pending, 1)
+
+		approved, err := testStore.TransitionTaxRecordDraft(reviewerCtx, draft.ID, model.DraftStatusPendingApproval, model.DraftStatusApproved)
+		require.NoError(t, err)
+		require.Equal(t, "reviewer", approved.ApprovedBy)
+	})
+
+	t.Run("publish due drafts", func(t *testing.T) {
+		ids, err := testStore.GetDueTaxRecordDraftIDs(context.Background(), time.Now())
+		require.NoError(t, err)
+		require.Equal(t, []int64{draft.ID}, ids)
+
+		_, err = testStore.PublishTaxRecordDraft(reviewerCtx, draft.ID, time.Now().Add(-time.Hour))
+		require.ErrorIs(t, err, model.ErrNotDue)
+
+		published, err := testStore.PublishTaxRecordDraft(reviewerCtx, draft.ID, time.Now())
+		require.NoError(t, err)
+		require.Equal(t, model.DraftStatusPublished, published.Status)
+		require.NotZero(t, published.PublishedRecordID)
+
+		records, err := testStore.GetTaxRecords(context.Background(), query)
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		require.Equal(t, published.PublishedRecordID, records[0].ID)
+
+		_, err = testStore.GetTaxRecordDraft(context.Background(), draft.ID+1000)
+		require.ErrorIs(t, err, model.ErrNotFound)
+	})
+}
+
+func TestReady(t *testing.T) {
+	ctx := context.Background()
+	require.NoError(t, testStore.Ready(ctx))
+
+	closedStore, err := store.NewPostgresStore(os.Getenv("TEST_DB_URL"))
+	require.NoError(t, err)
+	require.NoError(t, closedStore.Close())
+	require.Error(t, closedStore.Ready(ctx))
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// IncrementQuotaUsage counts a request of client on day and returns the number of requests it made that day.
+func (s *PostgresStore) IncrementQuotaUsage(ctx context.Context, client string, day time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("incrementQuotaUsage")
+	if err != nil {
+		return 0, err
+	}
+	var requests int64
+	if err := stmt.QueryRowContext(ctx, client, day).Scan(&requests); err != nil {
+		return 0, fmt.Errorf("failed to execute stmtIncrementQuotaUsage: %w", err)
+	}
+	return requests, nil
+}
+
+// DeleteQuotaUsageBefore removes the request counts of the days before day and returns how many were removed.
+func (s *PostgresStore) DeleteQuotaUsageBefore(ctx context.Context, day time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("deleteQuotaUsageBefore")
+	if err != nil {
+		return 0, err
+	}
+	result, err := stmt.ExecContext(ctx, day)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute stmtDeleteQuotaUsageBefore: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count deleted quota usage: %w", err)
+	}
+	return deleted, nil
+}
@@ -8,24 +8,549 @@ const (
 		tax_rate FLOAT NOT NULL,
 		period DATERANGE NOT NULL,
 		period_type TEXT NOT NULL CHECK (period_type IN ('yearly', 'monthly', 'weekly', 'daily')),
-		UNIQUE (municipality_name, period, period_type)
+		version INTEGER NOT NULL DEFAULT 1,
+		deleted_at TIMESTAMPTZ
 	)`
+	// sqlMigrateSoftDelete upgrades tables created before soft deletes were introduced,
+	// replacing the unique constraint with a unique index over the records that are not deleted.
+	sqlMigrateSoftDelete = `
+	ALTER TABLE municipality_taxes ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+	ALTER TABLE municipality_taxes DROP CONSTRAINT IF EXISTS municipality_taxes_municipality_name_period_period_type_key;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_municipality_taxes_unique_active
+	ON municipality_taxes(municipality_name, period, period_type) WHERE deleted_at IS NULL;`
+	// sqlMigrateVersion upgrades tables created before records were versioned for optimistic concurrency.
+	sqlMigrateVersion                      = `ALTER TABLE municipality_taxes ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1`
+	sqlCreateMunicipalityTaxesHistoryTable = `
+	CREATE TABLE IF NOT EXISTS municipality_taxes_history (
+		id BIGSERIAL PRIMARY KEY,
+		record_id INTEGER NOT NULL,
+		municipality_name TEXT NOT NULL,
+		period_type TEXT NOT NULL,
+		action TEXT NOT NULL,
+		old_tax_rate FLOAT,
+		new_tax_rate FLOAT,
+		old_period DATERANGE,
+		new_period DATERANGE,
+		actor TEXT NOT NULL,
+		request_id TEXT,
+		changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`
+	// sqlCreateMunicipalityTaxesVersionsTable holds the system-time versions of the tax records.
+	// Each version is valid for the transaction time range in system_period, the current version is unbounded.
+	sqlCreateMunicipalityTaxesVersionsTable = `
+	CREATE TABLE IF NOT EXISTS municipality_taxes_versions (
+		id BIGSERIAL PRIMARY KEY,
+		record_id INTEGER NOT NULL,
+		municipality_name TEXT NOT NULL,
+		tax_rate FLOAT NOT NULL,
+		period DATERANGE NOT NULL,
+		period_type TEXT NOT NULL,
+		system_period TSTZRANGE NOT NULL
+	)`
+	// sqlCreateMunicipalityTaxChangesTable is the change log of the tax records.
+	// Its IDs follow the commit order of the changes, see sqlLockChangeLog.
+	sqlCreateMunicipalityTaxChangesTable = `
+	CREATE TABLE IF NOT EXISTS municipality_tax_changes (
+		id BIGSERIAL PRIMARY KEY,
+		record_id INTEGER NOT NULL,
+		municipality_name TEXT NOT NULL,
+		action TEXT NOT NULL,
+		tax_rate FLOAT NOT NULL,
+		period DATERANGE NOT NULL,
+		period_type TEXT NOT NULL,
+		changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`
+	sqlCreateMunicipalityTaxDraftsTable = `
+	CREATE TABLE IF NOT EXISTS municipality_tax_drafts (
+		id BIGSERIAL PRIMARY KEY,
+		municipality_name TEXT NOT NULL,
+		tax_rate FLOAT NOT NULL,
+		period DATERANGE NOT NULL,
+		period_type TEXT NOT NULL CHECK (period_type IN ('yearly', 'monthly', 'weekly', 'daily')),
+		status TEXT NOT NULL CHECK (status IN ('draft', 'pending_approval', 'approved', 'published')),
+		publish_at TIMESTAMPTZ,
+		created_by TEXT NOT NULL,
+		approved_by TEXT,
+		published_record_id INTEGER,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`
+	// sqlCreateOutboxTable holds the changes of the change log that are not published yet.
+	// Rows are written in the transaction of the change and deleted once the change is published.
+	sqlCreateOutboxTable = `
+	CREATE TABLE IF NOT EXISTS municipality_tax_outbox (
+		id BIGSERIAL PRIMARY KEY,
+		change_id BIGINT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		last_error TEXT,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`
+	sqlCreateAPIKeysTable = `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id BIGSERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		key_hash BYTEA NOT NULL UNIQUE,
+		scopes TEXT[] NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		revoked_at TIMESTAMPTZ
+	)`
+	sqlCreateQuotaUsageTable = `
+	CREATE TABLE IF NOT EXISTS client_quota_usage (
+		client TEXT NOT NULL,
+		day DATE NOT NULL,
+		requests BIGINT NOT NULL,
+		PRIMARY KEY (client, day)
+	)`
+	// sqlMigrateAPIKeyMunicipalities upgrades tables created before API keys could be restricted to municipalities.
+	sqlMigrateAPIKeyMunicipalities = `ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS municipalities TEXT[] NOT NULL DEFAULT '{}'`
+	sqlCreateWebhookTables         = `
+	CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+		id BIGSERIAL PRIMARY KEY,
+		url TEXT NOT NULL,
+		municipality_name TEXT,
+		secret TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id BIGSERIAL PRIMARY KEY,
+		subscription_id BIGINT NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+		change_id BIGINT NOT NULL,
+		status TEXT NOT NULL CHECK (status IN ('pending', 'delivered', 'dead')),
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		last_error TEXT,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		UNIQUE (subscription_id, change_id)
+	);
+	CREATE TABLE IF NOT EXISTS webhook_delivery_attempts (
+		id BIGSERIAL PRIMARY KEY,
+		delivery_id BIGINT NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+		attempt INTEGER NOT NULL,
+		status_code INTEGER,
+		error TEXT,
+		duration_ms BIGINT NOT NULL,
+		attempted_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE TABLE IF NOT EXISTS webhook_dead_letters (
+		id BIGSERIAL PRIMARY KEY,
+		delivery_id BIGINT NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+		subscription_id BIGINT NOT NULL,
+		change_id BIGINT NOT NULL,
+		attempts INTEGER NOT NULL,
+		last_error TEXT,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	-- webhook_cursor holds the last change of the change log fanned out to the subscriptions.
+	-- It starts at the end of the change log, so that past changes are not delivered.
+	CREATE TABLE IF NOT EXISTS webhook_cursor (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		last_change_id BIGINT NOT NULL
+	);
+	INSERT INTO webhook_cursor (id, last_change_id)
+	SELECT 1, COALESCE(max(id), 0) FROM municipality_tax_changes
+	ON CONFLICT (id) DO NOTHING;`
+
 	sqlCreateIndexes = `
 	CREATE INDEX IF NOT EXISTS idx_municipality_name ON municipality_taxes(municipality_name);
-	CREATE INDEX IF NOT EXISTS idx_period ON municipality_taxes USING GIST (period);`
+	CREATE INDEX IF NOT EXISTS idx_period ON municipality_taxes USING GIST (period);
+	CREATE INDEX IF NOT EXISTS idx_history_record_id ON municipality_taxes_history(record_id);
+	CREATE INDEX IF NOT EXISTS idx_changes_municipality_id ON municipality_tax_changes(municipality_name, id);
+	CREATE INDEX IF NOT EXISTS idx_versions_record_id ON municipality_taxes_versions(record_id);
+	CREATE INDEX IF NOT EXISTS idx_versions_municipality_name ON municipality_taxes_versions(municipality_name);
+	CREATE INDEX IF NOT EXISTS idx_versions_system_period ON municipality_taxes_versions USING GIST (system_period);
+	CREATE INDEX IF NOT EXISTS idx_drafts_status_publish_at ON municipality_tax_drafts(status, publish_at);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_active_name ON api_keys(name) WHERE revoked_at IS NULL;
+	CREATE INDEX IF NOT EXISTS idx_outbox_next_attempt_at ON municipality_tax_outbox(next_attempt_at, id);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription_id ON webhook_deliveries(subscription_id);
+	CREATE INDEX IF NOT EXISTS idx_webhook_delivery_attempts_delivery_id ON webhook_delivery_attempts(delivery_id);
+	CREATE INDEX IF NOT EXISTS idx_webhook_dead_letters_subscription_id ON webhook_dead_letters(subscription_id);`
+
+	// sqlBackfillTaxRecordVersions creates a version known since forever for records
+	// that existed before system-time versioning was introduced.
+	sqlBackfillTaxRecordVersions = `
+	INSERT INTO municipality_taxes_versions (record_id, municipality_name, tax_rate, period, period_type, system_period)
+	SELECT id, municipality_name, tax_rate, period, period_type, tstzrange('-infinity', NULL)
+	FROM municipality_taxes t
+	WHERE t.deleted_at IS NULL
+	AND NOT EXISTS (SELECT 1 FROM municipality_taxes_versions v WHERE v.record_id = t.id)`
+
+	// sqlBackfillTaxRecordChanges logs the records that existed before the change log was introduced as created,
+	// so that readers starting from the beginning of the change log receive every record.
+	sqlBackfillTaxRecordChanges = `
+	INSERT INTO municipality_tax_changes (record_id, municipality_name, action, tax_rate, period, period_type)
+	SELECT id, municipality_name, 'create', tax_rate, period, period_type
+	FROM municipality_taxes t
+	WHERE t.deleted_at IS NULL
+	AND NOT EXISTS (SELECT 1 FROM municipality_tax_changes c WHERE c.record_id = t.id)
+	ORDER BY id`
+
+	// sqlLockMunicipality serializes writes to the records of a municipality until the transaction ends,
+	// so that the previous values written to the history are accurate.
+	sqlLockMunicipality = `SELECT pg_advisory_xact_lock(hashtext($1))`
+
+	// sqlNotifyTaxRecordsChanged notifies the listeners of channel $1 that the records of municipality $2 changed.
+	sqlNotifyTaxRecordsChanged = `SELECT pg_notify($1, $2)`
+
+	// sqlLockChangeLog serializes appends to the change log until the transaction ends, so that change IDs
+	// are assigned in commit order and readers resuming after an ID never skip a change committed later.
+	sqlLockChangeLog = `SELECT pg_advisory_xact_lock(hashtext('municipality_tax_changes'))`
+
+	sqlInsertTaxRecordChange = `
+	INSERT INTO municipality_tax_changes (record_id, municipality_name, action, tax_rate, period, period_type)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id`
+
+	sqlInsertOutboxEvent = `INSERT INTO municipality_tax_outbox (change_id) VALUES ($1)`
+
+	// sqlClaimOutboxEvents claims at most $1 due events by postponing them by the lease of $2 seconds,
+	// so that other instances skip them and an event interrupted by a crash is published again.
+	sqlClaimOutboxEvents = `
+	WITH claimed AS (
+		UPDATE municipality_tax_outbox
+		SET next_attempt_at = now() + make_interval(secs => $2)
+		WHERE id IN (
+			SELECT id FROM municipality_tax_outbox
+			WHERE next_attempt_at <= now()
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, change_id, attempts, COALESCE(last_error, '') AS last_error, created_at
+	)
+	SELECT claimed.id, claimed.attempts, claimed.last_error, claimed.created_at,
+		c.id, c.record_id, c.municipality_name, c.action, c.tax_rate, c.period, c.period_type, c.changed_at
+	FROM claimed
+	JOIN municipality_tax_changes c ON c.id = claimed.change_id
+	ORDER BY claimed.id`
+
+	sqlDeleteOutboxEvent = `DELETE FROM municipality_tax_outbox WHERE id = $1`
+
+	sqlUpdateOutboxEventFailure = `
+	UPDATE municipality_tax_outbox
+	SET attempts = attempts + 1, next_attempt_at = $2, last_error = $3
+	WHERE id = $1`
+
+	sqlSelectTaxRecordChanges = `
+	SELECT id, record_id, municipality_name, action, tax_rate, period, period_type, changed_at
+	FROM municipality_tax_changes
+	WHERE id > $1
+	ORDER BY id
+	LIMIT $2`
 
 	sqlInsertOrUpdateTaxRecord = `
 	INSERT INTO municipality_taxes (municipality_name, tax_rate, period, period_type)
 	VALUES ($1, $2, $3, $4)
-	ON CONFLICT (municipality_name, period, period_type)
-	DO UPDATE SET tax_rate = EXCLUDED.tax_rate, period_type = EXCLUDED.period_type`
+	ON CONFLICT (municipality_name, period, period_type) WHERE deleted_at IS NULL
+	DO UPDATE SET tax_rate = EXCLUDED.tax_rate, period_type = EXCLUDED.period_type,
+		version = municipality_taxes.version + CASE WHEN municipality_taxes.tax_rate <> EXCLUDED.tax_rate THEN 1 ELSE 0 END
+	RETURNING id, version`
+
+	sqlInsertTaxRecordHistory = `
+	INSERT INTO municipality_taxes_history
+	(record_id, municipality_name, period_type, action, old_tax_rate, new_tax_rate, old_period, new_period, actor, request_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	sqlSelectTaxRecordHistory = `
+	SELECT id, record_id, action, old_tax_rate, new_tax_rate, old_period, new_period, actor, request_id, changed_at
+	FROM municipality_taxes_history
+	WHERE record_id = $1
+	ORDER BY id`
+
+	// sqlSelectMunicipalityLastChange selects the latest entry of the change log for municipality $1.
+	// IDs are drawn under the change log lock, so they increase in commit order unlike changed_at,
+	// which is the start of the writing transaction.
+	sqlSelectMunicipalityLastChange = `
+	SELECT id, changed_at
+	FROM municipality_tax_changes
+	WHERE municipality_name = $1
+	ORDER BY id DESC
+	LIMIT 1`
+
+	sqlCloseTaxRecordVersion = `
+	UPDATE municipality_taxes_versions
+	SET system_period = tstzrange(lower(system_period), now())
+	WHERE record_id = $1
+	AND upper_inf(system_period)`
+
+	sqlInsertTaxRecordVersion = `
+	INSERT INTO municipality_taxes_versions (record_id, municipality_name, tax_rate, period, period_type, system_period)
+	SELECT id, municipality_name, tax_rate, period, period_type, tstzrange(now(), NULL)
+	FROM municipality_taxes
+	WHERE id = $1
+	AND deleted_at IS NULL`
+
+	sqlSelectTaxRecordsAsOf = `
+	SELECT record_id, municipality_name, tax_rate, period, period_type
+	FROM municipality_taxes_versions
+	WHERE municipality_name = $1
+	AND $2 <@ period
+	AND system_period @> $3::timestamptz;
+	`
+
+	sqlTaxRecordExists = `SELECT EXISTS (SELECT 1 FROM municipality_taxes WHERE id = $1)`
+
+	// sqlCloseOpenEndedTaxRecords closes open-ended records of the same municipality and period type
+	// that started before the given date, so that they end the day before it.
+	sqlCloseOpenEndedTaxRecords = `
+	UPDATE municipality_taxes
+	SET period = daterange(lower(period), $3::date), version = version + 1
+	WHERE municipality_name = $1
+	AND period_type = $2
+	AND upper_inf(period)
+	AND lower(period) < $3::date
+	AND deleted_at IS NULL
+	RETURNING id, tax_rate, period`
+
+	sqlSelectTaxRecordForUpdate = `
+	SELECT id, tax_rate, version
+	FROM municipality_taxes
+	WHERE municipality_name = $1
+	AND period = $2
+	AND period_type = $3
+	AND deleted_at IS NULL
+	FOR UPDATE`
+
+	sqlSelectMunicipalityOfTaxRecord = `SELECT municipality_name FROM municipality_taxes WHERE id = $1`
+
+	sqlTaxRecordColumns = `
+	id, municipality_name, tax_rate, period, period_type, version, deleted_at`
+
+	sqlSelectTaxRecordByID = `
+	SELECT` + sqlTaxRecordColumns + `
+	FROM municipality_taxes
+	WHERE id = $1`
+
+	sqlSelectTaxRecordByIDForUpdate = sqlSelectTaxRecordByID + `
+	FOR UPDATE`
+
+	sqlUpdateTaxRecordRate = `
+	UPDATE municipality_taxes
+	SET tax_rate = $2, version = version + CASE WHEN tax_rate <> $2 THEN 1 ELSE 0 END
+	WHERE id = $1
+	RETURNING` + sqlTaxRecordColumns
+
+	sqlSoftDeleteTaxRecord = `
+	UPDATE municipality_taxes
+	SET deleted_at = now(), version = version + 1
+	WHERE id = $1
+	RETURNING` + sqlTaxRecordColumns
+
+	sqlRestoreTaxRecord = `
+	UPDATE municipality_taxes
+	SET deleted_at = NULL, version = version + 1
+	WHERE id = $1
+	RETURNING` + sqlTaxRecordColumns
+
+	// sqlPurgeDeletedTaxRecords permanently removes records soft-deleted more than $1 seconds ago.
+	// Their history and versions are kept.
+	sqlPurgeDeletedTaxRecords = `
+	DELETE FROM municipality_taxes
+	WHERE deleted_at IS NOT NULL
+	AND deleted_at < now() - make_interval(secs => $1)`
 
 	sqlSelectTaxRecords = `
-	SELECT municipality_name, tax_rate, period, period_type
+	SELECT id, municipality_name, tax_rate, period, period_type
 	FROM municipality_taxes
 	WHERE municipality_name = $1
-	AND $2 <@ period;
+	AND $2 <@ period
+	AND deleted_at IS NULL;
 	`
 
-	sqlTruncateMunicipalityTaxesTable = `TRUNCATE TABLE municipality_taxes;`
+	sqlTaxRecordDraftColumns = `
+	id, municipality_name, tax_rate, period, period_type, status, publish_at,
+	created_by, approved_by, published_record_id, created_at, updated_at`
+
+	sqlInsertTaxRecordDraft = `
+	INSERT INTO municipality_tax_drafts (municipality_name, tax_rate, period, period_type, status, publish_at, created_by)
+	VALUES ($1, $2, $3, $4, 'draft', $5, $6)
+	RETURNING` + sqlTaxRecordDraftColumns
+
+	sqlSelectTaxRecordDraft = `
+	SELECT` + sqlTaxRecordDraftColumns + `
+	FROM municipality_tax_drafts
+	WHERE id = $1`
+
+	sqlSelectTaxRecordDraftForUpdate = sqlSelectTaxRecordDraft + `
+	FOR UPDATE`
+
+	// sqlSelectTaxRecordDrafts lists the drafts, optionally filtered by the status in $1.
+	sqlSelectTaxRecordDrafts = `
+	SELECT` + sqlTaxRecordDraftColumns + `
+	FROM municipality_tax_drafts
+	WHERE $1 = '' OR status = $1
+	ORDER BY id`
+
+	// sqlTransitionTaxRecordDraft moves a draft from status $2 to status $3,
+	// recording the actor in $4 as the approver when the draft is approved.
+	sqlTransitionTaxRecordDraft = `
+	UPDATE municipality_tax_drafts
+	SET status = $3,
+		approved_by = CASE WHEN $3 = 'approved' THEN $4 ELSE approved_by END,
+		updated_at = now()
+	WHERE id = $1
+	AND status = $2
+	RETURNING` + sqlTaxRecordDraftColumns
+
+	sqlMarkTaxRecordDraftPublished = `
+	UPDATE municipality_tax_drafts
+	SET status = 'published', published_record_id = $2, updated_at = now()
+	WHERE id = $1
+	RETURNING` + sqlTaxRecordDraftColumns
+
+	sqlSelectDueTaxRecordDrafts = `
+	SELECT id
+	FROM municipality_tax_drafts
+	WHERE status = 'approved'
+	AND publish_at <= $1
+	ORDER BY publish_at, id`
+
+	sqlAPIKeyColumns = `
+	id, name, prefix, scopes, municipalities, created_at, revoked_at`
+
+	sqlInsertAPIKey = `
+	INSERT INTO api_keys (name, prefix, key_hash, scopes, municipalities)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING` + sqlAPIKeyColumns
+
+	sqlSelectActiveAPIKeyByHash = `
+	SELECT` + sqlAPIKeyColumns + `
+	FROM api_keys
+	WHERE key_hash = $1 AND revoked_at IS NULL`
+
+	sqlSelectAPIKeys = `
+	SELECT` + sqlAPIKeyColumns + `
+	FROM api_keys
+	ORDER BY id`
+
+	sqlRevokeAPIKey = `
+	UPDATE api_keys
+	SET revoked_at = now()
+	WHERE id = $1 AND revoked_at IS NULL`
+
+	sqlIncrementQuotaUsage = `
+	INSERT INTO client_quota_usage (client, day, requests)
+	VALUES ($1, $2, 1)
+	ON CONFLICT (client, day) DO UPDATE SET requests = client_quota_usage.requests + 1
+	RETURNING requests`
+
+	sqlDeleteQuotaUsageBefore = `
+	DELETE FROM client_quota_usage
+	WHERE day < $1`
+
+	sqlWebhookSubscriptionColumns = `
+	id, url, COALESCE(municipality_name, ''), secret, created_at`
+
+	sqlInsertWebhookSubscription = `
+	INSERT INTO webhook_subscriptions (url, municipality_name, secret)
+	VALUES ($1, NULLIF($2, ''), $3)
+	RETURNING` + sqlWebhookSubscriptionColumns
+
+	sqlSelectWebhookSubscription = `
+	SELECT` + sqlWebhookSubscriptionColumns + `
+	FROM webhook_subscriptions
+	WHERE id = $1`
+
+	sqlSelectWebhookSubscriptions = `
+	SELECT` + sqlWebhookSubscriptionColumns + `
+	FROM webhook_subscriptions
+	ORDER BY id`
+
+	sqlDeleteWebhookSubscription = `DELETE FROM webhook_subscriptions WHERE id = $1`
+
+	sqlSelectWebhookCursorForUpdate = `SELECT last_change_id FROM webhook_cursor WHERE id = 1 FOR UPDATE`
+
+	// sqlFanOutTaxRecordChanges creates the deliveries of the changes with an ID in ($1, $2]
+	// to the subscriptions matching their municipality.
+	sqlFanOutTaxRecordChanges = `
+	INSERT INTO webhook_deliveries (subscription_id, change_id, status)
+	SELECT s.id, c.id, 'pending'
+	FROM municipality_tax_changes c
+	JOIN webhook_subscriptions s ON s.municipality_name IS NULL OR s.municipality_name = c.municipality_name
+	WHERE c.id > $1 AND c.id <= $2
+	ON CONFLICT (subscription_id, change_id) DO NOTHING`
+
+	// sqlSelectNextChangeID finds the ID up to which the next batch of at most $2 changes after $1 is fanned out.
+	sqlSelectNextChangeID = `
+	SELECT COALESCE(max(id), $1)
+	FROM (SELECT id FROM municipality_tax_changes WHERE id > $1 ORDER BY id LIMIT $2) batch`
+
+	sqlUpdateWebhookCursor = `UPDATE webhook_cursor SET last_change_id = $1 WHERE id = 1`
+
+	// sqlClaimWebhookDeliveries claims at most $1 due deliveries by postponing them by the lease of $2 seconds,
+	// so that other instances skip them and a delivery interrupted by a crash is attempted again.
+	sqlClaimWebhookDeliveries = `
+	WITH claimed AS (
+		UPDATE webhook_deliveries
+		SET next_attempt_at = now() + make_interval(secs => $2), updated_at = now()
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending'
+			AND next_attempt_at <= now()
+			ORDER BY next_attempt_at, id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, subscription_id, change_id, status, attempts, next_attempt_at, last_error, created_at, updated_at
+	)
+	SELECT claimed.id, claimed.status, claimed.attempts, claimed.next_attempt_at, COALESCE(claimed.last_error, ''),
+		claimed.created_at, claimed.updated_at,
+		s.id, s.url, COALESCE(s.municipality_name, ''), s.secret, s.created_at,
+		c.id, c.action, c.record_id, c.municipality_name, c.tax_rate, c.period, c.period_type, c.changed_at
+	FROM claimed
+	JOIN webhook_subscriptions s ON s.id = claimed.subscription_id
+	JOIN municipality_tax_changes c ON c.id = claimed.change_id
+	ORDER BY claimed.next_attempt_at, claimed.id`
+
+	sqlInsertWebhookDeliveryAttempt = `
+	INSERT INTO webhook_delivery_attempts (delivery_id, attempt, status_code, error, duration_ms)
+	VALUES ($1, $2, NULLIF($3, 0), NULLIF($4, ''), $5)`
+
+	// sqlUpdateWebhookDelivery records attempt $3 of delivery $1, unless another attempt was recorded since it was claimed.
+	sqlUpdateWebhookDelivery = `
+	UPDATE webhook_deliveries
+	SET status = $2, attempts = $3, next_attempt_at = $4, last_error = NULLIF($5, ''), updated_at = now()
+	WHERE id = $1 AND status = 'pending' AND attempts = $3 - 1
+	RETURNING subscription_id, change_id`
+
+	sqlInsertWebhookDeadLetter = `
+	INSERT INTO webhook_dead_letters (delivery_id, subscription_id, change_id, attempts, last_error)
+	VALUES ($1, $2, $3, $4, NULLIF($5, ''))`
+
+	sqlSelectWebhookDeliveryAttempts = `
+	SELECT a.id, a.delivery_id, a.attempt, COALESCE(a.status_code, 0), COALESCE(a.error, ''), a.duration_ms, a.attempted_at
+	FROM webhook_delivery_attempts a
+	JOIN webhook_deliveries d ON d.id = a.delivery_id
+	WHERE d.subscription_id = $1
+	ORDER BY a.id DESC
+	LIMIT $2`
+
+	sqlSelectWebhookDeadLetters = `
+	SELECT id, delivery_id, subscription_id, change_id, attempts, COALESCE(last_error, ''), created_at
+	FROM webhook_dead_letters
+	WHERE subscription_id = $1
+	ORDER BY id`
+
+	// sqlSelectMissingSchema returns the tables, and the "table.column" columns, of $1 missing from the schema.
+	sqlSelectMissingSchema = `
+	SELECT object FROM unnest($1::text[]) AS object
+	WHERE NOT EXISTS (
+		SELECT 1 FROM information_schema.columns
+		WHERE table_schema = current_schema()
+		AND (table_name = object OR table_name || '.' || column_name = object)
+	)`
+
+	sqlTruncateMunicipalityTaxesTable         = `TRUNCATE TABLE municipality_taxes;`
+	sqlTruncateMunicipalityTaxesHistoryTable  = `TRUNCATE TABLE municipality_taxes_history;`
+	sqlTruncateMunicipalityTaxesVersionsTable = `TRUNCATE TABLE municipality_taxes_versions;`
+	sqlTruncateMunicipalityTaxDraftsTable     = `TRUNCATE TABLE municipality_tax_drafts;`
+	sqlTruncateMunicipalityTaxChangesTable    = `TRUNCATE TABLE municipality_tax_changes;`
+	sqlTruncateOutboxTable                    = `TRUNCATE TABLE municipality_tax_outbox;`
+	sqlTruncateAPIKeysTable                   = `TRUNCATE TABLE api_keys;`
+	sqlTruncateQuotaUsageTable                = `TRUNCATE TABLE client_quota_usage;`
+	sqlTruncateWebhookTables                  = `TRUNCATE TABLE webhook_subscriptions, webhook_deliveries, webhook_delivery_attempts, webhook_dead_letters;`
+	sqlResetWebhookCursor                     = `UPDATE webhook_cursor SET last_change_id = 0`
 )
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer traces the executions of prepared statements through the global tracer provider.
+var tracer = otel.Tracer("github.com/rezkam/TaxMan/store")
+
+// StatementObserver is notified of every execution of a prepared statement with its name, duration and error.
+type StatementObserver func(statement string, duration time.Duration, err error)
+
+// preparedStatement is a prepared statement whose executions are traced and reported to the observer of its store.
+// Queries are timed until their first row is available, reading the rest of the rows is not included.
+type preparedStatement struct {
+	*sql.Stmt
+	name  string
+	store *PostgresStore
+}
+
+func (p preparedStatement) ExecContext(ctx context.Context, args ...any) (sql.Result, error) {
+	ctx, done := p.start(ctx)
+	result, err := p.Stmt.ExecContext(ctx, args...)
+	done(err)
+	return result, err
+}
+
+func (p preparedStatement) QueryContext(ctx context.Context, args ...any) (*sql.Rows, error) {
+	ctx, done := p.start(ctx)
+	rows, err := p.Stmt.QueryContext(ctx, args...)
+	done(err)
+	return rows, err
+}
+
+func (p preparedStatement) QueryRowContext(ctx context.Context, args ...any) *sql.Row {
+	ctx, done := p.start(ctx)
+	row := p.Stmt.QueryRowContext(ctx, args...)
+	done(row.Err())
+	return row
+}
+
+// start starts the span of an execution, done ends it and reports the execution to the observer.
+func (p preparedStatement) start(ctx context.Context) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, p.name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperationName(p.name)))
+
+	return ctx, func(err error) {
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+
+		if observer := p.store.observer.Load(); observer != nil {
+			(*observer)(p.name, time.Since(start), err)
+		}
+	}
+}
+
+// SetStatementObserver sets the observer notified of the executions of prepared statements, nil removes it.
+func (s *PostgresStore) SetStatementObserver(observer StatementObserver) {
+	if observer == nil {
+		s.observer.Store(nil)
+		return
+	}
+	s.observer.Store(&observer)
+}
+
+// Stats returns the statistics of the connection pool.
+func (s *PostgresStore) Stats() sql.DBStats {
+	return s.db.Stats()
+}
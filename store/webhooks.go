@@ -0,0 +1,323 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rezkam/TaxMan/model"
+)
+
+// CreateWebhookSubscription stores a new webhook subscription.
+func (s *PostgresStore) CreateWebhookSubscription(ctx context.Context, subscription model.WebhookSubscription) (model.WebhookSubscription, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("insertWebhookSubscription")
+	if err != nil {
+		return model.WebhookSubscription{}, err
+	}
+	row := stmt.QueryRowContext(ctx, subscription.URL, subscription.Municipality, subscription.Secret)
+	created, err := scanWebhookSubscription(row)
+	if err != nil {
+		return model.WebhookSubscription{}, fmt.Errorf("failed to execute stmtInsertWebhookSubscription: %w", err)
+	}
+	return created, nil
+}
+
+// GetWebhookSubscription retrieves a webhook subscription by its ID.
+// It returns model.ErrNotFound if the subscription does not exist.
+func (s *PostgresStore) GetWebhookSubscription(ctx context.Context, subscriptionID int64) (model.WebhookSubscription, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("selectWebhookSubscription")
+	if err != nil {
+		return model.WebhookSubscription{}, err
+	}
+	subscription, err := scanWebhookSubscription(stmt.QueryRowContext(ctx, subscriptionID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.WebhookSubscription{}, model.ErrNotFound
+		}
+		return model.WebhookSubscription{}, fmt.Errorf("failed to execute stmtSelectWebhookSubscription: %w", err)
+	}
+	return subscription, nil
+}
+
+// ListWebhookSubscriptions retrieves all webhook subscriptions, oldest first.
+func (s *PostgresStore) ListWebhookSubscriptions(ctx context.Context) ([]model.WebhookSubscription, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("selectWebhookSubscriptions")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute stmtSelectWebhookSubscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subscriptions []model.WebhookSubscription
+	for rows.Next() {
+		subscription, err := scanWebhookSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook subscription row: %w", err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read webhook subscriptions: %w", err)
+	}
+	return subscriptions, nil
+}
+
+// DeleteWebhookSubscription deletes a webhook subscription along with its deliveries and their logs.
+// It returns model.ErrNotFound if the subscription does not exist.
+func (s *PostgresStore) DeleteWebhookSubscription(ctx context.Context, subscriptionID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("deleteWebhookSubscription")
+	if err != nil {
+		return err
+	}
+	result, err := stmt.ExecContext(ctx, subscriptionID)
+	if err != nil {
+		return fmt.Errorf("failed to execute stmtDeleteWebhookSubscription: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to read deleted webhook subscriptions: %w", err)
+	}
+	if deleted == 0 {
+		return model.ErrNotFound
+	}
+	return nil
+}
+
+// FanOutTaxRecordChanges creates the deliveries of at most limit changes of the change log that were not fanned out yet
+// to the webhook subscriptions matching their municipality, and returns how many changes were fanned out.
+// The cursor of the change log is locked for the transaction, so that instances fan out each change once.
+func (s *PostgresStore) FanOutTaxRecordChanges(ctx context.Context, limit int) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, transactionTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	cursorStmt, err := s.txStatement(ctx, tx, "selectWebhookCursorForUpdate")
+	if err != nil {
+		return 0, err
+	}
+	var lastChangeID int64
+	if err := cursorStmt.QueryRowContext(ctx).Scan(&lastChangeID); err != nil {
+		return 0, fmt.Errorf("failed to execute stmtSelectWebhookCursorForUpdate: %w", err)
+	}
+
+	nextStmt, err := s.txStatement(ctx, tx, "selectNextChangeID")
+	if err != nil {
+		return 0, err
+	}
+	var nextChangeID int64
+	if err := nextStmt.QueryRowContext(ctx, lastChangeID, limit).Scan(&nextChangeID); err != nil {
+		return 0, fmt.Errorf("failed to execute stmtSelectNextChangeID: %w", err)
+	}
+	if nextChangeID == lastChangeID {
+		return 0, nil
+	}
+
+	fanOutStmt, err := s.txStatement(ctx, tx, "fanOutTaxRecordChanges")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := fanOutStmt.ExecContext(ctx, lastChangeID, nextChangeID); err != nil {
+		return 0, fmt.Errorf("failed to execute stmtFanOutTaxRecordChanges: %w", err)
+	}
+
+	updateStmt, err := s.txStatement(ctx, tx, "updateWebhookCursor")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := updateStmt.ExecContext(ctx, nextChangeID); err != nil {
+		return 0, fmt.Errorf("failed to execute stmtUpdateWebhookCursor: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nextChangeID - lastChangeID, nil
+}
+
+// ClaimWebhookDeliveries claims at most limit pending deliveries that are due, oldest first.
+// Claimed deliveries are postponed by lease, so that they are attempted again if their result is never recorded.
+func (s *PostgresStore) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]model.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("claimWebhookDeliveries")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := stmt.QueryContext(ctx, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute stmtClaimWebhookDeliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []model.WebhookDelivery
+	for rows.Next() {
+		var delivery model.WebhookDelivery
+		var period string
+		subscription := &delivery.Subscription
+		change := &delivery.Change
+		if err := rows.Scan(&delivery.ID, &delivery.Status, &delivery.Attempts, &delivery.NextAttemptAt, &delivery.LastError,
+			&delivery.CreatedAt, &delivery.UpdatedAt,
+			&subscription.ID, &subscription.URL, &subscription.Municipality, &subscription.Secret, &subscription.CreatedAt,
+			&change.ID, &change.Action, &change.Record.ID, &change.Record.Municipality, &change.Record.TaxRate, &period,
+			&change.Record.PeriodType, &change.ChangedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery row: %w", err)
+		}
+		change.Record.StartDate, change.Record.EndDate, err = unmarshalDateRange(period)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse period date range: %w", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// RecordWebhookDeliveryAttempt logs an attempt of a delivery and moves the delivery to status.
+// A pending delivery is attempted again at nextAttemptAt, a dead delivery is added to the dead letters.
+// The delivery must still be pending with the attempts preceding attempt, otherwise its claim was lost
+// to another dispatcher or it was removed, and model.ErrConflict is returned without logging the attempt.
+func (s *PostgresStore) RecordWebhookDeliveryAttempt(ctx context.Context, attempt model.WebhookDeliveryAttempt,
+	status model.WebhookDeliveryStatus, nextAttemptAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, transactionTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	updateStmt, err := s.txStatement(ctx, tx, "updateWebhookDelivery")
+	if err != nil {
+		return err
+	}
+	var subscriptionID, changeID int64
+	err = updateStmt.QueryRowContext(ctx, attempt.DeliveryID, status, attempt.Attempt, nextAttemptAt, attempt.Error).
+		Scan(&subscriptionID, &changeID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.ErrConflict
+		}
+		return fmt.Errorf("failed to execute stmtUpdateWebhookDelivery: %w", err)
+	}
+
+	attemptStmt, err := s.txStatement(ctx, tx, "insertWebhookDeliveryAttempt")
+	if err != nil {
+		return err
+	}
+	_, err = attemptStmt.ExecContext(ctx, attempt.DeliveryID, attempt.Attempt, attempt.StatusCode, attempt.Error,
+		attempt.Duration.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("failed to execute stmtInsertWebhookDeliveryAttempt: %w", err)
+	}
+
+	if status == model.WebhookDeliveryDead {
+		deadLetterStmt, err := s.txStatement(ctx, tx, "insertWebhookDeadLetter")
+		if err != nil {
+			return err
+		}
+		_, err = deadLetterStmt.ExecContext(ctx, attempt.DeliveryID, subscriptionID, changeID, attempt.Attempt, attempt.Error)
+		if err != nil {
+			return fmt.Errorf("failed to execute stmtInsertWebhookDeadLetter: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ListWebhookDeliveryAttempts retrieves the latest limit delivery attempts of a subscription, newest first.
+func (s *PostgresStore) ListWebhookDeliveryAttempts(ctx context.Context, subscriptionID int64, limit int) ([]model.WebhookDeliveryAttempt, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("selectWebhookDeliveryAttempts")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := stmt.QueryContext(ctx, subscriptionID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute stmtSelectWebhookDeliveryAttempts: %w", err)
+	}
+	defer rows.Close()
+
+	var attempts []model.WebhookDeliveryAttempt
+	for rows.Next() {
+		var attempt model.WebhookDeliveryAttempt
+		var durationMillis int64
+		if err := rows.Scan(&attempt.ID, &attempt.DeliveryID, &attempt.Attempt, &attempt.StatusCode, &attempt.Error,
+			&durationMillis, &attempt.AttemptedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery attempt row: %w", err)
+		}
+		attempt.Duration = time.Duration(durationMillis) * time.Millisecond
+		attempts = append(attempts, attempt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read webhook delivery attempts: %w", err)
+	}
+	return attempts, nil
+}
+
+// ListWebhookDeadLetters retrieves the dead letters of a subscription, oldest first.
+func (s *PostgresStore) ListWebhookDeadLetters(ctx context.Context, subscriptionID int64) ([]model.WebhookDeadLetter, error) {
+	ctx, cancel := context.WithTimeout(ctx, statementTimeout)
+	defer cancel()
+
+	stmt, err := s.statement("selectWebhookDeadLetters")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := stmt.QueryContext(ctx, subscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute stmtSelectWebhookDeadLetters: %w", err)
+	}
+	defer rows.Close()
+
+	var deadLetters []model.WebhookDeadLetter
+	for rows.Next() {
+		var deadLetter model.WebhookDeadLetter
+		if err := rows.Scan(&deadLetter.ID, &deadLetter.DeliveryID, &deadLetter.SubscriptionID, &deadLetter.ChangeID,
+			&deadLetter.Attempts, &deadLetter.LastError, &deadLetter.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook dead letter row: %w", err)
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read webhook dead letters: %w", err)
+	}
+	return deadLetters, nil
+}
+
+// scanWebhookSubscription scans a row selected with sqlWebhookSubscriptionColumns.
+func scanWebhookSubscription(row rowScanner) (model.WebhookSubscription, error) {
+	var subscription model.WebhookSubscription
+	err := row.Scan(&subscription.ID, &subscription.URL, &subscription.Municipality, &subscription.Secret, &subscription.CreatedAt)
+	return subscription, err
+}
@@ -0,0 +1,187 @@
+package taxservice
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/rezkam/TaxMan/model"
+)
+
+// CacheStats reports the usage of the tax rate lookup cache.
+type CacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+}
+
+// rateCacheKey identifies the tax records of a municipality that apply on a date,
+// or the latest change of the records of the municipality when lastChange is set.
+type rateCacheKey struct {
+	municipality string
+	date         time.Time
+	lastChange   bool
+}
+
+// municipalityChange is the latest change of the records of a municipality, which validates the lookups of its rates.
+type municipalityChange struct {
+	id           int64
+	lastModified time.Time
+}
+
+type rateCacheEntry struct {
+	key        rateCacheKey
+	records    []model.TaxRecord
+	lastChange municipalityChange
+	expiresAt  time.Time
+}
+
+// rateCache is a bounded LRU cache of the tax records looked up per municipality and date, with a TTL.
+// It also holds the latest change of each municipality, next to its lookups.
+// Entries are indexed per municipality so that a write can invalidate all lookups of its municipality.
+type rateCache struct {
+	mu             sync.Mutex
+	capacity       int
+	ttl            time.Duration
+	now            func() time.Time
+	lru            *list.List
+	entries        map[rateCacheKey]*list.Element
+	byMunicipality map[string]map[rateCacheKey]struct{}
+	// generation is incremented by every invalidation, so that lookups racing with a write are not cached.
+	generation uint64
+	hits       uint64
+	misses     uint64
+}
+
+func newRateCache(capacity int, ttl time.Duration) *rateCache {
+	return &rateCache{
+		capacity:       capacity,
+		ttl:            ttl,
+		now:            time.Now,
+		lru:            list.New(),
+		entries:        make(map[rateCacheKey]*list.Element),
+		byMunicipality: make(map[string]map[rateCacheKey]struct{}),
+	}
+}
+
+// get returns the cached records for key, and the generation to pass to put on a miss.
+func (c *rateCache) get(key rateCacheKey) ([]model.TaxRecord, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.lookup(key)
+	if !ok {
+		c.misses++
+		return nil, c.generation, false
+	}
+	c.hits++
+	return entry.records, c.generation, true
+}
+
+// put caches the records for key, unless the cache was invalidated since generation was returned by get.
+func (c *rateCache) put(key rateCacheKey, records []model.TaxRecord, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.store(&rateCacheEntry{key: key, records: records}, generation)
+}
+
+// getLastChange returns the cached latest change of a municipality, and the generation to pass to putLastChange
+// on a miss. It does not count in the stats, which only report the lookups of records.
+func (c *rateCache) getLastChange(municipality string) (municipalityChange, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.lookup(rateCacheKey{municipality: municipality, lastChange: true})
+	if !ok {
+		return municipalityChange{}, c.generation, false
+	}
+	return entry.lastChange, c.generation, true
+}
+
+// putLastChange caches the latest change of a municipality, unless the cache was invalidated since generation
+// was returned by getLastChange.
+func (c *rateCache) putLastChange(municipality string, change municipalityChange, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.store(&rateCacheEntry{key: rateCacheKey{municipality: municipality, lastChange: true}, lastChange: change}, generation)
+}
+
+// lookup returns the unexpired entry of key and marks it as recently used. The caller must hold mu.
+func (c *rateCache) lookup(key rateCacheKey) (*rateCacheEntry, bool) {
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*rateCacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(element)
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	return entry, true
+}
+
+// store caches entry, unless the cache was invalidated since generation. The caller must hold mu.
+func (c *rateCache) store(entry *rateCacheEntry, generation uint64) {
+	key := entry.key
+	if generation != c.generation {
+		return
+	}
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	for c.lru.Len() >= c.capacity {
+		c.remove(c.lru.Back())
+	}
+
+	entry.expiresAt = c.now().Add(c.ttl)
+	element := c.lru.PushFront(entry)
+	c.entries[key] = element
+	keys, ok := c.byMunicipality[key.municipality]
+	if !ok {
+		keys = make(map[rateCacheKey]struct{})
+		c.byMunicipality[key.municipality] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+// invalidate removes the cached lookups of a municipality.
+func (c *rateCache) invalidate(municipality string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for key := range c.byMunicipality[municipality] {
+		c.remove(c.entries[key])
+	}
+}
+
+// invalidateAll removes every cached lookup.
+func (c *rateCache) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.lru.Init()
+	clear(c.entries)
+	clear(c.byMunicipality)
+}
+
+func (c *rateCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Hits: c.hits, Misses: c.misses, Entries: c.lru.Len()}
+}
+
+// remove drops an element from the LRU list and both indexes. The caller must hold mu.
+func (c *rateCache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*rateCacheEntry)
+	delete(c.entries, entry.key)
+	keys := c.byMunicipality[entry.key.municipality]
+	delete(keys, entry.key)
+	if len(keys) == 0 {
+		delete(c.byMunicipality, entry.key.municipality)
+	}
+}
@@ -0,0 +1,127 @@
+package taxservice
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rezkam/TaxMan/internal/utils"
+	"github.com/rezkam/TaxMan/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateCache(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	newCache := func(capacity int) *rateCache {
+		cache := newRateCache(capacity, time.Minute)
+		cache.now = func() time.Time { return now }
+		return cache
+	}
+	copenhagen := rateCacheKey{municipality: "Copenhagen", date: utils.DateOnly(2024, time.January, 1)}
+	copenhagenLater := rateCacheKey{municipality: "Copenhagen", date: utils.DateOnly(2024, time.July, 1)}
+	aarhus := rateCacheKey{municipality: "Aarhus", date: utils.DateOnly(2024, time.January, 1)}
+	records := []model.TaxRecord{{ID: 1, TaxRate: 0.2}}
+
+	t.Run("hit and miss", func(t *testing.T) {
+		cache := newCache(10)
+
+		_, generation, ok := cache.get(copenhagen)
+		require.False(t, ok)
+		cache.put(copenhagen, records, generation)
+
+		cached, _, ok := cache.get(copenhagen)
+		require.True(t, ok)
+		require.Equal(t, records, cached)
+		require.Equal(t, CacheStats{Hits: 1, Misses: 1, Entries: 1}, cache.stats())
+	})
+
+	t.Run("expired entry", func(t *testing.T) {
+		cache := newCache(10)
+		_, generation, _ := cache.get(copenhagen)
+		cache.put(copenhagen, records, generation)
+
+		now = now.Add(time.Minute)
+		_, _, ok := cache.get(copenhagen)
+		require.False(t, ok)
+		require.Zero(t, cache.stats().Entries)
+	})
+
+	t.Run("least recently used entry is evicted", func(t *testing.T) {
+		cache := newCache(2)
+		_, generation, _ := cache.get(copenhagen)
+		cache.put(copenhagen, records, generation)
+		cache.put(aarhus, records, generation)
+
+		// Touch copenhagen so that aarhus becomes the least recently used entry
+		_, _, ok := cache.get(copenhagen)
+		require.True(t, ok)
+		cache.put(copenhagenLater, records, generation)
+
+		_, _, ok = cache.get(aarhus)
+		require.False(t, ok)
+		_, _, ok = cache.get(copenhagen)
+		require.True(t, ok)
+		require.Equal(t, 2, cache.stats().Entries)
+	})
+
+	t.Run("invalidate a municipality", func(t *testing.T) {
+		cache := newCache(10)
+		_, generation, _ := cache.get(copenhagen)
+		cache.put(copenhagen, records, generation)
+		cache.put(copenhagenLater, records, generation)
+		cache.put(aarhus, records, generation)
+
+		cache.invalidate("Copenhagen")
+
+		_, _, ok := cache.get(copenhagen)
+		require.False(t, ok)
+		_, _, ok = cache.get(copenhagenLater)
+		require.False(t, ok)
+		_, _, ok = cache.get(aarhus)
+		require.True(t, ok)
+	})
+
+	t.Run("invalidate all", func(t *testing.T) {
+		cache := newCache(10)
+		_, generation, _ := cache.get(copenhagen)
+		cache.put(copenhagen, records, generation)
+		cache.put(aarhus, records, generation)
+
+		cache.invalidateAll()
+
+		_, _, ok := cache.get(copenhagen)
+		require.False(t, ok)
+		_, _, ok = cache.get(aarhus)
+		require.False(t, ok)
+		require.Zero(t, cache.stats().Entries)
+	})
+
+	t.Run("latest change of a municipality", func(t *testing.T) {
+		cache := newCache(10)
+		change := municipalityChange{id: 7, lastModified: now}
+		_, generation, ok := cache.getLastChange("Copenhagen")
+		require.False(t, ok)
+		cache.putLastChange("Copenhagen", change, generation)
+		cache.put(copenhagen, records, generation)
+
+		cached, _, ok := cache.getLastChange("Copenhagen")
+		require.True(t, ok)
+		require.Equal(t, change, cached)
+		_, _, ok = cache.get(copenhagen)
+		require.True(t, ok)
+
+		cache.invalidate("Copenhagen")
+		_, _, ok = cache.getLastChange("Copenhagen")
+		require.False(t, ok)
+		require.Zero(t, cache.stats().Entries)
+	})
+
+	t.Run("lookup racing with a write is not cached", func(t *testing.T) {
+		cache := newCache(10)
+		_, generation, _ := cache.get(copenhagen)
+		cache.invalidate("Copenhagen")
+		cache.put(copenhagen, records, generation)
+
+		_, _, ok := cache.get(copenhagen)
+		require.False(t, ok)
+	})
+}
@@ -0,0 +1,116 @@
+package taxservice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/rezkam/TaxMan/internal/problem"
+)
+
+const (
+	// defaultChangeStreamPollInterval is how often change streams check for new changes by default.
+	defaultChangeStreamPollInterval = time.Second
+	// changeStreamBatchSize is the maximum number of changes read from the change log at once.
+	changeStreamBatchSize = 100
+	// changeStreamHeartbeatInterval is how often an idle change stream sends a comment to keep proxies from closing it.
+	changeStreamHeartbeatInterval = 15 * time.Second
+
+	lastEventIDHeader = "Last-Event-ID"
+	// lastEventIDQueryParam resumes a change stream for clients that cannot set the Last-Event-ID header.
+	lastEventIDQueryParam = "last_event_id"
+)
+
+// CloseChangeStreams ends the open change streams and the ones opened afterwards once they sent the pending changes.
+// Streams only end with their request otherwise, so it is called when the server shuts down not to wait for them.
+func (tx *Service) CloseChangeStreams() {
+	tx.closeStreamsOnce.Do(func() {
+		close(tx.streamsClosed)
+	})
+}
+
+// StreamTaxRecordChangesHandler streams the changes of the tax records as Server-Sent Events.
+// Each event carries the change log ID as its id and the action as its type, so that clients
+// resume after the last change they received through the Last-Event-ID header.
+func (tx *Service) StreamTaxRecordChangesHandler(w http.ResponseWriter, r *http.Request) {
+	lastEventID := r.Header.Get(lastEventIDHeader)
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get(lastEventIDQueryParam)
+	}
+	afterID, err := tx.LastEventIDRequestToModel(lastEventID)
+	if err != nil {
+		problem.WriteInvalid(w, r, err)
+		return
+	}
+
+	// The stream outlives the server's write timeout, which only suits regular requests
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		slog.ErrorContext(r.Context(), "failed to clear change stream write deadline", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set(cacheControlHeader, "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		slog.ErrorContext(r.Context(), "change stream cannot be flushed", "error", err)
+		return
+	}
+
+	ctx := r.Context()
+	poll := time.NewTicker(tx.config.ChangeStreamPollInterval)
+	defer poll.Stop()
+	lastWrite := time.Now()
+
+	for {
+		changes, err := tx.store.GetTaxRecordChanges(ctx, afterID, changeStreamBatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.ErrorContext(ctx, "failed to get tax record changes", "error", err)
+			}
+			return
+		}
+		for _, change := range changes {
+			data, err := json.Marshal(TaxRecordChangeToResponse(change))
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to encode tax record change", "error", err)
+				return
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", change.ID, change.Action, data); err != nil {
+				return
+			}
+			afterID = change.ID
+		}
+		if len(changes) > 0 {
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			lastWrite = time.Now()
+		}
+		// Read the next batch right away while the change log has more to catch up on
+		if len(changes) == changeStreamBatchSize {
+			continue
+		}
+
+		select {
+		case <-poll.C:
+		case <-ctx.Done():
+			return
+		case <-tx.streamsClosed:
+			return
+		}
+
+		if time.Since(lastWrite) >= changeStreamHeartbeatInterval {
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			lastWrite = time.Now()
+		}
+	}
+}
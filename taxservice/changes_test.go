@@ -0,0 +1,145 @@
+package taxservice
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rezkam/TaxMan/internal/utils"
+	"github.com/rezkam/TaxMan/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamTaxRecordChangesHandler(t *testing.T) {
+	config := Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+		ChangeStreamPollInterval:  time.Millisecond,
+	}
+
+	t.Run("resumes after the last event id", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var afterIDs []int64
+		mockStore := &mockStore{
+			getTaxRecordChangesFunc: func(ctx context.Context, afterID int64, limit int) ([]model.TaxRecordChange, error) {
+				afterIDs = append(afterIDs, afterID)
+				if afterID != 7 {
+					// Stop the stream once the changes were sent
+					cancel()
+					return nil, nil
+				}
+				return []model.TaxRecordChange{
+					{
+						ID:     8,
+						Action: model.ActionCreate,
+						Record: model.TaxRecord{
+							ID:           3,
+							Municipality: "Copenhagen",
+							TaxRate:      0.2,
+							StartDate:    utils.DateOnly(2024, time.January, 1),
+							EndDate:      utils.DateOnly(2024, time.December, 31),
+							PeriodType:   model.Yearly,
+						},
+						ChangedAt: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+					},
+					{
+						ID:     9,
+						Action: model.ActionDelete,
+						Record: model.TaxRecord{
+							ID:           3,
+							Municipality: "Copenhagen",
+							TaxRate:      0.2,
+							StartDate:    utils.DateOnly(2024, time.January, 1),
+							PeriodType:   model.Yearly,
+						},
+						ChangedAt: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC),
+					},
+				}, nil
+			},
+		}
+		svc, err := New(mockStore, config)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+		req.Header.Set("Last-Event-ID", "7")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(svc.StreamTaxRecordChangesHandler)
+		handler.ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+		require.Equal(t, []int64{7, 9}, afterIDs)
+		require.Equal(t, "id: 8\nevent: create\n"+
+			`data: {"id":8,"action":"create","record_id":3,"municipality":"Copenhagen","tax_rate":0.2,"start_date":"2024-01-01","end_date":"2024-12-31","period_type":"yearly","changed_at":"2024-01-01T10:00:00Z"}`+"\n\n"+
+			"id: 9\nevent: delete\n"+
+			`data: {"id":9,"action":"delete","record_id":3,"municipality":"Copenhagen","tax_rate":0.2,"start_date":"2024-01-01","period_type":"yearly","changed_at":"2024-01-02T10:00:00Z"}`+"\n\n",
+			rr.Body.String())
+	})
+
+	t.Run("ends when change streams are closed", func(t *testing.T) {
+		svc, err := New(&mockStore{}, config)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rr := httptest.NewRecorder()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			http.HandlerFunc(svc.StreamTaxRecordChangesHandler).ServeHTTP(rr, req)
+		}()
+		svc.CloseChangeStreams()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("change stream did not end after it was closed")
+		}
+		require.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("last event id from the query", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		mockStore := &mockStore{
+			getTaxRecordChangesFunc: func(ctx context.Context, afterID int64, limit int) ([]model.TaxRecordChange, error) {
+				require.Equal(t, int64(42), afterID)
+				cancel()
+				return nil, nil
+			},
+		}
+		svc, err := New(mockStore, config)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/?last_event_id=42", nil).WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(svc.StreamTaxRecordChangesHandler)
+		handler.ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Empty(t, rr.Body.String())
+	})
+
+	t.Run("invalid last event id", func(t *testing.T) {
+		svc, err := New(&mockStore{}, config)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Last-Event-ID", "abc")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(svc.StreamTaxRecordChangesHandler)
+		handler.ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
@@ -1,32 +1,80 @@
 package taxservice
 
 import (
-	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 	"unicode/utf8"
 
+	"github.com/rezkam/TaxMan/internal/problem"
 	"github.com/rezkam/TaxMan/model"
 )
 
 // validateMunicipality checks common municipality validation rules.
 func validateMunicipality(municipality string, maxLength int) error {
 	if municipality == "" {
-		return errors.New("municipality is required")
+		return problem.Invalid("municipality", problem.CodeMunicipalityRequired, "municipality is required")
 	}
 	if utf8.RuneCountInString(municipality) > maxLength {
-		return errors.New("municipality name exceeds maximum length")
+		return problem.Invalid("municipality", problem.CodeMunicipalityTooLong, "municipality name exceeds maximum length")
 	}
 	return nil
 }
 
-// validateDate parses and validates the date string.
-func validateDate(dateStr, fieldName string) (time.Time, error) {
+// reservedMunicipalities are the names taken by the routes of tax records and drafts, such as GET /tax/records/{id},
+// a municipality with one of them could not be looked up through GET /tax/{municipality}/{date}.
+var reservedMunicipalities = []string{"records", "drafts"}
+
+// validateMunicipalityNotReserved checks that the municipality does not collide with the routes of tax records.
+func validateMunicipalityNotReserved(municipality string) error {
+	for _, reserved := range reservedMunicipalities {
+		if municipality == reserved {
+			return problem.Invalid("municipality", problem.CodeMunicipalityReserved, "municipality name is reserved")
+		}
+	}
+	return nil
+}
+
+// taxRateDecimals is the number of decimal places of tax rates. Rates are stored as binary floating point,
+// which holds every rate of at most taxRateDecimals decimal places closely enough to restore its decimal exactly.
+const taxRateDecimals = 6
+
+// taxRateScale scales tax rates to the integer number of their smallest decimal unit.
+const taxRateScale = 1e6
+
+// validateTaxRate checks that the tax rate is a fraction of at most taxRateDecimals decimal places.
+func validateTaxRate(taxRate float64) error {
+	if taxRate < 0.0 || taxRate > 1.0 {
+		return problem.Invalid("tax_rate", problem.CodeInvalidTaxRate, "tax rate must be between 0.0 and 1.0")
+	}
+	if scaled := taxRate * taxRateScale; math.Abs(scaled-math.Round(scaled)) > 1e-6 {
+		return problem.Invalid("tax_rate", problem.CodeInvalidTaxRate,
+			fmt.Sprintf("tax rate must have at most %d decimal places", taxRateDecimals))
+	}
+	return nil
+}
+
+// formatTaxRate formats a tax rate as the exact decimal of at most taxRateDecimals decimal places it stands for.
+// The rate is scaled to an integer, so that the decimal does not depend on its binary floating point representation.
+func formatTaxRate(taxRate float64) string {
+	scaled := int64(math.Round(taxRate * taxRateScale))
+	fraction := strings.TrimRight(fmt.Sprintf("%0*d", taxRateDecimals, scaled%taxRateScale), "0")
+	if fraction == "" {
+		return strconv.FormatInt(scaled/taxRateScale, 10)
+	}
+	return strconv.FormatInt(scaled/taxRateScale, 10) + "." + fraction
+}
+
+// validateDate parses and validates the date string of field, fieldName names the field in the error messages.
+func validateDate(dateStr, field, fieldName string) (time.Time, error) {
 	if dateStr == "" {
-		return time.Time{}, errors.New(fieldName + " is required")
+		return time.Time{}, problem.Invalid(field, problem.CodeDateRequired, fieldName+" is required")
 	}
 	date, err := time.Parse("2006-01-02", dateStr)
 	if err != nil {
-		return time.Time{}, errors.New("invalid " + fieldName + " format")
+		return time.Time{}, problem.Invalid(field, problem.CodeInvalidDate, "invalid "+fieldName+" format")
 	}
 	return date, nil
 }
@@ -38,27 +86,27 @@ func validatePeriodType(periodType model.PeriodType) error {
 			return nil
 		}
 	}
-	return errors.New("invalid period type")
+	return problem.Invalid("period_type", problem.CodeInvalidPeriodType, "invalid period type")
 }
 
 // AddOrUpdateTaxRecordRequestToModel converts and validates the request for adding or updating a tax record.
 // Assumption: Tax rates are expressed as decimal values representing percentages (e.g., 0.1 for 10%).
+// Every field is validated, the returned error holds the failures of all invalid fields.
 func (tx *Service) AddOrUpdateTaxRecordRequestToModel(req AddOrUpdateTaxRecordRequest) (model.TaxRecord, error) {
-	if err := validateMunicipality(req.Municipality, tx.config.MaxMunicipalityNameLength); err != nil {
-		return model.TaxRecord{}, err
-	}
-	if req.TaxRate < 0.0 || req.TaxRate > 1.0 {
-		return model.TaxRecord{}, errors.New("tax rate must be between 0.0 and 1.0")
+	var errs problem.FieldErrors
+	errs.Add(validateMunicipality(req.Municipality, tx.config.MaxMunicipalityNameLength))
+	errs.Add(validateMunicipalityNotReserved(req.Municipality))
+	errs.Add(validateTaxRate(req.TaxRate))
+	startDate, err := validateDate(req.StartDate, "start_date", "start date")
+	errs.Add(err)
+	// An omitted end date makes the record open-ended
+	var endDate time.Time
+	if req.EndDate != "" {
+		endDate, err = validateDate(req.EndDate, "end_date", "end date")
+		errs.Add(err)
 	}
-	startDate, err := validateDate(req.StartDate, "start date")
-	if err != nil {
-		return model.TaxRecord{}, err
-	}
-	endDate, err := validateDate(req.EndDate, "end date")
-	if err != nil {
-		return model.TaxRecord{}, err
-	}
-	if err := validatePeriodType(req.PeriodType); err != nil {
+	errs.Add(validatePeriodType(req.PeriodType))
+	if err := errs.Err(); err != nil {
 		return model.TaxRecord{}, err
 	}
 
@@ -73,11 +121,12 @@ func (tx *Service) AddOrUpdateTaxRecordRequestToModel(req AddOrUpdateTaxRecordRe
 }
 
 // GetTaxRateRequestToModel converts and validates the request for retrieving the tax rate.
-func (tx *Service) GetTaxRateRequestToModel(municipality, date string) (model.TaxQuery, error) {
+// asOf is optional, when set it must be an RFC 3339 timestamp.
+func (tx *Service) GetTaxRateRequestToModel(municipality, date, asOf string) (model.TaxQuery, error) {
 	if err := validateMunicipality(municipality, tx.config.MaxMunicipalityNameLength); err != nil {
 		return model.TaxQuery{}, err
 	}
-	parsedDate, err := validateDate(date, "date")
+	parsedDate, err := validateDate(date, "date", "date")
 	if err != nil {
 		return model.TaxQuery{}, err
 	}
@@ -86,5 +135,230 @@ func (tx *Service) GetTaxRateRequestToModel(municipality, date string) (model.Ta
 		Municipality: municipality,
 		Date:         parsedDate,
 	}
+	if asOf != "" {
+		taxQuery.AsOf, err = time.Parse(time.RFC3339, asOf)
+		if err != nil {
+			return model.TaxQuery{}, problem.Invalid("as_of", problem.CodeInvalidAsOf, "invalid as_of format")
+		}
+	}
 	return taxQuery, nil
 }
+
+// validateID parses and validates a positive numeric ID taken from the URL.
+func validateID(id, fieldName string) (int64, error) {
+	if id == "" {
+		return 0, problem.Invalid("id", problem.CodeInvalidID, fieldName+" is required")
+	}
+	parsedID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || parsedID <= 0 {
+		return 0, problem.Invalid("id", problem.CodeInvalidID, "invalid "+fieldName)
+	}
+	return parsedID, nil
+}
+
+// RecordIDRequestToModel parses and validates the ID of a tax record taken from the URL.
+func (tx *Service) RecordIDRequestToModel(id string) (int64, error) {
+	return validateID(id, "record id")
+}
+
+// DraftIDRequestToModel parses and validates the ID of a tax record draft taken from the URL.
+func (tx *Service) DraftIDRequestToModel(id string) (int64, error) {
+	return validateID(id, "draft id")
+}
+
+// UpdateTaxRecordRequestToModel validates the request for changing the tax rate of a tax record.
+func (tx *Service) UpdateTaxRecordRequestToModel(req UpdateTaxRecordRequest) (float64, error) {
+	if err := validateTaxRate(req.TaxRate); err != nil {
+		return 0, err
+	}
+	return req.TaxRate, nil
+}
+
+// IfMatchRequestToModel parses the If-Match header of a request changing a tax record into the expected version.
+// The wildcard "*" matches any version and is returned as 0.
+func (tx *Service) IfMatchRequestToModel(ifMatch string) (int64, error) {
+	if ifMatch == "" {
+		return 0, ErrIfMatchRequired
+	}
+	if ifMatch == "*" {
+		return 0, nil
+	}
+	unquoted, err := strconv.Unquote(ifMatch)
+	if err != nil || !strings.HasPrefix(ifMatch, `"`) {
+		return 0, problem.Invalid(ifMatchHeader, problem.CodeInvalidIfMatch, "invalid If-Match header")
+	}
+	version, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, problem.Invalid(ifMatchHeader, problem.CodeInvalidIfMatch, "invalid If-Match header")
+	}
+	return version, nil
+}
+
+// TaxRecordETag formats the version of a tax record as a strong entity tag.
+func TaxRecordETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// TaxRecordToResponse converts a tax record to its response type.
+func TaxRecordToResponse(record model.TaxRecord) TaxRecordResponse {
+	resp := TaxRecordResponse{
+		ID:           record.ID,
+		Municipality: record.Municipality,
+		TaxRate:      record.TaxRate,
+		StartDate:    record.StartDate.Format("2006-01-02"),
+		PeriodType:   record.PeriodType,
+		Version:      record.Version,
+	}
+	if !record.IsOpenEnded() {
+		resp.EndDate = record.EndDate.Format("2006-01-02")
+	}
+	if record.IsDeleted() {
+		deletedAt := record.DeletedAt
+		resp.DeletedAt = &deletedAt
+	}
+	return resp
+}
+
+// defaultRateCategory is the category of rates falling back to the default tax rate.
+const defaultRateCategory = "default"
+
+// TaxRateToV2Response converts the tax rate of a municipality on a date to its v2 response.
+// The rate is formatted as the exact decimal it stands for, see formatTaxRate.
+func TaxRateToV2Response(municipality, date, asOf string, rate TaxRateResponse) GetTaxRateV2Response {
+	resp := GetTaxRateV2Response{
+		Municipality: municipality,
+		Date:         date,
+		TaxRate:      formatTaxRate(rate.TaxRate),
+		Category:     defaultRateCategory,
+		AsOf:         asOf,
+	}
+	if rate.Record != nil {
+		recordID := rate.Record.ID
+		resp.RecordID = &recordID
+		resp.Category = string(rate.Record.PeriodType)
+	}
+	return resp
+}
+
+// LastEventIDRequestToModel parses the ID of the last change a change stream client received, 0 if it is empty.
+func (tx *Service) LastEventIDRequestToModel(lastEventID string) (int64, error) {
+	if lastEventID == "" {
+		return 0, nil
+	}
+	parsedID, err := strconv.ParseInt(lastEventID, 10, 64)
+	if err != nil || parsedID < 0 {
+		return 0, problem.Invalid(lastEventIDHeader, problem.CodeInvalidLastEventID, "invalid last event id")
+	}
+	return parsedID, nil
+}
+
+// TaxRecordChangeToResponse converts a change of a tax record to its response type.
+func TaxRecordChangeToResponse(change model.TaxRecordChange) TaxRecordChangeResponse {
+	resp := TaxRecordChangeResponse{
+		ID:           change.ID,
+		Action:       change.Action,
+		RecordID:     change.Record.ID,
+		Municipality: change.Record.Municipality,
+		TaxRate:      change.Record.TaxRate,
+		StartDate:    change.Record.StartDate.Format("2006-01-02"),
+		PeriodType:   change.Record.PeriodType,
+		ChangedAt:    change.ChangedAt,
+	}
+	if !change.Record.IsOpenEnded() {
+		resp.EndDate = change.Record.EndDate.Format("2006-01-02")
+	}
+	return resp
+}
+
+// CreateTaxRecordDraftRequestToModel converts and validates the request for drafting a tax record change.
+// Every field is validated, the returned error holds the failures of all invalid fields.
+func (tx *Service) CreateTaxRecordDraftRequestToModel(req CreateTaxRecordDraftRequest) (model.TaxRecordDraft, error) {
+	var errs problem.FieldErrors
+	record, err := tx.AddOrUpdateTaxRecordRequestToModel(req.AddOrUpdateTaxRecordRequest)
+	errs.Add(err)
+
+	draft := model.TaxRecordDraft{Record: record}
+	if req.PublishAt != "" {
+		draft.PublishAt, err = time.Parse(time.RFC3339, req.PublishAt)
+		if err != nil {
+			errs.Add(problem.Invalid("publish_at", problem.CodeInvalidPublishAt, "invalid publish_at format"))
+		}
+	}
+	if err := errs.Err(); err != nil {
+		return model.TaxRecordDraft{}, err
+	}
+	return draft, nil
+}
+
+// DraftStatusRequestToModel validates the optional draft status used to filter drafts.
+func (tx *Service) DraftStatusRequestToModel(status string) (model.DraftStatus, error) {
+	if status == "" {
+		return "", nil
+	}
+	for _, validStatus := range model.ValidDraftStatuses {
+		if model.DraftStatus(status) == validStatus {
+			return validStatus, nil
+		}
+	}
+	return "", problem.Invalid("status", problem.CodeInvalidDraftStatus, "invalid draft status")
+}
+
+// TaxRecordHistoryToResponse converts the history of a tax record to its response type.
+func TaxRecordHistoryToResponse(recordID int64, history []model.TaxRecordHistoryEntry) GetTaxRecordHistoryResponse {
+	resp := GetTaxRecordHistoryResponse{
+		RecordID: recordID,
+		History:  make([]TaxRecordHistoryEntryResponse, 0, len(history)),
+	}
+	for _, entry := range history {
+		resp.History = append(resp.History, TaxRecordHistoryEntryResponse{
+			ID:        entry.ID,
+			Action:    entry.Action,
+			OldValue:  taxRecordStateToResponse(entry.Old),
+			NewValue:  taxRecordStateToResponse(entry.New),
+			Actor:     entry.Actor,
+			RequestID: entry.RequestID,
+			ChangedAt: entry.ChangedAt,
+		})
+	}
+	return resp
+}
+
+// taxRecordStateToResponse converts a tax record state to its response type, keeping nil states nil.
+func taxRecordStateToResponse(state *model.TaxRecordState) *TaxRecordStateResponse {
+	if state == nil {
+		return nil
+	}
+	resp := &TaxRecordStateResponse{
+		TaxRate:   state.TaxRate,
+		StartDate: state.StartDate.Format("2006-01-02"),
+	}
+	if !state.EndDate.IsZero() {
+		resp.EndDate = state.EndDate.Format("2006-01-02")
+	}
+	return resp
+}
+
+// TaxRecordDraftToResponse converts a tax record draft to its response type.
+func TaxRecordDraftToResponse(draft model.TaxRecordDraft) TaxRecordDraftResponse {
+	resp := TaxRecordDraftResponse{
+		ID:                draft.ID,
+		Municipality:      draft.Record.Municipality,
+		TaxRate:           draft.Record.TaxRate,
+		StartDate:         draft.Record.StartDate.Format("2006-01-02"),
+		PeriodType:        draft.Record.PeriodType,
+		Status:            draft.Status,
+		CreatedBy:         draft.CreatedBy,
+		ApprovedBy:        draft.ApprovedBy,
+		PublishedRecordID: draft.PublishedRecordID,
+		CreatedAt:         draft.CreatedAt,
+		UpdatedAt:         draft.UpdatedAt,
+	}
+	if !draft.Record.IsOpenEnded() {
+		resp.EndDate = draft.Record.EndDate.Format("2006-01-02")
+	}
+	if !draft.PublishAt.IsZero() {
+		publishAt := draft.PublishAt
+		resp.PublishAt = &publishAt
+	}
+	return resp
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/rezkam/TaxMan/internal/problem"
 	"github.com/rezkam/TaxMan/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -50,7 +51,7 @@ func TestValidateDate(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			date, err := validateDate(tt.dateStr, tt.fieldName)
+			date, err := validateDate(tt.dateStr, "start_date", tt.fieldName)
 			if tt.expectedErr != nil {
 				require.Error(t, err)
 				assert.Equal(t, tt.expectedErr.Error(), err.Error())
@@ -67,6 +68,7 @@ func TestAddOrUpdateTaxRecordRequestToModel(t *testing.T) {
 		MaxMunicipalityNameLength: 20,
 		MunicipalityURLPattern:    "municipality",
 		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
 	}
 	mockStore := &mockStore{}
 	svc, err := New(mockStore, config)
@@ -84,6 +86,12 @@ func TestAddOrUpdateTaxRecordRequestToModel(t *testing.T) {
 			expectedRecord: model.TaxRecord{},
 			expectedErr:    errors.New("municipality is required"),
 		},
+		{
+			name:           "Reserved Municipality",
+			request:        AddOrUpdateTaxRecordRequest{Municipality: "records", TaxRate: 0.1, StartDate: "2020-12-31", EndDate: "2021-12-31", PeriodType: model.Yearly},
+			expectedRecord: model.TaxRecord{},
+			expectedErr:    errors.New("municipality name is reserved"),
+		},
 		{
 			name:           "Negative Tax Rate",
 			request:        AddOrUpdateTaxRecordRequest{Municipality: "Valid Name", TaxRate: -0.1, StartDate: "2020-12-31", EndDate: "2021-12-31", PeriodType: model.Yearly},
@@ -96,6 +104,12 @@ func TestAddOrUpdateTaxRecordRequestToModel(t *testing.T) {
 			expectedRecord: model.TaxRecord{},
 			expectedErr:    errors.New("tax rate must be between 0.0 and 1.0"),
 		},
+		{
+			name:           "Tax Rate Too Precise",
+			request:        AddOrUpdateTaxRecordRequest{Municipality: "Valid Name", TaxRate: 0.1234567, StartDate: "2020-12-31", EndDate: "2021-12-31", PeriodType: model.Yearly},
+			expectedRecord: model.TaxRecord{},
+			expectedErr:    errors.New("tax rate must have at most 6 decimal places"),
+		},
 		{
 			name:           "Invalid Start Date",
 			request:        AddOrUpdateTaxRecordRequest{Municipality: "Valid Name", TaxRate: 0.1, StartDate: "invalid-date", EndDate: "2021-12-31", PeriodType: model.Yearly},
@@ -120,6 +134,12 @@ func TestAddOrUpdateTaxRecordRequestToModel(t *testing.T) {
 			expectedRecord: model.TaxRecord{Municipality: "Valid Name", TaxRate: 0.1, StartDate: time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC), PeriodType: model.Yearly},
 			expectedErr:    nil,
 		},
+		{
+			name:           "Open-ended Request",
+			request:        AddOrUpdateTaxRecordRequest{Municipality: "Valid Name", TaxRate: 0.1, StartDate: "2025-01-01", PeriodType: model.Yearly},
+			expectedRecord: model.TaxRecord{Municipality: "Valid Name", TaxRate: 0.1, StartDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), PeriodType: model.Yearly},
+			expectedErr:    nil,
+		},
 	}
 
 	for _, tt := range tests {
@@ -136,11 +156,38 @@ func TestAddOrUpdateTaxRecordRequestToModel(t *testing.T) {
 	}
 }
 
+func TestAddOrUpdateTaxRecordRequestToModelReportsAllFields(t *testing.T) {
+	svc, _ := New(nil, Config{
+		MaxMunicipalityNameLength: 10,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+	})
+
+	_, err := svc.AddOrUpdateTaxRecordRequestToModel(AddOrUpdateTaxRecordRequest{
+		Municipality: "A very long municipality name",
+		TaxRate:      -0.1,
+		StartDate:    "",
+		EndDate:      "2021-13-01",
+		PeriodType:   "hourly",
+	})
+	var fieldErrs problem.FieldErrors
+	require.ErrorAs(t, err, &fieldErrs)
+	assert.Equal(t, problem.FieldErrors{
+		{Field: "municipality", Code: problem.CodeMunicipalityTooLong, Detail: "municipality name exceeds maximum length"},
+		{Field: "tax_rate", Code: problem.CodeInvalidTaxRate, Detail: "tax rate must be between 0.0 and 1.0"},
+		{Field: "start_date", Code: problem.CodeDateRequired, Detail: "start date is required"},
+		{Field: "end_date", Code: problem.CodeInvalidDate, Detail: "invalid end date format"},
+		{Field: "period_type", Code: problem.CodeInvalidPeriodType, Detail: "invalid period type"},
+	}, fieldErrs)
+}
+
 func TestGetTaxRateRequestToModel(t *testing.T) {
 	config := Config{
 		MaxMunicipalityNameLength: 20,
 		MunicipalityURLPattern:    "municipality",
 		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
 	}
 	svc, _ := New(nil, config)
 
@@ -148,6 +195,7 @@ func TestGetTaxRateRequestToModel(t *testing.T) {
 		name          string
 		municipality  string
 		date          string
+		asOf          string
 		expectedQuery model.TaxQuery
 		expectedErr   error
 	}{
@@ -165,6 +213,14 @@ func TestGetTaxRateRequestToModel(t *testing.T) {
 			expectedQuery: model.TaxQuery{},
 			expectedErr:   errors.New("invalid date format"),
 		},
+		{
+			name:          "Invalid As Of",
+			municipality:  "Valid Name",
+			date:          "2020-12-31",
+			asOf:          "2021-01-01",
+			expectedQuery: model.TaxQuery{},
+			expectedErr:   errors.New("invalid as_of format"),
+		},
 		{
 			name:          "Valid Request",
 			municipality:  "Valid Name",
@@ -172,11 +228,19 @@ func TestGetTaxRateRequestToModel(t *testing.T) {
 			expectedQuery: model.TaxQuery{Municipality: "Valid Name", Date: time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC)},
 			expectedErr:   nil,
 		},
+		{
+			name:          "Valid Request As Of",
+			municipality:  "Valid Name",
+			date:          "2020-12-31",
+			asOf:          "2021-01-01T10:00:00Z",
+			expectedQuery: model.TaxQuery{Municipality: "Valid Name", Date: time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC), AsOf: time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)},
+			expectedErr:   nil,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			query, err := svc.GetTaxRateRequestToModel(tt.municipality, tt.date)
+			query, err := svc.GetTaxRateRequestToModel(tt.municipality, tt.date, tt.asOf)
 			if tt.expectedErr != nil {
 				require.Error(t, err)
 				assert.Equal(t, tt.expectedErr.Error(), err.Error())
@@ -187,3 +251,187 @@ func TestGetTaxRateRequestToModel(t *testing.T) {
 		})
 	}
 }
+
+func TestRecordIDRequestToModel(t *testing.T) {
+	svc, _ := New(nil, Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+	})
+
+	tests := []struct {
+		name        string
+		id          string
+		expectedID  int64
+		expectedErr error
+	}{
+		{"Empty ID", "", 0, errors.New("record id is required")},
+		{"Not A Number", "abc", 0, errors.New("invalid record id")},
+		{"Not Positive", "0", 0, errors.New("invalid record id")},
+		{"Valid ID", "42", 42, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := svc.RecordIDRequestToModel(tt.id)
+			if tt.expectedErr != nil {
+				require.Error(t, err)
+				assert.Equal(t, tt.expectedErr.Error(), err.Error())
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedID, id)
+			}
+		})
+	}
+}
+
+func TestIfMatchRequestToModel(t *testing.T) {
+	svc, _ := New(nil, Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+	})
+
+	tests := []struct {
+		name            string
+		ifMatch         string
+		expectedVersion int64
+		expectedErr     error
+	}{
+		{"Missing", "", 0, ErrIfMatchRequired},
+		{"Wildcard", "*", 0, nil},
+		{"Unquoted", "3", 0, errors.New("invalid If-Match header")},
+		{"Weak", `W/"3"`, 0, errors.New("invalid If-Match header")},
+		{"Not A Version", `"abc"`, 0, errors.New("invalid If-Match header")},
+		{"Valid", `"3"`, 3, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, err := svc.IfMatchRequestToModel(tt.ifMatch)
+			if tt.expectedErr != nil {
+				require.Error(t, err)
+				assert.Equal(t, tt.expectedErr.Error(), err.Error())
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedVersion, version)
+				assert.Equal(t, `"3"`, TaxRecordETag(3))
+			}
+		})
+	}
+}
+
+func TestCreateTaxRecordDraftRequestToModel(t *testing.T) {
+	svc, _ := New(nil, Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+	})
+	record := AddOrUpdateTaxRecordRequest{Municipality: "Valid Name", TaxRate: 0.1, StartDate: "2025-01-01", PeriodType: model.Yearly}
+
+	t.Run("Invalid Record", func(t *testing.T) {
+		invalid := record
+		invalid.TaxRate = 2
+		_, err := svc.CreateTaxRecordDraftRequestToModel(CreateTaxRecordDraftRequest{AddOrUpdateTaxRecordRequest: invalid})
+		require.Error(t, err)
+		assert.Equal(t, "tax rate must be between 0.0 and 1.0", err.Error())
+	})
+
+	t.Run("Invalid Publish At", func(t *testing.T) {
+		_, err := svc.CreateTaxRecordDraftRequestToModel(CreateTaxRecordDraftRequest{AddOrUpdateTaxRecordRequest: record, PublishAt: "tomorrow"})
+		require.Error(t, err)
+		assert.Equal(t, "invalid publish_at format", err.Error())
+	})
+
+	t.Run("Invalid Record And Publish At", func(t *testing.T) {
+		invalid := record
+		invalid.TaxRate = 2
+		_, err := svc.CreateTaxRecordDraftRequestToModel(CreateTaxRecordDraftRequest{AddOrUpdateTaxRecordRequest: invalid, PublishAt: "tomorrow"})
+		var fieldErrs problem.FieldErrors
+		require.ErrorAs(t, err, &fieldErrs)
+		assert.Equal(t, problem.FieldErrors{
+			{Field: "tax_rate", Code: problem.CodeInvalidTaxRate, Detail: "tax rate must be between 0.0 and 1.0"},
+			{Field: "publish_at", Code: problem.CodeInvalidPublishAt, Detail: "invalid publish_at format"},
+		}, fieldErrs)
+	})
+
+	t.Run("Valid Request", func(t *testing.T) {
+		draft, err := svc.CreateTaxRecordDraftRequestToModel(CreateTaxRecordDraftRequest{AddOrUpdateTaxRecordRequest: record, PublishAt: "2024-12-31T23:00:00Z"})
+		require.NoError(t, err)
+		assert.Equal(t, "Valid Name", draft.Record.Municipality)
+		assert.True(t, draft.Record.IsOpenEnded())
+		assert.Equal(t, time.Date(2024, 12, 31, 23, 0, 0, 0, time.UTC), draft.PublishAt)
+	})
+}
+
+func TestDraftStatusRequestToModel(t *testing.T) {
+	svc, _ := New(nil, Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+	})
+
+	tests := []struct {
+		name           string
+		status         string
+		expectedStatus model.DraftStatus
+		expectedErr    error
+	}{
+		{"Empty Status", "", "", nil},
+		{"Invalid Status", "rejected", "", errors.New("invalid draft status")},
+		{"Valid Status", "pending_approval", model.DraftStatusPendingApproval, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := svc.DraftStatusRequestToModel(tt.status)
+			if tt.expectedErr != nil {
+				require.Error(t, err)
+				assert.Equal(t, tt.expectedErr.Error(), err.Error())
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedStatus, status)
+			}
+		})
+	}
+}
+
+func TestTaxRateToV2Response(t *testing.T) {
+	record := model.TaxRecord{ID: 7, TaxRate: 0.15, PeriodType: model.Monthly}
+	recordID := int64(7)
+
+	assert.Equal(t, GetTaxRateV2Response{
+		Municipality: "Copenhagen",
+		Date:         "2024-01-01",
+		TaxRate:      "0.15",
+		Category:     "monthly",
+		RecordID:     &recordID,
+	}, TaxRateToV2Response("Copenhagen", "2024-01-01", "", TaxRateResponse{TaxRate: record.TaxRate, Record: &record}))
+
+	assert.Equal(t, GetTaxRateV2Response{
+		Municipality: "Copenhagen",
+		Date:         "2024-01-01",
+		TaxRate:      "0.5",
+		Category:     "default",
+		AsOf:         "2024-06-01T00:00:00Z",
+	}, TaxRateToV2Response("Copenhagen", "2024-01-01", "2024-06-01T00:00:00Z", TaxRateResponse{TaxRate: 0.5, IsDefaultRate: true}))
+}
+
+func TestFormatTaxRate(t *testing.T) {
+	testCases := map[float64]string{
+		0:          "0",
+		1:          "1",
+		0.25:       "0.25",
+		0.1 + 0.2:  "0.3",
+		0.123456:   "0.123456",
+		0.000001:   "0.000001",
+		0.07000001: "0.07",
+	}
+	for rate, expected := range testCases {
+		assert.Equal(t, expected, formatTaxRate(rate), "rate %v", rate)
+	}
+}
@@ -1,65 +1,447 @@
 package taxservice
 
 import (
-	"encoding/json"
 	"errors"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/rezkam/TaxMan/internal/jsonutils"
+	"github.com/rezkam/TaxMan/internal/problem"
 	"github.com/rezkam/TaxMan/model"
 )
 
+// asOfQueryParam is the query parameter used to evaluate a tax rate lookup against the records known at a past moment.
+const asOfQueryParam = "as_of"
+
+const (
+	etagHeader    = "ETag"
+	ifMatchHeader = "If-Match"
+)
+
 func (tx *Service) AddOrUpdateTaxRecordHandler(w http.ResponseWriter, r *http.Request) {
+	if tx.config.RequireApproval {
+		problem.Write(w, r, http.StatusForbidden, problem.CodeApprovalRequired, "tax records must be changed through approved drafts")
+		return
+	}
+
 	var req AddOrUpdateTaxRecordRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		jsonutils.JsonError(w, "invalid json input", http.StatusBadRequest)
+	if err := jsonutils.DecodeRequest(w, r, &req, tx.config.MaxRequestBodySize); err != nil {
+		problem.WriteDecodeError(w, r, err)
 		return
 	}
 
 	taxRecord, err := tx.AddOrUpdateTaxRecordRequestToModel(req)
 	if err != nil {
-		jsonutils.JsonError(w, err.Error(), http.StatusBadRequest)
+		problem.WriteInvalid(w, r, err)
 		return
 	}
+	if !permitMunicipality(w, r, taxRecord.Municipality) {
+		return
+	}
+
+	// Without If-Match the record is only created, so that concurrent writers cannot silently overwrite each other
+	expectedVersion := model.NoVersion
+	if ifMatch := r.Header.Get(ifMatchHeader); ifMatch != "" {
+		expectedVersion, err = tx.IfMatchRequestToModel(ifMatch)
+		if err != nil {
+			problem.WriteInvalid(w, r, err)
+			return
+		}
+	}
 
-	err = tx.store.AddOrUpdateTaxRecord(r.Context(), taxRecord)
+	storedRecord, err := tx.store.AddOrUpdateTaxRecord(r.Context(), taxRecord, expectedVersion)
 	if err != nil {
-		slog.Error("failed to add or update tax record", "error", err)
-		jsonutils.JsonError(w, "failed to add or update tax record", http.StatusInternalServerError)
+		// The ETag of the existing record lets the caller overwrite it once it has reviewed it
+		if storedRecord.ID != 0 {
+			w.Header().Set(etagHeader, TaxRecordETag(storedRecord.Version))
+		}
+		if errors.Is(err, model.ErrVersionRequired) {
+			problem.Write(w, r, http.StatusPreconditionRequired, problem.CodeIfMatchRequired,
+				"a tax record with the same municipality, period and period type exists, its ETag is required in If-Match to overwrite it")
+			return
+		}
+		writeRecordError(w, r, err, "tax record not found", "failed to add or update tax record")
 		return
 	}
 
-	resp := AddOrUpdateTaxRecordResponse{Success: true}
+	tx.InvalidateTaxRates(storedRecord.Municipality)
+
+	resp := AddOrUpdateTaxRecordResponse{Success: true, ID: storedRecord.ID, Version: storedRecord.Version}
+	w.Header().Set(etagHeader, TaxRecordETag(storedRecord.Version))
 	jsonutils.JsonResponse(w, resp, http.StatusOK)
 }
 
 func (tx *Service) GetTaxRateHandler(w http.ResponseWriter, r *http.Request) {
 	municipality := r.PathValue(tx.config.MunicipalityURLPattern)
 	date := r.PathValue(tx.config.DateURLPattern)
+	asOf := r.URL.Query().Get(asOfQueryParam)
 
-	taxQuery, err := tx.GetTaxRateRequestToModel(municipality, date)
-	if err != nil {
-		jsonutils.JsonError(w, err.Error(), http.StatusBadRequest)
+	taxRateResp, ok := tx.lookupTaxRate(w, r, municipality, date, asOf)
+	if !ok {
+		return
+	}
+
+	resp := GetTaxRateResponse{
+		Municipality:  municipality,
+		Date:          date,
+		TaxRate:       taxRateResp.TaxRate,
+		IsDefaultRate: taxRateResp.IsDefaultRate,
+		AsOf:          asOf,
+	}
+	jsonutils.JsonResponse(w, resp, http.StatusOK)
+}
+
+// GetTaxRateV2Handler looks up the tax rate like GetTaxRateHandler, answering with the rate as a decimal,
+// its category and the ID of the tax record it is taken from.
+func (tx *Service) GetTaxRateV2Handler(w http.ResponseWriter, r *http.Request) {
+	municipality := r.PathValue(tx.config.MunicipalityURLPattern)
+	date := r.PathValue(tx.config.DateURLPattern)
+	asOf := r.URL.Query().Get(asOfQueryParam)
+
+	taxRateResp, ok := tx.lookupTaxRate(w, r, municipality, date, asOf)
+	if !ok {
 		return
 	}
 
+	jsonutils.JsonResponse(w, TaxRateToV2Response(municipality, date, asOf, taxRateResp), http.StatusOK)
+}
+
+// lookupTaxRate looks up the tax rate of a municipality on a date and writes the cache validators of the lookup.
+// It answers the request itself and returns false when the lookup is invalid, fails or is not modified.
+func (tx *Service) lookupTaxRate(w http.ResponseWriter, r *http.Request, municipality, date, asOf string) (TaxRateResponse, bool) {
+	taxQuery, err := tx.GetTaxRateRequestToModel(municipality, date, asOf)
+	if err != nil {
+		problem.WriteInvalid(w, r, err)
+		return TaxRateResponse{}, false
+	}
+
+	// Rates only change with the records of the municipality, so its latest change validates cached lookups
+	changeID, lastModified, err := tx.getMunicipalityLastChange(r.Context(), taxQuery.Municipality)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get municipality last change", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternalError, "failed to get tax rate")
+		return TaxRateResponse{}, false
+	}
+	etag := municipalityETag(changeID)
+	cacheControl := tx.cacheControl(taxQuery.Date, time.Now())
+	if !noneMatch(r, etag) {
+		writeValidators(w, etag, lastModified, cacheControl)
+		w.WriteHeader(http.StatusNotModified)
+		return TaxRateResponse{}, false
+	}
+
 	taxRateResp, err := tx.GetTaxRate(r.Context(), taxQuery)
 	if err != nil {
 		if errors.Is(err, model.ErrNotFound) {
-			jsonutils.JsonError(w, "tax rate not found", http.StatusNotFound)
+			problem.Write(w, r, http.StatusNotFound, problem.CodeTaxRateNotFound, "tax rate not found")
+			return TaxRateResponse{}, false
+		}
+		slog.ErrorContext(r.Context(), "failed to get tax rate", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternalError, "failed to get tax rate")
+		return TaxRateResponse{}, false
+	}
+
+	writeValidators(w, etag, lastModified, cacheControl)
+	return taxRateResp, true
+}
+
+func (tx *Service) GetTaxRecordHandler(w http.ResponseWriter, r *http.Request) {
+	recordID, err := tx.RecordIDRequestToModel(r.PathValue(tx.config.RecordIDURLPattern))
+	if err != nil {
+		problem.WriteInvalid(w, r, err)
+		return
+	}
+
+	record, err := tx.store.GetTaxRecord(r.Context(), recordID)
+	if err != nil {
+		writeRecordError(w, r, err, "tax record not found", "failed to get tax record")
+		return
+	}
+
+	w.Header().Set(etagHeader, TaxRecordETag(record.Version))
+	jsonutils.JsonResponse(w, TaxRecordToResponse(record), http.StatusOK)
+}
+
+func (tx *Service) UpdateTaxRecordHandler(w http.ResponseWriter, r *http.Request) {
+	if tx.config.RequireApproval {
+		problem.Write(w, r, http.StatusForbidden, problem.CodeApprovalRequired, "tax records must be changed through approved drafts")
+		return
+	}
+
+	recordID, expectedVersion, ok := tx.recordPrecondition(w, r)
+	if !ok {
+		return
+	}
+	if !tx.permitRecord(w, r, recordID) {
+		return
+	}
+
+	var req UpdateTaxRecordRequest
+	if err := jsonutils.DecodeRequest(w, r, &req, tx.config.MaxRequestBodySize); err != nil {
+		problem.WriteDecodeError(w, r, err)
+		return
+	}
+
+	taxRate, err := tx.UpdateTaxRecordRequestToModel(req)
+	if err != nil {
+		problem.WriteInvalid(w, r, err)
+		return
+	}
+
+	record, err := tx.store.UpdateTaxRecordRate(r.Context(), recordID, taxRate, expectedVersion)
+	if err != nil {
+		writeRecordError(w, r, err, "tax record not found", "failed to update tax record")
+		return
+	}
+	tx.InvalidateTaxRates(record.Municipality)
+
+	w.Header().Set(etagHeader, TaxRecordETag(record.Version))
+	jsonutils.JsonResponse(w, TaxRecordToResponse(record), http.StatusOK)
+}
+
+func (tx *Service) DeleteTaxRecordHandler(w http.ResponseWriter, r *http.Request) {
+	if tx.config.RequireApproval {
+		problem.Write(w, r, http.StatusForbidden, problem.CodeApprovalRequired, "tax records cannot be deleted while changes require approval")
+		return
+	}
+	recordID, expectedVersion, ok := tx.recordPrecondition(w, r)
+	if !ok {
+		return
+	}
+	if !tx.permitRecord(w, r, recordID) {
+		return
+	}
+
+	record, err := tx.store.DeleteTaxRecord(r.Context(), recordID, expectedVersion)
+	if err != nil {
+		writeRecordError(w, r, err, "tax record not found", "failed to delete tax record")
+		return
+	}
+	tx.InvalidateTaxRates(record.Municipality)
+
+	resp := DeleteTaxRecordResponse{Success: true, ID: recordID, Version: record.Version}
+	w.Header().Set(etagHeader, TaxRecordETag(record.Version))
+	jsonutils.JsonResponse(w, resp, http.StatusOK)
+}
+
+func (tx *Service) RestoreTaxRecordHandler(w http.ResponseWriter, r *http.Request) {
+	if tx.config.RequireApproval {
+		problem.Write(w, r, http.StatusForbidden, problem.CodeApprovalRequired, "tax records cannot be restored while changes require approval")
+		return
+	}
+	recordID, expectedVersion, ok := tx.recordPrecondition(w, r)
+	if !ok {
+		return
+	}
+	if !tx.permitRecord(w, r, recordID) {
+		return
+	}
+
+	record, err := tx.store.RestoreTaxRecord(r.Context(), recordID, expectedVersion)
+	if err != nil {
+		if errors.Is(err, model.ErrConflict) {
+			problem.Write(w, r, http.StatusConflict, problem.CodeTaxRecordConflict,
+				"a tax record with the same municipality, period and period type already exists")
 			return
 		}
-		slog.Error("failed to get tax rate", "error", err)
-		jsonutils.JsonError(w, "failed to get tax rate", http.StatusInternalServerError)
+		writeRecordError(w, r, err, "deleted tax record not found", "failed to restore tax record")
 		return
 	}
+	tx.InvalidateTaxRates(record.Municipality)
 
-	resp := GetTaxRateResponse{
-		Municipality:  municipality,
-		Date:          date,
-		TaxRate:       taxRateResp.TaxRate,
-		IsDefaultRate: taxRateResp.IsDefaultRate,
+	resp := RestoreTaxRecordResponse{Success: true, ID: recordID, Version: record.Version}
+	w.Header().Set(etagHeader, TaxRecordETag(record.Version))
+	jsonutils.JsonResponse(w, resp, http.StatusOK)
+}
+
+// recordPrecondition extracts the ID of the tax record to change and the version expected by the If-Match header.
+// It writes the error response and returns false if either is missing or invalid.
+func (tx *Service) recordPrecondition(w http.ResponseWriter, r *http.Request) (int64, int64, bool) {
+	recordID, err := tx.RecordIDRequestToModel(r.PathValue(tx.config.RecordIDURLPattern))
+	if err != nil {
+		problem.WriteInvalid(w, r, err)
+		return 0, 0, false
+	}
+
+	expectedVersion, err := tx.IfMatchRequestToModel(r.Header.Get(ifMatchHeader))
+	if err != nil {
+		if errors.Is(err, ErrIfMatchRequired) {
+			problem.Write(w, r, http.StatusPreconditionRequired, problem.CodeIfMatchRequired, err.Error())
+			return 0, 0, false
+		}
+		problem.WriteInvalid(w, r, err)
+		return 0, 0, false
+	}
+	return recordID, expectedVersion, true
+}
+
+// writeRecordError maps the errors of tax record changes to problems, internal errors are logged and replaced by msg.
+func writeRecordError(w http.ResponseWriter, r *http.Request, err error, notFoundMsg, msg string) {
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		problem.Write(w, r, http.StatusNotFound, problem.CodeTaxRecordNotFound, notFoundMsg)
+	case errors.Is(err, model.ErrVersionMismatch):
+		problem.Write(w, r, http.StatusPreconditionFailed, problem.CodeTaxRecordModified, "tax record was modified, fetch its current ETag and retry")
+	default:
+		slog.ErrorContext(r.Context(), msg, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternalError, msg)
+	}
+}
+
+func (tx *Service) GetTaxRecordHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	recordID, err := tx.RecordIDRequestToModel(r.PathValue(tx.config.RecordIDURLPattern))
+	if err != nil {
+		problem.WriteInvalid(w, r, err)
+		return
+	}
+
+	history, err := tx.store.GetTaxRecordHistory(r.Context(), recordID)
+	if err != nil {
+		if errors.Is(err, model.ErrNotFound) {
+			problem.Write(w, r, http.StatusNotFound, problem.CodeTaxRecordNotFound, "tax record not found")
+			return
+		}
+		slog.ErrorContext(r.Context(), "failed to get tax record history", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternalError, "failed to get tax record history")
+		return
+	}
+
+	jsonutils.JsonResponse(w, TaxRecordHistoryToResponse(recordID, history), http.StatusOK)
+}
+
+func (tx *Service) CreateTaxRecordDraftHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateTaxRecordDraftRequest
+	if err := jsonutils.DecodeRequest(w, r, &req, tx.config.MaxRequestBodySize); err != nil {
+		problem.WriteDecodeError(w, r, err)
+		return
+	}
+
+	draft, err := tx.CreateTaxRecordDraftRequestToModel(req)
+	if err != nil {
+		problem.WriteInvalid(w, r, err)
+		return
+	}
+	if !permitMunicipality(w, r, draft.Record.Municipality) {
+		return
+	}
+
+	created, err := tx.store.CreateTaxRecordDraft(r.Context(), draft)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to create tax record draft", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternalError, "failed to create tax record draft")
+		return
+	}
+
+	jsonutils.JsonResponse(w, TaxRecordDraftToResponse(created), http.StatusCreated)
+}
+
+func (tx *Service) ListTaxRecordDraftsHandler(w http.ResponseWriter, r *http.Request) {
+	status, err := tx.DraftStatusRequestToModel(r.URL.Query().Get("status"))
+	if err != nil {
+		problem.WriteInvalid(w, r, err)
+		return
+	}
+
+	drafts, err := tx.store.ListTaxRecordDrafts(r.Context(), status)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list tax record drafts", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternalError, "failed to list tax record drafts")
+		return
+	}
+
+	resp := ListTaxRecordDraftsResponse{Drafts: make([]TaxRecordDraftResponse, 0, len(drafts))}
+	for _, draft := range drafts {
+		resp.Drafts = append(resp.Drafts, TaxRecordDraftToResponse(draft))
 	}
 	jsonutils.JsonResponse(w, resp, http.StatusOK)
 }
+
+func (tx *Service) GetTaxRecordDraftHandler(w http.ResponseWriter, r *http.Request) {
+	draftID, err := tx.DraftIDRequestToModel(r.PathValue(tx.config.RecordIDURLPattern))
+	if err != nil {
+		problem.WriteInvalid(w, r, err)
+		return
+	}
+
+	draft, err := tx.store.GetTaxRecordDraft(r.Context(), draftID)
+	if err != nil {
+		writeDraftError(w, r, err, "failed to get tax record draft")
+		return
+	}
+	jsonutils.JsonResponse(w, TaxRecordDraftToResponse(draft), http.StatusOK)
+}
+
+func (tx *Service) SubmitTaxRecordDraftHandler(w http.ResponseWriter, r *http.Request) {
+	draftID, err := tx.DraftIDRequestToModel(r.PathValue(tx.config.RecordIDURLPattern))
+	if err != nil {
+		problem.WriteInvalid(w, r, err)
+		return
+	}
+	if !tx.permitDraft(w, r, draftID) {
+		return
+	}
+
+	draft, err := tx.store.TransitionTaxRecordDraft(r.Context(), draftID, model.DraftStatusDraft, model.DraftStatusPendingApproval)
+	if err != nil {
+		writeDraftError(w, r, err, "failed to submit tax record draft")
+		return
+	}
+	jsonutils.JsonResponse(w, TaxRecordDraftToResponse(draft), http.StatusOK)
+}
+
+func (tx *Service) ApproveTaxRecordDraftHandler(w http.ResponseWriter, r *http.Request) {
+	draftID, err := tx.DraftIDRequestToModel(r.PathValue(tx.config.RecordIDURLPattern))
+	if err != nil {
+		problem.WriteInvalid(w, r, err)
+		return
+	}
+	if !tx.permitDraft(w, r, draftID) {
+		return
+	}
+
+	draft, err := tx.ApproveTaxRecordDraft(r.Context(), draftID)
+	if err != nil {
+		writeDraftError(w, r, err, "failed to approve tax record draft")
+		return
+	}
+	jsonutils.JsonResponse(w, TaxRecordDraftToResponse(draft), http.StatusOK)
+}
+
+func (tx *Service) PublishTaxRecordDraftHandler(w http.ResponseWriter, r *http.Request) {
+	draftID, err := tx.DraftIDRequestToModel(r.PathValue(tx.config.RecordIDURLPattern))
+	if err != nil {
+		problem.WriteInvalid(w, r, err)
+		return
+	}
+	if !tx.permitDraft(w, r, draftID) {
+		return
+	}
+
+	draft, err := tx.store.PublishTaxRecordDraft(r.Context(), draftID, time.Now())
+	if err != nil {
+		writeDraftError(w, r, err, "failed to publish tax record draft")
+		return
+	}
+	tx.InvalidateTaxRates(draft.Record.Municipality)
+	jsonutils.JsonResponse(w, TaxRecordDraftToResponse(draft), http.StatusOK)
+}
+
+// writeDraftError maps the errors of the draft workflow to problems, internal errors are logged and replaced by msg.
+func writeDraftError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, model.ErrNotFound):
+		problem.Write(w, r, http.StatusNotFound, problem.CodeDraftNotFound, "tax record draft not found")
+	case errors.Is(err, model.ErrInvalidState):
+		problem.Write(w, r, http.StatusConflict, problem.CodeInvalidDraftState, "tax record draft is not in the required status")
+	case errors.Is(err, model.ErrNotDue):
+		problem.Write(w, r, http.StatusConflict, problem.CodeDraftNotDue, "tax record draft is scheduled to be published later")
+	case errors.Is(err, ErrApproverRequired):
+		problem.Write(w, r, http.StatusForbidden, problem.CodeApproverRequired, err.Error())
+	case errors.Is(err, ErrSelfApproval):
+		problem.Write(w, r, http.StatusForbidden, problem.CodeSelfApproval, err.Error())
+	default:
+		slog.ErrorContext(r.Context(), msg, "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternalError, msg)
+	}
+}
@@ -5,25 +5,40 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/rezkam/TaxMan/internal/problem"
+	"github.com/rezkam/TaxMan/internal/requestctx"
+	"github.com/rezkam/TaxMan/internal/utils"
 	"github.com/rezkam/TaxMan/model"
 	"github.com/stretchr/testify/require"
 )
 
+// newJSONRequest creates a request with a JSON body.
+func newJSONRequest(method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
 func TestAddOrUpdateTaxRecordHandler(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
 		mockStore := &mockStore{
-			addOrUpdateTaxRecordFunc: func(ctx context.Context, record model.TaxRecord) error {
-				return nil
+			addOrUpdateTaxRecordFunc: func(ctx context.Context, record model.TaxRecord, expectedVersion int64) (model.TaxRecord, error) {
+				record.ID = 1
+				return record, nil
 			},
 		}
 		svc, err := New(mockStore, Config{
 			MaxMunicipalityNameLength: 20,
 			MunicipalityURLPattern:    "municipality",
 			DateURLPattern:            "date",
+			RecordIDURLPattern:        "id",
 		})
 		require.NoError(t, err)
 
@@ -36,7 +51,7 @@ func TestAddOrUpdateTaxRecordHandler(t *testing.T) {
 		})
 		require.NoError(t, err)
 
-		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(reqBody))
+		req := newJSONRequest(http.MethodPost, "/", bytes.NewReader(reqBody))
 		rr := httptest.NewRecorder()
 
 		handler := http.HandlerFunc(svc.AddOrUpdateTaxRecordHandler)
@@ -48,12 +63,105 @@ func TestAddOrUpdateTaxRecordHandler(t *testing.T) {
 		require.Equal(t, http.StatusOK, resp.StatusCode)
 	})
 
+	t.Run("concurrent overwrites", func(t *testing.T) {
+		// The store holds a record at version 1 which both writers read
+		stored := model.TaxRecord{ID: 7, Version: 1}
+		mockStore := &mockStore{
+			addOrUpdateTaxRecordFunc: func(ctx context.Context, record model.TaxRecord, expectedVersion int64) (model.TaxRecord, error) {
+				switch {
+				case expectedVersion == model.NoVersion:
+					return stored, model.ErrVersionRequired
+				case expectedVersion != model.AnyVersion && expectedVersion != stored.Version:
+					return stored, model.ErrVersionMismatch
+				}
+				stored.TaxRate = record.TaxRate
+				stored.Version++
+				return stored, nil
+			},
+		}
+		svc, err := New(mockStore, Config{
+			MaxMunicipalityNameLength: 20,
+			MunicipalityURLPattern:    "municipality",
+			DateURLPattern:            "date",
+			RecordIDURLPattern:        "id",
+		})
+		require.NoError(t, err)
+
+		write := func(taxRate float64, ifMatch string) *httptest.ResponseRecorder {
+			reqBody, err := json.Marshal(AddOrUpdateTaxRecordRequest{
+				Municipality: "Valid Name",
+				TaxRate:      taxRate,
+				StartDate:    "2020-12-31",
+				EndDate:      "2021-12-31",
+				PeriodType:   model.Yearly,
+			})
+			require.NoError(t, err)
+			req := newJSONRequest(http.MethodPost, "/tax", bytes.NewReader(reqBody))
+			if ifMatch != "" {
+				req.Header.Set("If-Match", ifMatch)
+			}
+			rr := httptest.NewRecorder()
+			svc.AddOrUpdateTaxRecordHandler(rr, req)
+			return rr
+		}
+
+		// Overwriting without If-Match is refused and answered with the current ETag
+		rr := write(0.2, "")
+		require.Equal(t, http.StatusPreconditionRequired, rr.Code)
+		require.Equal(t, TaxRecordETag(1), rr.Header().Get("ETag"))
+		var body problem.Problem
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+		require.Equal(t, problem.CodeIfMatchRequired, body.Code)
+
+		// The first writer wins, the second one wrote against a stale version
+		rr = write(0.2, TaxRecordETag(1))
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, TaxRecordETag(2), rr.Header().Get("ETag"))
+
+		rr = write(0.3, TaxRecordETag(1))
+		require.Equal(t, http.StatusPreconditionFailed, rr.Code)
+		require.Equal(t, TaxRecordETag(2), rr.Header().Get("ETag"))
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+		require.Equal(t, problem.CodeTaxRecordModified, body.Code)
+		require.Equal(t, 0.2, stored.TaxRate)
+
+		// The wildcard overwrites whatever version
+		rr = write(0.3, "*")
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, 0.3, stored.TaxRate)
+	})
+
+	t.Run("invalid If-Match", func(t *testing.T) {
+		svc, err := New(&mockStore{
+			addOrUpdateTaxRecordFunc: func(ctx context.Context, record model.TaxRecord, expectedVersion int64) (model.TaxRecord, error) {
+				t.Fatal("record must not be written with an invalid If-Match")
+				return model.TaxRecord{}, nil
+			},
+		}, Config{
+			MaxMunicipalityNameLength: 20,
+			MunicipalityURLPattern:    "municipality",
+			DateURLPattern:            "date",
+			RecordIDURLPattern:        "id",
+		})
+		require.NoError(t, err)
+
+		reqBody, err := json.Marshal(AddOrUpdateTaxRecordRequest{Municipality: "Valid Name", TaxRate: 0.1, StartDate: "2020-12-31", PeriodType: model.Yearly})
+		require.NoError(t, err)
+		req := newJSONRequest(http.MethodPost, "/tax", bytes.NewReader(reqBody))
+		req.Header.Set("If-Match", "1")
+		rr := httptest.NewRecorder()
+		svc.AddOrUpdateTaxRecordHandler(rr, req)
+
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
 	t.Run("invalid municipality", func(t *testing.T) {
 		mockStore := &mockStore{}
 		svc, err := New(mockStore, Config{
 			MaxMunicipalityNameLength: 20,
 			MunicipalityURLPattern:    "municipality",
 			DateURLPattern:            "date",
+			RecordIDURLPattern:        "id",
 		})
 		require.NoError(t, err)
 
@@ -66,7 +174,8 @@ func TestAddOrUpdateTaxRecordHandler(t *testing.T) {
 		})
 		require.NoError(t, err)
 
-		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(reqBody))
+		req := newJSONRequest(http.MethodPost, "/tax", bytes.NewReader(reqBody))
+		req = req.WithContext(requestctx.WithRequestID(req.Context(), "request-1"))
 		rr := httptest.NewRecorder()
 
 		handler := http.HandlerFunc(svc.AddOrUpdateTaxRecordHandler)
@@ -76,18 +185,105 @@ func TestAddOrUpdateTaxRecordHandler(t *testing.T) {
 		defer resp.Body.Close()
 
 		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		require.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+		var body problem.Problem
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		require.Equal(t, problem.Problem{
+			Type:      "urn:taxman:problem:municipality_required",
+			Title:     "Bad Request",
+			Status:    http.StatusBadRequest,
+			Detail:    "municipality is required",
+			Instance:  "/tax",
+			Code:      problem.CodeMunicipalityRequired,
+			RequestID: "request-1",
+			Errors: []problem.FieldError{
+				{Field: "municipality", Code: problem.CodeMunicipalityRequired, Detail: "municipality is required"},
+			},
+		}, body)
+	})
+
+	t.Run("several invalid fields", func(t *testing.T) {
+		svc, err := New(&mockStore{}, Config{
+			MaxMunicipalityNameLength: 20,
+			MunicipalityURLPattern:    "municipality",
+			DateURLPattern:            "date",
+			RecordIDURLPattern:        "id",
+		})
+		require.NoError(t, err)
+
+		reqBody, err := json.Marshal(AddOrUpdateTaxRecordRequest{TaxRate: 2, StartDate: "2020-12-31", PeriodType: "hourly"})
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		svc.AddOrUpdateTaxRecordHandler(rr, newJSONRequest(http.MethodPost, "/tax", bytes.NewReader(reqBody)))
+
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		var body problem.Problem
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+		require.Equal(t, problem.CodeValidationFailed, body.Code)
+		require.Equal(t, "municipality is required; tax rate must be between 0.0 and 1.0; invalid period type", body.Detail)
+		require.Len(t, body.Errors, 3)
+		require.Equal(t, "municipality", body.Errors[0].Field)
+		require.Equal(t, "tax_rate", body.Errors[1].Field)
+		require.Equal(t, "period_type", body.Errors[2].Field)
+	})
+
+	t.Run("undecodable body", func(t *testing.T) {
+		svc, err := New(&mockStore{}, Config{
+			MaxMunicipalityNameLength: 20,
+			MunicipalityURLPattern:    "municipality",
+			DateURLPattern:            "date",
+			RecordIDURLPattern:        "id",
+			MaxRequestBodySize:        128,
+		})
+		require.NoError(t, err)
+
+		valid := `{"municipality":"Valid Name","tax_rate":0.1,"start_date":"2020-12-31","period_type":"yearly"}`
+		testCases := []struct {
+			name        string
+			contentType string
+			body        string
+			status      int
+			code        problem.Code
+			detail      string
+		}{
+			{"missing content type", "", valid, http.StatusUnsupportedMediaType, problem.CodeUnsupportedMediaType, "content type must be application/json"},
+			{"other content type", "text/plain", valid, http.StatusUnsupportedMediaType, problem.CodeUnsupportedMediaType, "content type must be application/json"},
+			{"too large", "application/json", `{"municipality":"` + strings.Repeat("a", 128) + `"}`, http.StatusRequestEntityTooLarge, problem.CodeBodyTooLarge, "request body is too large, the limit is 128 bytes"},
+			{"unknown field", "application/json", `{"municipality":"Valid Name","rate":0.1}`, http.StatusBadRequest, problem.CodeInvalidJSON, `unknown field "rate"`},
+			{"trailing data", "application/json; charset=utf-8", valid + `{}`, http.StatusBadRequest, problem.CodeInvalidJSON, "request body must contain a single json object"},
+			{"wrong type", "application/json", `{"tax_rate":"0.1"}`, http.StatusBadRequest, problem.CodeInvalidJSON, `invalid type of field "tax_rate"`},
+			{"empty", "application/json", ``, http.StatusBadRequest, problem.CodeInvalidJSON, "request body is empty"},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodPost, "/tax", strings.NewReader(tc.body))
+				if tc.contentType != "" {
+					req.Header.Set("Content-Type", tc.contentType)
+				}
+				rr := httptest.NewRecorder()
+				svc.AddOrUpdateTaxRecordHandler(rr, req)
+
+				require.Equal(t, tc.status, rr.Code)
+				var body problem.Problem
+				require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+				require.Equal(t, tc.code, body.Code)
+				require.Equal(t, tc.detail, body.Detail)
+			})
+		}
 	})
 
 	t.Run("store error", func(t *testing.T) {
 		mockStore := &mockStore{
-			addOrUpdateTaxRecordFunc: func(ctx context.Context, record model.TaxRecord) error {
-				return errors.New("store error")
+			addOrUpdateTaxRecordFunc: func(ctx context.Context, record model.TaxRecord, expectedVersion int64) (model.TaxRecord, error) {
+				return model.TaxRecord{}, errors.New("store error")
 			},
 		}
 		svc, err := New(mockStore, Config{
 			MaxMunicipalityNameLength: 20,
 			MunicipalityURLPattern:    "municipality",
 			DateURLPattern:            "date",
+			RecordIDURLPattern:        "id",
 		})
 		require.NoError(t, err)
 
@@ -100,7 +296,7 @@ func TestAddOrUpdateTaxRecordHandler(t *testing.T) {
 		})
 		require.NoError(t, err)
 
-		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(reqBody))
+		req := newJSONRequest(http.MethodPost, "/", bytes.NewReader(reqBody))
 		rr := httptest.NewRecorder()
 
 		handler := http.HandlerFunc(svc.AddOrUpdateTaxRecordHandler)
@@ -129,6 +325,7 @@ func TestGetTaxRateHandler(t *testing.T) {
 			MaxMunicipalityNameLength: 20,
 			MunicipalityURLPattern:    "municipality",
 			DateURLPattern:            "date",
+			RecordIDURLPattern:        "id",
 		})
 		require.NoError(t, err)
 
@@ -152,12 +349,173 @@ func TestGetTaxRateHandler(t *testing.T) {
 		require.Equal(t, 5.5, respBody.TaxRate)
 	})
 
+	t.Run("cache validators", func(t *testing.T) {
+		changeID := int64(7)
+		lastModified := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+		lookups := 0
+		mockStore := &mockStore{
+			getMunicipalityLastChangeFunc: func(ctx context.Context, municipality string) (int64, time.Time, error) {
+				require.Equal(t, "Valid Name", municipality)
+				return changeID, lastModified, nil
+			},
+			getTaxRecordsFunc: func(ctx context.Context, query model.TaxQuery) ([]model.TaxRecord, error) {
+				lookups++
+				return []model.TaxRecord{
+					{TaxRate: 0.2, PeriodType: model.Yearly},
+				}, nil
+			},
+		}
+		svc, err := New(mockStore, Config{
+			MaxMunicipalityNameLength: 20,
+			MunicipalityURLPattern:    "municipality",
+			DateURLPattern:            "date",
+			RecordIDURLPattern:        "id",
+			CacheControlPolicies: []CacheControlPolicy{
+				{MinAge: 24 * time.Hour, CacheControl: "public, max-age=3600"},
+				{MinAge: 0, CacheControl: "no-cache"},
+			},
+		})
+		require.NoError(t, err)
+
+		lookup := func(date, ifNoneMatch string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.SetPathValue(svc.config.MunicipalityURLPattern, "Valid Name")
+			req.SetPathValue(svc.config.DateURLPattern, date)
+			if ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", ifNoneMatch)
+			}
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(svc.GetTaxRateHandler).ServeHTTP(rr, req)
+			return rr
+		}
+
+		rr := lookup("2020-12-31", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		etag := rr.Header().Get("ETag")
+		require.NotEmpty(t, etag)
+		require.Equal(t, "Fri, 01 Mar 2024 12:30:00 GMT", rr.Header().Get("Last-Modified"))
+		require.Equal(t, "public, max-age=3600", rr.Header().Get("Cache-Control"))
+		require.Equal(t, 1, lookups)
+
+		rr = lookup("2020-12-31", `"other", `+etag)
+		require.Equal(t, http.StatusNotModified, rr.Code)
+		require.Empty(t, rr.Body.String())
+		require.Equal(t, etag, rr.Header().Get("ETag"))
+		require.Equal(t, 1, lookups)
+
+		rr = lookup(time.Now().AddDate(1, 0, 0).Format("2006-01-02"), "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, "no-cache", rr.Header().Get("Cache-Control"))
+
+		// A change committed later may have started earlier, only its ID is sure to differ
+		changeID++
+		lastModified = lastModified.Add(-time.Second)
+		rr = lookup("2020-12-31", etag)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.NotEqual(t, etag, rr.Header().Get("ETag"))
+	})
+
+	t.Run("cache hit does not query the store", func(t *testing.T) {
+		changeLookups, recordLookups := 0, 0
+		mockStore := &mockStore{
+			getMunicipalityLastChangeFunc: func(ctx context.Context, municipality string) (int64, time.Time, error) {
+				changeLookups++
+				return int64(changeLookups), time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC), nil
+			},
+			getTaxRecordsFunc: func(ctx context.Context, query model.TaxQuery) ([]model.TaxRecord, error) {
+				recordLookups++
+				return []model.TaxRecord{{TaxRate: 0.2, PeriodType: model.Yearly}}, nil
+			},
+		}
+		svc, err := New(mockStore, Config{
+			MaxMunicipalityNameLength: 20,
+			MunicipalityURLPattern:    "municipality",
+			DateURLPattern:            "date",
+			RecordIDURLPattern:        "id",
+			RateCacheSize:             10,
+			RateCacheTTL:              time.Minute,
+		})
+		require.NoError(t, err)
+
+		lookup := func(ifNoneMatch string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.SetPathValue(svc.config.MunicipalityURLPattern, "Valid Name")
+			req.SetPathValue(svc.config.DateURLPattern, "2020-12-31")
+			if ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", ifNoneMatch)
+			}
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(svc.GetTaxRateHandler).ServeHTTP(rr, req)
+			return rr
+		}
+
+		rr := lookup("")
+		require.Equal(t, http.StatusOK, rr.Code)
+		etag := rr.Header().Get("ETag")
+
+		rr = lookup("")
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, etag, rr.Header().Get("ETag"))
+		rr = lookup(etag)
+		require.Equal(t, http.StatusNotModified, rr.Code)
+		require.Equal(t, 1, changeLookups)
+		require.Equal(t, 1, recordLookups)
+
+		// A change of the municipality drops its latest change along with its lookups
+		svc.InvalidateTaxRates("Valid Name")
+		rr = lookup(etag)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.NotEqual(t, etag, rr.Header().Get("ETag"))
+		require.Equal(t, 2, changeLookups)
+		require.Equal(t, 2, recordLookups)
+	})
+
+	t.Run("as of a past moment", func(t *testing.T) {
+		asOf := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+		mockStore := &mockStore{
+			getTaxRecordsFunc: func(ctx context.Context, query model.TaxQuery) ([]model.TaxRecord, error) {
+				require.Equal(t, asOf, query.AsOf)
+				return []model.TaxRecord{
+					{TaxRate: 0.3, PeriodType: model.Yearly},
+				}, nil
+			},
+		}
+		svc, err := New(mockStore, Config{
+			MaxMunicipalityNameLength: 20,
+			MunicipalityURLPattern:    "municipality",
+			DateURLPattern:            "date",
+			RecordIDURLPattern:        "id",
+		})
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/?as_of=2021-01-01T10:00:00Z", nil)
+		rr := httptest.NewRecorder()
+
+		req.SetPathValue(svc.config.MunicipalityURLPattern, "Valid Name")
+		req.SetPathValue(svc.config.DateURLPattern, "2020-12-31")
+
+		handler := http.HandlerFunc(svc.GetTaxRateHandler)
+		handler.ServeHTTP(rr, req)
+
+		resp := rr.Result()
+		defer resp.Body.Close()
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var respBody GetTaxRateResponse
+		err = json.NewDecoder(resp.Body).Decode(&respBody)
+		require.NoError(t, err)
+		require.Equal(t, 0.3, respBody.TaxRate)
+		require.Equal(t, "2021-01-01T10:00:00Z", respBody.AsOf)
+	})
+
 	t.Run("invalid municipality", func(t *testing.T) {
 		mockStore := &mockStore{}
 		svc, err := New(mockStore, Config{
 			MaxMunicipalityNameLength: 20,
 			MunicipalityURLPattern:    "municipality",
 			DateURLPattern:            "date",
+			RecordIDURLPattern:        "id",
 		})
 		require.NoError(t, err)
 
@@ -186,6 +544,7 @@ func TestGetTaxRateHandler(t *testing.T) {
 			MaxMunicipalityNameLength: 20,
 			MunicipalityURLPattern:    "municipality",
 			DateURLPattern:            "date",
+			RecordIDURLPattern:        "id",
 		})
 		require.NoError(t, err)
 
@@ -213,6 +572,7 @@ func TestGetTaxRateHandler(t *testing.T) {
 			MaxMunicipalityNameLength: 20,
 			MunicipalityURLPattern:    "municipality",
 			DateURLPattern:            "date",
+			RecordIDURLPattern:        "id",
 			DefaultTaxRate:            &defaultTaxRate,
 		})
 		require.NoError(t, err)
@@ -248,6 +608,7 @@ func TestGetTaxRateHandler(t *testing.T) {
 			MaxMunicipalityNameLength: 20,
 			MunicipalityURLPattern:    "municipality",
 			DateURLPattern:            "date",
+			RecordIDURLPattern:        "id",
 		})
 		require.NoError(t, err)
 
@@ -267,3 +628,597 @@ func TestGetTaxRateHandler(t *testing.T) {
 	})
 
 }
+
+func TestGetTaxRateV2Handler(t *testing.T) {
+	mockStore := &mockStore{
+		getTaxRecordsFunc: func(ctx context.Context, query model.TaxQuery) ([]model.TaxRecord, error) {
+			return []model.TaxRecord{
+				{ID: 3, TaxRate: 0.2, PeriodType: model.Yearly},
+				{ID: 4, TaxRate: 0.1, PeriodType: model.Daily},
+			}, nil
+		},
+	}
+	svc, err := New(mockStore, Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/tax/Copenhagen/2024-01-01", nil)
+	req.SetPathValue(svc.config.MunicipalityURLPattern, "Copenhagen")
+	req.SetPathValue(svc.config.DateURLPattern, "2024-01-01")
+	rr := httptest.NewRecorder()
+	svc.GetTaxRateV2Handler(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NotEmpty(t, rr.Header().Get("ETag"))
+	require.JSONEq(t, `{"municipality":"Copenhagen","date":"2024-01-01","tax_rate":"0.1","category":"daily","record_id":4}`, rr.Body.String())
+}
+
+func TestGetTaxRecordHistoryHandler(t *testing.T) {
+	config := Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+	}
+
+	t.Run("success", func(t *testing.T) {
+		changedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		mockStore := &mockStore{
+			getTaxRecordHistoryFunc: func(ctx context.Context, recordID int64) ([]model.TaxRecordHistoryEntry, error) {
+				require.Equal(t, int64(42), recordID)
+				return []model.TaxRecordHistoryEntry{
+					{
+						ID:        1,
+						RecordID:  recordID,
+						Action:    model.ActionCreate,
+						New:       &model.TaxRecordState{TaxRate: 0.2, StartDate: utils.DateOnly(2024, time.January, 1)},
+						Actor:     "clerk",
+						ChangedAt: changedAt,
+					},
+					{
+						ID:        2,
+						RecordID:  recordID,
+						Action:    model.ActionUpdate,
+						Old:       &model.TaxRecordState{TaxRate: 0.2, StartDate: utils.DateOnly(2024, time.January, 1)},
+						New:       &model.TaxRecordState{TaxRate: 0.3, StartDate: utils.DateOnly(2024, time.January, 1)},
+						Actor:     "clerk",
+						RequestID: "req-2",
+						ChangedAt: changedAt,
+					},
+				}, nil
+			},
+		}
+		svc, err := New(mockStore, config)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetPathValue(svc.config.RecordIDURLPattern, "42")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(svc.GetTaxRecordHistoryHandler)
+		handler.ServeHTTP(rr, req)
+
+		resp := rr.Result()
+		defer resp.Body.Close()
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var respBody GetTaxRecordHistoryResponse
+		err = json.NewDecoder(resp.Body).Decode(&respBody)
+		require.NoError(t, err)
+		require.Equal(t, int64(42), respBody.RecordID)
+		require.Len(t, respBody.History, 2)
+		require.Nil(t, respBody.History[0].OldValue)
+		require.Equal(t, 0.2, respBody.History[0].NewValue.TaxRate)
+		require.Empty(t, respBody.History[0].NewValue.EndDate)
+		require.Equal(t, 0.2, respBody.History[1].OldValue.TaxRate)
+		require.Equal(t, 0.3, respBody.History[1].NewValue.TaxRate)
+		require.Equal(t, "req-2", respBody.History[1].RequestID)
+	})
+
+	t.Run("invalid record id", func(t *testing.T) {
+		svc, err := New(&mockStore{}, config)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetPathValue(svc.config.RecordIDURLPattern, "abc")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(svc.GetTaxRecordHistoryHandler)
+		handler.ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		mockStore := &mockStore{
+			getTaxRecordHistoryFunc: func(ctx context.Context, recordID int64) ([]model.TaxRecordHistoryEntry, error) {
+				return nil, model.ErrNotFound
+			},
+		}
+		svc, err := New(mockStore, config)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetPathValue(svc.config.RecordIDURLPattern, "7")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(svc.GetTaxRecordHistoryHandler)
+		handler.ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
+
+func TestDeleteTaxRecordHandler(t *testing.T) {
+	config := Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+	}
+
+	testCases := []struct {
+		name            string
+		recordID        string
+		ifMatch         string
+		storeErr        error
+		expectedStatus  int
+		expectedVersion int64
+	}{
+		{"success", "3", `"2"`, nil, http.StatusOK, 2},
+		{"any version", "3", "*", nil, http.StatusOK, 0},
+		{"invalid record id", "abc", `"2"`, nil, http.StatusBadRequest, 0},
+		{"missing if-match", "3", "", nil, http.StatusPreconditionRequired, 0},
+		{"invalid if-match", "3", "2", nil, http.StatusBadRequest, 0},
+		{"version mismatch", "3", `"1"`, model.ErrVersionMismatch, http.StatusPreconditionFailed, 1},
+		{"not found", "3", `"2"`, model.ErrNotFound, http.StatusNotFound, 2},
+		{"store error", "3", `"2"`, errors.New("store error"), http.StatusInternalServerError, 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockStore := &mockStore{
+				deleteTaxRecordFunc: func(ctx context.Context, recordID int64, expectedVersion int64) (model.TaxRecord, error) {
+					require.Equal(t, tc.expectedVersion, expectedVersion)
+					return model.TaxRecord{ID: recordID, Version: 3}, tc.storeErr
+				},
+			}
+			svc, err := New(mockStore, config)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodDelete, "/", nil)
+			req.SetPathValue(svc.config.RecordIDURLPattern, tc.recordID)
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+			rr := httptest.NewRecorder()
+
+			handler := http.HandlerFunc(svc.DeleteTaxRecordHandler)
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				require.Equal(t, `"3"`, rr.Header().Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestTaxRecordWritesInvalidateRateCache(t *testing.T) {
+	lookups := 0
+	mockStore := &mockStore{
+		getTaxRecordsFunc: func(ctx context.Context, query model.TaxQuery) ([]model.TaxRecord, error) {
+			lookups++
+			return []model.TaxRecord{{TaxRate: 0.2, PeriodType: model.Yearly}}, nil
+		},
+		deleteTaxRecordFunc: func(ctx context.Context, recordID int64, expectedVersion int64) (model.TaxRecord, error) {
+			return model.TaxRecord{ID: recordID, Municipality: "Copenhagen", Version: 2}, nil
+		},
+	}
+	svc, err := New(mockStore, Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+		RateCacheSize:             10,
+		RateCacheTTL:              time.Minute,
+	})
+	require.NoError(t, err)
+
+	lookup := func() {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetPathValue(svc.config.MunicipalityURLPattern, "Copenhagen")
+		req.SetPathValue(svc.config.DateURLPattern, "2024-03-01")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(svc.GetTaxRateHandler).ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	lookup()
+	lookup()
+	require.Equal(t, 1, lookups)
+
+	req := httptest.NewRequest(http.MethodDelete, "/", nil)
+	req.SetPathValue(svc.config.RecordIDURLPattern, "3")
+	req.Header.Set("If-Match", `"1"`)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(svc.DeleteTaxRecordHandler).ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	lookup()
+	require.Equal(t, 2, lookups)
+}
+
+func TestGetTaxRecordHandler(t *testing.T) {
+	config := Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+	}
+
+	testCases := []struct {
+		name           string
+		recordID       string
+		storeErr       error
+		expectedStatus int
+	}{
+		{"success", "3", nil, http.StatusOK},
+		{"invalid record id", "abc", nil, http.StatusBadRequest},
+		{"not found", "3", model.ErrNotFound, http.StatusNotFound},
+		{"store error", "3", errors.New("store error"), http.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockStore := &mockStore{
+				getTaxRecordFunc: func(ctx context.Context, recordID int64) (model.TaxRecord, error) {
+					return model.TaxRecord{
+						ID:           recordID,
+						Municipality: "Copenhagen",
+						TaxRate:      0.2,
+						StartDate:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+						PeriodType:   model.Yearly,
+						Version:      4,
+					}, tc.storeErr
+				},
+			}
+			svc, err := New(mockStore, config)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.SetPathValue(svc.config.RecordIDURLPattern, tc.recordID)
+			rr := httptest.NewRecorder()
+
+			handler := http.HandlerFunc(svc.GetTaxRecordHandler)
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				require.Equal(t, `"4"`, rr.Header().Get("ETag"))
+
+				var resp TaxRecordResponse
+				require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+				require.Equal(t, int64(4), resp.Version)
+				require.Equal(t, "2024-01-01", resp.StartDate)
+				require.Empty(t, resp.EndDate)
+				require.Nil(t, resp.DeletedAt)
+			}
+		})
+	}
+}
+
+func TestUpdateTaxRecordHandler(t *testing.T) {
+	config := Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+	}
+
+	testCases := []struct {
+		name           string
+		recordID       string
+		ifMatch        string
+		body           string
+		storeErr       error
+		expectedStatus int
+	}{
+		{"success", "3", `"2"`, `{"tax_rate": 0.3}`, nil, http.StatusOK},
+		{"invalid record id", "abc", `"2"`, `{"tax_rate": 0.3}`, nil, http.StatusBadRequest},
+		{"missing if-match", "3", "", `{"tax_rate": 0.3}`, nil, http.StatusPreconditionRequired},
+		{"invalid json", "3", `"2"`, `{"tax_rate": `, nil, http.StatusBadRequest},
+		{"invalid tax rate", "3", `"2"`, `{"tax_rate": 1.5}`, nil, http.StatusBadRequest},
+		{"version mismatch", "3", `"1"`, `{"tax_rate": 0.3}`, model.ErrVersionMismatch, http.StatusPreconditionFailed},
+		{"not found", "3", `"2"`, `{"tax_rate": 0.3}`, model.ErrNotFound, http.StatusNotFound},
+		{"store error", "3", `"2"`, `{"tax_rate": 0.3}`, errors.New("store error"), http.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockStore := &mockStore{
+				updateTaxRecordRateFunc: func(ctx context.Context, recordID int64, taxRate float64, expectedVersion int64) (model.TaxRecord, error) {
+					return model.TaxRecord{ID: recordID, TaxRate: taxRate, Version: expectedVersion + 1}, tc.storeErr
+				},
+			}
+			svc, err := New(mockStore, config)
+			require.NoError(t, err)
+
+			req := newJSONRequest(http.MethodPut, "/", strings.NewReader(tc.body))
+			req.SetPathValue(svc.config.RecordIDURLPattern, tc.recordID)
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+			rr := httptest.NewRecorder()
+
+			handler := http.HandlerFunc(svc.UpdateTaxRecordHandler)
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				require.Equal(t, `"3"`, rr.Header().Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestRestoreTaxRecordHandler(t *testing.T) {
+	config := Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+	}
+
+	testCases := []struct {
+		name           string
+		recordID       string
+		ifMatch        string
+		storeErr       error
+		expectedStatus int
+	}{
+		{"success", "3", `"2"`, nil, http.StatusOK},
+		{"invalid record id", "-1", `"2"`, nil, http.StatusBadRequest},
+		{"missing if-match", "3", "", nil, http.StatusPreconditionRequired},
+		{"version mismatch", "3", `"1"`, model.ErrVersionMismatch, http.StatusPreconditionFailed},
+		{"not found", "3", `"2"`, model.ErrNotFound, http.StatusNotFound},
+		{"conflict", "3", `"2"`, model.ErrConflict, http.StatusConflict},
+		{"store error", "3", `"2"`, errors.New("store error"), http.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockStore := &mockStore{
+				restoreTaxRecordFunc: func(ctx context.Context, recordID int64, expectedVersion int64) (model.TaxRecord, error) {
+					return model.TaxRecord{ID: recordID, Version: expectedVersion + 1}, tc.storeErr
+				},
+			}
+			svc, err := New(mockStore, config)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.SetPathValue(svc.config.RecordIDURLPattern, tc.recordID)
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+			rr := httptest.NewRecorder()
+
+			handler := http.HandlerFunc(svc.RestoreTaxRecordHandler)
+			handler.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.expectedStatus, rr.Code)
+		})
+	}
+}
+
+func TestAddOrUpdateTaxRecordHandlerRequiresApproval(t *testing.T) {
+	svc, err := New(&mockStore{
+		addOrUpdateTaxRecordFunc: func(ctx context.Context, record model.TaxRecord, expectedVersion int64) (model.TaxRecord, error) {
+			t.Fatal("record must not be written directly when approval is required")
+			return record, nil
+		},
+	}, Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+		RequireApproval:           true,
+	})
+	require.NoError(t, err)
+
+	reqBody, err := json.Marshal(AddOrUpdateTaxRecordRequest{
+		Municipality: "Valid Name",
+		TaxRate:      0.1,
+		StartDate:    "2020-12-31",
+		EndDate:      "2021-12-31",
+		PeriodType:   model.Yearly,
+	})
+	require.NoError(t, err)
+
+	req := newJSONRequest(http.MethodPost, "/", bytes.NewReader(reqBody))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(svc.AddOrUpdateTaxRecordHandler)
+	handler.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestDeleteAndRestoreTaxRecordHandlersRequireApproval(t *testing.T) {
+	svc, err := New(&mockStore{
+		deleteTaxRecordFunc: func(ctx context.Context, recordID int64, expectedVersion int64) (model.TaxRecord, error) {
+			t.Fatal("record must not be deleted directly when approval is required")
+			return model.TaxRecord{}, nil
+		},
+		restoreTaxRecordFunc: func(ctx context.Context, recordID int64, expectedVersion int64) (model.TaxRecord, error) {
+			t.Fatal("record must not be restored directly when approval is required")
+			return model.TaxRecord{}, nil
+		},
+	}, Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+		RequireApproval:           true,
+	})
+	require.NoError(t, err)
+
+	for _, handler := range []http.HandlerFunc{svc.DeleteTaxRecordHandler, svc.RestoreTaxRecordHandler} {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.SetPathValue(svc.config.RecordIDURLPattern, "5")
+		req.Header.Set("If-Match", TaxRecordETag(1))
+		rr := httptest.NewRecorder()
+
+		handler.ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusForbidden, rr.Code)
+	}
+}
+
+func TestTaxRecordDraftHandlers(t *testing.T) {
+	config := Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+		RequireApproval:           true,
+	}
+
+	t.Run("create", func(t *testing.T) {
+		mockStore := &mockStore{
+			createTaxRecordDraftFunc: func(ctx context.Context, draft model.TaxRecordDraft) (model.TaxRecordDraft, error) {
+				draft.ID = 5
+				draft.Status = model.DraftStatusDraft
+				draft.CreatedBy = "author"
+				return draft, nil
+			},
+		}
+		svc, err := New(mockStore, config)
+		require.NoError(t, err)
+
+		reqBody, err := json.Marshal(CreateTaxRecordDraftRequest{
+			AddOrUpdateTaxRecordRequest: AddOrUpdateTaxRecordRequest{
+				Municipality: "Valid Name",
+				TaxRate:      0.1,
+				StartDate:    "2025-01-01",
+				PeriodType:   model.Yearly,
+			},
+			PublishAt: "2024-12-31T23:00:00Z",
+		})
+		require.NoError(t, err)
+
+		req := newJSONRequest(http.MethodPost, "/", bytes.NewReader(reqBody))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(svc.CreateTaxRecordDraftHandler)
+		handler.ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusCreated, rr.Code)
+
+		var respBody TaxRecordDraftResponse
+		err = json.NewDecoder(rr.Body).Decode(&respBody)
+		require.NoError(t, err)
+		require.Equal(t, int64(5), respBody.ID)
+		require.Equal(t, model.DraftStatusDraft, respBody.Status)
+		require.Empty(t, respBody.EndDate)
+		require.NotNil(t, respBody.PublishAt)
+	})
+
+	t.Run("approve", func(t *testing.T) {
+		testCases := []struct {
+			name           string
+			actor          string
+			draftErr       error
+			transitionErr  error
+			expectedStatus int
+		}{
+			{"success", "reviewer", nil, nil, http.StatusOK},
+			{"author", "author", nil, nil, http.StatusForbidden},
+			{"anonymous", "", nil, nil, http.StatusForbidden},
+			{"not found", "reviewer", model.ErrNotFound, nil, http.StatusNotFound},
+			{"not pending", "reviewer", nil, model.ErrInvalidState, http.StatusConflict},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				mockStore := &mockStore{
+					getTaxRecordDraftFunc: func(ctx context.Context, draftID int64) (model.TaxRecordDraft, error) {
+						return model.TaxRecordDraft{ID: draftID, CreatedBy: "author"}, tc.draftErr
+					},
+					transitionTaxRecordDraftFunc: func(ctx context.Context, draftID int64, from, to model.DraftStatus) (model.TaxRecordDraft, error) {
+						return model.TaxRecordDraft{ID: draftID, Status: to}, tc.transitionErr
+					},
+				}
+				svc, err := New(mockStore, config)
+				require.NoError(t, err)
+
+				req := httptest.NewRequest(http.MethodPost, "/", nil)
+				req = req.WithContext(requestctx.WithActor(req.Context(), tc.actor))
+				req.SetPathValue(svc.config.RecordIDURLPattern, "5")
+				rr := httptest.NewRecorder()
+
+				handler := http.HandlerFunc(svc.ApproveTaxRecordDraftHandler)
+				handler.ServeHTTP(rr, req)
+
+				require.Equal(t, tc.expectedStatus, rr.Code)
+			})
+		}
+	})
+
+	t.Run("publish not approved", func(t *testing.T) {
+		mockStore := &mockStore{
+			publishTaxRecordDraftFunc: func(ctx context.Context, draftID int64, now time.Time) (model.TaxRecordDraft, error) {
+				return model.TaxRecordDraft{}, model.ErrInvalidState
+			},
+		}
+		svc, err := New(mockStore, config)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.SetPathValue(svc.config.RecordIDURLPattern, "5")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(svc.PublishTaxRecordDraftHandler)
+		handler.ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusConflict, rr.Code)
+	})
+
+	t.Run("publish before scheduled time", func(t *testing.T) {
+		mockStore := &mockStore{
+			publishTaxRecordDraftFunc: func(ctx context.Context, draftID int64, now time.Time) (model.TaxRecordDraft, error) {
+				return model.TaxRecordDraft{}, model.ErrNotDue
+			},
+		}
+		svc, err := New(mockStore, config)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.SetPathValue(svc.config.RecordIDURLPattern, "5")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(svc.PublishTaxRecordDraftHandler)
+		handler.ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusConflict, rr.Code)
+		require.Contains(t, rr.Body.String(), string(problem.CodeDraftNotDue))
+	})
+
+	t.Run("list with invalid status", func(t *testing.T) {
+		svc, err := New(&mockStore{}, config)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/?status=unknown", nil)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(svc.ListTaxRecordDraftsHandler)
+		handler.ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
@@ -0,0 +1,92 @@
+package taxservice
+
+import (
+	"cmp"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	cacheControlHeader = "Cache-Control"
+	lastModifiedHeader = "Last-Modified"
+	ifNoneMatchHeader  = "If-None-Match"
+)
+
+// CacheControlPolicy is the Cache-Control header sent with rate lookups of dates that ended at least MinAge ago.
+type CacheControlPolicy struct {
+	// MinAge is how long ago the queried date must have ended for the policy to apply.
+	// Today and future dates have an age of 0.
+	MinAge time.Duration
+	// CacheControl is the value of the Cache-Control header, e.g. "public, max-age=86400".
+	CacheControl string
+}
+
+// validateCacheControlPolicies checks the policies and returns them sorted by MinAge.
+func validateCacheControlPolicies(policies []CacheControlPolicy) ([]CacheControlPolicy, error) {
+	sorted := slices.Clone(policies)
+	for _, policy := range sorted {
+		if policy.MinAge < 0 {
+			return nil, errors.New("CacheControlPolicy MinAge cannot be negative")
+		}
+		if policy.CacheControl == "" {
+			return nil, errors.New("CacheControlPolicy CacheControl cannot be empty")
+		}
+	}
+	slices.SortFunc(sorted, func(a, b CacheControlPolicy) int {
+		return cmp.Compare(a.MinAge, b.MinAge)
+	})
+	return sorted, nil
+}
+
+// cacheControl returns the Cache-Control header for a rate lookup of date at now,
+// or an empty string if no policy applies.
+func (tx *Service) cacheControl(date, now time.Time) string {
+	age := max(now.Sub(date.AddDate(0, 0, 1)), 0)
+	cacheControl := ""
+	for _, policy := range tx.config.CacheControlPolicies {
+		if policy.MinAge > age {
+			break
+		}
+		cacheControl = policy.CacheControl
+	}
+	return cacheControl
+}
+
+// municipalityETag derives a weak entity tag for the rate lookups of a municipality from the ID of its latest change.
+// Unlike the time of the change, the ID never goes back, so a change committed late cannot reuse an older tag.
+func municipalityETag(changeID int64) string {
+	return `W/"` + strconv.FormatInt(changeID, 10) + `"`
+}
+
+// writeValidators sets the validators of a rate lookup response derived from the latest change of the municipality.
+func writeValidators(w http.ResponseWriter, etag string, lastModified time.Time, cacheControl string) {
+	w.Header().Set(etagHeader, etag)
+	if !lastModified.IsZero() {
+		w.Header().Set(lastModifiedHeader, lastModified.UTC().Format(http.TimeFormat))
+	}
+	if cacheControl != "" {
+		w.Header().Set(cacheControlHeader, cacheControl)
+	}
+}
+
+// noneMatch reports whether the If-None-Match header of r fails to match etag,
+// i.e. whether the full response must be sent. Entity tags are compared weakly.
+func noneMatch(r *http.Request, etag string) bool {
+	ifNoneMatch := r.Header.Get(ifNoneMatchHeader)
+	if ifNoneMatch == "" {
+		return true
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return false
+		}
+	}
+	return true
+}
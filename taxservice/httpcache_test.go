@@ -0,0 +1,87 @@
+package taxservice
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheControl(t *testing.T) {
+	svc, err := New(nil, Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+		CacheControlPolicies: []CacheControlPolicy{
+			{MinAge: 365 * 24 * time.Hour, CacheControl: "public, max-age=86400"},
+			{MinAge: 24 * time.Hour, CacheControl: "public, max-age=3600"},
+		},
+	})
+	require.NoError(t, err)
+
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		date     time.Time
+		expected string
+	}{
+		{"Future", time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC), ""},
+		{"Today", time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC), ""},
+		{"Ended Hours Ago", time.Date(2024, 6, 14, 0, 0, 0, 0, time.UTC), ""},
+		{"Ended A Day Ago", time.Date(2024, 6, 13, 0, 0, 0, 0, time.UTC), "public, max-age=3600"},
+		{"Ended Years Ago", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), "public, max-age=86400"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, svc.cacheControl(tt.date, now))
+		})
+	}
+}
+
+func TestInvalidCacheControlPolicies(t *testing.T) {
+	config := Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+	}
+
+	config.CacheControlPolicies = []CacheControlPolicy{{MinAge: -time.Hour, CacheControl: "no-cache"}}
+	_, err := New(nil, config)
+	require.Error(t, err)
+
+	config.CacheControlPolicies = []CacheControlPolicy{{MinAge: time.Hour}}
+	_, err = New(nil, config)
+	require.Error(t, err)
+}
+
+func TestNoneMatch(t *testing.T) {
+	etag := municipalityETag(42)
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		expected    bool
+	}{
+		{"Missing", "", true},
+		{"Wildcard", "*", false},
+		{"Same", etag, false},
+		{"Strong Form", etag[2:], false},
+		{"In List", `"a", ` + etag, false},
+		{"Different", `W/"a"`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			assert.Equal(t, tt.expected, noneMatch(req, etag))
+		})
+	}
+}
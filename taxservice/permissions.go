@@ -0,0 +1,56 @@
+package taxservice
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/rezkam/TaxMan/auth"
+	"github.com/rezkam/TaxMan/internal/problem"
+)
+
+// restrictedPrincipal returns the principal of r if it may only change the tax records of some municipalities.
+// Requests without a principal were not authenticated by the auth middleware, which decides alone whether they may write.
+func restrictedPrincipal(r *http.Request) (auth.Principal, bool) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	return principal, ok && len(principal.Municipalities) > 0
+}
+
+// permitMunicipality checks that the caller of r may change the tax records of municipality.
+// It writes a forbidden response and returns false if it may not.
+func permitMunicipality(w http.ResponseWriter, r *http.Request, municipality string) bool {
+	principal, restricted := restrictedPrincipal(r)
+	if !restricted || principal.CanChangeMunicipality(municipality) {
+		return true
+	}
+	problem.Write(w, r, http.StatusForbidden, problem.CodeMunicipalityNotPermitted,
+		fmt.Sprintf("not permitted to change the tax records of municipality %q", municipality))
+	return false
+}
+
+// permitRecord checks that the caller of r may change the tax record with the given ID, including deleted records.
+// It writes the error response and returns false if it may not or the record cannot be read.
+func (tx *Service) permitRecord(w http.ResponseWriter, r *http.Request, recordID int64) bool {
+	if _, restricted := restrictedPrincipal(r); !restricted {
+		return true
+	}
+	record, err := tx.store.GetTaxRecord(r.Context(), recordID)
+	if err != nil {
+		writeRecordError(w, r, err, "tax record not found", "failed to get tax record")
+		return false
+	}
+	return permitMunicipality(w, r, record.Municipality)
+}
+
+// permitDraft checks that the caller of r may change the tax record proposed by the draft with the given ID.
+// It writes the error response and returns false if it may not or the draft cannot be read.
+func (tx *Service) permitDraft(w http.ResponseWriter, r *http.Request, draftID int64) bool {
+	if _, restricted := restrictedPrincipal(r); !restricted {
+		return true
+	}
+	draft, err := tx.store.GetTaxRecordDraft(r.Context(), draftID)
+	if err != nil {
+		writeDraftError(w, r, err, "failed to get tax record draft")
+		return false
+	}
+	return permitMunicipality(w, r, draft.Record.Municipality)
+}
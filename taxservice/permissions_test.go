@@ -0,0 +1,134 @@
+package taxservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rezkam/TaxMan/auth"
+	"github.com/rezkam/TaxMan/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMunicipalityPermissions(t *testing.T) {
+	config := Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+	}
+	clerk := auth.Principal{Name: "clerk", Scopes: []model.Scope{model.ScopeWrite}, Municipalities: []string{"Copenhagen"}}
+	admin := auth.Principal{Name: "admin", Scopes: []model.Scope{model.ScopeAdmin}}
+
+	newService := func(t *testing.T) *Service {
+		t.Helper()
+		mockStore := &mockStore{
+			addOrUpdateTaxRecordFunc: func(ctx context.Context, record model.TaxRecord, expectedVersion int64) (model.TaxRecord, error) {
+				record.ID = 1
+				return record, nil
+			},
+			getTaxRecordFunc: func(ctx context.Context, recordID int64) (model.TaxRecord, error) {
+				if recordID == 404 {
+					return model.TaxRecord{}, model.ErrNotFound
+				}
+				return model.TaxRecord{ID: recordID, Municipality: "Aarhus", Version: 2}, nil
+			},
+			deleteTaxRecordFunc: func(ctx context.Context, recordID int64, expectedVersion int64) (model.TaxRecord, error) {
+				return model.TaxRecord{ID: recordID, Municipality: "Aarhus", Version: expectedVersion + 1}, nil
+			},
+			getTaxRecordDraftFunc: func(ctx context.Context, draftID int64) (model.TaxRecordDraft, error) {
+				return model.TaxRecordDraft{ID: draftID, Record: model.TaxRecord{Municipality: "Aarhus"}}, nil
+			},
+			publishTaxRecordDraftFunc: func(ctx context.Context, draftID int64, now time.Time) (model.TaxRecordDraft, error) {
+				return model.TaxRecordDraft{ID: draftID, Record: model.TaxRecord{Municipality: "Aarhus"}, Status: model.DraftStatusPublished}, nil
+			},
+		}
+		svc, err := New(mockStore, config)
+		require.NoError(t, err)
+		return svc
+	}
+
+	t.Run("add or update", func(t *testing.T) {
+		testCases := []struct {
+			name           string
+			principal      *auth.Principal
+			municipality   string
+			expectedStatus int
+		}{
+			{"own municipality", &clerk, "Copenhagen", http.StatusOK},
+			{"other municipality", &clerk, "Aarhus", http.StatusForbidden},
+			{"unrestricted principal", &admin, "Aarhus", http.StatusOK},
+			{"no principal", nil, "Aarhus", http.StatusOK},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				svc := newService(t)
+				reqBody, err := json.Marshal(AddOrUpdateTaxRecordRequest{
+					Municipality: tc.municipality,
+					TaxRate:      0.1,
+					StartDate:    "2024-01-01",
+					EndDate:      "2024-12-31",
+					PeriodType:   model.Yearly,
+				})
+				require.NoError(t, err)
+
+				req := newJSONRequest(http.MethodPost, "/", bytes.NewReader(reqBody))
+				if tc.principal != nil {
+					req = req.WithContext(auth.WithPrincipal(req.Context(), *tc.principal))
+				}
+				rr := httptest.NewRecorder()
+				svc.AddOrUpdateTaxRecordHandler(rr, req)
+
+				require.Equal(t, tc.expectedStatus, rr.Code)
+				if tc.expectedStatus == http.StatusForbidden {
+					require.Contains(t, rr.Body.String(), `not permitted to change the tax records of municipality`)
+				}
+			})
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		testCases := []struct {
+			name           string
+			principal      auth.Principal
+			recordID       string
+			expectedStatus int
+		}{
+			{"other municipality", clerk, "3", http.StatusForbidden},
+			{"unrestricted principal", admin, "3", http.StatusOK},
+			{"not found", clerk, "404", http.StatusNotFound},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				svc := newService(t)
+				req := httptest.NewRequest(http.MethodDelete, "/", nil)
+				req = req.WithContext(auth.WithPrincipal(req.Context(), tc.principal))
+				req.SetPathValue(svc.config.RecordIDURLPattern, tc.recordID)
+				req.Header.Set("If-Match", `"2"`)
+				rr := httptest.NewRecorder()
+				svc.DeleteTaxRecordHandler(rr, req)
+
+				require.Equal(t, tc.expectedStatus, rr.Code)
+			})
+		}
+	})
+
+	t.Run("publish draft", func(t *testing.T) {
+		for principal, expectedStatus := range map[*auth.Principal]int{&clerk: http.StatusForbidden, &admin: http.StatusOK} {
+			svc := newService(t)
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req = req.WithContext(auth.WithPrincipal(req.Context(), *principal))
+			req.SetPathValue(svc.config.RecordIDURLPattern, "5")
+			rr := httptest.NewRecorder()
+			svc.PublishTaxRecordDraftHandler(rr, req)
+
+			require.Equal(t, expectedStatus, rr.Code, principal.Name)
+		}
+	})
+}
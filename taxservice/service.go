@@ -4,14 +4,54 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/rezkam/TaxMan/internal/requestctx"
 	"github.com/rezkam/TaxMan/model"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer traces the tax rate lookups through the global tracer provider.
+var tracer = otel.Tracer("github.com/rezkam/TaxMan/taxservice")
+
+var (
+	// ErrApproverRequired is returned when a draft is approved without an authenticated actor.
+	ErrApproverRequired = errors.New("approving a draft requires an authenticated user")
+	// ErrSelfApproval is returned when the author of a draft tries to approve it.
+	ErrSelfApproval = errors.New("a draft must be approved by a different user than its author")
+	// ErrIfMatchRequired is returned when a tax record is changed without the If-Match header.
+	ErrIfMatchRequired = errors.New("the If-Match header with the ETag of the tax record is required")
 )
 
 // Service handles the business logic for managing municipality tax records.
 type Service struct {
 	store  taxStore
 	config Config
+	// cache holds recent rate lookups, it is nil when caching is disabled.
+	cache *rateCache
+	// recordHits, defaultFallbacks and notFound count the outcomes of the tax rate lookups.
+	recordHits       atomic.Uint64
+	defaultFallbacks atomic.Uint64
+	notFound         atomic.Uint64
+	// streamsClosed is closed by CloseChangeStreams to end the change streams.
+	streamsClosed    chan struct{}
+	closeStreamsOnce sync.Once
+}
+
+// TaxRateStats reports the outcomes of the tax rate lookups.
+type TaxRateStats struct {
+	// RecordHits are the lookups answered with the rate of a tax record.
+	RecordHits uint64
+	// DefaultFallbacks are the lookups answered with the default tax rate.
+	DefaultFallbacks uint64
+	// NotFound are the lookups answered with no rate.
+	NotFound uint64
 }
 
 type Config struct {
@@ -21,18 +61,77 @@ type Config struct {
 	MunicipalityURLPattern string
 	// DateURLPattern is the pattern used to extract the date from a URL.
 	DateURLPattern string
+	// RecordIDURLPattern is the pattern used to extract the ID of a tax record from a URL.
+	RecordIDURLPattern string
 	// DefaultTaxRate is the default tax rate to use if no specific rate is found for a municipality.
 	// This value is optional and can be nil.
 	DefaultTaxRate *float64
+	// CacheControlPolicies set the Cache-Control header of rate lookups by how long ago the queried date ended.
+	// The policy with the largest MinAge not exceeding that age applies, no header is sent if none does.
+	// This value is optional.
+	CacheControlPolicies []CacheControlPolicy
+	// RateCacheSize is the maximum number of rate lookups kept in memory, 0 disables the cache.
+	RateCacheSize int
+	// RateCacheTTL is how long a cached rate lookup is used before it is looked up again.
+	// It bounds how long changes go unnoticed when notifications of changes made by other instances are lost.
+	RateCacheTTL time.Duration
+	// ChangeStreamPollInterval is how often change streams check the change log for new changes.
+	// This value is optional and defaults to one second.
+	ChangeStreamPollInterval time.Duration
+	// RequireApproval disables direct writes of tax records, changes must then go through approved drafts.
+	RequireApproval bool
+	// MaxRequestBodySize is the maximum size in bytes of request bodies.
+	// This value is optional and defaults to jsonutils.DefaultMaxBodySize.
+	MaxRequestBodySize int64
 }
 
 type taxStore interface {
-	// AddOrUpdateTaxRecord adds a new tax record or updates an existing one.
-	AddOrUpdateTaxRecord(ctx context.Context, record model.TaxRecord) error
+	// AddOrUpdateTaxRecord adds a new tax record or updates an existing one at expectedVersion and returns the stored record.
+	AddOrUpdateTaxRecord(ctx context.Context, record model.TaxRecord, expectedVersion int64) (model.TaxRecord, error)
 
 	// GetTaxRecords retrieves all tax records for a municipality that match a specific date.
 	// The service layer will be responsible for selecting the most appropriate record.
 	GetTaxRecords(ctx context.Context, query model.TaxQuery) ([]model.TaxRecord, error)
+
+	// GetMunicipalityLastChange retrieves the ID and the time of the latest change to the tax records of a municipality,
+	// or 0 and the zero time if they were never changed. IDs increase with every committed change.
+	GetMunicipalityLastChange(ctx context.Context, municipality string) (int64, time.Time, error)
+
+	// GetTaxRecord retrieves a tax record by its ID, including soft-deleted records.
+	GetTaxRecord(ctx context.Context, recordID int64) (model.TaxRecord, error)
+
+	// UpdateTaxRecordRate changes the tax rate of a record if its version matches expectedVersion, 0 matches any version.
+	UpdateTaxRecordRate(ctx context.Context, recordID int64, taxRate float64, expectedVersion int64) (model.TaxRecord, error)
+
+	// DeleteTaxRecord soft-deletes a tax record if its version matches expectedVersion, 0 matches any version.
+	DeleteTaxRecord(ctx context.Context, recordID int64, expectedVersion int64) (model.TaxRecord, error)
+
+	// RestoreTaxRecord restores a soft-deleted tax record if its version matches expectedVersion, 0 matches any version.
+	RestoreTaxRecord(ctx context.Context, recordID int64, expectedVersion int64) (model.TaxRecord, error)
+
+	// GetTaxRecordHistory retrieves the changes made to a tax record, oldest first.
+	GetTaxRecordHistory(ctx context.Context, recordID int64) ([]model.TaxRecordHistoryEntry, error)
+
+	// GetTaxRecordChanges retrieves at most limit entries of the change log with an ID greater than afterID, oldest first.
+	GetTaxRecordChanges(ctx context.Context, afterID int64, limit int) ([]model.TaxRecordChange, error)
+
+	// CreateTaxRecordDraft stores a new draft of a tax record change, created by the actor in ctx.
+	CreateTaxRecordDraft(ctx context.Context, draft model.TaxRecordDraft) (model.TaxRecordDraft, error)
+
+	// GetTaxRecordDraft retrieves a draft by its ID.
+	GetTaxRecordDraft(ctx context.Context, draftID int64) (model.TaxRecordDraft, error)
+
+	// ListTaxRecordDrafts retrieves the drafts in the given status, or all drafts if status is empty.
+	ListTaxRecordDrafts(ctx context.Context, status model.DraftStatus) ([]model.TaxRecordDraft, error)
+
+	// TransitionTaxRecordDraft moves a draft from one status to the next, recording the actor in ctx as approver.
+	TransitionTaxRecordDraft(ctx context.Context, draftID int64, from, to model.DraftStatus) (model.TaxRecordDraft, error)
+
+	// PublishTaxRecordDraft writes the record of an approved draft to the tax records.
+	PublishTaxRecordDraft(ctx context.Context, draftID int64, now time.Time) (model.TaxRecordDraft, error)
+
+	// GetDueTaxRecordDraftIDs retrieves the IDs of the approved drafts scheduled to be published at or before now.
+	GetDueTaxRecordDraftIDs(ctx context.Context, now time.Time) ([]int64, error)
 }
 
 // New creates a new Service with the provided store and configuration.
@@ -40,7 +139,19 @@ func New(store taxStore, config Config) (*Service, error) {
 	if err := validateConfig(config); err != nil {
 		return nil, err
 	}
-	return &Service{store: store, config: config}, nil
+	policies, err := validateCacheControlPolicies(config.CacheControlPolicies)
+	if err != nil {
+		return nil, err
+	}
+	config.CacheControlPolicies = policies
+	if config.ChangeStreamPollInterval == 0 {
+		config.ChangeStreamPollInterval = defaultChangeStreamPollInterval
+	}
+	svc := &Service{store: store, config: config, streamsClosed: make(chan struct{})}
+	if config.RateCacheSize > 0 {
+		svc.cache = newRateCache(config.RateCacheSize, config.RateCacheTTL)
+	}
+	return svc, nil
 }
 
 // validateConfig checks if the provided Config values are valid.
@@ -57,6 +168,21 @@ func validateConfig(config Config) error {
 	if config.DateURLPattern == "" {
 		return errors.New("DatePattern cannot be empty")
 	}
+	if config.RecordIDURLPattern == "" {
+		return errors.New("RecordIDURLPattern cannot be empty")
+	}
+	if config.ChangeStreamPollInterval < 0 {
+		return errors.New("ChangeStreamPollInterval cannot be negative")
+	}
+	if config.RateCacheSize < 0 {
+		return errors.New("RateCacheSize cannot be negative")
+	}
+	if config.RateCacheSize > 0 && config.RateCacheTTL <= 0 {
+		return errors.New("RateCacheTTL must be greater than 0 when the rate cache is enabled")
+	}
+	if config.MaxRequestBodySize < 0 {
+		return errors.New("MaxRequestBodySize cannot be negative")
+	}
 	return nil
 }
 
@@ -64,11 +190,48 @@ func validateConfig(config Config) error {
 type TaxRateResponse struct {
 	TaxRate       float64
 	IsDefaultRate bool
+	// Record is the tax record the rate is taken from, nil for the default rate.
+	Record *model.TaxRecord
 }
 
-// GetTaxRate retrieves the tax rate for a municipality on a specific date.
+// GetTaxRate retrieves the tax rate for a municipality on a specific date and counts the outcome of the lookup.
 func (tx *Service) GetTaxRate(ctx context.Context, query model.TaxQuery) (TaxRateResponse, error) {
-	records, err := tx.store.GetTaxRecords(ctx, query)
+	ctx, span := tracer.Start(ctx, "Service.GetTaxRate", trace.WithAttributes(
+		attribute.String("taxman.municipality", query.Municipality),
+		attribute.String("taxman.date", query.Date.Format(time.DateOnly)),
+	))
+	defer span.End()
+
+	resp, err := tx.getTaxRate(ctx, query)
+	switch {
+	case err == nil && resp.IsDefaultRate:
+		tx.defaultFallbacks.Add(1)
+		span.SetAttributes(attribute.String("taxman.tax_rate.outcome", "default"))
+	case err == nil:
+		tx.recordHits.Add(1)
+		span.SetAttributes(attribute.String("taxman.tax_rate.outcome", "record"))
+	case errors.Is(err, model.ErrNotFound):
+		tx.notFound.Add(1)
+		span.SetAttributes(attribute.String("taxman.tax_rate.outcome", "not_found"))
+	default:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return resp, err
+}
+
+// TaxRateStats reports the outcomes of the tax rate lookups since the service was created.
+func (tx *Service) TaxRateStats() TaxRateStats {
+	return TaxRateStats{
+		RecordHits:       tx.recordHits.Load(),
+		DefaultFallbacks: tx.defaultFallbacks.Load(),
+		NotFound:         tx.notFound.Load(),
+	}
+}
+
+// getTaxRate retrieves the rate of the best matching tax record, falling back to the default tax rate.
+func (tx *Service) getTaxRate(ctx context.Context, query model.TaxQuery) (TaxRateResponse, error) {
+	records, err := tx.getTaxRecords(ctx, query)
 	if err != nil {
 		if errors.Is(err, model.ErrNotFound) && tx.config.DefaultTaxRate != nil {
 			return TaxRateResponse{TaxRate: *tx.config.DefaultTaxRate, IsDefaultRate: true}, nil
@@ -84,9 +247,10 @@ func (tx *Service) GetTaxRate(ctx context.Context, query model.TaxQuery) (TaxRat
 	}
 
 	// Select the best record based on business logic
-	bestRecord, err := tx.selectBestTaxRecord(records)
+	bestRecord, err := tx.selectBestTaxRecord(ctx, records)
 	if err == nil {
-		return TaxRateResponse{TaxRate: bestRecord.TaxRate, IsDefaultRate: false}, nil
+		record := *bestRecord
+		return TaxRateResponse{TaxRate: record.TaxRate, IsDefaultRate: false, Record: &record}, nil
 	}
 
 	// If no suitable record was found, fall back to the default tax rate if it exists
@@ -99,10 +263,75 @@ func (tx *Service) GetTaxRate(ctx context.Context, query model.TaxQuery) (TaxRat
 
 }
 
+// getTaxRecords retrieves the tax records matching query through the rate cache.
+// Lookups of past states with AsOf bypass the cache.
+func (tx *Service) getTaxRecords(ctx context.Context, query model.TaxQuery) ([]model.TaxRecord, error) {
+	if tx.cache == nil || !query.AsOf.IsZero() {
+		return tx.store.GetTaxRecords(ctx, query)
+	}
+
+	key := rateCacheKey{municipality: query.Municipality, date: query.Date}
+	records, generation, ok := tx.cache.get(key)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("taxman.rate_cache.hit", ok))
+	if ok {
+		return records, nil
+	}
+	records, err := tx.store.GetTaxRecords(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	tx.cache.put(key, records, generation)
+	return records, nil
+}
+
+// getMunicipalityLastChange retrieves the ID and time of the latest change of the records of a municipality
+// through the rate cache, so that cached lookups are validated without querying the store.
+func (tx *Service) getMunicipalityLastChange(ctx context.Context, municipality string) (int64, time.Time, error) {
+	if tx.cache == nil {
+		return tx.store.GetMunicipalityLastChange(ctx, municipality)
+	}
+
+	change, generation, ok := tx.cache.getLastChange(municipality)
+	if ok {
+		return change.id, change.lastModified, nil
+	}
+	changeID, lastModified, err := tx.store.GetMunicipalityLastChange(ctx, municipality)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	tx.cache.putLastChange(municipality, municipalityChange{id: changeID, lastModified: lastModified}, generation)
+	return changeID, lastModified, nil
+}
+
+// InvalidateTaxRates drops the cached rate lookups of a municipality after its records changed.
+func (tx *Service) InvalidateTaxRates(municipality string) {
+	if tx.cache != nil {
+		tx.cache.invalidate(municipality)
+	}
+}
+
+// InvalidateAllTaxRates drops every cached rate lookup, for when changes may have gone unnoticed.
+func (tx *Service) InvalidateAllTaxRates() {
+	if tx.cache != nil {
+		tx.cache.invalidateAll()
+	}
+}
+
+// RateCacheStats reports the usage of the rate lookup cache, all zero when caching is disabled.
+func (tx *Service) RateCacheStats() CacheStats {
+	if tx.cache == nil {
+		return CacheStats{}
+	}
+	return tx.cache.stats()
+}
+
 // selectBestTaxRecord selects the most appropriate tax record from a list of records.
 // if multiple tax rates apply to a specific date, the record with the highest priority period type is selected
 // if multiple records have the same period type, the record with the highest tax rate is selected
-func (tx *Service) selectBestTaxRecord(records []model.TaxRecord) (*model.TaxRecord, error) {
+func (tx *Service) selectBestTaxRecord(ctx context.Context, records []model.TaxRecord) (*model.TaxRecord, error) {
+	_, span := tracer.Start(ctx, "Service.selectBestTaxRecord", trace.WithAttributes(attribute.Int("taxman.records", len(records))))
+	defer span.End()
+
 	if len(records) == 0 {
 		return nil, fmt.Errorf("no tax records found")
 	}
@@ -128,3 +357,46 @@ func (tx *Service) selectBestTaxRecord(records []model.TaxRecord) (*model.TaxRec
 
 	return bestRecord, nil
 }
+
+// ApproveTaxRecordDraft approves a draft pending approval on behalf of the actor in ctx.
+// Drafts follow the four-eyes principle, the approver must be authenticated and differ from the author.
+func (tx *Service) ApproveTaxRecordDraft(ctx context.Context, draftID int64) (model.TaxRecordDraft, error) {
+	approver := requestctx.Actor(ctx)
+	if approver == requestctx.AnonymousActor {
+		return model.TaxRecordDraft{}, ErrApproverRequired
+	}
+
+	draft, err := tx.store.GetTaxRecordDraft(ctx, draftID)
+	if err != nil {
+		return model.TaxRecordDraft{}, err
+	}
+	if draft.CreatedBy == approver {
+		return model.TaxRecordDraft{}, ErrSelfApproval
+	}
+
+	return tx.store.TransitionTaxRecordDraft(ctx, draftID, model.DraftStatusPendingApproval, model.DraftStatusApproved)
+}
+
+// PublishDueTaxRecordDrafts publishes the approved drafts whose scheduled time has passed and returns how many were published.
+// Drafts published concurrently by another instance are skipped.
+func (tx *Service) PublishDueTaxRecordDrafts(ctx context.Context, now time.Time) (int, error) {
+	draftIDs, err := tx.store.GetDueTaxRecordDraftIDs(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, draftID := range draftIDs {
+		draft, err := tx.store.PublishTaxRecordDraft(ctx, draftID, now)
+		if errors.Is(err, model.ErrInvalidState) || errors.Is(err, model.ErrNotFound) || errors.Is(err, model.ErrNotDue) {
+			continue
+		}
+		if err != nil {
+			slog.Error("failed to publish scheduled tax record draft", "draftID", draftID, "error", err)
+			continue
+		}
+		tx.InvalidateTaxRates(draft.Record.Municipality)
+		published++
+	}
+	return published, nil
+}
@@ -6,9 +6,15 @@ import (
 	"testing"
 	"time"
 
+	"github.com/rezkam/TaxMan/internal/requestctx"
 	"github.com/rezkam/TaxMan/internal/utils"
 	"github.com/rezkam/TaxMan/model"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func TestGetTaxRate(t *testing.T) {
@@ -27,6 +33,7 @@ func TestGetTaxRate(t *testing.T) {
 			MaxMunicipalityNameLength: 20,
 			MunicipalityURLPattern:    "municipality",
 			DateURLPattern:            "date",
+			RecordIDURLPattern:        "id",
 		})
 		require.NoError(t, err)
 
@@ -35,6 +42,7 @@ func TestGetTaxRate(t *testing.T) {
 		require.NoError(t, err)
 		require.False(t, resp.IsDefaultRate)
 		require.Equal(t, 0.5, resp.TaxRate)
+		require.Equal(t, TaxRateStats{RecordHits: 1}, svc.TaxRateStats())
 	})
 
 	t.Run("fallback to default rate when no records found", func(t *testing.T) {
@@ -48,6 +56,7 @@ func TestGetTaxRate(t *testing.T) {
 			MaxMunicipalityNameLength: 20,
 			MunicipalityURLPattern:    "municipality",
 			DateURLPattern:            "date",
+			RecordIDURLPattern:        "id",
 		})
 		require.NoError(t, err)
 
@@ -56,6 +65,7 @@ func TestGetTaxRate(t *testing.T) {
 		require.NoError(t, err)
 		require.True(t, resp.IsDefaultRate)
 		require.Equal(t, defaultTaxRate, resp.TaxRate)
+		require.Equal(t, TaxRateStats{DefaultFallbacks: 1}, svc.TaxRateStats())
 	})
 
 	t.Run("error when no records found and no default rate", func(t *testing.T) {
@@ -68,6 +78,7 @@ func TestGetTaxRate(t *testing.T) {
 			MaxMunicipalityNameLength: 20,
 			MunicipalityURLPattern:    "municipality",
 			DateURLPattern:            "date",
+			RecordIDURLPattern:        "id",
 		})
 		require.NoError(t, err)
 
@@ -76,6 +87,7 @@ func TestGetTaxRate(t *testing.T) {
 		require.Error(t, err)
 		require.Equal(t, model.ErrNotFound, err)
 		require.Equal(t, 0.0, resp.TaxRate)
+		require.Equal(t, TaxRateStats{NotFound: 1}, svc.TaxRateStats())
 	})
 
 	t.Run("error from store", func(t *testing.T) {
@@ -88,6 +100,7 @@ func TestGetTaxRate(t *testing.T) {
 			MaxMunicipalityNameLength: 20,
 			MunicipalityURLPattern:    "municipality",
 			DateURLPattern:            "date",
+			RecordIDURLPattern:        "id",
 		})
 		require.NoError(t, err)
 
@@ -96,14 +109,95 @@ func TestGetTaxRate(t *testing.T) {
 		require.Error(t, err)
 		require.Equal(t, "store error", err.Error())
 		require.Equal(t, 0.0, resp.TaxRate)
+		require.Equal(t, TaxRateStats{}, svc.TaxRateStats())
 	})
 }
 
+func TestGetTaxRateTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	mockStore := &mockStore{
+		getTaxRecordsFunc: func(ctx context.Context, query model.TaxQuery) ([]model.TaxRecord, error) {
+			// The store continues the trace of the lookup
+			require.True(t, trace.SpanFromContext(ctx).SpanContext().IsValid())
+			return []model.TaxRecord{{TaxRate: 0.2, PeriodType: model.Yearly}}, nil
+		},
+	}
+	svc, err := New(mockStore, Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+		RateCacheSize:             10,
+		RateCacheTTL:              time.Minute,
+	})
+	require.NoError(t, err)
+
+	query := model.TaxQuery{Municipality: "Copenhagen", Date: utils.DateOnly(2024, time.March, 1)}
+	_, err = svc.GetTaxRate(context.Background(), query)
+	require.NoError(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	selectSpan, lookupSpan := spans[0], spans[1]
+	require.Equal(t, "Service.selectBestTaxRecord", selectSpan.Name())
+	require.Equal(t, "Service.GetTaxRate", lookupSpan.Name())
+	require.Equal(t, lookupSpan.SpanContext().SpanID(), selectSpan.Parent().SpanID())
+	require.Contains(t, lookupSpan.Attributes(), attribute.String("taxman.municipality", "Copenhagen"))
+	require.Contains(t, lookupSpan.Attributes(), attribute.String("taxman.tax_rate.outcome", "record"))
+	require.Contains(t, lookupSpan.Attributes(), attribute.Bool("taxman.rate_cache.hit", false))
+}
+
+func TestGetTaxRateCache(t *testing.T) {
+	lookups := 0
+	mockStore := &mockStore{
+		getTaxRecordsFunc: func(ctx context.Context, query model.TaxQuery) ([]model.TaxRecord, error) {
+			lookups++
+			return []model.TaxRecord{{TaxRate: 0.2, PeriodType: model.Yearly}}, nil
+		},
+	}
+	svc, err := New(mockStore, Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+		RateCacheSize:             10,
+		RateCacheTTL:              time.Minute,
+	})
+	require.NoError(t, err)
+
+	query := model.TaxQuery{Municipality: "Copenhagen", Date: utils.DateOnly(2024, time.March, 1)}
+
+	for range 3 {
+		resp, err := svc.GetTaxRate(context.Background(), query)
+		require.NoError(t, err)
+		require.Equal(t, 0.2, resp.TaxRate)
+	}
+	require.Equal(t, 1, lookups)
+	require.Equal(t, CacheStats{Hits: 2, Misses: 1, Entries: 1}, svc.RateCacheStats())
+
+	// Lookups of past states are not cached
+	asOfQuery := query
+	asOfQuery.AsOf = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err = svc.GetTaxRate(context.Background(), asOfQuery)
+	require.NoError(t, err)
+	require.Equal(t, 2, lookups)
+
+	svc.InvalidateTaxRates("Copenhagen")
+	_, err = svc.GetTaxRate(context.Background(), query)
+	require.NoError(t, err)
+	require.Equal(t, 3, lookups)
+}
+
 func TestSelectBestTaxRecord(t *testing.T) {
 	svc, _ := New(nil, Config{
 		MaxMunicipalityNameLength: 20,
 		MunicipalityURLPattern:    "municipality",
 		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
 	})
 
 	t.Run("select best record by period type priority", func(t *testing.T) {
@@ -113,7 +207,7 @@ func TestSelectBestTaxRecord(t *testing.T) {
 			{Municipality: "Copenhagen", TaxRate: 0.1, PeriodType: model.Daily},
 		}
 
-		bestRecord, err := svc.selectBestTaxRecord(records)
+		bestRecord, err := svc.selectBestTaxRecord(context.Background(), records)
 		require.NoError(t, err)
 		require.Equal(t, 0.1, bestRecord.TaxRate)
 		require.Equal(t, model.Daily, bestRecord.PeriodType)
@@ -125,7 +219,7 @@ func TestSelectBestTaxRecord(t *testing.T) {
 			{Municipality: "Copenhagen", TaxRate: 0.3, PeriodType: model.Yearly},
 		}
 
-		bestRecord, err := svc.selectBestTaxRecord(records)
+		bestRecord, err := svc.selectBestTaxRecord(context.Background(), records)
 		require.NoError(t, err)
 		require.Equal(t, 0.3, bestRecord.TaxRate)
 		require.Equal(t, model.Yearly, bestRecord.PeriodType)
@@ -133,8 +227,81 @@ func TestSelectBestTaxRecord(t *testing.T) {
 
 	t.Run("error if no records provided", func(t *testing.T) {
 		var records []model.TaxRecord
-		_, err := svc.selectBestTaxRecord(records)
+		_, err := svc.selectBestTaxRecord(context.Background(), records)
 		require.Error(t, err)
 		require.Equal(t, "no tax records found", err.Error())
 	})
 }
+
+func TestApproveTaxRecordDraft(t *testing.T) {
+	config := Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+	}
+	mockStore := &mockStore{
+		getTaxRecordDraftFunc: func(ctx context.Context, draftID int64) (model.TaxRecordDraft, error) {
+			return model.TaxRecordDraft{ID: draftID, Status: model.DraftStatusPendingApproval, CreatedBy: "author"}, nil
+		},
+		transitionTaxRecordDraftFunc: func(ctx context.Context, draftID int64, from, to model.DraftStatus) (model.TaxRecordDraft, error) {
+			require.Equal(t, model.DraftStatusPendingApproval, from)
+			require.Equal(t, model.DraftStatusApproved, to)
+			return model.TaxRecordDraft{ID: draftID, Status: to, CreatedBy: "author", ApprovedBy: requestctx.Actor(ctx)}, nil
+		},
+	}
+	svc, err := New(mockStore, config)
+	require.NoError(t, err)
+
+	t.Run("approved by another user", func(t *testing.T) {
+		ctx := requestctx.WithActor(context.Background(), "reviewer")
+		draft, err := svc.ApproveTaxRecordDraft(ctx, 1)
+		require.NoError(t, err)
+		require.Equal(t, model.DraftStatusApproved, draft.Status)
+		require.Equal(t, "reviewer", draft.ApprovedBy)
+	})
+
+	t.Run("author cannot approve", func(t *testing.T) {
+		ctx := requestctx.WithActor(context.Background(), "author")
+		_, err := svc.ApproveTaxRecordDraft(ctx, 1)
+		require.ErrorIs(t, err, ErrSelfApproval)
+	})
+
+	t.Run("anonymous cannot approve", func(t *testing.T) {
+		_, err := svc.ApproveTaxRecordDraft(context.Background(), 1)
+		require.ErrorIs(t, err, ErrApproverRequired)
+	})
+}
+
+func TestPublishDueTaxRecordDrafts(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var publishedIDs []int64
+	mockStore := &mockStore{
+		getDueTaxRecordDraftIDsFunc: func(ctx context.Context, dueAt time.Time) ([]int64, error) {
+			require.Equal(t, now, dueAt)
+			return []int64{1, 2, 3}, nil
+		},
+		publishTaxRecordDraftFunc: func(ctx context.Context, draftID int64, now time.Time) (model.TaxRecordDraft, error) {
+			switch draftID {
+			case 2:
+				return model.TaxRecordDraft{}, model.ErrInvalidState
+			case 3:
+				return model.TaxRecordDraft{}, errors.New("store error")
+			}
+			publishedIDs = append(publishedIDs, draftID)
+			return model.TaxRecordDraft{ID: draftID, Status: model.DraftStatusPublished}, nil
+		},
+	}
+	svc, err := New(mockStore, Config{
+		MaxMunicipalityNameLength: 20,
+		MunicipalityURLPattern:    "municipality",
+		DateURLPattern:            "date",
+		RecordIDURLPattern:        "id",
+	})
+	require.NoError(t, err)
+
+	published, err := svc.PublishDueTaxRecordDrafts(context.Background(), now)
+	require.NoError(t, err)
+	require.Equal(t, 1, published)
+	require.Equal(t, []int64{1}, publishedIDs)
+}
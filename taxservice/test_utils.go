@@ -2,19 +2,35 @@ package taxservice
 
 import (
 	"context"
+	"time"
+
 	"github.com/rezkam/TaxMan/model"
 )
 
 type mockStore struct {
-	addOrUpdateTaxRecordFunc func(ctx context.Context, record model.TaxRecord) error
-	getTaxRecordsFunc        func(ctx context.Context, query model.TaxQuery) ([]model.TaxRecord, error)
+	addOrUpdateTaxRecordFunc      func(ctx context.Context, record model.TaxRecord, expectedVersion int64) (model.TaxRecord, error)
+	getTaxRecordsFunc             func(ctx context.Context, query model.TaxQuery) ([]model.TaxRecord, error)
+	getMunicipalityLastChangeFunc func(ctx context.Context, municipality string) (int64, time.Time, error)
+	getTaxRecordFunc              func(ctx context.Context, recordID int64) (model.TaxRecord, error)
+	updateTaxRecordRateFunc       func(ctx context.Context, recordID int64, taxRate float64, expectedVersion int64) (model.TaxRecord, error)
+	deleteTaxRecordFunc           func(ctx context.Context, recordID int64, expectedVersion int64) (model.TaxRecord, error)
+	restoreTaxRecordFunc          func(ctx context.Context, recordID int64, expectedVersion int64) (model.TaxRecord, error)
+	getTaxRecordHistoryFunc       func(ctx context.Context, recordID int64) ([]model.TaxRecordHistoryEntry, error)
+	getTaxRecordChangesFunc       func(ctx context.Context, afterID int64, limit int) ([]model.TaxRecordChange, error)
+
+	createTaxRecordDraftFunc     func(ctx context.Context, draft model.TaxRecordDraft) (model.TaxRecordDraft, error)
+	getTaxRecordDraftFunc        func(ctx context.Context, draftID int64) (model.TaxRecordDraft, error)
+	listTaxRecordDraftsFunc      func(ctx context.Context, status model.DraftStatus) ([]model.TaxRecordDraft, error)
+	transitionTaxRecordDraftFunc func(ctx context.Context, draftID int64, from, to model.DraftStatus) (model.TaxRecordDraft, error)
+	publishTaxRecordDraftFunc    func(ctx context.Context, draftID int64, now time.Time) (model.TaxRecordDraft, error)
+	getDueTaxRecordDraftIDsFunc  func(ctx context.Context, now time.Time) ([]int64, error)
 }
 
-func (m *mockStore) AddOrUpdateTaxRecord(ctx context.Context, record model.TaxRecord) error {
+func (m *mockStore) AddOrUpdateTaxRecord(ctx context.Context, record model.TaxRecord, expectedVersion int64) (model.TaxRecord, error) {
 	if m.addOrUpdateTaxRecordFunc != nil {
-		return m.addOrUpdateTaxRecordFunc(ctx, record)
+		return m.addOrUpdateTaxRecordFunc(ctx, record, expectedVersion)
 	}
-	return nil
+	return record, nil
 }
 
 func (m *mockStore) GetTaxRecords(ctx context.Context, query model.TaxQuery) ([]model.TaxRecord, error) {
@@ -23,3 +39,94 @@ func (m *mockStore) GetTaxRecords(ctx context.Context, query model.TaxQuery) ([]
 	}
 	return nil, nil
 }
+
+func (m *mockStore) GetMunicipalityLastChange(ctx context.Context, municipality string) (int64, time.Time, error) {
+	if m.getMunicipalityLastChangeFunc != nil {
+		return m.getMunicipalityLastChangeFunc(ctx, municipality)
+	}
+	return 0, time.Time{}, nil
+}
+
+func (m *mockStore) GetTaxRecord(ctx context.Context, recordID int64) (model.TaxRecord, error) {
+	if m.getTaxRecordFunc != nil {
+		return m.getTaxRecordFunc(ctx, recordID)
+	}
+	return model.TaxRecord{ID: recordID, Version: 1}, nil
+}
+
+func (m *mockStore) UpdateTaxRecordRate(ctx context.Context, recordID int64, taxRate float64, expectedVersion int64) (model.TaxRecord, error) {
+	if m.updateTaxRecordRateFunc != nil {
+		return m.updateTaxRecordRateFunc(ctx, recordID, taxRate, expectedVersion)
+	}
+	return model.TaxRecord{ID: recordID, TaxRate: taxRate, Version: expectedVersion + 1}, nil
+}
+
+func (m *mockStore) DeleteTaxRecord(ctx context.Context, recordID int64, expectedVersion int64) (model.TaxRecord, error) {
+	if m.deleteTaxRecordFunc != nil {
+		return m.deleteTaxRecordFunc(ctx, recordID, expectedVersion)
+	}
+	return model.TaxRecord{ID: recordID, Version: expectedVersion + 1}, nil
+}
+
+func (m *mockStore) RestoreTaxRecord(ctx context.Context, recordID int64, expectedVersion int64) (model.TaxRecord, error) {
+	if m.restoreTaxRecordFunc != nil {
+		return m.restoreTaxRecordFunc(ctx, recordID, expectedVersion)
+	}
+	return model.TaxRecord{ID: recordID, Version: expectedVersion + 1}, nil
+}
+
+func (m *mockStore) GetTaxRecordHistory(ctx context.Context, recordID int64) ([]model.TaxRecordHistoryEntry, error) {
+	if m.getTaxRecordHistoryFunc != nil {
+		return m.getTaxRecordHistoryFunc(ctx, recordID)
+	}
+	return nil, nil
+}
+
+func (m *mockStore) GetTaxRecordChanges(ctx context.Context, afterID int64, limit int) ([]model.TaxRecordChange, error) {
+	if m.getTaxRecordChangesFunc != nil {
+		return m.getTaxRecordChangesFunc(ctx, afterID, limit)
+	}
+	return nil, nil
+}
+
+func (m *mockStore) CreateTaxRecordDraft(ctx context.Context, draft model.TaxRecordDraft) (model.TaxRecordDraft, error) {
+	if m.createTaxRecordDraftFunc != nil {
+		return m.createTaxRecordDraftFunc(ctx, draft)
+	}
+	return draft, nil
+}
+
+func (m *mockStore) GetTaxRecordDraft(ctx context.Context, draftID int64) (model.TaxRecordDraft, error) {
+	if m.getTaxRecordDraftFunc != nil {
+		return m.getTaxRecordDraftFunc(ctx, draftID)
+	}
+	return model.TaxRecordDraft{ID: draftID}, nil
+}
+
+func (m *mockStore) ListTaxRecordDrafts(ctx context.Context, status model.DraftStatus) ([]model.TaxRecordDraft, error) {
+	if m.listTaxRecordDraftsFunc != nil {
+		return m.listTaxRecordDraftsFunc(ctx, status)
+	}
+	return nil, nil
+}
+
+func (m *mockStore) TransitionTaxRecordDraft(ctx context.Context, draftID int64, from, to model.DraftStatus) (model.TaxRecordDraft, error) {
+	if m.transitionTaxRecordDraftFunc != nil {
+		return m.transitionTaxRecordDraftFunc(ctx, draftID, from, to)
+	}
+	return model.TaxRecordDraft{ID: draftID, Status: to}, nil
+}
+
+func (m *mockStore) PublishTaxRecordDraft(ctx context.Context, draftID int64, now time.Time) (model.TaxRecordDraft, error) {
+	if m.publishTaxRecordDraftFunc != nil {
+		return m.publishTaxRecordDraftFunc(ctx, draftID, now)
+	}
+	return model.TaxRecordDraft{ID: draftID, Status: model.DraftStatusPublished}, nil
+}
+
+func (m *mockStore) GetDueTaxRecordDraftIDs(ctx context.Context, now time.Time) ([]int64, error) {
+	if m.getDueTaxRecordDraftIDsFunc != nil {
+		return m.getDueTaxRecordDraftIDsFunc(ctx, now)
+	}
+	return nil, nil
+}
@@ -1,19 +1,42 @@
 package taxservice
 
-import "github.com/rezkam/TaxMan/model"
+import (
+	"time"
+
+	"github.com/rezkam/TaxMan/model"
+)
 
 // AddOrUpdateTaxRecordRequest is the request type for adding or updating a tax record.
 type AddOrUpdateTaxRecordRequest struct {
 	Municipality string           `json:"municipality"`
 	TaxRate      float64          `json:"tax_rate"`
 	StartDate    string           `json:"start_date"`
-	EndDate      string           `json:"end_date"`
+	EndDate      string           `json:"end_date,omitempty"`
 	PeriodType   model.PeriodType `json:"period_type"`
 }
 
 // AddOrUpdateTaxRecordResponse is the response type for adding or updating a tax record.
 type AddOrUpdateTaxRecordResponse struct {
-	Success bool `json:"success"`
+	Success bool  `json:"success"`
+	ID      int64 `json:"id"`
+	Version int64 `json:"version"`
+}
+
+// UpdateTaxRecordRequest is the request type for changing the tax rate of an existing tax record.
+type UpdateTaxRecordRequest struct {
+	TaxRate float64 `json:"tax_rate"`
+}
+
+// TaxRecordResponse is the response type for a single tax record.
+type TaxRecordResponse struct {
+	ID           int64            `json:"id"`
+	Municipality string           `json:"municipality"`
+	TaxRate      float64          `json:"tax_rate"`
+	StartDate    string           `json:"start_date"`
+	EndDate      string           `json:"end_date,omitempty"`
+	PeriodType   model.PeriodType `json:"period_type"`
+	Version      int64            `json:"version"`
+	DeletedAt    *time.Time       `json:"deleted_at,omitempty"`
 }
 
 // GetTaxRateResponse is the response type for retrieving the tax rate for a municipality on a given date.
@@ -22,4 +45,99 @@ type GetTaxRateResponse struct {
 	Date          string  `json:"date"`
 	TaxRate       float64 `json:"tax_rate"`
 	IsDefaultRate bool    `json:"is_default_rate"`
+	AsOf          string  `json:"as_of,omitempty"`
+}
+
+// GetTaxRateV2Response is the v2 response type for retrieving the tax rate for a municipality on a given date.
+// The rate is a decimal string, so that clients do not parse it into binary floating point.
+type GetTaxRateV2Response struct {
+	Municipality string `json:"municipality"`
+	Date         string `json:"date"`
+	TaxRate      string `json:"tax_rate"`
+	// Category is the period type of the tax record the rate is taken from, or "default" for the default rate.
+	Category string `json:"category"`
+	// RecordID is the ID of the tax record the rate is taken from, omitted for the default rate.
+	RecordID *int64 `json:"record_id,omitempty"`
+	AsOf     string `json:"as_of,omitempty"`
+}
+
+// DeleteTaxRecordResponse is the response type for deleting a tax record.
+type DeleteTaxRecordResponse struct {
+	Success bool  `json:"success"`
+	ID      int64 `json:"id"`
+	Version int64 `json:"version"`
+}
+
+// RestoreTaxRecordResponse is the response type for restoring a deleted tax record.
+type RestoreTaxRecordResponse struct {
+	Success bool  `json:"success"`
+	ID      int64 `json:"id"`
+	Version int64 `json:"version"`
+}
+
+// TaxRecordStateResponse is the state of a tax record's values at one point in its history.
+type TaxRecordStateResponse struct {
+	TaxRate   float64 `json:"tax_rate"`
+	StartDate string  `json:"start_date"`
+	EndDate   string  `json:"end_date,omitempty"`
+}
+
+// TaxRecordHistoryEntryResponse is a single change made to a tax record.
+type TaxRecordHistoryEntryResponse struct {
+	ID        int64                   `json:"id"`
+	Action    model.ChangeAction      `json:"action"`
+	OldValue  *TaxRecordStateResponse `json:"old_value"`
+	NewValue  *TaxRecordStateResponse `json:"new_value"`
+	Actor     string                  `json:"actor"`
+	RequestID string                  `json:"request_id,omitempty"`
+	ChangedAt time.Time               `json:"changed_at"`
+}
+
+// GetTaxRecordHistoryResponse is the response type for retrieving the history of a tax record.
+type GetTaxRecordHistoryResponse struct {
+	RecordID int64                           `json:"record_id"`
+	History  []TaxRecordHistoryEntryResponse `json:"history"`
+}
+
+// TaxRecordChangeResponse is a change of a tax record sent as the data of a change stream event.
+// The values are the record's state after the change, or before it for deletions.
+type TaxRecordChangeResponse struct {
+	ID           int64              `json:"id"`
+	Action       model.ChangeAction `json:"action"`
+	RecordID     int64              `json:"record_id"`
+	Municipality string             `json:"municipality"`
+	TaxRate      float64            `json:"tax_rate"`
+	StartDate    string             `json:"start_date"`
+	EndDate      string             `json:"end_date,omitempty"`
+	PeriodType   model.PeriodType   `json:"period_type"`
+	ChangedAt    time.Time          `json:"changed_at"`
+}
+
+// CreateTaxRecordDraftRequest is the request type for drafting a tax record change that requires approval.
+type CreateTaxRecordDraftRequest struct {
+	AddOrUpdateTaxRecordRequest
+	// PublishAt is an optional RFC 3339 timestamp at which the draft is published once approved.
+	PublishAt string `json:"publish_at,omitempty"`
+}
+
+// TaxRecordDraftResponse is the response type for a tax record draft.
+type TaxRecordDraftResponse struct {
+	ID                int64             `json:"id"`
+	Municipality      string            `json:"municipality"`
+	TaxRate           float64           `json:"tax_rate"`
+	StartDate         string            `json:"start_date"`
+	EndDate           string            `json:"end_date,omitempty"`
+	PeriodType        model.PeriodType  `json:"period_type"`
+	Status            model.DraftStatus `json:"status"`
+	PublishAt         *time.Time        `json:"publish_at,omitempty"`
+	CreatedBy         string            `json:"created_by"`
+	ApprovedBy        string            `json:"approved_by,omitempty"`
+	PublishedRecordID int64             `json:"published_record_id,omitempty"`
+	CreatedAt         time.Time         `json:"created_at"`
+	UpdatedAt         time.Time         `json:"updated_at"`
+}
+
+// ListTaxRecordDraftsResponse is the response type for listing tax record drafts.
+type ListTaxRecordDraftsResponse struct {
+	Drafts []TaxRecordDraftResponse `json:"drafts"`
 }
@@ -17,6 +17,7 @@ import (
 
 const municipalityWildcardName = "municipality"
 const dateWildcardName = "date"
+const recordIDWildcardName = "id"
 
 var postgresStore *store.PostgresStore
 
@@ -48,12 +49,14 @@ func setupTestServer(t *testing.T) *httptest.Server {
 	svc, err := taxservice.New(postgresStore, taxservice.Config{
 		MunicipalityURLPattern:    municipalityWildcardName,
 		DateURLPattern:            dateWildcardName,
+		RecordIDURLPattern:        recordIDWildcardName,
 		MaxMunicipalityNameLength: 100,
 	})
 	require.NoError(t, err)
 
 	mux := http.NewServeMux()
 	routes.SetupTaxRoutes(svc, mux)
+	routes.SetupTaxRoutes(svc, routes.NewGroup(mux, routes.V1Prefix))
 
 	return httptest.NewServer(mux)
 }
@@ -233,8 +236,19 @@ func TestUpdateExistingTaxRecord(t *testing.T) {
 	}
 	reqBody, err = json.Marshal(updatedRecord)
 	require.NoError(t, err)
+	etag := resp.Header.Get("ETag")
+
+	// Overwriting the record requires its ETag
 	resp, err = http.Post(ts.URL+"/tax", "application/json", bytes.NewReader(reqBody))
 	require.NoError(t, err)
+	require.Equal(t, http.StatusPreconditionRequired, resp.StatusCode)
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/tax", bytes.NewReader(reqBody))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 
 	// Fetch the tax rate to verify it has been updated
@@ -247,3 +261,34 @@ func TestUpdateExistingTaxRecord(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, 0.15, respBody.TaxRate)
 }
+
+func TestV1Routes(t *testing.T) {
+	ts := setupTestServer(t)
+	defer ts.Close()
+
+	cleanupDatabase(t)
+
+	// Add a tax record through the v1 route
+	record := taxservice.AddOrUpdateTaxRecordRequest{
+		Municipality: "Copenhagen",
+		TaxRate:      0.2,
+		StartDate:    "2024-01-01",
+		EndDate:      "2024-12-31",
+		PeriodType:   "yearly",
+	}
+	reqBody, err := json.Marshal(record)
+	require.NoError(t, err)
+	resp, err := http.Post(ts.URL+"/v1/tax", "application/json", bytes.NewReader(reqBody))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Fetch the tax rate through the v1 route
+	resp, err = http.Get(ts.URL + "/v1/tax/Copenhagen/2024-06-01")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var respBody taxservice.GetTaxRateResponse
+	err = json.NewDecoder(resp.Body).Decode(&respBody)
+	require.NoError(t, err)
+	require.Equal(t, 0.2, respBody.TaxRate)
+}
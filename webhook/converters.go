@@ -0,0 +1,122 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/rezkam/TaxMan/internal/problem"
+	"github.com/rezkam/TaxMan/model"
+)
+
+const (
+	// generatedSecretSize is the number of random bytes of a generated subscription secret.
+	generatedSecretSize = 32
+	// defaultDeliveryAttemptsLimit is how many delivery attempts are listed when no limit is given.
+	defaultDeliveryAttemptsLimit = 50
+	// maxDeliveryAttemptsLimit is the maximum number of delivery attempts listed at once.
+	maxDeliveryAttemptsLimit = 500
+)
+
+// CreateWebhookSubscriptionRequestToModel converts and validates the request for subscribing an endpoint.
+// A random secret is generated when the request has none.
+func (s *Service) CreateWebhookSubscriptionRequestToModel(req CreateWebhookSubscriptionRequest) (model.WebhookSubscription, error) {
+	endpoint, err := url.Parse(req.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return model.WebhookSubscription{}, problem.Invalid("url", problem.CodeInvalidURL, "url must be an absolute http or https url")
+	}
+	if utf8.RuneCountInString(req.Municipality) > s.config.MaxMunicipalityNameLength {
+		return model.WebhookSubscription{}, problem.Invalid("municipality", problem.CodeMunicipalityTooLong, "municipality name exceeds maximum length")
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		random := make([]byte, generatedSecretSize)
+		if _, err := rand.Read(random); err != nil {
+			return model.WebhookSubscription{}, fmt.Errorf("failed to generate secret: %w", err)
+		}
+		secret = hex.EncodeToString(random)
+	}
+
+	return model.WebhookSubscription{URL: req.URL, Municipality: req.Municipality, Secret: secret}, nil
+}
+
+// SubscriptionIDRequestToModel parses and validates the ID of a webhook subscription taken from the URL.
+func (s *Service) SubscriptionIDRequestToModel(id string) (int64, error) {
+	if id == "" {
+		return 0, problem.Invalid("id", problem.CodeInvalidID, "subscription id is required")
+	}
+	parsedID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || parsedID <= 0 {
+		return 0, problem.Invalid("id", problem.CodeInvalidID, "invalid subscription id")
+	}
+	return parsedID, nil
+}
+
+// LimitRequestToModel parses the optional limit of listed delivery attempts.
+func (s *Service) LimitRequestToModel(limit string) (int, error) {
+	if limit == "" {
+		return defaultDeliveryAttemptsLimit, nil
+	}
+	parsedLimit, err := strconv.Atoi(limit)
+	if err != nil || parsedLimit <= 0 || parsedLimit > maxDeliveryAttemptsLimit {
+		return 0, problem.Invalid("limit", problem.CodeInvalidLimit, fmt.Sprintf("limit must be between 1 and %d", maxDeliveryAttemptsLimit))
+	}
+	return parsedLimit, nil
+}
+
+// WebhookSubscriptionToResponse converts a webhook subscription to its response type, without its secret.
+func WebhookSubscriptionToResponse(subscription model.WebhookSubscription) WebhookSubscriptionResponse {
+	return WebhookSubscriptionResponse{
+		ID:           subscription.ID,
+		URL:          subscription.URL,
+		Municipality: subscription.Municipality,
+		CreatedAt:    subscription.CreatedAt,
+	}
+}
+
+// WebhookDeliveryAttemptToResponse converts a delivery attempt to its response type.
+func WebhookDeliveryAttemptToResponse(attempt model.WebhookDeliveryAttempt) WebhookDeliveryAttemptResponse {
+	return WebhookDeliveryAttemptResponse{
+		ID:          attempt.ID,
+		DeliveryID:  attempt.DeliveryID,
+		Attempt:     attempt.Attempt,
+		StatusCode:  attempt.StatusCode,
+		Error:       attempt.Error,
+		DurationMs:  attempt.Duration.Milliseconds(),
+		AttemptedAt: attempt.AttemptedAt,
+	}
+}
+
+// WebhookDeadLetterToResponse converts a dead letter to its response type.
+func WebhookDeadLetterToResponse(deadLetter model.WebhookDeadLetter) WebhookDeadLetterResponse {
+	return WebhookDeadLetterResponse{
+		ID:         deadLetter.ID,
+		DeliveryID: deadLetter.DeliveryID,
+		ChangeID:   deadLetter.ChangeID,
+		Attempts:   deadLetter.Attempts,
+		LastError:  deadLetter.LastError,
+		CreatedAt:  deadLetter.CreatedAt,
+	}
+}
+
+// ChangeToPayload converts a change of a tax record to the body of its notification.
+func ChangeToPayload(change model.TaxRecordChange) Payload {
+	payload := Payload{
+		ID:           change.ID,
+		Action:       change.Action,
+		RecordID:     change.Record.ID,
+		Municipality: change.Record.Municipality,
+		TaxRate:      change.Record.TaxRate,
+		StartDate:    change.Record.StartDate.Format("2006-01-02"),
+		PeriodType:   change.Record.PeriodType,
+		ChangedAt:    change.ChangedAt,
+	}
+	if !change.Record.IsOpenEnded() {
+		payload.EndDate = change.Record.EndDate.Format("2006-01-02")
+	}
+	return payload
+}
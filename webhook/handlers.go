@@ -0,0 +1,144 @@
+package webhook
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/rezkam/TaxMan/internal/jsonutils"
+	"github.com/rezkam/TaxMan/internal/problem"
+	"github.com/rezkam/TaxMan/model"
+)
+
+func (s *Service) CreateWebhookSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateWebhookSubscriptionRequest
+	if err := jsonutils.DecodeRequest(w, r, &req, s.config.MaxRequestBodySize); err != nil {
+		problem.WriteDecodeError(w, r, err)
+		return
+	}
+
+	subscription, err := s.CreateWebhookSubscriptionRequestToModel(req)
+	if err != nil {
+		problem.WriteInvalid(w, r, err)
+		return
+	}
+
+	created, err := s.store.CreateWebhookSubscription(r.Context(), subscription)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to create webhook subscription", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternalError, "failed to create webhook subscription")
+		return
+	}
+
+	// The secret is only shown once, so that it is not exposed by later reads
+	resp := WebhookSubscriptionToResponse(created)
+	resp.Secret = created.Secret
+	jsonutils.JsonResponse(w, resp, http.StatusCreated)
+}
+
+func (s *Service) ListWebhookSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := s.store.ListWebhookSubscriptions(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list webhook subscriptions", "error", err)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternalError, "failed to list webhook subscriptions")
+		return
+	}
+
+	resp := ListWebhookSubscriptionsResponse{Subscriptions: make([]WebhookSubscriptionResponse, 0, len(subscriptions))}
+	for _, subscription := range subscriptions {
+		resp.Subscriptions = append(resp.Subscriptions, WebhookSubscriptionToResponse(subscription))
+	}
+	jsonutils.JsonResponse(w, resp, http.StatusOK)
+}
+
+func (s *Service) GetWebhookSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptionID, err := s.SubscriptionIDRequestToModel(r.PathValue(s.config.SubscriptionIDURLPattern))
+	if err != nil {
+		problem.WriteInvalid(w, r, err)
+		return
+	}
+
+	subscription, err := s.store.GetWebhookSubscription(r.Context(), subscriptionID)
+	if err != nil {
+		writeSubscriptionError(w, r, err, "failed to get webhook subscription")
+		return
+	}
+	jsonutils.JsonResponse(w, WebhookSubscriptionToResponse(subscription), http.StatusOK)
+}
+
+func (s *Service) DeleteWebhookSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptionID, err := s.SubscriptionIDRequestToModel(r.PathValue(s.config.SubscriptionIDURLPattern))
+	if err != nil {
+		problem.WriteInvalid(w, r, err)
+		return
+	}
+
+	if err := s.store.DeleteWebhookSubscription(r.Context(), subscriptionID); err != nil {
+		writeSubscriptionError(w, r, err, "failed to delete webhook subscription")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Service) ListWebhookDeliveryAttemptsHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptionID, err := s.SubscriptionIDRequestToModel(r.PathValue(s.config.SubscriptionIDURLPattern))
+	if err != nil {
+		problem.WriteInvalid(w, r, err)
+		return
+	}
+	limit, err := s.LimitRequestToModel(r.URL.Query().Get("limit"))
+	if err != nil {
+		problem.WriteInvalid(w, r, err)
+		return
+	}
+
+	if _, err := s.store.GetWebhookSubscription(r.Context(), subscriptionID); err != nil {
+		writeSubscriptionError(w, r, err, "failed to list webhook delivery attempts")
+		return
+	}
+	attempts, err := s.store.ListWebhookDeliveryAttempts(r.Context(), subscriptionID, limit)
+	if err != nil {
+		writeSubscriptionError(w, r, err, "failed to list webhook delivery attempts")
+		return
+	}
+
+	resp := ListWebhookDeliveryAttemptsResponse{Attempts: make([]WebhookDeliveryAttemptResponse, 0, len(attempts))}
+	for _, attempt := range attempts {
+		resp.Attempts = append(resp.Attempts, WebhookDeliveryAttemptToResponse(attempt))
+	}
+	jsonutils.JsonResponse(w, resp, http.StatusOK)
+}
+
+func (s *Service) ListWebhookDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptionID, err := s.SubscriptionIDRequestToModel(r.PathValue(s.config.SubscriptionIDURLPattern))
+	if err != nil {
+		problem.WriteInvalid(w, r, err)
+		return
+	}
+
+	if _, err := s.store.GetWebhookSubscription(r.Context(), subscriptionID); err != nil {
+		writeSubscriptionError(w, r, err, "failed to list webhook dead letters")
+		return
+	}
+	deadLetters, err := s.store.ListWebhookDeadLetters(r.Context(), subscriptionID)
+	if err != nil {
+		writeSubscriptionError(w, r, err, "failed to list webhook dead letters")
+		return
+	}
+
+	resp := ListWebhookDeadLettersResponse{DeadLetters: make([]WebhookDeadLetterResponse, 0, len(deadLetters))}
+	for _, deadLetter := range deadLetters {
+		resp.DeadLetters = append(resp.DeadLetters, WebhookDeadLetterToResponse(deadLetter))
+	}
+	jsonutils.JsonResponse(w, resp, http.StatusOK)
+}
+
+// writeSubscriptionError maps the errors of subscription lookups to problems, internal errors are logged and replaced by msg.
+func writeSubscriptionError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	if errors.Is(err, model.ErrNotFound) {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeSubscriptionNotFound, "webhook subscription not found")
+		return
+	}
+	slog.ErrorContext(r.Context(), msg, "error", err)
+	problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternalError, msg)
+}
@@ -0,0 +1,128 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rezkam/TaxMan/model"
+	"github.com/stretchr/testify/require"
+)
+
+// newJSONRequest creates a request with a JSON body.
+func newJSONRequest(method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestCreateWebhookSubscriptionHandler(t *testing.T) {
+	t.Run("secret generated and returned once", func(t *testing.T) {
+		var stored model.WebhookSubscription
+		store := &mockStore{
+			createWebhookSubscriptionFunc: func(ctx context.Context, subscription model.WebhookSubscription) (model.WebhookSubscription, error) {
+				stored = subscription
+				subscription.ID = 1
+				return subscription, nil
+			},
+		}
+		svc, err := New(store, testConfig())
+		require.NoError(t, err)
+
+		reqBody, err := json.Marshal(CreateWebhookSubscriptionRequest{URL: "https://example.com/hook", Municipality: "Copenhagen"})
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		svc.CreateWebhookSubscriptionHandler(rr, newJSONRequest(http.MethodPost, "/webhooks", bytes.NewReader(reqBody)))
+
+		require.Equal(t, http.StatusCreated, rr.Code)
+		var resp WebhookSubscriptionResponse
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		require.Equal(t, int64(1), resp.ID)
+		require.Len(t, resp.Secret, 2*generatedSecretSize)
+		require.Equal(t, stored.Secret, resp.Secret)
+		require.Equal(t, "Copenhagen", stored.Municipality)
+	})
+
+	t.Run("invalid url", func(t *testing.T) {
+		svc, err := New(&mockStore{}, testConfig())
+		require.NoError(t, err)
+
+		for _, url := range []string{"", "example.com/hook", "ftp://example.com", "http://"} {
+			reqBody, err := json.Marshal(CreateWebhookSubscriptionRequest{URL: url})
+			require.NoError(t, err)
+			rr := httptest.NewRecorder()
+			svc.CreateWebhookSubscriptionHandler(rr, newJSONRequest(http.MethodPost, "/webhooks", bytes.NewReader(reqBody)))
+			require.Equal(t, http.StatusBadRequest, rr.Code, url)
+		}
+	})
+}
+
+func TestGetWebhookSubscriptionHandler(t *testing.T) {
+	store := &mockStore{
+		getWebhookSubscriptionFunc: func(ctx context.Context, subscriptionID int64) (model.WebhookSubscription, error) {
+			if subscriptionID != 1 {
+				return model.WebhookSubscription{}, model.ErrNotFound
+			}
+			return model.WebhookSubscription{ID: 1, URL: "https://example.com/hook", Secret: "secret"}, nil
+		},
+	}
+	svc, err := New(store, testConfig())
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /webhooks/{id}", svc.GetWebhookSubscriptionHandler)
+
+	t.Run("secret not exposed", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/webhooks/1", nil))
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		var resp WebhookSubscriptionResponse
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		require.Equal(t, "https://example.com/hook", resp.URL)
+		require.Empty(t, resp.Secret)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/webhooks/2", nil))
+		require.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("invalid id", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/webhooks/abc", nil))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+func TestListWebhookDeliveryAttemptsHandler(t *testing.T) {
+	var requestedLimit int
+	store := &mockStore{
+		listWebhookDeliveryAttemptsFunc: func(ctx context.Context, subscriptionID int64, limit int) ([]model.WebhookDeliveryAttempt, error) {
+			requestedLimit = limit
+			return []model.WebhookDeliveryAttempt{{ID: 1, DeliveryID: 2, Attempt: 1, StatusCode: http.StatusOK}}, nil
+		},
+	}
+	svc, err := New(store, testConfig())
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /webhooks/{id}/deliveries", svc.ListWebhookDeliveryAttemptsHandler)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/webhooks/1/deliveries", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, defaultDeliveryAttemptsLimit, requestedLimit)
+	var resp ListWebhookDeliveryAttemptsResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	require.Len(t, resp.Attempts, 1)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/webhooks/1/deliveries?limit=0", nil))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}
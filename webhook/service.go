@@ -0,0 +1,226 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rezkam/TaxMan/model"
+)
+
+// maxResponseBodySize is how much of a receiver's response body is read before the connection is reused.
+const maxResponseBodySize = 64 << 10
+
+// claimLeaseMargin extends the lease of claimed deliveries beyond their attempts, for recording their results.
+const claimLeaseMargin = time.Minute
+
+// Service manages the webhook subscriptions and notifies them of the changes of tax records.
+type Service struct {
+	store  webhookStore
+	config Config
+	client *http.Client
+	now    func() time.Time
+}
+
+type Config struct {
+	// MaxMunicipalityNameLength is the maximum length allowed for the municipality filter of a subscription.
+	MaxMunicipalityNameLength int
+	// SubscriptionIDURLPattern is the pattern used to extract the ID of a subscription from a URL.
+	SubscriptionIDURLPattern string
+	// MaxAttempts is how many times a notification is attempted before it is moved to the dead letters.
+	MaxAttempts int
+	// InitialBackoff is the delay before the second attempt of a notification, it doubles with every failed attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts of a notification.
+	MaxBackoff time.Duration
+	// DeliveryTimeout is how long a receiver has to answer a notification.
+	DeliveryTimeout time.Duration
+	// BatchSize is the maximum number of changes fanned out and of notifications sent by one dispatch.
+	BatchSize int
+	// MaxRequestBodySize is the maximum size in bytes of request bodies.
+	// This value is optional and defaults to jsonutils.DefaultMaxBodySize.
+	MaxRequestBodySize int64
+}
+
+type webhookStore interface {
+	// CreateWebhookSubscription stores a new webhook subscription.
+	CreateWebhookSubscription(ctx context.Context, subscription model.WebhookSubscription) (model.WebhookSubscription, error)
+
+	// GetWebhookSubscription retrieves a webhook subscription by its ID.
+	GetWebhookSubscription(ctx context.Context, subscriptionID int64) (model.WebhookSubscription, error)
+
+	// ListWebhookSubscriptions retrieves all webhook subscriptions.
+	ListWebhookSubscriptions(ctx context.Context) ([]model.WebhookSubscription, error)
+
+	// DeleteWebhookSubscription deletes a webhook subscription along with its deliveries.
+	DeleteWebhookSubscription(ctx context.Context, subscriptionID int64) error
+
+	// FanOutTaxRecordChanges creates the deliveries of the next limit changes of the change log to the matching subscriptions.
+	FanOutTaxRecordChanges(ctx context.Context, limit int) (int64, error)
+
+	// ClaimWebhookDeliveries claims at most limit due deliveries, postponing them by lease.
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]model.WebhookDelivery, error)
+
+	// RecordWebhookDeliveryAttempt logs an attempt of a delivery and moves the delivery to status.
+	// It returns model.ErrConflict if the delivery was attempted or removed since it was claimed.
+	RecordWebhookDeliveryAttempt(ctx context.Context, attempt model.WebhookDeliveryAttempt, status model.WebhookDeliveryStatus, nextAttemptAt time.Time) error
+
+	// ListWebhookDeliveryAttempts retrieves the latest limit delivery attempts of a subscription.
+	ListWebhookDeliveryAttempts(ctx context.Context, subscriptionID int64, limit int) ([]model.WebhookDeliveryAttempt, error)
+
+	// ListWebhookDeadLetters retrieves the dead letters of a subscription.
+	ListWebhookDeadLetters(ctx context.Context, subscriptionID int64) ([]model.WebhookDeadLetter, error)
+}
+
+// New creates a new Service with the provided store and configuration.
+func New(store webhookStore, config Config) (*Service, error) {
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+	return &Service{
+		store:  store,
+		config: config,
+		client: &http.Client{Timeout: config.DeliveryTimeout},
+		now:    time.Now,
+	}, nil
+}
+
+// validateConfig checks if the provided Config values are valid.
+func validateConfig(config Config) error {
+	if config.MaxMunicipalityNameLength <= 0 {
+		return errors.New("MaxMunicipalityNameLength must be greater than 0")
+	}
+	if config.SubscriptionIDURLPattern == "" {
+		return errors.New("SubscriptionIDURLPattern cannot be empty")
+	}
+	if config.MaxAttempts <= 0 {
+		return errors.New("MaxAttempts must be greater than 0")
+	}
+	if config.InitialBackoff <= 0 || config.MaxBackoff < config.InitialBackoff {
+		return errors.New("InitialBackoff must be greater than 0 and not exceed MaxBackoff")
+	}
+	if config.DeliveryTimeout <= 0 {
+		return errors.New("DeliveryTimeout must be greater than 0")
+	}
+	if config.BatchSize <= 0 {
+		return errors.New("BatchSize must be greater than 0")
+	}
+	if config.MaxRequestBodySize < 0 {
+		return errors.New("MaxRequestBodySize cannot be negative")
+	}
+	return nil
+}
+
+// DispatchWebhooks fans out the new changes of tax records to the subscriptions and sends the due notifications.
+// It returns how many notifications were delivered.
+func (s *Service) DispatchWebhooks(ctx context.Context) (int, error) {
+	if _, err := s.store.FanOutTaxRecordChanges(ctx, s.config.BatchSize); err != nil {
+		return 0, err
+	}
+
+	// A claimed delivery is attempted again after the lease if its result is never recorded, e.g. after a crash.
+	// The deliveries are attempted one after the other, so the lease covers all their attempts timing out.
+	lease := time.Duration(s.config.BatchSize)*s.config.DeliveryTimeout + claimLeaseMargin
+	deliveries, err := s.store.ClaimWebhookDeliveries(ctx, s.config.BatchSize, lease)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, delivery := range deliveries {
+		attempt := s.deliver(ctx, delivery)
+		status, nextAttemptAt := s.nextStep(attempt)
+		err := s.store.RecordWebhookDeliveryAttempt(ctx, attempt, status, nextAttemptAt)
+		if errors.Is(err, model.ErrConflict) {
+			// Another dispatcher claimed the delivery after the lease expired, its attempt is the one recorded
+			slog.Warn("webhook delivery claim was lost", "deliveryID", delivery.ID, "attempt", attempt.Attempt)
+			continue
+		}
+		if err != nil {
+			slog.Error("failed to record webhook delivery attempt", "deliveryID", delivery.ID, "error", err)
+			continue
+		}
+		switch status {
+		case model.WebhookDeliveryDelivered:
+			delivered++
+		case model.WebhookDeliveryDead:
+			slog.Warn("webhook delivery moved to dead letters", "deliveryID", delivery.ID,
+				"subscriptionID", delivery.Subscription.ID, "attempts", attempt.Attempt, "error", attempt.Error)
+		}
+	}
+	return delivered, nil
+}
+
+// deliver sends the notification of a delivery to its subscription and logs the attempt.
+func (s *Service) deliver(ctx context.Context, delivery model.WebhookDelivery) model.WebhookDeliveryAttempt {
+	attempt := model.WebhookDeliveryAttempt{
+		DeliveryID:  delivery.ID,
+		Attempt:     delivery.Attempts + 1,
+		AttemptedAt: s.now(),
+	}
+
+	body, err := json.Marshal(ChangeToPayload(delivery.Change))
+	if err != nil {
+		attempt.Error = fmt.Sprintf("failed to encode payload: %v", err)
+		return attempt
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = fmt.Sprintf("invalid request: %v", err)
+		return attempt
+	}
+	timestamp := attempt.AttemptedAt.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "TaxMan-Webhooks")
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(EventHeader, string(delivery.Change.Action))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Subscription.Secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	attempt.Duration = s.now().Sub(attempt.AttemptedAt)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBodySize))
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = "unexpected status " + resp.Status
+	}
+	return attempt
+}
+
+// nextStep decides the status of a delivery after an attempt, and when it is attempted again if it is still pending.
+func (s *Service) nextStep(attempt model.WebhookDeliveryAttempt) (model.WebhookDeliveryStatus, time.Time) {
+	now := s.now()
+	if attempt.Error == "" {
+		return model.WebhookDeliveryDelivered, now
+	}
+	if attempt.Attempt >= s.config.MaxAttempts {
+		return model.WebhookDeliveryDead, now
+	}
+	return model.WebhookDeliveryPending, now.Add(s.backoff(attempt.Attempt))
+}
+
+// backoff is the delay after a failed attempt, doubling from InitialBackoff with every attempt up to MaxBackoff.
+func (s *Service) backoff(attempt int) time.Duration {
+	delay := s.config.InitialBackoff
+	for range attempt - 1 {
+		delay *= 2
+		if delay >= s.config.MaxBackoff {
+			return s.config.MaxBackoff
+		}
+	}
+	return delay
+}
@@ -0,0 +1,235 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rezkam/TaxMan/internal/utils"
+	"github.com/rezkam/TaxMan/model"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig() Config {
+	return Config{
+		MaxMunicipalityNameLength: 20,
+		SubscriptionIDURLPattern:  "id",
+		MaxAttempts:               3,
+		InitialBackoff:            time.Second,
+		MaxBackoff:                5 * time.Second,
+		DeliveryTimeout:           time.Second,
+		BatchSize:                 10,
+	}
+}
+
+type recordedAttempt struct {
+	attempt       model.WebhookDeliveryAttempt
+	status        model.WebhookDeliveryStatus
+	nextAttemptAt time.Time
+}
+
+func dispatchOnce(t *testing.T, svc *Service, store *mockStore, delivery model.WebhookDelivery) recordedAttempt {
+	t.Helper()
+	var recorded []recordedAttempt
+	store.claimWebhookDeliveriesFunc = func(ctx context.Context, limit int, lease time.Duration) ([]model.WebhookDelivery, error) {
+		return []model.WebhookDelivery{delivery}, nil
+	}
+	store.recordWebhookDeliveryAttemptFunc = func(ctx context.Context, attempt model.WebhookDeliveryAttempt, status model.WebhookDeliveryStatus, nextAttemptAt time.Time) error {
+		recorded = append(recorded, recordedAttempt{attempt, status, nextAttemptAt})
+		return nil
+	}
+	_, err := svc.DispatchWebhooks(context.Background())
+	require.NoError(t, err)
+	require.Len(t, recorded, 1)
+	return recorded[0]
+}
+
+func TestDispatchWebhooks(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	change := model.TaxRecordChange{
+		ID:     7,
+		Action: model.ActionUpdate,
+		Record: model.TaxRecord{
+			ID:           3,
+			Municipality: "Copenhagen",
+			TaxRate:      0.2,
+			StartDate:    utils.DateOnly(2024, time.January, 1),
+			EndDate:      utils.DateOnly(2024, time.December, 31),
+			PeriodType:   model.Yearly,
+		},
+		ChangedAt: now,
+	}
+
+	t.Run("signed notification delivered", func(t *testing.T) {
+		var body []byte
+		var header http.Header
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ = io.ReadAll(r.Body)
+			header = r.Header.Clone()
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer receiver.Close()
+
+		store := &mockStore{}
+		svc, err := New(store, testConfig())
+		require.NoError(t, err)
+		svc.now = func() time.Time { return now }
+
+		delivery := model.WebhookDelivery{
+			ID:           5,
+			Subscription: model.WebhookSubscription{ID: 1, URL: receiver.URL, Secret: "secret"},
+			Change:       change,
+		}
+		recorded := dispatchOnce(t, svc, store, delivery)
+
+		require.Equal(t, model.WebhookDeliveryDelivered, recorded.status)
+		require.Equal(t, 1, recorded.attempt.Attempt)
+		require.Equal(t, http.StatusNoContent, recorded.attempt.StatusCode)
+		require.Empty(t, recorded.attempt.Error)
+
+		timestamp, err := strconv.ParseInt(header.Get(TimestampHeader), 10, 64)
+		require.NoError(t, err)
+		require.Equal(t, now.Unix(), timestamp)
+		require.True(t, VerifySignature("secret", timestamp, body, header.Get(SignatureHeader)))
+		require.False(t, VerifySignature("other", timestamp, body, header.Get(SignatureHeader)))
+		require.Equal(t, "5", header.Get(DeliveryHeader))
+		require.Equal(t, string(model.ActionUpdate), header.Get(EventHeader))
+
+		var payload Payload
+		require.NoError(t, json.Unmarshal(body, &payload))
+		require.Equal(t, int64(7), payload.ID)
+		require.Equal(t, "Copenhagen", payload.Municipality)
+		require.Equal(t, "2024-01-01", payload.StartDate)
+		require.Equal(t, "2024-12-31", payload.EndDate)
+	})
+
+	t.Run("failed attempt retried with backoff", func(t *testing.T) {
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer receiver.Close()
+
+		store := &mockStore{}
+		svc, err := New(store, testConfig())
+		require.NoError(t, err)
+		svc.now = func() time.Time { return now }
+
+		delivery := model.WebhookDelivery{
+			ID:           5,
+			Subscription: model.WebhookSubscription{ID: 1, URL: receiver.URL, Secret: "secret"},
+			Change:       change,
+			Attempts:     1,
+		}
+		recorded := dispatchOnce(t, svc, store, delivery)
+
+		require.Equal(t, model.WebhookDeliveryPending, recorded.status)
+		require.Equal(t, 2, recorded.attempt.Attempt)
+		require.Equal(t, http.StatusInternalServerError, recorded.attempt.StatusCode)
+		require.NotEmpty(t, recorded.attempt.Error)
+		require.Equal(t, now.Add(2*time.Second), recorded.nextAttemptAt)
+	})
+
+	t.Run("last failed attempt moves delivery to dead letters", func(t *testing.T) {
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer receiver.Close()
+
+		store := &mockStore{}
+		svc, err := New(store, testConfig())
+		require.NoError(t, err)
+		svc.now = func() time.Time { return now }
+
+		delivery := model.WebhookDelivery{
+			ID:           5,
+			Subscription: model.WebhookSubscription{ID: 1, URL: receiver.URL, Secret: "secret"},
+			Change:       change,
+			Attempts:     2,
+		}
+		recorded := dispatchOnce(t, svc, store, delivery)
+
+		require.Equal(t, model.WebhookDeliveryDead, recorded.status)
+		require.Equal(t, 3, recorded.attempt.Attempt)
+	})
+
+	t.Run("unreachable receiver", func(t *testing.T) {
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		url := receiver.URL
+		receiver.Close()
+
+		store := &mockStore{}
+		svc, err := New(store, testConfig())
+		require.NoError(t, err)
+
+		delivery := model.WebhookDelivery{
+			ID:           5,
+			Subscription: model.WebhookSubscription{ID: 1, URL: url, Secret: "secret"},
+			Change:       change,
+		}
+		recorded := dispatchOnce(t, svc, store, delivery)
+
+		require.Equal(t, model.WebhookDeliveryPending, recorded.status)
+		require.Zero(t, recorded.attempt.StatusCode)
+		require.NotEmpty(t, recorded.attempt.Error)
+	})
+
+	t.Run("lease covers the attempts of the batch", func(t *testing.T) {
+		config := testConfig()
+		var claimedLease time.Duration
+		store := &mockStore{
+			claimWebhookDeliveriesFunc: func(ctx context.Context, limit int, lease time.Duration) ([]model.WebhookDelivery, error) {
+				require.Equal(t, config.BatchSize, limit)
+				claimedLease = lease
+				return nil, nil
+			},
+		}
+		svc, err := New(store, config)
+		require.NoError(t, err)
+
+		_, err = svc.DispatchWebhooks(context.Background())
+		require.NoError(t, err)
+		require.Greater(t, claimedLease, time.Duration(config.BatchSize)*config.DeliveryTimeout)
+	})
+
+	t.Run("lost claim is not counted", func(t *testing.T) {
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer receiver.Close()
+
+		store := &mockStore{
+			claimWebhookDeliveriesFunc: func(ctx context.Context, limit int, lease time.Duration) ([]model.WebhookDelivery, error) {
+				return []model.WebhookDelivery{{
+					ID:           5,
+					Subscription: model.WebhookSubscription{ID: 1, URL: receiver.URL, Secret: "secret"},
+					Change:       change,
+				}}, nil
+			},
+			recordWebhookDeliveryAttemptFunc: func(ctx context.Context, attempt model.WebhookDeliveryAttempt, status model.WebhookDeliveryStatus, nextAttemptAt time.Time) error {
+				return model.ErrConflict
+			},
+		}
+		svc, err := New(store, testConfig())
+		require.NoError(t, err)
+
+		delivered, err := svc.DispatchWebhooks(context.Background())
+		require.NoError(t, err)
+		require.Zero(t, delivered)
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	svc, err := New(&mockStore{}, testConfig())
+	require.NoError(t, err)
+
+	require.Equal(t, time.Second, svc.backoff(1))
+	require.Equal(t, 2*time.Second, svc.backoff(2))
+	require.Equal(t, 4*time.Second, svc.backoff(3))
+	require.Equal(t, 5*time.Second, svc.backoff(4))
+	require.Equal(t, 5*time.Second, svc.backoff(40))
+}
@@ -0,0 +1,36 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+const (
+	// SignatureHeader carries the HMAC-SHA256 signature of a notification, as "sha256=<hex>".
+	SignatureHeader = "X-TaxMan-Signature"
+	// TimestampHeader carries the Unix time at which a notification was signed.
+	TimestampHeader = "X-TaxMan-Timestamp"
+	// DeliveryHeader carries the ID of the delivery, which is the same for every attempt of a delivery.
+	DeliveryHeader = "X-TaxMan-Delivery"
+	// EventHeader carries the action of the change a notification is about.
+	EventHeader = "X-TaxMan-Event"
+
+	signaturePrefix = "sha256="
+)
+
+// Sign computes the signature of a notification body sent at timestamp with the secret of a subscription.
+// The timestamp is part of the signed content, so that receivers can reject replayed notifications.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature reports whether signature is the valid signature of body sent at timestamp with secret.
+func VerifySignature(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
@@ -0,0 +1,84 @@
+package webhook
+
+import (
+	"context"
+	"time"
+
+	"github.com/rezkam/TaxMan/model"
+)
+
+type mockStore struct {
+	createWebhookSubscriptionFunc    func(ctx context.Context, subscription model.WebhookSubscription) (model.WebhookSubscription, error)
+	getWebhookSubscriptionFunc       func(ctx context.Context, subscriptionID int64) (model.WebhookSubscription, error)
+	listWebhookSubscriptionsFunc     func(ctx context.Context) ([]model.WebhookSubscription, error)
+	deleteWebhookSubscriptionFunc    func(ctx context.Context, subscriptionID int64) error
+	fanOutTaxRecordChangesFunc       func(ctx context.Context, limit int) (int64, error)
+	claimWebhookDeliveriesFunc       func(ctx context.Context, limit int, lease time.Duration) ([]model.WebhookDelivery, error)
+	recordWebhookDeliveryAttemptFunc func(ctx context.Context, attempt model.WebhookDeliveryAttempt, status model.WebhookDeliveryStatus, nextAttemptAt time.Time) error
+	listWebhookDeliveryAttemptsFunc  func(ctx context.Context, subscriptionID int64, limit int) ([]model.WebhookDeliveryAttempt, error)
+	listWebhookDeadLettersFunc       func(ctx context.Context, subscriptionID int64) ([]model.WebhookDeadLetter, error)
+}
+
+func (m *mockStore) CreateWebhookSubscription(ctx context.Context, subscription model.WebhookSubscription) (model.WebhookSubscription, error) {
+	if m.createWebhookSubscriptionFunc != nil {
+		return m.createWebhookSubscriptionFunc(ctx, subscription)
+	}
+	subscription.ID = 1
+	return subscription, nil
+}
+
+func (m *mockStore) GetWebhookSubscription(ctx context.Context, subscriptionID int64) (model.WebhookSubscription, error) {
+	if m.getWebhookSubscriptionFunc != nil {
+		return m.getWebhookSubscriptionFunc(ctx, subscriptionID)
+	}
+	return model.WebhookSubscription{ID: subscriptionID}, nil
+}
+
+func (m *mockStore) ListWebhookSubscriptions(ctx context.Context) ([]model.WebhookSubscription, error) {
+	if m.listWebhookSubscriptionsFunc != nil {
+		return m.listWebhookSubscriptionsFunc(ctx)
+	}
+	return nil, nil
+}
+
+func (m *mockStore) DeleteWebhookSubscription(ctx context.Context, subscriptionID int64) error {
+	if m.deleteWebhookSubscriptionFunc != nil {
+		return m.deleteWebhookSubscriptionFunc(ctx, subscriptionID)
+	}
+	return nil
+}
+
+func (m *mockStore) FanOutTaxRecordChanges(ctx context.Context, limit int) (int64, error) {
+	if m.fanOutTaxRecordChangesFunc != nil {
+		return m.fanOutTaxRecordChangesFunc(ctx, limit)
+	}
+	return 0, nil
+}
+
+func (m *mockStore) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]model.WebhookDelivery, error) {
+	if m.claimWebhookDeliveriesFunc != nil {
+		return m.claimWebhookDeliveriesFunc(ctx, limit, lease)
+	}
+	return nil, nil
+}
+
+func (m *mockStore) RecordWebhookDeliveryAttempt(ctx context.Context, attempt model.WebhookDeliveryAttempt, status model.WebhookDeliveryStatus, nextAttemptAt time.Time) error {
+	if m.recordWebhookDeliveryAttemptFunc != nil {
+		return m.recordWebhookDeliveryAttemptFunc(ctx, attempt, status, nextAttemptAt)
+	}
+	return nil
+}
+
+func (m *mockStore) ListWebhookDeliveryAttempts(ctx context.Context, subscriptionID int64, limit int) ([]model.WebhookDeliveryAttempt, error) {
+	if m.listWebhookDeliveryAttemptsFunc != nil {
+		return m.listWebhookDeliveryAttemptsFunc(ctx, subscriptionID, limit)
+	}
+	return nil, nil
+}
+
+func (m *mockStore) ListWebhookDeadLetters(ctx context.Context, subscriptionID int64) ([]model.WebhookDeadLetter, error) {
+	if m.listWebhookDeadLettersFunc != nil {
+		return m.listWebhookDeadLettersFunc(ctx, subscriptionID)
+	}
+	return nil, nil
+}
@@ -0,0 +1,76 @@
+package webhook
+
+import (
+	"time"
+
+	"github.com/rezkam/TaxMan/model"
+)
+
+// CreateWebhookSubscriptionRequest is the request type for subscribing an endpoint to the changes of tax records.
+type CreateWebhookSubscriptionRequest struct {
+	URL string `json:"url"`
+	// Municipality is an optional filter, the endpoint is notified of the changes of all municipalities when it is empty.
+	Municipality string `json:"municipality,omitempty"`
+	// Secret is the optional key of the HMAC signatures, a random secret is generated when it is empty.
+	Secret string `json:"secret,omitempty"`
+}
+
+// WebhookSubscriptionResponse is the response type for a webhook subscription.
+// The secret is only returned when the subscription is created.
+type WebhookSubscriptionResponse struct {
+	ID           int64     `json:"id"`
+	URL          string    `json:"url"`
+	Municipality string    `json:"municipality,omitempty"`
+	Secret       string    `json:"secret,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ListWebhookSubscriptionsResponse is the response type for listing webhook subscriptions.
+type ListWebhookSubscriptionsResponse struct {
+	Subscriptions []WebhookSubscriptionResponse `json:"subscriptions"`
+}
+
+// WebhookDeliveryAttemptResponse is the log of a single attempt to notify a subscription.
+type WebhookDeliveryAttemptResponse struct {
+	ID          int64     `json:"id"`
+	DeliveryID  int64     `json:"delivery_id"`
+	Attempt     int       `json:"attempt"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	DurationMs  int64     `json:"duration_ms"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+// ListWebhookDeliveryAttemptsResponse is the response type for the delivery logs of a subscription.
+type ListWebhookDeliveryAttemptsResponse struct {
+	Attempts []WebhookDeliveryAttemptResponse `json:"attempts"`
+}
+
+// WebhookDeadLetterResponse is a notification that was given up after its last attempt failed.
+type WebhookDeadLetterResponse struct {
+	ID         int64     `json:"id"`
+	DeliveryID int64     `json:"delivery_id"`
+	ChangeID   int64     `json:"change_id"`
+	Attempts   int       `json:"attempts"`
+	LastError  string    `json:"last_error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ListWebhookDeadLettersResponse is the response type for the dead letters of a subscription.
+type ListWebhookDeadLettersResponse struct {
+	DeadLetters []WebhookDeadLetterResponse `json:"dead_letters"`
+}
+
+// Payload is the body of a notification sent to a subscription, describing a change of a tax record.
+// The values are the record's state after the change, or before it for deletions.
+type Payload struct {
+	ID           int64              `json:"id"`
+	Action       model.ChangeAction `json:"action"`
+	RecordID     int64              `json:"record_id"`
+	Municipality string             `json:"municipality"`
+	TaxRate      float64            `json:"tax_rate"`
+	StartDate    string             `json:"start_date"`
+	EndDate      string             `json:"end_date,omitempty"`
+	PeriodType   model.PeriodType   `json:"period_type"`
+	ChangedAt    time.Time          `json:"changed_at"`
+}